@@ -0,0 +1,44 @@
+package proteus
+
+import (
+	"gopkg.in/src-d/proteus.v1/output"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// Adopt writes the locks of the packages of the given options with the
+// numbers of the fields and enum values of the given schemas, such as
+// hand-written proto files read with protobuf.ReadProto, so the first proto
+// files generated for the packages are compatible in the wire with them.
+// Messages and enums adopt the ones of the schemas with the same name, unless
+// they are renamed in renames, which holds the old name of the messages and
+// enums indexed by their new name. Locks that already exist are replaced.
+func Adopt(options Options, schemas []*protobuf.Package, renames map[string]string) error {
+	a := protobuf.NewAdopter(schemas...)
+	for name, old := range renames {
+		a.Rename(name, old)
+	}
+
+	g := protobuf.NewGenerator(options.BasePath)
+	g.SetOutput(options.output(output.NewDisk(options.BasePath)))
+	prepare := func(t *protobuf.Transformer, pkgs []*scanner.Package) error {
+		t.SetNullableMode(options.Nullable)
+		t.SetTarget(options.Target)
+		return nil
+	}
+
+	return transformToProtobuf(options, prepare, func(p *scanner.Package, pkg *protobuf.Package) error {
+		lock, err := a.Adopt(pkg)
+		if err != nil {
+			return err
+		}
+
+		if err := g.WriteLock(p.Path, lock); err != nil {
+			return err
+		}
+
+		report.Info("Adopted schema of package %s", p.Path)
+		return nil
+	})
+}
@@ -0,0 +1,118 @@
+package proteus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/src-d/proteus.v1/output"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+)
+
+// Breaking generates the proto files of the packages of the given options in
+// memory and returns the changes from their previous revision that are not
+// compatible in the wire, along with the renamed fields, as
+// protobuf.BreakingChanges does. The previous revision of every proto file
+// is the file with the same name, e.g. "my/pkg/generated.proto", in the
+// given descriptor set, or the first file with the same proto package if
+// there is none. If it is nil, the previous revisions are the
+// generated.protoset files written by the last generation to the base path.
+// Files without a previous revision are not compared.
+func Breaking(options Options, previous *descriptorpb.FileDescriptorSet) ([]*protobuf.BreakingChange, error) {
+	files := output.NewMemory()
+	options.Output = files
+	if err := GenerateProtos(options); err != nil {
+		return nil, err
+	}
+
+	var changes []*protobuf.BreakingChange
+	for _, path := range files.Paths() {
+		if filepath.Base(path) != protobuf.DescriptorSetFileName {
+			continue
+		}
+
+		current, err := readDescriptorSet(files[path])
+		if err != nil {
+			return nil, err
+		}
+
+		set := previous
+		if set == nil {
+			if set, err = ReadDescriptorSet(path); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		file := current.File[len(current.File)-1]
+		if old := findFile(set, file); old != nil {
+			changes = append(changes, protobuf.BreakingChanges(old, file)...)
+		}
+	}
+
+	return changes, nil
+}
+
+// ReadDescriptorSet reads the binary FileDescriptorSet in the file at the
+// given path, such as the generated.protoset files or the ones written by
+// protoc with --descriptor_set_out.
+func ReadDescriptorSet(path string) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := readDescriptorSet(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read descriptor set %s: %s", path, err)
+	}
+	return set, nil
+}
+
+func readDescriptorSet(data []byte) (*descriptorpb.FileDescriptorSet, error) {
+	set := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// ReadProtoFile reads the proto3 file at the given path, e.g. a
+// generated.proto committed before, as protobuf.ReadProto does, and returns
+// a descriptor set with its descriptor and the ones of its imports, which
+// can be given to Breaking as the previous revision.
+func ReadProtoFile(path string) (*descriptorpb.FileDescriptorSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pkg, err := protobuf.ReadProto(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read proto file %s: %s", path, err)
+	}
+
+	return protobuf.NewGenerator("").DescriptorSet(pkg), nil
+}
+
+// findFile returns the file with the given name of the set or, if there is
+// none, the first one with the package of the given file.
+func findFile(set *descriptorpb.FileDescriptorSet, file *descriptorpb.FileDescriptorProto) *descriptorpb.FileDescriptorProto {
+	for _, f := range set.File {
+		if f.GetName() == file.GetName() {
+			return f
+		}
+	}
+
+	for _, f := range set.File {
+		if f.GetPackage() == file.GetPackage() {
+			return f
+		}
+	}
+	return nil
+}
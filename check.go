@@ -0,0 +1,147 @@
+package proteus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/src-d/proteus.v1/loader"
+	"gopkg.in/src-d/proteus.v1/output"
+)
+
+// checkedFiles are the names of the generated files compared by Check.
+var checkedFiles = map[string]bool{
+	"generated.proto":    true,
+	"server.proteus.go":  true,
+	"convert.proteus.go": true,
+}
+
+// goFiles are the names of the generated files written to the directories of
+// the Go packages, which are stale if they are not generated anymore.
+var goFiles = []string{"server.proteus.go", "convert.proteus.go"}
+
+// Check generates the files of the packages of the given options in memory,
+// as GenerateInMemory does, and compares the generated.proto,
+// server.proteus.go and convert.proteus.go files with the ones on disk. It
+// returns the unified diff between the files on disk and the generated ones,
+// which is empty if they are up to date. Files that do not exist on disk are
+// diffed against /dev/null, as git does, and so are the server.proteus.go and
+// convert.proteus.go files on disk that are not generated anymore for the
+// target of the options, e.g. the conversion functions of packages generated
+// for the gogo target. The marshal.proteus.go files written by
+// GenerateMarshalers are not compared.
+func Check(options Options) (string, error) {
+	files, err := GenerateInMemory(options)
+	if err != nil {
+		return "", err
+	}
+
+	stale, err := staleFiles(options, files)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, path := range files.Paths() {
+		if !checkedFiles[filepath.Base(path)] {
+			continue
+		}
+
+		text, err := diffFile(path, files[path])
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(text)
+	}
+
+	for _, path := range stale {
+		text, err := diffFile(path, nil)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(text)
+	}
+
+	return buf.String(), nil
+}
+
+// diffFile returns the unified diff between the file on disk at the given
+// path and the given generated content. If it is nil, the file is not
+// generated anymore, so it is diffed against /dev/null.
+func diffFile(path string, generated []byte) (string, error) {
+	diff := difflib.UnifiedDiff{
+		B:        difflib.SplitLines(string(generated)),
+		FromFile: path,
+		ToFile:   path,
+		Context:  3,
+	}
+
+	if generated == nil {
+		diff.B = nil
+		diff.ToFile = os.DevNull
+	}
+
+	current, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		diff.FromFile = os.DevNull
+	case err != nil:
+		return "", err
+	default:
+		diff.A = difflib.SplitLines(string(current))
+	}
+
+	return difflib.GetUnifiedDiffString(diff)
+}
+
+// staleFiles returns the paths of the server.proteus.go and
+// convert.proteus.go files on disk that are not in the given generated files,
+// looking for them in the directories of the packages of the given options
+// and of the packages with a generated proto, which include the discovered
+// ones.
+func staleFiles(options Options, files output.Memory) ([]string, error) {
+	l := loader.New()
+	pkgs, err := l.Resolve(options.Packages...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range files.Paths() {
+		if filepath.Base(path) != "generated.proto" {
+			continue
+		}
+
+		rel, err := filepath.Rel(options.BasePath, filepath.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, filepath.ToSlash(rel))
+	}
+
+	var seen = make(map[string]bool)
+	var stale []string
+	for _, pkg := range pkgs {
+		dir, err := l.Dir(pkg)
+		if err != nil || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		for _, name := range goFiles {
+			path := filepath.Join(dir, name)
+			if _, ok := files[path]; ok {
+				continue
+			}
+
+			if _, err := os.Stat(path); err == nil {
+				stale = append(stale, path)
+			}
+		}
+	}
+
+	sort.Strings(stale)
+	return stale, nil
+}
@@ -6,20 +6,43 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/src-d/proteus.v1"
+	"gopkg.in/src-d/proteus.v1/loader"
 	"gopkg.in/src-d/proteus.v1/protobuf"
 	"gopkg.in/src-d/proteus.v1/report"
 
+	"google.golang.org/protobuf/types/descriptorpb"
 	"gopkg.in/urfave/cli.v1"
 )
 
 var (
-	packages cli.StringSlice
-	path     string
-	verbose  bool
+	packages     cli.StringSlice
+	discover     cli.StringSlice
+	path         string
+	verbose      bool
+	nullable     string
+	target       string
+	mappingsFile string
+	against      string
+	schemas      cli.StringSlice
+	renames      cli.StringSlice
+	protoPaths   cli.StringSlice
+	mappings     protobuf.TypeMappings
 )
 
+var nullableModes = map[string]protobuf.NullableMode{
+	"scalar":   protobuf.NullableAsScalar,
+	"optional": protobuf.NullableAsOptional,
+	"wrapper":  protobuf.NullableAsWrapper,
+}
+
+var targets = map[string]protobuf.Target{
+	"gogo":   protobuf.TargetGogo,
+	"golang": protobuf.TargetGolang,
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "proteus"
@@ -29,7 +52,7 @@ func main() {
 	baseFlags := []cli.Flag{
 		cli.StringSliceFlag{
 			Name:  "pkg, p",
-			Usage: "Use `PACKAGE` as input for the generation. You can use this flag multiple times to specify more than one package.",
+			Usage: "Use `PACKAGE` as input for the generation. You can use this flag multiple times to specify more than one package. Inside a Go module, patterns relative to the working directory such as ./... are accepted too.",
 			Value: &packages,
 		},
 		cli.BoolFlag{
@@ -37,6 +60,16 @@ func main() {
 			Usage:       "Print all warnings and info messages.",
 			Destination: &verbose,
 		},
+		cli.StringFlag{
+			Name:        "mappings",
+			Usage:       "Read custom mappings of Go types to protobuf types from the JSON `FILE`.",
+			Destination: &mappingsFile,
+		},
+		cli.StringSliceFlag{
+			Name:  "discover",
+			Usage: "Scan the packages whose import path starts with `PREFIX` when the types of the given packages refer to their types, generating only the referenced types. You can use this flag multiple times to specify more than one prefix.",
+			Value: &discover,
+		},
 	}
 
 	folderFlag := cli.StringFlag{
@@ -45,14 +78,84 @@ func main() {
 		Destination: &path,
 	}
 
-	app.Flags = append(baseFlags, folderFlag)
+	nullableFlag := cli.StringFlag{
+		Name:        "nullable",
+		Usage:       "Generate pointers to basic types and database/sql Null* types as `MODE`: scalar, optional or wrapper.",
+		Value:       "scalar",
+		Destination: &nullable,
+	}
+
+	targetFlag := cli.StringFlag{
+		Name:        "target",
+		Usage:       "Generate the .proto files for the `TARGET` protobuf generator: gogo, for the gogo protobuf generators, or golang, for protoc-gen-go along with functions to convert between your Go types and the generated ones.",
+		Value:       "gogo",
+		Destination: &target,
+	}
+
+	protoPathFlag := cli.StringSliceFlag{
+		Name:  "proto_path, I",
+		Usage: "Look for the .proto files imported by the generated ones in `DIR` when running protoc, such as the ones of the types generated by protoc of other packages. You can use this flag multiple times to specify more than one directory.",
+		Value: &protoPaths,
+	}
+
+	app.Flags = append(baseFlags, folderFlag, nullableFlag, targetFlag, protoPathFlag)
 	app.Commands = []cli.Command{
 		{
 			Name:        "proto",
 			Description: "Generates .proto files from your Go source code.",
 			Usage:       "Generates .proto files from Go packages",
 			Action:      initCmd(genProtos),
-			Flags:       append(baseFlags, folderFlag),
+			Flags:       append(baseFlags, folderFlag, nullableFlag, targetFlag),
+		},
+		{
+			Name:        "convert",
+			Description: "Generates the functions to convert between the Go types of your Go source code and the ones generated by protoc-gen-go for the .proto files generated with the golang target.",
+			Usage:       "Generates functions to convert from and to the types generated by protoc-gen-go",
+			Action:      initCmd(genConverters),
+			Flags:       append(baseFlags, nullableFlag),
+		},
+		{
+			Name:        "marshal",
+			Description: "Generates .proto files from your Go source code along with the methods to marshal your Go types to the protobuf wire format and unmarshal them back, without the need of protoc.",
+			Usage:       "Generates .proto files and marshal methods without protoc",
+			Action:      initCmd(genMarshalers),
+			Flags:       append(baseFlags, folderFlag, nullableFlag),
+		},
+		{
+			Name:        "check",
+			Description: "Generates the .proto files along with the gRPC server implementation, or the conversion functions for the golang target, in memory and compares them with the ones on disk, printing their differences and failing if they are not up to date or if they are not generated anymore.",
+			Usage:       "Checks that the generated files are up to date",
+			Action:      initCmd(check),
+			Flags:       append(baseFlags, folderFlag, nullableFlag, targetFlag),
+		},
+		{
+			Name:        "breaking",
+			Description: "Generates the .proto files in memory and compares them with their previous revision, printing the changes that are not compatible in the wire and failing if there is any. Renamed fields are printed too, but they do not make it fail.",
+			Usage:       "Checks that the .proto files have no breaking changes",
+			Action:      initCmd(breaking),
+			Flags: append(baseFlags, folderFlag, nullableFlag, targetFlag, cli.StringFlag{
+				Name:        "against",
+				Usage:       "Compare with the previous revision of the .proto files in the descriptor set `FILE`, or in the .proto file if its extension is .proto, instead of the generated.protoset files in the folder.",
+				Destination: &against,
+			}),
+		},
+		{
+			Name:        "adopt",
+			Description: "Writes the proteus.lock files of the Go packages with the numbers of the fields and enum values of the existing .proto files they replace, matching messages and enums by name, so the .proto files generated afterwards are compatible in the wire with them.",
+			Usage:       "Adopts the field numbers of existing .proto files",
+			Action:      initCmd(adopt),
+			Flags: append(baseFlags, folderFlag, nullableFlag, targetFlag,
+				cli.StringSliceFlag{
+					Name:  "schema",
+					Usage: "Adopt the messages and enums of the .proto `FILE`. You can use this flag multiple times to specify more than one file.",
+					Value: &schemas,
+				},
+				cli.StringSliceFlag{
+					Name:  "rename",
+					Usage: "Adopt the message or enum OLD of the .proto files for the message or enum NEW of the Go packages, given as `NEW=OLD`. You can use this flag multiple times.",
+					Value: &renames,
+				},
+			),
 		},
 		{
 			Name:        "rpc",
@@ -82,6 +185,14 @@ func initCmd(next action) func(c *cli.Context) error {
 			report.Silent()
 		}
 
+		if mappingsFile != "" {
+			m, err := readMappings(mappingsFile)
+			if err != nil {
+				return err
+			}
+			mappings = m
+		}
+
 		return next(c)
 	}
 }
@@ -95,20 +206,210 @@ func genProtos(c *cli.Context) error {
 		return err
 	}
 
-	return proteus.GenerateProtos(proteus.Options{
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	return proteus.GenerateProtos(opts)
+}
+
+func genConverters(c *cli.Context) error {
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	return proteus.GenerateConverters(opts)
+}
+
+func genMarshalers(c *cli.Context) error {
+	if path == "" {
+		return errors.New("destination path cannot be empty")
+	}
+
+	if err := checkFolder(path); err != nil {
+		return err
+	}
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	return proteus.GenerateMarshalers(opts)
+}
+
+func check(c *cli.Context) error {
+	if path == "" {
+		return errors.New("destination path cannot be empty")
+	}
+
+	if err := checkFolder(path); err != nil {
+		return err
+	}
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	diff, err := proteus.Check(opts)
+	if err != nil {
+		return err
+	}
+
+	if diff != "" {
+		fmt.Print(diff)
+		return errors.New("generated files are not up to date, run proteus to generate them again")
+	}
+	return nil
+}
+
+func breaking(c *cli.Context) error {
+	if path == "" {
+		return errors.New("destination path cannot be empty")
+	}
+
+	if err := checkFolder(path); err != nil {
+		return err
+	}
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	var previous *descriptorpb.FileDescriptorSet
+	if against != "" {
+		read := proteus.ReadDescriptorSet
+		if filepath.Ext(against) == ".proto" {
+			read = proteus.ReadProtoFile
+		}
+
+		if previous, err = read(against); err != nil {
+			return err
+		}
+	}
+
+	changes, err := proteus.Breaking(opts, previous)
+	if err != nil {
+		return err
+	}
+
+	var breaking int
+	for _, c := range changes {
+		fmt.Println(c)
+		if !c.WireCompatible {
+			breaking++
+		}
+	}
+
+	if breaking > 0 {
+		return fmt.Errorf("found %d breaking changes", breaking)
+	}
+	return nil
+}
+
+func adopt(c *cli.Context) error {
+	if path == "" {
+		return errors.New("destination path cannot be empty")
+	}
+
+	if err := checkFolder(path); err != nil {
+		return err
+	}
+
+	if len(schemas) == 0 {
+		return errors.New("no .proto file to adopt was given, use --schema")
+	}
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	var pkgs []*protobuf.Package
+	for _, s := range schemas {
+		pkg, err := readProto(s)
+		if err != nil {
+			return err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	var names = make(map[string]string)
+	for _, r := range renames {
+		idx := strings.Index(r, "=")
+		if idx <= 0 || idx == len(r)-1 {
+			return fmt.Errorf("invalid rename %q, it must be NEW=OLD", r)
+		}
+		names[r[:idx]] = r[idx+1:]
+	}
+
+	return proteus.Adopt(opts, pkgs, names)
+}
+
+func readProto(path string) (*protobuf.Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open .proto file: %s", err)
+	}
+	defer f.Close()
+
+	pkg, err := protobuf.ReadProto(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read .proto file %s: %s", path, err)
+	}
+	return pkg, nil
+}
+
+// options returns the options of the generation given by the flags.
+func options() (proteus.Options, error) {
+	mode, ok := nullableModes[nullable]
+	if !ok {
+		return proteus.Options{}, fmt.Errorf("invalid nullable mode %q, it must be scalar, optional or wrapper", nullable)
+	}
+
+	t, ok := targets[target]
+	if !ok {
+		return proteus.Options{}, fmt.Errorf("invalid target %q, it must be gogo or golang", target)
+	}
+
+	return proteus.Options{
 		BasePath: path,
 		Packages: packages,
-	})
+		Nullable: mode,
+		Mappings: mappings,
+		Discover: discover,
+		Target:   t,
+	}, nil
 }
 
 func genRPCServer(c *cli.Context) error {
-	return proteus.GenerateRPCServer(packages)
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	return proteus.GenerateRPCServerWithOptions(opts)
 }
 
-var (
-	goSrc       = filepath.Join(os.Getenv("GOPATH"), "src")
-	protobufSrc = filepath.Join(goSrc, "github.com", "gogo", "protobuf")
-)
+func readMappings(path string) (protobuf.TypeMappings, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open mappings file: %s", err)
+	}
+	defer f.Close()
+
+	m, err := protobuf.ReadMappings(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mappings file %s: %s", path, err)
+	}
+	return m, nil
+}
+
+const gogoProtobuf = "github.com/gogo/protobuf"
 
 func genAll(c *cli.Context) error {
 	protocPath, err := exec.LookPath("protoc")
@@ -116,19 +417,40 @@ func genAll(c *cli.Context) error {
 		return fmt.Errorf("protoc is not installed: %s", err)
 	}
 
-	if err := checkFolder(protobufSrc); err != nil {
-		return fmt.Errorf("github.com/gogo/protobuf is not installed")
+	if target == "golang" {
+		return genAllGolang(c, protocPath)
+	}
+
+	if nullable == "optional" {
+		return errors.New("proto3 optional fields are not supported by the gogo protobuf generator, use the proto command and your own generator instead")
+	}
+
+	l := loader.New()
+	gogoproto, err := l.Dir(gogoProtobuf + "/gogoproto")
+	if err != nil {
+		return fmt.Errorf("%s is not installed", gogoProtobuf)
+	}
+	protobufSrc := filepath.Dir(gogoproto)
+
+	pkgs, err := l.Resolve(packages...)
+	if err != nil {
+		return err
 	}
 
 	if err := genProtos(c); err != nil {
 		return err
 	}
 
-	for _, p := range packages {
-		outPath := goSrc
+	if len(discover) > 0 {
+		if pkgs, err = addGeneratedPackages(pkgs, path); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range pkgs {
 		proto := filepath.Join(path, p, "generated.proto")
 
-		if err := protocExec(protocPath, p, outPath, proto); err != nil {
+		if err := protocExec(protocPath, protobufSrc, p, path, proto); err != nil {
 			return fmt.Errorf("error generating Go files from %q: %s", proto, err)
 		}
 
@@ -137,7 +459,11 @@ func genAll(c *cli.Context) error {
 			return fmt.Errorf("error moving Go files")
 		}
 
-		moveToDir := filepath.Join(outPath, p)
+		moveToDir, err := l.Dir(p)
+		if err != nil {
+			return err
+		}
+
 		for _, s := range matches {
 			mv(s, moveToDir)
 		}
@@ -146,14 +472,111 @@ func genAll(c *cli.Context) error {
 	return genRPCServer(c)
 }
 
-func protocExec(protocPath, pkg, outPath, protoFile string) error {
+// genAllGolang generates the proto files for the golang target, the Go code
+// of every one of them with protoc-gen-go, which is written to a package
+// inside of the Go package named after it with a pb suffix, and the functions
+// to convert between the types of both packages.
+func genAllGolang(c *cli.Context, protocPath string) error {
+	if _, err := exec.LookPath("protoc-gen-go"); err != nil {
+		return fmt.Errorf("protoc-gen-go is not installed: %s", err)
+	}
+
+	grpc := true
+	if _, err := exec.LookPath("protoc-gen-go-grpc"); err != nil {
+		report.Warn("protoc-gen-go-grpc is not installed, gRPC services will not be generated")
+		grpc = false
+	}
+
+	l := loader.New()
+	pkgs, err := l.Resolve(packages...)
+	if err != nil {
+		return err
+	}
+
+	if err := genProtos(c); err != nil {
+		return err
+	}
+
+	if len(discover) > 0 {
+		if pkgs, err = addGeneratedPackages(pkgs, path); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range pkgs {
+		proto := filepath.Join(path, p, "generated.proto")
+
+		pkg, err := l.Load(p)
+		if err != nil {
+			return err
+		}
+
+		_, name := protobuf.GolangPackage(p, pkg.Types.Name())
+		moveToDir := filepath.Join(pkg.Dir, name)
+		if err := os.MkdirAll(moveToDir, 0755); err != nil {
+			return err
+		}
+
+		args := []string{"--proto_path=" + path}
+		for _, p := range protoPaths {
+			args = append(args, "--proto_path="+p)
+		}
+
+		args = append(args, golangOutOption("go", path))
+		if grpc {
+			args = append(args, golangOutOption("go-grpc", path))
+		}
+
+		report.Info("executing protoc: %s %s", protocPath, strings.Join(args, " "))
+		cmd := exec.Command(protocPath, append(args, proto)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error generating Go files from %q: %s", proto, err)
+		}
+
+		matches, err := filepath.Glob(filepath.Join(path, p, "*.pb.go"))
+		if err != nil {
+			return fmt.Errorf("error moving Go files")
+		}
+
+		for _, s := range matches {
+			mv(s, moveToDir)
+		}
+	}
+
+	return genConverters(c)
+}
+
+// golangOutOption returns the option to write the files generated by the
+// protoc-gen-<plugin> plugin to outPath next to their proto files, with the
+// import mappings of the custom mappings.
+func golangOutOption(plugin, outPath string) string {
+	str := fmt.Sprintf("--%s_out=paths=source_relative", plugin)
+	if importMappings := mappings.ToGoOutPath(); importMappings != "" {
+		str += fmt.Sprintf(",%s", importMappings)
+	}
+
+	return fmt.Sprintf("%s:%s", str, outPath)
+}
+
+// protocExec runs protoc for the given proto file, writing the Go files to
+// outPath. Imports of gogo protobuf files are resolved against protobufSrc,
+// which is the directory where github.com/gogo/protobuf can be found either in
+// GOPATH or in the module cache, and the other imports against the given
+// proto paths as well.
+func protocExec(protocPath, protobufSrc, pkg, outPath, protoFile string) error {
 	protocArgs := fmt.Sprintf(
-		"--proto_path=%s:%s:%s:%s:.",
-		goSrc,
+		"--proto_path=%s:%s=%s:%s:%s:.",
 		path,
+		gogoProtobuf,
+		protobufSrc,
 		filepath.Join(protobufSrc, "protobuf"),
 		filepath.Join(path, pkg),
 	)
+	for _, p := range protoPaths {
+		protocArgs += ":" + p
+	}
 
 	report.Info("executing protoc: %s %s", protocPath, protocArgs)
 
@@ -179,10 +602,10 @@ func mv(from, to string) error {
 
 func genAllGoFastOutOption(outPath string) string {
 	str := "--gofast_out=plugins=grpc"
-	importMappings := protobuf.DefaultMappings.ToGoOutPath()
-
-	if importMappings != "" {
-		str += fmt.Sprintf(",%s", importMappings)
+	for _, m := range []protobuf.TypeMappings{protobuf.DefaultMappings, protobuf.WrapperMappings, mappings} {
+		if importMappings := m.ToGoOutPath(); importMappings != "" {
+			str += fmt.Sprintf(",%s", importMappings)
+		}
 	}
 
 	str += fmt.Sprintf(":%s", outPath)
@@ -190,6 +613,35 @@ func genAllGoFastOutOption(outPath string) string {
 	return str
 }
 
+// addGeneratedPackages adds to the given packages the ones with a generated
+// proto file in the given folder, which include the discovered packages.
+func addGeneratedPackages(pkgs []string, folder string) ([]string, error) {
+	var seen = make(map[string]struct{}, len(pkgs))
+	for _, p := range pkgs {
+		seen[p] = struct{}{}
+	}
+
+	err := filepath.Walk(folder, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || fi.Name() != "generated.proto" {
+			return err
+		}
+
+		rel, err := filepath.Rel(folder, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+
+		pkg := filepath.ToSlash(rel)
+		if _, ok := seen[pkg]; !ok {
+			seen[pkg] = struct{}{}
+			pkgs = append(pkgs, pkg)
+		}
+		return nil
+	})
+
+	return pkgs, err
+}
+
 func checkFolder(p string) error {
 	fi, err := os.Stat(p)
 	switch {
@@ -0,0 +1,198 @@
+package convert // import "gopkg.in/src-d/proteus.v1/convert"
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"path/filepath"
+
+	"gopkg.in/src-d/proteus.v1/internal/gen"
+	"gopkg.in/src-d/proteus.v1/loader"
+	"gopkg.in/src-d/proteus.v1/output"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/report"
+)
+
+const (
+	toProto   = "ToProto"
+	fromProto = "FromProto"
+)
+
+// Generator generates the functions that convert between the Go types of a
+// package and the Go types generated for its proto file by protoc-gen-go,
+// which is what the proto files generated for the protobuf.TargetGolang
+// target are meant to be used with. As protoc-gen-go declares its own types
+// instead of using the ones of the package, they are generated in a separate
+// package, see protobuf.GolangPackage.
+//
+// For every struct Foo of the package with a message in the proto file, the
+// functions FooToProto and FooFromProto are generated, which convert
+// between *Foo and the pointer to the type of the message. The same goes for
+// enums, whose functions convert between the values of the Go enum and the
+// values of the protobuf enum. Messages of instantiations of generic structs
+// have their functions as well, named after the message, e.g.
+// PageUserToProto for Page[User].
+//
+// A single file per package will be generated containing all the functions.
+// The file will be written to the directory of the package and it will be
+// named "convert.proteus.go". Functions already defined in the package are
+// not generated, so they can be customized.
+type Generator struct {
+	loader *loader.Loader
+	output output.Output
+}
+
+// NewGenerator creates a new Generator, which writes the files to disk.
+func NewGenerator() *Generator {
+	return &Generator{loader.New(), output.Disk}
+}
+
+// SetOutput sets the output the generated files are written to.
+func (g *Generator) SetOutput(o output.Output) {
+	g.output = o
+}
+
+// Generate creates a new file in the package at the given path with the
+// functions that convert between the Go types of the package and the Go
+// types generated for the given proto package.
+func (g *Generator) Generate(proto *protobuf.Package, path string) error {
+	pkg, err := g.loader.Load(path)
+	if err != nil {
+		return err
+	}
+
+	ctx := newContext(proto, pkg.Types)
+	var buf bytes.Buffer
+	for _, e := range proto.Enums {
+		g.writeEnumFuncs(ctx, &buf, e)
+	}
+
+	for _, m := range proto.Messages {
+		g.writeMessageFuncs(ctx, &buf, m)
+	}
+
+	if buf.Len() == 0 {
+		report.Warn("no messages or enums to convert in the given proto file, not generating anything")
+		return nil
+	}
+
+	src, err := format.Source(append(ctx.Header(), buf.Bytes()...))
+	if err != nil {
+		return fmt.Errorf("unable to format the conversion functions of package %s: %s", path, err)
+	}
+
+	return g.output.WriteFile(filepath.Join(pkg.Dir, "convert.proteus.go"), src)
+}
+
+type context struct {
+	*gen.File
+	proto *protobuf.Package
+	pkg   *types.Package
+	// instances are the instantiations of generic structs used in the
+	// package by their Go types, e.g. Page[User].
+	instances map[string]*types.Named
+}
+
+func newContext(proto *protobuf.Package, pkg *types.Package) *context {
+	return &context{
+		File:  gen.NewFile(pkg),
+		proto: proto,
+		pkg:   pkg,
+	}
+}
+
+func (c *context) isNameDefined(name string) bool {
+	return c.pkg.Scope().Lookup(name) != nil
+}
+
+// pbQualifier returns the name of the package generated by protoc-gen-go
+// for the given package, importing it.
+func (c *context) pbQualifier(pkg *types.Package) string {
+	return c.AddImport(protobuf.GolangPackage(loader.ImportPath(pkg.Path()), pkg.Name()))
+}
+
+// funcName returns the name of the function that converts the values of
+// the given Go type in the given direction, qualified with its package if it
+// is not the one being generated.
+func (c *context) funcName(obj *types.TypeName, direction string) string {
+	name := obj.Name() + direction
+	if qual := c.Qualifier(obj.Pkg()); qual != "" {
+		return qual + "." + name
+	}
+	return name
+}
+
+// instance returns the instantiation of a generic struct used in the package
+// whose Go type is the given one, e.g. Page[User], written as the scanner
+// does.
+func (c *context) instance(generic string) *types.Named {
+	if c.instances != nil {
+		return c.instances[generic]
+	}
+
+	qualifier := func(pkg *types.Package) string {
+		if pkg.Path() == c.pkg.Path() {
+			return ""
+		}
+		return loader.ImportPath(pkg.Path())
+	}
+
+	c.instances = make(map[string]*types.Named)
+	seen := make(map[types.Type]bool)
+	var visit func(types.Type)
+	visit = func(typ types.Type) {
+		if seen[typ] {
+			return
+		}
+		seen[typ] = true
+
+		switch t := typ.(type) {
+		case *types.Named:
+			if t.TypeArgs().Len() > 0 {
+				c.instances[types.TypeString(t, qualifier)] = t
+				for i := 0; i < t.TypeArgs().Len(); i++ {
+					visit(t.TypeArgs().At(i))
+				}
+				visit(t.Underlying())
+			}
+		case *types.Pointer:
+			visit(t.Elem())
+		case *types.Slice:
+			visit(t.Elem())
+		case *types.Array:
+			visit(t.Elem())
+		case *types.Map:
+			visit(t.Key())
+			visit(t.Elem())
+		case *types.Struct:
+			for i := 0; i < t.NumFields(); i++ {
+				visit(t.Field(i).Type())
+			}
+		case *types.Signature:
+			visit(t.Params())
+			visit(t.Results())
+		case *types.Tuple:
+			for i := 0; i < t.Len(); i++ {
+				visit(t.At(i).Type())
+			}
+		}
+	}
+
+	scope := c.pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if _, ok := obj.(*types.TypeName); ok {
+			visit(obj.Type().Underlying())
+			if named, ok := obj.Type().(*types.Named); ok {
+				for i := 0; i < named.NumMethods(); i++ {
+					visit(named.Method(i).Type())
+				}
+			}
+			continue
+		}
+		visit(obj.Type())
+	}
+
+	return c.instances[generic]
+}
@@ -0,0 +1,550 @@
+package convert
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/protoc-gen-gogo/generator"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/resolver"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+type ConvertSuite struct {
+	suite.Suite
+	g *Generator
+}
+
+func (s *ConvertSuite) SetupTest() {
+	s.g = NewGenerator()
+}
+
+const expectedEnumFuncs = `package fake
+
+import (
+	"fake/fakepb"
+)
+
+func ColorToProto(v Color) fakepb.Color {
+	switch v {
+	case Red:
+		return fakepb.Color_RED
+	case Blue:
+		return fakepb.Color_BLUE
+	}
+	return 0
+}
+
+func ColorFromProto(v fakepb.Color) Color {
+	switch v {
+	case fakepb.Color_RED:
+		return Red
+	case fakepb.Color_BLUE:
+		return Blue
+	}
+	return ""
+}
+
+func KindToProto(v Kind) fakepb.Kind {
+	return fakepb.Kind(v)
+}
+
+func KindFromProto(v fakepb.Kind) Kind {
+	return Kind(v)
+}
+`
+
+func (s *ConvertSuite) TestWriteEnumFuncs() {
+	ctx := newContext(nil, s.fakePkg())
+
+	var buf bytes.Buffer
+	s.g.writeEnumFuncs(ctx, &buf, &protobuf.Enum{
+		Name: "Color",
+		Values: []*protobuf.EnumValue{
+			{Name: "COLOR_UNSPECIFIED"},
+			{Name: "RED", GoName: "Red", Value: 1},
+			{Name: "BLUE", GoName: "Blue", Value: 2},
+			{Name: "AZURE", GoName: "Azure", Value: 2},
+		},
+	})
+	s.g.writeEnumFuncs(ctx, &buf, &protobuf.Enum{Name: "Kind"})
+	s.g.writeEnumFuncs(ctx, &buf, &protobuf.Enum{Name: "Defined"})
+
+	s.Equal(expectedEnumFuncs, s.render(ctx, &buf))
+}
+
+const expectedMessageFuncs = `package fake
+
+import (
+	"fake/fakepb"
+)
+
+func BarToProto(v *Bar) *fakepb.Bar {
+	if v == nil {
+		return nil
+	}
+
+	p := new(fakepb.Bar)
+	p.Name = v.Name
+	return p
+}
+
+func BarFromProto(p *fakepb.Bar) *Bar {
+	if p == nil {
+		return nil
+	}
+
+	v := new(Bar)
+	v.Name = p.Name
+	return v
+}
+
+func PageBarToProto(v *Page[Bar]) *fakepb.PageBar {
+	if v == nil {
+		return nil
+	}
+
+	p := new(fakepb.PageBar)
+	if v.Items != nil {
+		p.Items = make([]*fakepb.Bar, len(v.Items))
+		for i, x := range v.Items {
+			p.Items[i] = BarToProto(&x)
+		}
+	}
+	return p
+}
+
+func PageBarFromProto(p *fakepb.PageBar) *Page[Bar] {
+	if p == nil {
+		return nil
+	}
+
+	v := new(Page[Bar])
+	if p.Items != nil {
+		v.Items = make([]Bar, len(p.Items))
+		for i, x := range p.Items {
+			if m1 := BarFromProto(x); m1 != nil {
+				v.Items[i] = *m1
+			}
+		}
+	}
+	return v
+}
+
+func DrawingToProto(v *Drawing) *fakepb.Drawing {
+	if v == nil {
+		return nil
+	}
+
+	p := new(fakepb.Drawing)
+	switch x := v.Shape.(type) {
+	case Circle:
+		val := new(fakepb.Drawing_Circle)
+		val.Circle = CircleToProto(&x)
+		p.Shape = val
+	case *Circle:
+		val := new(fakepb.Drawing_Circle)
+		val.Circle = CircleToProto(x)
+		p.Shape = val
+	case *Square:
+		val := new(fakepb.Drawing_Square)
+		val.Square = SquareToProto(x)
+		p.Shape = val
+	}
+	return p
+}
+
+func DrawingFromProto(p *fakepb.Drawing) *Drawing {
+	if p == nil {
+		return nil
+	}
+
+	v := new(Drawing)
+	switch x := p.Shape.(type) {
+	case *fakepb.Drawing_Circle:
+		if m1 := CircleFromProto(x.Circle); m1 != nil {
+			v.Shape = *m1
+		}
+	case *fakepb.Drawing_Square:
+		v.Shape = SquareFromProto(x.Square)
+	}
+	return v
+}
+`
+
+func (s *ConvertSuite) TestFieldConverters() {
+	ctx := newContext(nil, s.fakePkg())
+	foo := ctx.pkg.Scope().Lookup("Foo").Type()
+	msg := &protobuf.Message{Name: "Foo"}
+	msg.AddMessage(&protobuf.Message{
+		Name: "Meta",
+		Fields: []*protobuf.Field{
+			{Name: "name", GoName: "Name", Type: protobuf.NewBasic("string")},
+		},
+	})
+
+	cases := []struct {
+		field    *protobuf.Field
+		to, from string
+	}{
+		{
+			&protobuf.Field{Name: "name", GoName: "Name", Type: protobuf.NewBasic("string")},
+			"p.Name = v.Name\n",
+			"v.Name = p.Name\n",
+		},
+		{
+			&protobuf.Field{Name: "age", GoName: "Age", Type: protobuf.NewBasic("int64")},
+			"p.Age = int64(v.Age)\n",
+			"v.Age = int(p.Age)\n",
+		},
+		{
+			&protobuf.Field{Name: "nick", GoName: "Nick", Type: protobuf.NewBasic("string")},
+			"if v.Nick != nil {\np.Nick = *v.Nick\n}\n",
+			"if p.Nick != \"\" {\nval := p.Nick\nv.Nick = &val\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "score", GoName: "Score", Type: protobuf.NewBasic("int64"), Optional: true},
+			"if v.Score != nil {\nval := int64(*v.Score)\np.Score = &val\n}\n",
+			"if p.Score != nil {\nval := int(*p.Score)\nv.Score = &val\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "kind", GoName: "Kind", Type: protobuf.NewNamed("fake", "Kind")},
+			"p.Kind = KindToProto(v.Kind)\n",
+			"v.Kind = KindFromProto(p.Kind)\n",
+		},
+		{
+			&protobuf.Field{Name: "kinds", GoName: "Kinds", Type: protobuf.NewNamed("fake", "Kind"), Repeated: true},
+			"if v.Kinds != nil {\np.Kinds = make([]fakepb.Kind, len(v.Kinds))\nfor i, x := range v.Kinds {\np.Kinds[i] = KindToProto(x)\n}\n}\n",
+			"if p.Kinds != nil {\nv.Kinds = make([]Kind, len(p.Kinds))\nfor i, x := range p.Kinds {\nv.Kinds[i] = KindFromProto(x)\n}\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "bar", GoName: "Bar", Type: protobuf.NewNamed("fake", "Bar")},
+			"p.Bar = BarToProto(v.Bar)\n",
+			"v.Bar = BarFromProto(p.Bar)\n",
+		},
+		{
+			&protobuf.Field{Name: "main_bar", GoName: "MainBar", Type: protobuf.NewNamed("fake", "Bar")},
+			"p.MainBar = BarToProto(&v.MainBar)\n",
+			"if m := BarFromProto(p.MainBar); m != nil {\nv.MainBar = *m\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "bars", GoName: "Bars", Type: protobuf.NewNamed("fake", "Bar"), Repeated: true},
+			"if v.Bars != nil {\np.Bars = make([]*fakepb.Bar, len(v.Bars))\nfor i, x := range v.Bars {\np.Bars[i] = BarToProto(&x)\n}\n}\n",
+			"if p.Bars != nil {\nv.Bars = make([]Bar, len(p.Bars))\nfor i, x := range p.Bars {\nif m1 := BarFromProto(x); m1 != nil {\nv.Bars[i] = *m1\n}\n}\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "data", GoName: "Data", Type: protobuf.NewBasic("bytes")},
+			"p.Data = v.Data\n",
+			"v.Data = p.Data\n",
+		},
+		{
+			&protobuf.Field{Name: "id", GoName: "ID", Type: protobuf.NewAlias(protobuf.NewNamed("fake", "ID"), protobuf.NewBasic("string"))},
+			"p.Id = string(v.ID)\n",
+			"v.ID = ID(p.Id)\n",
+		},
+		{
+			&protobuf.Field{Name: "at", GoName: "At", Type: protobuf.NewNamed("google.protobuf", "Timestamp")},
+			"p.At = timestamppb.New(v.At)\n",
+			"if p.At != nil {\nv.At = p.At.AsTime()\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "deleted_at", GoName: "DeletedAt", Type: protobuf.NewNamed("google.protobuf", "Timestamp")},
+			"if v.DeletedAt != nil {\np.DeletedAt = timestamppb.New(*v.DeletedAt)\n}\n",
+			"if p.DeletedAt != nil {\nval := p.DeletedAt.AsTime()\nv.DeletedAt = &val\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "took", GoName: "Took", Type: protobuf.NewNamed("google.protobuf", "Duration")},
+			"p.Took = durationpb.New(v.Took)\n",
+			"if p.Took != nil {\nv.Took = p.Took.AsDuration()\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "note", GoName: "Note", Type: protobuf.NewBasic("string")},
+			"if v.Note.Valid {\np.Note = v.Note.String\n}\n",
+			"if p.Note != \"\" {\nv.Note = sql.NullString{String: p.Note, Valid: true}\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "note", GoName: "Note", Type: protobuf.NewNamed("google.protobuf", "StringValue")},
+			"if v.Note.Valid {\np.Note = wrapperspb.String(v.Note.String)\n}\n",
+			"if p.Note != nil {\nv.Note = sql.NullString{String: p.Note.GetValue(), Valid: true}\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "score", GoName: "Score", Type: protobuf.NewNamed("google.protobuf", "Int64Value")},
+			"if v.Score != nil {\np.Score = wrapperspb.Int64(int64(*v.Score))\n}\n",
+			"if p.Score != nil {\nval := int(p.Score.GetValue())\nv.Score = &val\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "labels", GoName: "Labels", Type: protobuf.NewMap(protobuf.NewBasic("string"), protobuf.NewBasic("int64"))},
+			"if v.Labels != nil {\np.Labels = make(map[string]int64, len(v.Labels))\nfor k, x := range v.Labels {\np.Labels[k] = int64(x)\n}\n}\n",
+			"if p.Labels != nil {\nv.Labels = make(map[string]int, len(p.Labels))\nfor k, x := range p.Labels {\nv.Labels[k] = int(x)\n}\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "bars_by_id", GoName: "BarsByID", Type: protobuf.NewMap(protobuf.NewAlias(protobuf.NewNamed("fake", "ID"), protobuf.NewBasic("string")), protobuf.NewNamed("fake", "Bar"))},
+			"if v.BarsByID != nil {\np.BarsById = make(map[string]*fakepb.Bar, len(v.BarsByID))\nfor k, x := range v.BarsByID {\np.BarsById[string(k)] = BarToProto(x)\n}\n}\n",
+			"if p.BarsById != nil {\nv.BarsByID = make(map[ID]*Bar, len(p.BarsById))\nfor k, x := range p.BarsById {\nv.BarsByID[ID(k)] = BarFromProto(x)\n}\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "meta", GoName: "Meta", Type: protobuf.NewNamed("", "Meta")},
+			"{\nval := new(fakepb.Foo_Meta)\nval.Name = v.Meta.Name\np.Meta = val\n}\n",
+			"if p.Meta != nil {\nvar val struct{Name string}\nval.Name = p.Meta.Name\nv.Meta = val\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "page", GoName: "Page", Type: protobuf.NewNamed("fake", "PageBar")},
+			"p.Page = PageBarToProto(&v.Page)\n",
+			"if m := PageBarFromProto(p.Page); m != nil {\nv.Page = *m\n}\n",
+		},
+	}
+
+	for _, c := range cases {
+		conv, err := ctx.fieldConverter(msg, foo, c.field)
+		s.NoError(err, c.field.Name)
+		s.Equal(c.to, conv.toProto("p."+pbFieldName(c.field), "v."+c.field.GoName), c.field.Name)
+		s.Equal(c.from, conv.fromProto("v."+c.field.GoName, "p."+pbFieldName(c.field)), c.field.Name)
+	}
+}
+
+func (s *ConvertSuite) TestFieldConvertersNotSupported() {
+	ctx := newContext(nil, s.fakePkg())
+	foo := ctx.pkg.Scope().Lookup("Foo").Type()
+
+	fields := []*protobuf.Field{
+		{Name: "missing", GoName: "Missing", Type: protobuf.NewBasic("string")},
+		{Name: "name", GoName: "Name", Type: protobuf.NewBasic("int64")},
+		{Name: "empty", GoName: "Empty", Type: protobuf.NewNamed("google.protobuf", "Timestamp")},
+		{Name: "took", GoName: "Took", Type: protobuf.NewNamed("google.protobuf", "Timestamp")},
+		{Name: "meta", GoName: "Meta", Type: protobuf.NewNamed("", "Missing")},
+		{Name: "labels", GoName: "Labels", Type: protobuf.NewMap(protobuf.NewNamed("fake", "Bar"), protobuf.NewBasic("int64"))},
+		{Name: "data", GoName: "Data", Type: protobuf.NewNamed("google.protobuf", "Struct")},
+	}
+
+	for _, f := range fields {
+		_, err := ctx.fieldConverter(&protobuf.Message{Name: "Foo"}, foo, f)
+		s.Error(err, f.Name)
+	}
+}
+
+func (s *ConvertSuite) TestWriteMessageFuncs() {
+	ctx := newContext(nil, s.fakePkg())
+
+	var buf bytes.Buffer
+	s.g.writeMessageFuncs(ctx, &buf, &protobuf.Message{
+		Name: "Bar",
+		Fields: []*protobuf.Field{
+			{Name: "name", GoName: "Name", Type: protobuf.NewBasic("string")},
+			{Name: "missing", GoName: "Missing", Type: protobuf.NewBasic("string")},
+		},
+	})
+	s.g.writeMessageFuncs(ctx, &buf, &protobuf.Message{Name: "FooRequest"})
+	s.g.writeMessageFuncs(ctx, &buf, &protobuf.Message{Name: "Baz"})
+	s.g.writeMessageFuncs(ctx, &buf, &protobuf.Message{
+		Name:    "PageBar",
+		Generic: "Page[Bar]",
+		Fields: []*protobuf.Field{
+			{Name: "items", GoName: "Items", Type: protobuf.NewNamed("fake", "Bar"), Repeated: true},
+		},
+	})
+	s.g.writeMessageFuncs(ctx, &buf, &protobuf.Message{Name: "Page"})
+
+	circle := protobuf.NewNamed("fake", "Circle")
+	circle.SetSource(scanner.NewNamed("fake", "Circle"))
+	square := protobuf.NewNamed("fake", "Square")
+	squareSrc := scanner.NewNamed("fake", "Square")
+	squareSrc.SetNullable(true)
+	square.SetSource(squareSrc)
+	s.g.writeMessageFuncs(ctx, &buf, &protobuf.Message{
+		Name: "Drawing",
+		Oneofs: []*protobuf.Oneof{{
+			Name:   "shape",
+			GoName: "Shape",
+			Fields: []*protobuf.Field{
+				{Name: "circle", Type: circle},
+				{Name: "square", Type: square},
+			},
+		}},
+	})
+
+	s.Equal(expectedMessageFuncs, s.render(ctx, &buf))
+}
+
+const expectedGeneratedFile = `package subpkg
+
+import (
+	"gopkg.in/src-d/proteus.v1/fixtures/subpkg/subpkgpb"
+)
+
+func PointToProto(v *Point) *subpkgpb.Point {
+	if v == nil {
+		return nil
+	}
+
+	p := new(subpkgpb.Point)
+	p.X = int64(v.X)
+	p.Y = int64(v.Y)
+	return p
+}
+
+func PointFromProto(p *subpkgpb.Point) *Point {
+	if p == nil {
+		return nil
+	}
+
+	v := new(Point)
+	v.X = int(p.X)
+	v.Y = int(p.Y)
+	return v
+}
+`
+
+func (s *ConvertSuite) TestGenerate() {
+	pkg := "gopkg.in/src-d/proteus.v1/fixtures/subpkg"
+	scanner, err := scanner.New(pkg)
+	s.Nil(err)
+
+	pkgs, err := scanner.Scan()
+	s.Nil(err)
+
+	r := resolver.New()
+	r.Resolve(pkgs)
+
+	t := protobuf.NewTransformer()
+	t.SetTarget(protobuf.TargetGolang)
+	proto, err := t.Transform(pkgs[0])
+	s.Nil(err)
+	s.Nil(s.g.Generate(proto, pkg))
+
+	data, err := ioutil.ReadFile(projectPath("fixtures/subpkg/convert.proteus.go"))
+	s.Nil(err)
+	s.Equal(expectedGeneratedFile, string(data))
+
+	s.Nil(os.Remove(projectPath("fixtures/subpkg/convert.proteus.go")))
+}
+
+const testPkg = `package fake
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Color string
+
+const (
+	Red   Color = "red"
+	Blue  Color = "blue"
+	Azure Color = "blue"
+)
+
+type Kind int
+
+type Defined int
+
+func DefinedToProto(v Defined) int { return 0 }
+func DefinedFromProto(v int) Defined { return 0 }
+
+type ID string
+
+type Bar struct {
+	Name string
+}
+
+type Page[T any] struct {
+	Items []T
+}
+
+type Foo struct {
+	Name      string
+	Age       int
+	Nick      *string
+	Score     *int
+	Kind      Kind
+	Kinds     []Kind
+	Bar       *Bar
+	MainBar   Bar
+	Bars      []Bar
+	Data      []byte
+	ID        ID
+	Empty     struct{}
+	At        time.Time
+	DeletedAt *time.Time
+	Took      time.Duration
+	Note      sql.NullString
+	Labels    map[string]int
+	BarsByID  map[ID]*Bar
+	Meta      struct{ Name string }
+	Page      Page[Bar]
+}
+
+type Shape interface{ isShape() }
+
+type Circle struct {
+	Radius int
+}
+
+func (Circle) isShape() {}
+
+type Square struct {
+	Side int
+}
+
+func (*Square) isShape() {}
+
+type Drawing struct {
+	Shape Shape
+}
+
+type Baz struct{}
+
+func BazToProto(v *Baz) *Bar   { return nil }
+func BazFromProto(p *Bar) *Baz { return nil }
+`
+
+func (s *ConvertSuite) fakePkg() *types.Package {
+	fs := token.NewFileSet()
+
+	f, err := parser.ParseFile(fs, "src.go", testPkg, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	config := types.Config{
+		FakeImportC: true,
+		Importer:    importer.Default(),
+	}
+
+	pkg, err := config.Check("fake", fs, []*ast.File{f}, nil)
+	s.Nil(err)
+	return pkg
+}
+
+func (s *ConvertSuite) render(ctx *context, buf *bytes.Buffer) string {
+	src, err := format.Source(append(ctx.Header(), buf.Bytes()...))
+	s.Nil(err)
+	return string(src)
+}
+
+func pbFieldName(f *protobuf.Field) string {
+	return generator.CamelCase(f.Name)
+}
+
+func TestConvertSuite(t *testing.T) {
+	suite.Run(t, new(ConvertSuite))
+}
+
+// projectPath returns the absolute path of the given file of the project,
+// found from the directory of the package being tested.
+func projectPath(path string) string {
+	abs, err := filepath.Abs(filepath.Join("..", path))
+	if err != nil {
+		panic(err)
+	}
+	return abs
+}
@@ -0,0 +1,83 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+
+	"gopkg.in/src-d/proteus.v1/protobuf"
+)
+
+// writeEnumFuncs writes the functions that convert between the values of
+// the Go enum of the given protobuf enum and the values of the protobuf enum.
+// Enums whose underlying type is an integer have the same values in both, so
+// they are just cast. The values of the enums whose underlying type is string
+// are converted one by one.
+func (g *Generator) writeEnumFuncs(ctx *context, buf *bytes.Buffer, e *protobuf.Enum) {
+	obj, ok := ctx.pkg.Scope().Lookup(e.Name).(*types.TypeName)
+	if !ok {
+		return
+	}
+
+	basic, ok := obj.Type().Underlying().(*types.Basic)
+	if !ok {
+		return
+	}
+
+	var (
+		toName   = e.Name + toProto
+		fromName = e.Name + fromProto
+		genTo    = !ctx.isNameDefined(toName)
+		genFrom  = !ctx.isNameDefined(fromName)
+	)
+	if !genTo && !genFrom {
+		return
+	}
+
+	pbType := fmt.Sprintf("%s.%s", ctx.pbQualifier(ctx.pkg), e.Name)
+	values := uniqueValues(e)
+	if genTo {
+		fmt.Fprintf(buf, "func %s(v %s) %s {\n", toName, e.Name, pbType)
+		if basic.Info()&types.IsString == 0 {
+			fmt.Fprintf(buf, "return %s(v)\n}\n\n", pbType)
+		} else {
+			buf.WriteString("switch v {\n")
+			for _, v := range values {
+				fmt.Fprintf(buf, "case %s:\nreturn %s_%s\n", v.GoName, pbType, v.Name)
+			}
+			buf.WriteString("}\nreturn 0\n}\n\n")
+		}
+	}
+
+	if genFrom {
+		fmt.Fprintf(buf, "func %s(v %s) %s {\n", fromName, pbType, e.Name)
+		if basic.Info()&types.IsString == 0 {
+			fmt.Fprintf(buf, "return %s(v)\n}\n\n", e.Name)
+		} else {
+			buf.WriteString("switch v {\n")
+			for _, v := range values {
+				fmt.Fprintf(buf, "case %s_%s:\nreturn %s\n", pbType, v.Name, v.GoName)
+			}
+			buf.WriteString("}\nreturn \"\"\n}\n\n")
+		}
+	}
+}
+
+// uniqueValues returns the values of the given enum that are declared for Go
+// constants, skipping the ones whose value is the same as the one of a
+// previous value, which are aliases.
+func uniqueValues(e *protobuf.Enum) []*protobuf.EnumValue {
+	var (
+		values []*protobuf.EnumValue
+		seen   = make(map[int32]struct{})
+	)
+	for _, v := range e.Values {
+		if _, ok := seen[v.Value]; ok || v.GoName == "" {
+			continue
+		}
+
+		seen[v.Value] = struct{}{}
+		values = append(values, v)
+	}
+	return values
+}
@@ -0,0 +1,522 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+
+	"github.com/gogo/protobuf/protoc-gen-gogo/generator"
+	"gopkg.in/src-d/proteus.v1/internal/gen"
+	"gopkg.in/src-d/proteus.v1/loader"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// converter converts the values of a Go type to and from the values of the
+// type generated by protoc-gen-go for its protobuf type.
+type converter struct {
+	// goType and pbType return the Go type and the generated type in the
+	// generated code. They are only called if the types are used, as they
+	// may import packages.
+	goType, pbType func() string
+	// toProto and fromProto return the statements that assign the
+	// converted value of src to dst.
+	toProto, fromProto func(dst, src string) string
+}
+
+// fieldConverter converts a field of a Go struct to and from the field of
+// the type generated for its message.
+type fieldConverter struct {
+	*converter
+	goName, pbName string
+}
+
+// writeMessageFuncs writes the functions that convert between the Go struct
+// of the given message and the type generated for the message. Messages
+// without a Go struct in the package, such as the ones generated for the
+// arguments and results of RPCs, are skipped.
+func (g *Generator) writeMessageFuncs(ctx *context, buf *bytes.Buffer, msg *protobuf.Message) {
+	typ := ctx.messageType(msg)
+	if typ == nil {
+		return
+	}
+
+	var (
+		toName   = msg.Name + toProto
+		fromName = msg.Name + fromProto
+		genTo    = !ctx.isNameDefined(toName)
+		genFrom  = !ctx.isNameDefined(fromName)
+	)
+	if !genTo && !genFrom {
+		return
+	}
+
+	var (
+		fields = ctx.fieldConverters(msg, typ)
+		goType = ctx.TypeString(typ)
+		pbType = fmt.Sprintf("%s.%s", ctx.pbQualifier(ctx.pkg), msg.GoName())
+	)
+	if genTo {
+		fmt.Fprintf(buf, "func %s(v *%s) *%s {\n", toName, goType, pbType)
+		fmt.Fprintf(buf, "if v == nil {\nreturn nil\n}\n\np := new(%s)\n", pbType)
+		buf.WriteString(convertFields(fields, toProto, "v", "p"))
+		buf.WriteString("return p\n}\n\n")
+	}
+
+	if genFrom {
+		fmt.Fprintf(buf, "func %s(p *%s) *%s {\n", fromName, pbType, goType)
+		fmt.Fprintf(buf, "if p == nil {\nreturn nil\n}\n\nv := new(%s)\n", goType)
+		buf.WriteString(convertFields(fields, fromProto, "v", "p"))
+		buf.WriteString("return v\n}\n\n")
+	}
+}
+
+// messageType returns the Go struct type of the given message, if it is
+// declared for one of the package.
+func (c *context) messageType(msg *protobuf.Message) types.Type {
+	if msg.Generic != "" {
+		if inst := c.instance(msg.Generic); inst != nil {
+			return inst
+		}
+		return nil
+	}
+
+	obj, ok := c.pkg.Scope().Lookup(msg.Name).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+
+	named, ok := obj.Type().(*types.Named)
+	if !ok || named.TypeParams().Len() > 0 {
+		return nil
+	}
+
+	if _, ok := named.Underlying().(*types.Struct); !ok {
+		return nil
+	}
+	return named
+}
+
+// fieldConverters returns the converters of the fields and oneofs of the
+// given message, whose Go struct is of the given type. The ones that cannot
+// be converted are reported and skipped.
+func (c *context) fieldConverters(msg *protobuf.Message, st types.Type) []*fieldConverter {
+	var fields []*fieldConverter
+	for _, f := range msg.Fields {
+		conv, err := c.fieldConverter(msg, st, f)
+		if err != nil {
+			report.Warn("field %q of message %q cannot be converted, ignoring it: %s", f.Name, msg.Name, err)
+			continue
+		}
+
+		fields = append(fields, &fieldConverter{conv, f.GoName, generator.CamelCase(f.Name)})
+	}
+
+	for _, o := range msg.Oneofs {
+		conv, err := c.oneof(msg, st, o)
+		if err != nil {
+			report.Warn("oneof %q of message %q cannot be converted, ignoring it: %s", o.Name, msg.Name, err)
+			continue
+		}
+
+		fields = append(fields, &fieldConverter{conv, o.GoName, generator.CamelCase(o.Name)})
+	}
+
+	return fields
+}
+
+// convertFields returns the statements converting the given fields in the
+// given direction between the Go struct goVar and the generated type pbVar.
+func convertFields(fields []*fieldConverter, direction, goVar, pbVar string) string {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		goField := goVar + "." + f.goName
+		pbField := pbVar + "." + f.pbName
+		if direction == toProto {
+			buf.WriteString(f.toProto(pbField, goField))
+		} else {
+			buf.WriteString(f.fromProto(goField, pbField))
+		}
+	}
+	return buf.String()
+}
+
+// fieldConverter returns the converter of the Go struct field of the given
+// struct type for the given field of the given message.
+func (c *context) fieldConverter(msg *protobuf.Message, st types.Type, f *protobuf.Field) (*converter, error) {
+	v, err := c.Field(st, f.GoName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !f.Repeated {
+		p := setIfNonZero
+		if f.Optional {
+			p = setIfPresent
+		}
+		return c.converter(msg, v.Type(), f.Type, p)
+	}
+
+	slice, ok := v.Type().Underlying().(*types.Slice)
+	if !ok {
+		return nil, fmt.Errorf("repeated type %s is not a slice", v.Type())
+	}
+
+	elem, err := c.converter(msg, slice.Elem(), f.Type, setAlways)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.repeated(elem, v.Type()), nil
+}
+
+// converter returns the converter of the given Go type for the given
+// protobuf type of a field of the given message, whose values that are not
+// set are told apart with the given presence.
+func (c *context) converter(msg *protobuf.Message, typ types.Type, pt protobuf.Type, p presence) (*converter, error) {
+	if alias, ok := pt.(*protobuf.Alias); ok {
+		pt = alias.Underlying
+	}
+
+	named, ptr := namedType(typ)
+	switch pt := pt.(type) {
+	case *protobuf.Map:
+		return c.mapOf(msg, typ, pt)
+	case *protobuf.Named:
+		if pt.Package == "google.protobuf" {
+			break
+		}
+
+		if pt.Package == "" {
+			return c.nested(msg, typ, pt.Name)
+		}
+
+		if named == nil {
+			break
+		}
+
+		if loader.IsProtocMessage(named) {
+			return c.protoc(typ, ptr)
+		}
+
+		if _, ok := named.Underlying().(*types.Struct); ok && gen.SQLNullValue(named) == nil {
+			return c.message(named, ptr, pt), nil
+		}
+	}
+
+	return c.value(typ, pt, p)
+}
+
+// message returns the converter of the given Go struct, or a pointer to it,
+// for the given message, which uses the conversion functions of the struct.
+func (c *context) message(named *types.Named, ptr bool, pt *protobuf.Named) *converter {
+	obj := named.Obj()
+	funcName := func(direction string) string {
+		return c.funcName(obj, direction)
+	}
+	pbType := func() string {
+		return fmt.Sprintf("*%s.%s", c.pbQualifier(obj.Pkg()), obj.Name())
+	}
+
+	// Messages of instantiations of generic structs are declared in the
+	// package using them, named after the instantiation.
+	if named.TypeArgs().Len() > 0 {
+		funcName = func(direction string) string {
+			return pt.Name + direction
+		}
+		pbType = func() string {
+			return fmt.Sprintf("*%s.%s", c.pbQualifier(c.pkg), pt.Name)
+		}
+	}
+
+	conv := &converter{
+		goType: func() string {
+			if ptr {
+				return "*" + c.TypeString(named)
+			}
+			return c.TypeString(named)
+		},
+		pbType: pbType,
+	}
+
+	if ptr {
+		conv.toProto = func(dst, src string) string {
+			return fmt.Sprintf("%s = %s(%s)\n", dst, funcName(toProto), src)
+		}
+		conv.fromProto = func(dst, src string) string {
+			return fmt.Sprintf("%s = %s(%s)\n", dst, funcName(fromProto), src)
+		}
+		return conv
+	}
+
+	conv.toProto = func(dst, src string) string {
+		return fmt.Sprintf("%s = %s(&%s)\n", dst, funcName(toProto), src)
+	}
+	conv.fromProto = func(dst, src string) string {
+		return c.Block(func(suffix string) string {
+			m := "m" + suffix
+			return fmt.Sprintf("if %s := %s(%s); %s != nil {\n%s = *%s\n}\n", m, funcName(fromProto), src, m, dst, m)
+		})
+	}
+	return conv
+}
+
+// protoc returns the converter of the given pointer to a message generated
+// by protoc, which is the type of the generated field too, so it is assigned
+// as it is.
+func (c *context) protoc(typ types.Type, ptr bool) (*converter, error) {
+	if !ptr {
+		return nil, fmt.Errorf("conversion of %s is not supported, as messages generated by protoc can not be copied, use a pointer instead", typ)
+	}
+
+	assign := func(dst, src string) string {
+		return fmt.Sprintf("%s = %s\n", dst, src)
+	}
+	return &converter{
+		goType:    func() string { return c.TypeString(typ) },
+		pbType:    func() string { return c.TypeString(typ) },
+		toProto:   assign,
+		fromProto: assign,
+	}, nil
+}
+
+// nested returns the converter of the given anonymous Go struct, or a
+// pointer to it, for the message with the given name nested in the given
+// message. As anonymous structs have no conversion functions, their fields
+// are converted in place.
+func (c *context) nested(msg *protobuf.Message, typ types.Type, name string) (*converter, error) {
+	var nested *protobuf.Message
+	for _, m := range msg.Messages {
+		if m.Name == name {
+			nested = m
+		}
+	}
+
+	st, ptr := typ, false
+	if p, ok := typ.(*types.Pointer); ok {
+		st, ptr = p.Elem(), true
+	}
+
+	if _, ok := st.(*types.Struct); !ok || nested == nil {
+		return nil, fmt.Errorf("conversion of %s to nested message %s is not supported", typ, name)
+	}
+
+	fields := c.fieldConverters(nested, st)
+	pbElem := func() string {
+		return fmt.Sprintf("%s.%s", c.pbQualifier(c.pkg), nested.GoName())
+	}
+
+	conv := &converter{
+		goType: func() string { return c.TypeString(typ) },
+		pbType: func() string { return "*" + pbElem() },
+	}
+	conv.toProto = func(dst, src string) string {
+		return c.Block(func(suffix string) string {
+			val := "val" + suffix
+			stmts := fmt.Sprintf(
+				"%s := new(%s)\n%s%s = %s\n",
+				val, pbElem(), convertFields(fields, toProto, src, val), dst, val,
+			)
+			if ptr {
+				return fmt.Sprintf("if %s != nil {\n%s}\n", src, stmts)
+			}
+			return fmt.Sprintf("{\n%s}\n", stmts)
+		})
+	}
+	conv.fromProto = func(dst, src string) string {
+		return c.Block(func(suffix string) string {
+			val := "val" + suffix
+			decl := fmt.Sprintf("var %s %s\n", val, c.TypeString(st))
+			if ptr {
+				decl = fmt.Sprintf("%s := new(%s)\n", val, c.TypeString(st))
+			}
+			return fmt.Sprintf(
+				"if %s != nil {\n%s%s%s = %s\n}\n",
+				src, decl, convertFields(fields, fromProto, val, src), dst, val,
+			)
+		})
+	}
+	return conv, nil
+}
+
+// oneof returns the converter of the Go interface field of the given struct
+// type for the given oneof of the given message. The values of the
+// implementations of the interface are converted to and from the values of
+// the types generated to wrap every field of the oneof.
+func (c *context) oneof(msg *protobuf.Message, st types.Type, oneof *protobuf.Oneof) (*converter, error) {
+	v, err := c.Field(st, oneof.GoName)
+	if err != nil {
+		return nil, err
+	}
+
+	type oneofCase struct {
+		*converter
+		wrapper, field string
+	}
+
+	var cases []*oneofCase
+	for _, f := range oneof.Fields {
+		impl, ok := f.Type.Source().(*scanner.Named)
+		if !ok {
+			return nil, fmt.Errorf("field %q is not a named type", f.Name)
+		}
+
+		obj, ok := c.Lookup(impl.Path, impl.Name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("type %s is not found", impl)
+		}
+
+		// Both values and pointers implement the interface if the
+		// implementation is not nullable.
+		typs := []types.Type{types.NewPointer(obj.Type())}
+		if !impl.IsNullable() {
+			typs = append([]types.Type{obj.Type()}, typs...)
+		}
+
+		for _, typ := range typs {
+			conv, err := c.converter(msg, typ, f.Type, setAlways)
+			if err != nil {
+				return nil, err
+			}
+
+			field := generator.CamelCase(f.Name)
+			cases = append(cases, &oneofCase{conv, msg.GoName() + "_" + field, field})
+		}
+	}
+
+	// The interface of the generated wrappers is not exported, so the
+	// generated type of the oneof cannot be referred to.
+	return &converter{
+		goType: func() string { return c.TypeString(v.Type()) },
+		toProto: func(dst, src string) string {
+			return c.Block(func(suffix string) string {
+				x, val := "x"+suffix, "val"+suffix
+				var buf bytes.Buffer
+				fmt.Fprintf(&buf, "switch %s := %s.(type) {\n", x, src)
+				for _, cs := range cases {
+					fmt.Fprintf(&buf, "case %s:\n", cs.goType())
+					fmt.Fprintf(&buf, "%s := new(%s.%s)\n", val, c.pbQualifier(c.pkg), cs.wrapper)
+					buf.WriteString(cs.toProto(val+"."+cs.field, x))
+					fmt.Fprintf(&buf, "%s = %s\n", dst, val)
+				}
+				buf.WriteString("}\n")
+				return buf.String()
+			})
+		},
+		fromProto: func(dst, src string) string {
+			return c.Block(func(suffix string) string {
+				x := "x" + suffix
+				var buf bytes.Buffer
+				fmt.Fprintf(&buf, "switch %s := %s.(type) {\n", x, src)
+				seen := make(map[string]bool)
+				for _, cs := range cases {
+					// Values and pointers share the wrapper, which is
+					// converted back to values.
+					if seen[cs.wrapper] {
+						continue
+					}
+					seen[cs.wrapper] = true
+
+					fmt.Fprintf(&buf, "case *%s.%s:\n", c.pbQualifier(c.pkg), cs.wrapper)
+					buf.WriteString(cs.fromProto(dst, x+"."+cs.field))
+				}
+				buf.WriteString("}\n")
+				return buf.String()
+			})
+		},
+	}, nil
+}
+
+// mapOf returns the converter of the given Go map for the given protobuf
+// map of a field of the given message.
+func (c *context) mapOf(msg *protobuf.Message, typ types.Type, pt *protobuf.Map) (*converter, error) {
+	m, ok := typ.Underlying().(*types.Map)
+	if !ok {
+		return nil, fmt.Errorf("map type %s is not a map", typ)
+	}
+
+	key := pt.Key
+	if alias, ok := key.(*protobuf.Alias); ok {
+		key = alias.Underlying
+	}
+
+	basic, ok := key.(*protobuf.Basic)
+	if !ok {
+		return nil, fmt.Errorf("map key %s is not a scalar", pt.Key)
+	}
+
+	keys, err := c.scalarCasts(m.Key(), basic.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	elem, err := c.converter(msg, m.Elem(), pt.Value, setAlways)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &converter{
+		goType: func() string { return c.TypeString(typ) },
+		pbType: func() string {
+			return fmt.Sprintf("map[%s]%s", keys.pbType(), elem.pbType())
+		},
+	}
+	conv.toProto = func(dst, src string) string {
+		return c.loop(dst, src, conv.pbType(), keys.toProto, elem.toProto)
+	}
+	conv.fromProto = func(dst, src string) string {
+		return c.loop(dst, src, conv.goType(), keys.fromProto, elem.fromProto)
+	}
+	return conv, nil
+}
+
+// repeated returns the converter of the slices of the given Go type, whose
+// elements are converted with the given converter.
+func (c *context) repeated(elem *converter, typ types.Type) *converter {
+	conv := &converter{
+		goType: func() string { return c.TypeString(typ) },
+		pbType: func() string { return "[]" + elem.pbType() },
+	}
+	conv.toProto = func(dst, src string) string {
+		return c.loop(dst, src, conv.pbType(), nil, elem.toProto)
+	}
+	conv.fromProto = func(dst, src string) string {
+		return c.loop(dst, src, conv.goType(), nil, elem.fromProto)
+	}
+	return conv
+}
+
+// loop returns the statements that make dst of the given type, a slice or a
+// map, and convert every element of src into it. The keys of maps are cast
+// with the given key expression, which is nil for slices.
+func (c *context) loop(dst, src, typ string, key func(string) string, convert func(dst, src string) string) string {
+	return c.Block(func(suffix string) string {
+		i, x := "i"+suffix, "x"+suffix
+		if key != nil {
+			i = "k" + suffix
+		}
+
+		elem := fmt.Sprintf("%s[%s]", dst, i)
+		if key != nil {
+			elem = fmt.Sprintf("%s[%s]", dst, key(i))
+		}
+
+		return fmt.Sprintf(
+			"if %s != nil {\n%s = make(%s, len(%s))\nfor %s, %s := range %s {\n%s}\n}\n",
+			src, dst, typ, src, i, x, src, convert(elem, x),
+		)
+	})
+}
+
+// namedType returns the named type of the given type, which may be a pointer
+// to it, and whether it is a pointer.
+func namedType(typ types.Type) (*types.Named, bool) {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		named, _ := ptr.Elem().(*types.Named)
+		return named, true
+	}
+
+	named, _ := typ.(*types.Named)
+	return named, false
+}
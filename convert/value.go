@@ -0,0 +1,345 @@
+package convert
+
+import (
+	"fmt"
+	"go/types"
+
+	"gopkg.in/src-d/proteus.v1/internal/gen"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+)
+
+// holder is the way a value that may not be set is held on one of the sides
+// of a conversion, e.g. a pointer, a database/sql Null* type or a
+// google.protobuf wrapper type.
+type holder struct {
+	// typ returns the type holding a value of the given type.
+	typ func(elem string) string
+	// isSet returns the expression reporting whether the value held by v is
+	// set, or nil if it is always set.
+	isSet func(v string) string
+	// get returns the expression of the value held by v.
+	get func(v string) string
+	// set returns the statements making dst hold the given value.
+	set func(dst, value string) string
+	// addr reports whether set takes the address of the value, so it must
+	// be a variable.
+	addr bool
+}
+
+// plain holds the values themselves, which are always set.
+var plain = &holder{
+	typ: func(elem string) string { return elem },
+	get: func(v string) string { return v },
+	set: func(dst, value string) string {
+		return fmt.Sprintf("%s = %s\n", dst, value)
+	},
+}
+
+// pointer holds the values in pointers, which are not set if they are nil.
+var pointer = &holder{
+	typ:   func(elem string) string { return "*" + elem },
+	isSet: notNil,
+	get:   func(v string) string { return "*" + v },
+	set: func(dst, value string) string {
+		return fmt.Sprintf("%s = &%s\n", dst, value)
+	},
+	addr: true,
+}
+
+func notNil(v string) string {
+	return v + " != nil"
+}
+
+// sqlNull returns the holder of the values held by the given database/sql
+// Null* type in its field with the given name.
+func (c *context) sqlNull(named *types.Named, field string) *holder {
+	return &holder{
+		typ:   func(string) string { return c.TypeString(named) },
+		isSet: func(v string) string { return v + ".Valid" },
+		get:   func(v string) string { return v + "." + field },
+		set: func(dst, value string) string {
+			return fmt.Sprintf("%s = %s{%s: %s, Valid: true}\n", dst, c.TypeString(named), field, value)
+		},
+	}
+}
+
+// wellKnown returns the holder of the values held by pointers to the given
+// type of a package of google.golang.org/protobuf/types/known. They are got
+// with the given method and created with the given function of the package.
+func (c *context) wellKnown(pkg, typ, method, fn string) *holder {
+	path := "google.golang.org/protobuf/types/known/" + pkg
+	return &holder{
+		typ: func(string) string {
+			return fmt.Sprintf("*%s.%s", c.AddImport(path, pkg), typ)
+		},
+		isSet: notNil,
+		get:   func(v string) string { return fmt.Sprintf("%s.%s()", v, method) },
+		set: func(dst, value string) string {
+			return fmt.Sprintf("%s = %s.%s(%s)\n", dst, c.AddImport(path, pkg), fn, value)
+		},
+	}
+}
+
+// presence is the way the values of a protobuf field that are not set are
+// told apart from the ones that are.
+type presence int
+
+const (
+	// setAlways values are always set, such as the elements of repeated
+	// fields and maps and the fields of oneofs.
+	setAlways presence = iota
+	// setIfNonZero values are not set if they are the zero value, such as
+	// the ones of scalars that are not optional, which are not in the wire
+	// either.
+	setIfNonZero
+	// setIfPresent values are held in pointers, such as the ones of proto3
+	// optional fields.
+	setIfPresent
+)
+
+// nonZero returns the holder of the values of the Go type generated for the
+// given protobuf scalar or enum type, which are not set if they are the zero
+// value.
+func nonZero(pt protobuf.Type) *holder {
+	isSet := func(v string) string { return v + " != 0" }
+	if basic, ok := pt.(*protobuf.Basic); ok {
+		switch basic.Name {
+		case "string":
+			isSet = func(v string) string { return v + ` != ""` }
+		case "bytes":
+			isSet = func(v string) string { return fmt.Sprintf("len(%s) > 0", v) }
+		case "bool":
+			isSet = func(v string) string { return v }
+		}
+	}
+
+	return &holder{typ: plain.typ, isSet: isSet, get: plain.get, set: plain.set}
+}
+
+// casts holds the expressions that convert the values of a Go type to and
+// from the values of the type generated for a protobuf type.
+type casts struct {
+	goType, pbType     func() string
+	toProto, fromProto func(src string) string
+}
+
+// goHolder returns the holder of the given Go type and the type of the
+// values it holds.
+func (c *context) goHolder(typ types.Type) (*holder, types.Type) {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		return pointer, ptr.Elem()
+	}
+
+	if named, ok := typ.(*types.Named); ok {
+		if f := gen.SQLNullValue(named); f != nil {
+			return c.sqlNull(named, f.Name()), f.Type()
+		}
+	}
+
+	return plain, typ
+}
+
+// value returns the converter of the given Go type for the given protobuf
+// type, whose values are copied, such as scalars, enums, the google.protobuf
+// wrapper types and the well-known types of time.Time and time.Duration.
+// The values of the protobuf type that are not set are told apart with the
+// given presence.
+func (c *context) value(typ types.Type, pt protobuf.Type, p presence) (*converter, error) {
+	goHolder, elem := c.goHolder(typ)
+	pbHolder, casts, err := c.pbHolder(elem, pt, p == setIfPresent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Go values that may not be set are left unset for the zero values of
+	// fields without presence, as the marshal methods do.
+	if p == setIfNonZero && pbHolder == plain && goHolder.isSet != nil {
+		pbHolder = nonZero(pt)
+	}
+
+	return &converter{
+		goType:    func() string { return goHolder.typ(casts.goType()) },
+		pbType:    func() string { return pbHolder.typ(casts.pbType()) },
+		toProto:   c.convertHeld(goHolder, pbHolder, casts.toProto),
+		fromProto: c.convertHeld(pbHolder, goHolder, casts.fromProto),
+	}, nil
+}
+
+// wrapperFuncs are the functions of the wrapperspb package that create the
+// values of the google.protobuf wrapper types, with the protobuf type of the
+// values they wrap.
+var wrapperFuncs = map[string][2]string{
+	"DoubleValue": {"Double", "double"},
+	"FloatValue":  {"Float", "float"},
+	"Int64Value":  {"Int64", "int64"},
+	"UInt64Value": {"UInt64", "uint64"},
+	"Int32Value":  {"Int32", "int32"},
+	"UInt32Value": {"UInt32", "uint32"},
+	"BoolValue":   {"Bool", "bool"},
+	"StringValue": {"String", "string"},
+	"BytesValue":  {"Bytes", "bytes"},
+}
+
+// pbHolder returns the holder of the type generated for the given protobuf
+// type and the casts of the values of the given Go type to the values it
+// holds.
+func (c *context) pbHolder(elem types.Type, pt protobuf.Type, optional bool) (*holder, *casts, error) {
+	if alias, ok := pt.(*protobuf.Alias); ok {
+		pt = alias.Underlying
+	}
+
+	held := plain
+	if optional {
+		held = pointer
+	}
+
+	switch pt := pt.(type) {
+	case *protobuf.Basic:
+		casts, err := c.scalarCasts(elem, pt.Name)
+		return held, casts, err
+	case *protobuf.Named:
+		if pt.Package != "google.protobuf" {
+			if named, ok := elem.(*types.Named); ok && isEnum(named) {
+				return held, c.enumCasts(named), nil
+			}
+			break
+		}
+
+		if wrapper, ok := wrapperFuncs[pt.Name]; ok {
+			casts, err := c.scalarCasts(elem, wrapper[1])
+			return c.wellKnown("wrapperspb", pt.Name, "GetValue", wrapper[0]), casts, err
+		}
+
+		switch pt.Name {
+		case "Timestamp":
+			casts, err := c.timeCasts(elem, "Time")
+			return c.wellKnown("timestamppb", pt.Name, "AsTime", "New"), casts, err
+		case "Duration":
+			casts, err := c.timeCasts(elem, "Duration")
+			return c.wellKnown("durationpb", pt.Name, "AsDuration", "New"), casts, err
+		}
+	}
+
+	return nil, nil, fmt.Errorf("conversion of %s to %s is not supported", elem, pt)
+}
+
+// convertHeld returns the conversion of the values held by the from holder
+// to the values held by the to holder, which are cast with the given cast.
+func (c *context) convertHeld(from, to *holder, cast func(string) string) func(dst, src string) string {
+	return func(dst, src string) string {
+		return c.Block(func(suffix string) string {
+			var (
+				value = cast(from.get(src))
+				stmts string
+			)
+			if to.addr {
+				val := "val" + suffix
+				stmts = fmt.Sprintf("%s := %s\n%s", val, value, to.set(dst, val))
+			} else {
+				stmts = to.set(dst, value)
+			}
+
+			switch {
+			case from.isSet != nil:
+				return fmt.Sprintf("if %s {\n%s}\n", from.isSet(src), stmts)
+			case to.addr:
+				return fmt.Sprintf("{\n%s}\n", stmts)
+			}
+			return stmts
+		})
+	}
+}
+
+// pbScalarTypes are the Go types generated by protoc-gen-go for the
+// protobuf scalar types.
+var pbScalarTypes = map[string]types.Type{
+	"double":   types.Typ[types.Float64],
+	"float":    types.Typ[types.Float32],
+	"int32":    types.Typ[types.Int32],
+	"sint32":   types.Typ[types.Int32],
+	"sfixed32": types.Typ[types.Int32],
+	"int64":    types.Typ[types.Int64],
+	"sint64":   types.Typ[types.Int64],
+	"sfixed64": types.Typ[types.Int64],
+	"uint32":   types.Typ[types.Uint32],
+	"fixed32":  types.Typ[types.Uint32],
+	"uint64":   types.Typ[types.Uint64],
+	"fixed64":  types.Typ[types.Uint64],
+	"bool":     types.Typ[types.Bool],
+	"string":   types.Typ[types.String],
+	"bytes":    types.NewSlice(types.Typ[types.Byte]),
+}
+
+// scalarCasts returns the casts of the given Go type to the Go type
+// generated for the given protobuf scalar type, which are only needed if the
+// types are not the same.
+func (c *context) scalarCasts(typ types.Type, name string) (*casts, error) {
+	pbType, ok := pbScalarTypes[name]
+	if !ok || !types.ConvertibleTo(typ, pbType) {
+		return nil, fmt.Errorf("conversion of %s to %s is not supported", typ, name)
+	}
+
+	needed := !types.Identical(typ, pbType)
+	casts := &casts{
+		goType: func() string { return c.TypeString(typ) },
+		pbType: func() string { return c.TypeString(pbType) },
+	}
+	casts.toProto = cast(casts.pbType, needed)
+	casts.fromProto = cast(casts.goType, needed)
+	return casts, nil
+}
+
+// timeCasts returns the casts of the given Go type to the type of the time
+// package with the given name, which must be the same.
+func (c *context) timeCasts(typ types.Type, name string) (*casts, error) {
+	named, ok := typ.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != "time" || named.Obj().Name() != name {
+		return nil, fmt.Errorf("conversion of %s to time.%s is not supported", typ, name)
+	}
+
+	noCast := func(src string) string { return src }
+	return &casts{
+		goType:    func() string { return c.TypeString(typ) },
+		pbType:    func() string { return c.TypeString(typ) },
+		toProto:   noCast,
+		fromProto: noCast,
+	}, nil
+}
+
+// enumCasts returns the casts of the values of the given Go enum to the
+// values of its protobuf enum, which use the conversion functions of the
+// enum.
+func (c *context) enumCasts(named *types.Named) *casts {
+	obj := named.Obj()
+	return &casts{
+		goType: func() string { return c.TypeString(named) },
+		pbType: func() string {
+			return fmt.Sprintf("%s.%s", c.pbQualifier(obj.Pkg()), obj.Name())
+		},
+		toProto:   call(c, obj, toProto),
+		fromProto: call(c, obj, fromProto),
+	}
+}
+
+func isEnum(named *types.Named) bool {
+	_, ok := named.Underlying().(*types.Basic)
+	return ok
+}
+
+// cast returns the expression casting src to the given type if needed.
+func cast(typ func() string, needed bool) func(src string) string {
+	return func(src string) string {
+		if !needed {
+			return src
+		}
+		return fmt.Sprintf("%s(%s)", typ(), src)
+	}
+}
+
+// call returns the expression calling the function that converts the values
+// of the given Go type in the given direction with src.
+func call(c *context, obj *types.TypeName, direction string) func(src string) string {
+	return func(src string) string {
+		return fmt.Sprintf("%s(%s)", c.funcName(obj, direction), src)
+	}
+}
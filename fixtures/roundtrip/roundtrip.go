@@ -0,0 +1,86 @@
+package roundtrip
+
+import "time"
+
+// Kind ...
+//proteus:generate
+type Kind int
+
+const (
+	Unknown Kind = iota
+	Member
+	Admin
+)
+
+// Color ...
+//proteus:generate
+type Color string
+
+const (
+	Red  Color = "red"
+	Blue Color = "blue"
+)
+
+// Email ...
+type Email string
+
+// Tags ...
+type Tags []string
+
+// Shape ...
+//proteus:generate Circle Square
+type Shape interface {
+	isShape()
+}
+
+// Circle ...
+type Circle struct {
+	Radius int
+}
+
+func (Circle) isShape() {}
+
+// Square ...
+type Square struct {
+	Side float64
+}
+
+func (*Square) isShape() {}
+
+// Point ...
+type Point struct {
+	X int32
+	Y int32
+}
+
+// Record ...
+//proteus:generate
+type Record struct {
+	Name   string
+	Age    int
+	Score  uint64
+	Delta  int32
+	Ratio  float64
+	Weight float32
+	OK     bool
+	Data   []byte
+	Ints   []int64
+	Names  []string
+	Email  Email
+	Tags   Tags
+	Kind   Kind
+	Kinds  []Kind
+	Color  Color
+	Counts map[string]int32
+	Points map[int64]*Point
+	Origin Point
+	Target *Point
+	Path   []*Point
+	At     time.Time
+	Took   time.Duration
+	Meta   struct {
+		Source string
+		Labels []string
+	}
+	Shape Shape
+}
@@ -0,0 +1,147 @@
+//go:build roundtrip
+
+package roundtrip
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// These tests are run by the tests of the marshal package, which generate
+// the marshal methods of the package and its descriptor set, whose path is
+// in the PROTEUS_DESCRIPTOR_SET environment variable.
+
+func TestRoundTrip(t *testing.T) {
+	desc := recordDescriptor(t)
+
+	for name, rec := range map[string]*Record{
+		"empty":  new(Record),
+		"circle": fullRecord(Circle{Radius: 3}),
+		"square": fullRecord(&Square{Side: 2.5}),
+	} {
+		data, err := rec.Marshal()
+		require.NoError(t, err, name)
+		require.Equal(t, len(data), rec.ProtoSize(), name)
+
+		msg := dynamicpb.NewMessage(desc)
+		require.NoError(t, proto.Unmarshal(data, msg), name)
+		require.Empty(t, msg.GetUnknown(), "all fields of %s are known", name)
+		require.Equal(t, proto.Size(msg), rec.ProtoSize(), name)
+
+		data, err = proto.Marshal(msg)
+		require.NoError(t, err, name)
+
+		var got Record
+		require.NoError(t, got.Unmarshal(data), name)
+		require.Equal(t, rec, &got, name)
+	}
+}
+
+func TestRoundTripValues(t *testing.T) {
+	desc := recordDescriptor(t)
+	fields := desc.Fields()
+
+	data, err := fullRecord(&Square{Side: 2.5}).Marshal()
+	require.NoError(t, err)
+
+	msg := dynamicpb.NewMessage(desc)
+	require.NoError(t, proto.Unmarshal(data, msg))
+
+	require.Equal(t, "John", msg.Get(fields.ByName("name")).String())
+	require.Equal(t, int64(42), msg.Get(fields.ByName("age")).Int())
+	require.Equal(t, float32(1.5), float32(msg.Get(fields.ByName("weight")).Float()))
+	require.Equal(t, "john@example.com", msg.Get(fields.ByName("email")).String())
+	require.Equal(t, "blue", msg.Get(fields.ByName("color")).String())
+	require.Equal(t, protoreflect.EnumNumber(Admin), msg.Get(fields.ByName("kind")).Enum())
+	require.Equal(t, 3, msg.Get(fields.ByName("ints")).List().Len())
+	require.Equal(t, int64(7), msg.Get(fields.ByName("points")).Map().Get(protoreflect.ValueOfInt64(1).MapKey()).Message().Get(desc.Fields().ByName("points").MapValue().Message().Fields().ByName("y")).Int())
+	require.Equal(t, "api", msg.Get(fields.ByName("meta")).Message().Get(fields.ByName("meta").Message().Fields().ByName("source")).String())
+	require.Equal(t, protoreflect.Name("shape_square"), msg.WhichOneof(desc.Oneofs().ByName("shape")).Name())
+
+	at := msg.Get(fields.ByName("at")).Message()
+	require.Equal(t, int64(1500000000), at.Get(at.Descriptor().Fields().ByName("seconds")).Int())
+
+	took := msg.Get(fields.ByName("took")).Message()
+	require.Equal(t, int64(90), took.Get(took.Descriptor().Fields().ByName("seconds")).Int())
+
+	// Packed and unpacked encodings of repeated scalars are both accepted
+	// when unmarshaling.
+	var unpacked []byte
+	for _, v := range []int64{1, -2, 300} {
+		unpacked = appendField(unpacked, fields.ByName("ints").Number(), v)
+	}
+
+	var got Record
+	require.NoError(t, got.Unmarshal(unpacked))
+	require.Equal(t, []int64{1, -2, 300}, got.Ints)
+}
+
+// appendField appends the given value of the field with the given number to
+// the data in the unpacked varint encoding.
+func appendField(data []byte, num protoreflect.FieldNumber, v int64) []byte {
+	data = appendVarint(data, uint64(num)<<3)
+	return appendVarint(data, uint64(v))
+}
+
+func appendVarint(data []byte, v uint64) []byte {
+	for v >= 0x80 {
+		data = append(data, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(data, byte(v))
+}
+
+func fullRecord(shape Shape) *Record {
+	rec := &Record{
+		Name:   "John",
+		Age:    42,
+		Score:  1 << 40,
+		Delta:  -7,
+		Ratio:  0.25,
+		Weight: 1.5,
+		OK:     true,
+		Data:   []byte{0, 1, 2},
+		Ints:   []int64{1, -2, 300},
+		Names:  []string{"a", "", "c"},
+		Email:  "john@example.com",
+		Tags:   Tags{"x", "y"},
+		Kind:   Admin,
+		Kinds:  []Kind{Member, Unknown, Admin},
+		Color:  Blue,
+		Counts: map[string]int32{"a": 1, "b": -1},
+		Points: map[int64]*Point{1: {X: 0, Y: 7}, -5: {X: 3}},
+		Origin: Point{X: 1, Y: 2},
+		Target: &Point{X: -1},
+		Path:   []*Point{{X: 1}, {Y: 1}},
+		At:     time.Unix(1500000000, 123).UTC(),
+		Took:   90*time.Second + time.Millisecond,
+		Shape:  shape,
+	}
+	rec.Meta.Source = "api"
+	rec.Meta.Labels = []string{"l"}
+	return rec
+}
+
+func recordDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	data, err := ioutil.ReadFile(os.Getenv("PROTEUS_DESCRIPTOR_SET"))
+	require.NoError(t, err)
+
+	var set descriptorpb.FileDescriptorSet
+	require.NoError(t, proto.Unmarshal(data, &set))
+
+	files, err := protodesc.NewFiles(&set)
+	require.NoError(t, err)
+
+	desc, err := files.FindDescriptorByName("gopkg.in.srcd.proteus.v1.fixtures.roundtrip.Record")
+	require.NoError(t, err)
+	return desc.(protoreflect.MessageDescriptor)
+}
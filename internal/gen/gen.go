@@ -0,0 +1,123 @@
+// Package gen implements the helpers shared by the generators that write Go
+// code for the types of a package, such as the conversion functions and the
+// marshal methods.
+package gen // import "gopkg.in/src-d/proteus.v1/internal/gen"
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"path"
+	"sort"
+	"strconv"
+
+	"gopkg.in/src-d/proteus.v1/loader"
+)
+
+// File is a Go file being generated in a package. It keeps the packages the
+// generated code imports and the depth of the blocks of statements being
+// generated.
+type File struct {
+	pkg *types.Package
+	// imports are the names of the imported packages by their paths.
+	imports map[string]string
+	// depth is the number of nested blocks of the statements being
+	// generated.
+	depth int
+}
+
+// NewFile returns a new file generated in the given package.
+func NewFile(pkg *types.Package) *File {
+	return &File{
+		pkg:     pkg,
+		imports: make(map[string]string),
+	}
+}
+
+// Qualifier returns the name the given package is referred to with in the
+// generated code, importing it if needed.
+func (f *File) Qualifier(pkg *types.Package) string {
+	if pkg == nil || pkg.Path() == f.pkg.Path() {
+		return ""
+	}
+
+	return f.AddImport(loader.ImportPath(pkg.Path()), pkg.Name())
+}
+
+// AddImport imports the package with the given import path with the given
+// name, which is returned.
+func (f *File) AddImport(importPath, name string) string {
+	f.imports[importPath] = name
+	return name
+}
+
+// TypeString returns the representation of the given Go type in the
+// generated code.
+func (f *File) TypeString(t types.Type) string {
+	return types.TypeString(t, f.Qualifier)
+}
+
+// Block returns the statements generated by fn, which are nested in the
+// statements being generated. The variables they declare are named with the
+// given suffix, so they do not shadow the ones of the outer statements.
+func (f *File) Block(fn func(suffix string) string) string {
+	var suffix string
+	if f.depth > 0 {
+		suffix = strconv.Itoa(f.depth)
+	}
+
+	f.depth++
+	defer func() { f.depth-- }()
+	return fn(suffix)
+}
+
+// Field returns the Go struct field of the given struct type with the given
+// name.
+func (f *File) Field(st types.Type, name string) (*types.Var, error) {
+	obj, _, _ := types.LookupFieldOrMethod(st, true, f.pkg, name)
+	v, ok := obj.(*types.Var)
+	if !ok || !v.IsField() {
+		return nil, fmt.Errorf("there is no Go field named %s", name)
+	}
+	return v, nil
+}
+
+// Lookup returns the object with the given name declared in the package
+// with the given import path, which is either the package of the file or
+// one of its imports.
+func (f *File) Lookup(path, name string) types.Object {
+	pkgs := append([]*types.Package{f.pkg}, f.pkg.Imports()...)
+	for _, pkg := range pkgs {
+		if loader.ImportPath(pkg.Path()) == path {
+			return pkg.Scope().Lookup(name)
+		}
+	}
+	return nil
+}
+
+// Header returns the package clause of the file and the declaration of the
+// packages it imports.
+func (f *File) Header() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n\n", f.pkg.Name())
+
+	if len(f.imports) > 0 {
+		var paths = make([]string, 0, len(f.imports))
+		for p := range f.imports {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+
+		buf.WriteString("import (\n")
+		for _, p := range paths {
+			if name := f.imports[p]; name != path.Base(p) {
+				fmt.Fprintf(&buf, "%s %q\n", name, p)
+			} else {
+				fmt.Fprintf(&buf, "%q\n", p)
+			}
+		}
+		buf.WriteString(")\n\n")
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,81 @@
+package gen
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	require := require.New(t)
+	f := NewFile(types.NewPackage("example.com/foo", "foo"))
+
+	require.Equal("package foo\n\n", string(f.Header()))
+
+	require.Equal("", f.Qualifier(types.NewPackage("example.com/foo", "foo")))
+	require.Equal("bar", f.Qualifier(types.NewPackage("example.com/bar", "bar")))
+	require.Equal("barpb", f.AddImport("example.com/bar/v2", "barpb"))
+	require.Equal("time", f.AddImport("time", "time"))
+
+	require.Equal(`package foo
+
+import (
+"example.com/bar"
+barpb "example.com/bar/v2"
+"time"
+)
+
+`, string(f.Header()))
+}
+
+func TestBlock(t *testing.T) {
+	f := NewFile(types.NewPackage("example.com/foo", "foo"))
+
+	stmts := f.Block(func(suffix string) string {
+		return "v" + suffix + " " + f.Block(func(suffix string) string {
+			return "v" + suffix
+		})
+	})
+	require.Equal(t, "v v1", stmts)
+	require.Equal(t, "v", f.Block(func(suffix string) string { return "v" + suffix }))
+}
+
+func TestField(t *testing.T) {
+	require := require.New(t)
+	pkg := types.NewPackage("example.com/foo", "foo")
+	f := NewFile(pkg)
+	st := types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, "Name", types.Typ[types.String], false),
+	}, nil)
+
+	v, err := f.Field(st, "Name")
+	require.Nil(err)
+	require.Equal("Name", v.Name())
+
+	_, err = f.Field(st, "Age")
+	require.NotNil(err)
+}
+
+func TestSQLNullValue(t *testing.T) {
+	require := require.New(t)
+	sql := types.NewPackage("database/sql", "sql")
+
+	nullString := sqlType(sql, "NullString", "String", types.Typ[types.String])
+	v := SQLNullValue(nullString)
+	require.NotNil(v)
+	require.Equal("String", v.Name())
+	require.Equal(types.Typ[types.String], v.Type())
+
+	require.Nil(SQLNullValue(sqlType(sql, "NullUUID", "UUID", types.Typ[types.String])))
+	other := types.NewPackage("example.com/sql", "sql")
+	require.Nil(SQLNullValue(sqlType(other, "NullString", "String", types.Typ[types.String])))
+}
+
+func sqlType(pkg *types.Package, name, field string, typ types.Type) *types.Named {
+	obj := types.NewTypeName(0, pkg, name, nil)
+	return types.NewNamed(obj, types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, field, typ, false),
+		types.NewField(0, pkg, "Valid", types.Typ[types.Bool], false),
+	}, nil), nil)
+}
@@ -0,0 +1,23 @@
+package gen
+
+import (
+	"go/types"
+
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// SQLNullValue returns the field holding the value of the given database/sql
+// Null* type, or nil if it is not one of scanner.SQLNullTypes.
+func SQLNullValue(named *types.Named) *types.Var {
+	if _, ok := scanner.SQLNullTypes[types.TypeString(named, nil)]; !ok {
+		return nil
+	}
+
+	st := named.Underlying().(*types.Struct)
+	for i := 0; i < st.NumFields(); i++ {
+		if f := st.Field(i); f.Name() != "Valid" {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package loader // import "gopkg.in/src-d/proteus.v1/loader"
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+
+	"gopkg.in/src-d/go-parse-utils.v1"
+)
+
+var (
+	goPath = os.Getenv("GOPATH")
+	goSrc  = filepath.Join(goPath, "src")
+)
+
+// Package is a Go package loaded from source code.
+type Package struct {
+	// Path is the import path of the package.
+	Path string
+	// Dir is the directory in which the source files of the package live.
+	Dir string
+	// Types is the type-checked package. Files generated by protoc and
+	// proteus are not taken into account.
+	Types *types.Package
+	// AST is the syntax tree of the package, including comments.
+	AST *ast.Package
+}
+
+// Loader locates and loads Go packages from source. If the current working
+// directory is inside a Go module, packages are located using the go command
+// in module mode, which means go.mod requirements, replace directives and
+// relative patterns such as "./..." are honored. Otherwise, packages are
+// looked up in GOPATH, as import paths relative to $GOPATH/src.
+type Loader struct {
+	modules  bool
+	importer *parseutil.Importer
+}
+
+// New creates a new Loader, detecting whether module mode should be used.
+func New() *Loader {
+	return &Loader{
+		modules:  inModule(),
+		importer: parseutil.NewImporter(),
+	}
+}
+
+// Modules reports whether the loader locates packages in module mode.
+func (l *Loader) Modules() bool {
+	return l.modules
+}
+
+// Resolve expands the given package patterns into the import paths of the
+// packages they match. In GOPATH mode, patterns must be import paths.
+func (l *Loader) Resolve(patterns ...string) ([]string, error) {
+	if !l.modules {
+		for _, p := range patterns {
+			dir := filepath.Join(goSrc, p)
+			fi, err := os.Stat(dir)
+			switch {
+			case err != nil:
+				return nil, err
+			case !fi.IsDir():
+				return nil, fmt.Errorf("path is not directory: %s", dir)
+			}
+		}
+		return patterns, nil
+	}
+
+	pkgs, err := list(packages.NeedName|packages.NeedFiles, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths = make([]string, 0, len(pkgs))
+	for _, p := range pkgs {
+		paths = append(paths, p.PkgPath)
+	}
+	return paths, nil
+}
+
+// Dir returns the directory in which the package with the given import path
+// lives. Packages of the standard library are found in GOROOT.
+func (l *Loader) Dir(path string) (string, error) {
+	if IsStandard(path) {
+		return filepath.Join(build.Default.GOROOT, "src", path), nil
+	}
+
+	if !l.modules {
+		dir := filepath.Join(goSrc, path)
+		if _, err := os.Stat(dir); err != nil {
+			return "", err
+		}
+		return dir, nil
+	}
+
+	pkgs, err := list(packages.NeedName|packages.NeedFiles, path)
+	if err != nil {
+		return "", err
+	}
+	return pkgs[0].Dir, nil
+}
+
+// Load parses and type-checks the package with the given import path. Files
+// ending in ".pb.go" or ".proteus.go" are ignored, as they are generated from
+// the package itself and may not be up to date.
+func (l *Loader) Load(path string) (*Package, error) {
+	if !l.modules {
+		return l.loadFromGoPath(path)
+	}
+
+	pkgs, err := list(
+		packages.NeedName|packages.NeedFiles|packages.NeedImports|
+			packages.NeedDeps|packages.NeedTypes,
+		path,
+	)
+	if err != nil {
+		return nil, err
+	}
+	pkg := pkgs[0]
+
+	fset := token.NewFileSet()
+	astPkg := &ast.Package{Files: make(map[string]*ast.File)}
+	var files []*ast.File
+	for _, name := range pkg.GoFiles {
+		if isGenerated(name) {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+
+		if astPkg.Name != "" && astPkg.Name != f.Name.Name {
+			return nil, fmt.Errorf("more than one package found in %s", pkg.Dir)
+		}
+		astPkg.Name = f.Name.Name
+		astPkg.Files[name] = f
+		files = append(files, f)
+	}
+
+	config := types.Config{
+		FakeImportC: true,
+		Importer: importerFunc(func(p string) (*types.Package, error) {
+			if p == "unsafe" {
+				return types.Unsafe, nil
+			}
+
+			if imp, ok := pkg.Imports[p]; ok && imp.Types != nil {
+				return imp.Types, nil
+			}
+			return nil, fmt.Errorf("could not import %s", p)
+		}),
+	}
+
+	typ, err := config.Check(pkg.PkgPath, fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Package{
+		Path:  pkg.PkgPath,
+		Dir:   pkg.Dir,
+		Types: typ,
+		AST:   astPkg,
+	}, nil
+}
+
+func (l *Loader) loadFromGoPath(path string) (*Package, error) {
+	typ, err := l.importer.ImportWithFilters(
+		path,
+		parseutil.FileFilters{
+			func(pkg, file string, typ parseutil.FileType) bool {
+				return !isGenerated(file)
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	astPkg, err := parseutil.PackageAST(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Package{
+		Path:  path,
+		Dir:   filepath.Join(goSrc, path),
+		Types: typ,
+		AST:   astPkg,
+	}, nil
+}
+
+// IsStandard reports whether the package with the given import path is part
+// of the standard library, that is, it lives in GOROOT.
+func IsStandard(path string) bool {
+	if path == "" || build.Default.GOROOT == "" {
+		return false
+	}
+
+	fi, err := os.Stat(filepath.Join(build.Default.GOROOT, "src", path))
+	return err == nil && fi.IsDir()
+}
+
+// ImportPath returns the import path for a package path returned by the
+// type checker. In GOPATH mode, packages are identified by their absolute
+// path, so the $GOPATH/src prefix is removed.
+func ImportPath(path string) string {
+	if goPath == "" {
+		return path
+	}
+	return strings.TrimPrefix(path, goSrc+string(filepath.Separator))
+}
+
+func list(mode packages.LoadMode, patterns ...string) ([]*packages.Package, error) {
+	pkgs, err := packages.Load(&packages.Config{Mode: mode}, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages found matching %s", strings.Join(patterns, ", "))
+	}
+
+	for _, p := range pkgs {
+		for _, e := range p.Errors {
+			if e.Kind == packages.ListError {
+				return nil, errors.New(e.Msg)
+			}
+		}
+	}
+
+	return pkgs, nil
+}
+
+func isGenerated(file string) bool {
+	return strings.HasSuffix(file, ".pb.go") || strings.HasSuffix(file, ".proteus.go")
+}
+
+// inModule reports whether the go command considers the working directory
+// to be inside a module.
+func inModule() bool {
+	out, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		return false
+	}
+
+	gomod := strings.TrimSpace(string(out))
+	return gomod != "" && gomod != os.DevNull
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
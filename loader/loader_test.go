@@ -0,0 +1,162 @@
+package loader
+
+import (
+	"go/build"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const project = "gopkg.in/src-d/proteus.v1"
+
+func TestResolve(t *testing.T) {
+	require := require.New(t)
+	l := New()
+
+	paths, err := l.Resolve(projectPkg("fixtures"), projectPkg("fixtures/subpkg"))
+	require.Nil(err)
+	require.Equal([]string{projectPkg("fixtures"), projectPkg("fixtures/subpkg")}, paths)
+
+	_, err = l.Resolve("github.com/src-d/nonexistingprojectforsure")
+	require.NotNil(err)
+
+	_, err = l.Resolve(projectPkg("fixtures/foo.go"))
+	require.NotNil(err)
+}
+
+func TestDir(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := New().Dir(projectPkg("fixtures/subpkg"))
+	require.Nil(err)
+	requireSameDir(t, filepath.Join("..", "fixtures", "subpkg"), dir)
+
+	dir, err = New().Dir("database/sql")
+	require.Nil(err)
+	require.Equal(filepath.Join(build.Default.GOROOT, "src", "database", "sql"), dir)
+}
+
+func TestIsStandard(t *testing.T) {
+	require.True(t, IsStandard("database/sql"))
+	require.True(t, IsStandard("time"))
+	require.False(t, IsStandard(projectPkg("fixtures")))
+	require.False(t, IsStandard(""))
+}
+
+func TestLoad(t *testing.T) {
+	require := require.New(t)
+
+	pkg, err := New().Load(projectPkg("fixtures/subpkg"))
+	require.Nil(err)
+	require.Equal(projectPkg("fixtures/subpkg"), pkg.Path)
+	require.Equal("subpkg", pkg.Types.Name())
+	require.Equal(projectPkg("fixtures/subpkg"), ImportPath(pkg.Types.Path()))
+	require.NotNil(pkg.Types.Scope().Lookup("Point"))
+	require.Equal("subpkg", pkg.AST.Name)
+}
+
+func TestLoad_multiplePackages(t *testing.T) {
+	require := require.New(t)
+
+	dir := filepath.Join("..", "fixtures", "multiple")
+	require.Nil(os.MkdirAll(dir, 0777))
+	defer os.RemoveAll(dir)
+	require.Nil(ioutil.WriteFile(filepath.Join(dir, "foo.go"), []byte("package foo"), 0777))
+	require.Nil(ioutil.WriteFile(filepath.Join(dir, "bar.go"), []byte("package bar"), 0777))
+
+	_, err := New().Load(projectPkg("fixtures/multiple"))
+	require.NotNil(err)
+}
+
+func TestImportPath(t *testing.T) {
+	if goPath != "" {
+		require.Equal(t, "foo/bar", ImportPath(filepath.Join(goSrc, "foo/bar")))
+	}
+	require.Equal(t, "github.com/foo/src/bar", ImportPath("github.com/foo/src/bar"))
+}
+
+func TestModules(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "proteus-loader")
+	require.Nil(err)
+	defer os.RemoveAll(root)
+
+	writeFiles(t, root, map[string]string{
+		"app/go.mod": "module example.com/app\n\ngo 1.18\n\n" +
+			"require example.com/lib v0.0.0\n\n" +
+			"replace example.com/lib => ../lib\n",
+		"app/models/models.go": "package models\n\nimport \"example.com/lib/types\"\n\n" +
+			"// User is a user.\ntype User struct {\n\tID types.ID\n}\n",
+		"app/models/models.pb.go": "package models\n\nfunc broken( {}\n",
+		"app/other/other.go":      "package other\n",
+		"lib/go.mod":              "module example.com/lib\n\ngo 1.18\n",
+		"lib/types/types.go":      "package types\n\ntype ID string\n",
+	})
+
+	for k, v := range map[string]string{
+		"GO111MODULE": "on",
+		"GOFLAGS":     "-mod=mod",
+		"GOPROXY":     "off",
+		"GOWORK":      "off",
+	} {
+		t.Setenv(k, v)
+	}
+
+	wd, err := os.Getwd()
+	require.Nil(err)
+	require.Nil(os.Chdir(filepath.Join(root, "app")))
+	defer os.Chdir(wd)
+
+	l := New()
+	require.True(l.Modules())
+
+	paths, err := l.Resolve("./...")
+	require.Nil(err)
+	require.Equal([]string{"example.com/app/models", "example.com/app/other"}, paths)
+
+	dir, err := l.Dir("example.com/lib/types")
+	require.Nil(err)
+	requireSameDir(t, filepath.Join(root, "lib", "types"), dir)
+
+	pkg, err := l.Load("example.com/app/models")
+	require.Nil(err, "replaced modules are loaded and .pb.go files ignored")
+	require.Equal("example.com/app/models", pkg.Path)
+	requireSameDir(t, filepath.Join(root, "app", "models"), pkg.Dir)
+	require.Equal("models", pkg.AST.Name)
+
+	user := pkg.Types.Scope().Lookup("User").Type().Underlying().(*types.Struct)
+	require.Equal("example.com/lib/types.ID", user.Field(0).Type().String())
+
+	_, err = l.Resolve("example.com/app/nonexisting")
+	require.NotNil(err)
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0777))
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0666))
+	}
+}
+
+// requireSameDir checks that both paths are the same directory, whether
+// they are relative, absolute or go through symlinks.
+func requireSameDir(t *testing.T, expected, actual string) {
+	expected, err := filepath.Abs(expected)
+	require.Nil(t, err)
+	expected, err = filepath.EvalSymlinks(expected)
+	require.Nil(t, err)
+
+	actual, err = filepath.EvalSymlinks(actual)
+	require.Nil(t, err)
+	require.Equal(t, expected, actual)
+}
+
+func projectPkg(pkg string) string {
+	return filepath.Join(project, pkg)
+}
@@ -0,0 +1,342 @@
+package loader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ProtocType is a protobuf message or enum whose Go type was generated by
+// protoc, with protoc-gen-go or the gogo protobuf generators.
+type ProtocType struct {
+	// Name is the full name of the message or enum, e.g. shared.Address.
+	Name string
+	// Enum reports whether the type is an enum.
+	Enum bool
+	// File is the descriptor of the .proto file that declares the type.
+	File *descriptorpb.FileDescriptorProto
+}
+
+// ProtocTypes returns the protobuf messages and enums declared in the files
+// generated by protoc of the package with the given import path, indexed by
+// the name of their Go type. The types are found by the Descriptor and
+// EnumDescriptor methods of the generated code, which return the descriptor
+// of their file, and so are their names. The map is empty if the package has
+// no generated files.
+func (l *Loader) ProtocTypes(path string) (map[string]*ProtocType, error) {
+	dir, err := l.Dir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pb.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result = make(map[string]*ProtocType)
+	for _, name := range files {
+		f, err := parser.ParseFile(token.NewFileSet(), name, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := newProtocFile(f).types(result); err != nil {
+			return nil, fmt.Errorf("unable to read the descriptors of %s: %s", name, err)
+		}
+	}
+
+	return result, nil
+}
+
+// IsProtocMessage reports whether the given type, or a pointer to it, is a
+// message generated by protoc.
+func IsProtocMessage(typ types.Type) bool {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem()
+	}
+
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return false
+	}
+
+	if _, ok := named.Underlying().(*types.Struct); !ok {
+		return false
+	}
+
+	methods := types.NewMethodSet(types.NewPointer(named))
+	return methods.Lookup(nil, "ProtoMessage") != nil
+}
+
+// protocFile is a Go file generated by protoc.
+type protocFile struct {
+	file *ast.File
+	// values are the values of the package level constants and variables.
+	values map[string]ast.Expr
+	// descriptors are the descriptors already decoded by the name of the
+	// constant or variable holding them.
+	descriptors map[string]*descriptorpb.FileDescriptorProto
+}
+
+func newProtocFile(f *ast.File) *protocFile {
+	p := &protocFile{
+		file:        f,
+		values:      make(map[string]ast.Expr),
+		descriptors: make(map[string]*descriptorpb.FileDescriptorProto),
+	}
+
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || (gen.Tok != token.CONST && gen.Tok != token.VAR) {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, name := range vs.Names {
+				if i < len(vs.Values) {
+					p.values[name.Name] = vs.Values[i]
+				}
+			}
+		}
+	}
+
+	return p
+}
+
+// types adds the types of the file to the given ones.
+func (p *protocFile) types(result map[string]*ProtocType) error {
+	for _, decl := range p.file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || fn.Body == nil || len(fn.Body.List) != 1 {
+			continue
+		}
+
+		enum := fn.Name.Name == "EnumDescriptor"
+		if !enum && fn.Name.Name != "Descriptor" {
+			continue
+		}
+
+		ret, ok := fn.Body.List[0].(*ast.ReturnStmt)
+		if !ok || len(ret.Results) != 2 {
+			continue
+		}
+
+		path, ok := indexPath(ret.Results[1])
+		if !ok {
+			continue
+		}
+
+		file, err := p.descriptor(ret.Results[0])
+		if err != nil {
+			return err
+		}
+
+		name, ok := typeName(file, path, enum)
+		if !ok {
+			return fmt.Errorf("there is no type at %v in %s", path, file.GetName())
+		}
+
+		if recv := receiverName(fn.Recv); recv != "" {
+			result[recv] = &ProtocType{Name: name, Enum: enum, File: file}
+		}
+	}
+
+	return nil
+}
+
+// descriptor returns the descriptor returned by the given expression of a
+// Descriptor method, which is either the variable holding it, as the gogo
+// protobuf generators do, or a call to the function compressing the raw
+// descriptor, as protoc-gen-go does.
+func (p *protocFile) descriptor(expr ast.Expr) (*descriptorpb.FileDescriptorProto, error) {
+	var name string
+	switch e := expr.(type) {
+	case *ast.Ident:
+		name = e.Name
+	case *ast.CallExpr:
+		fn, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return nil, fmt.Errorf("unexpected descriptor %s", types.ExprString(expr))
+		}
+		name = strings.TrimSuffix(fn.Name, "GZIP")
+	default:
+		return nil, fmt.Errorf("unexpected descriptor %s", types.ExprString(expr))
+	}
+
+	if file, ok := p.descriptors[name]; ok {
+		return file, nil
+	}
+
+	value, ok := p.values[name]
+	if !ok {
+		return nil, fmt.Errorf("descriptor %s is not declared", name)
+	}
+
+	data, err := p.bytes(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	file := new(descriptorpb.FileDescriptorProto)
+	if err := proto.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+
+	p.descriptors[name] = file
+	return file, nil
+}
+
+// bytes evaluates the given expression, which is made of string and byte
+// slice literals, conversions between them, concatenations and references to
+// other constants and variables.
+func (p *protocFile) bytes(expr ast.Expr) ([]byte, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			s, err := strconv.Unquote(e.Value)
+			return []byte(s), err
+		}
+	case *ast.BinaryExpr:
+		if e.Op == token.ADD {
+			x, err := p.bytes(e.X)
+			if err != nil {
+				return nil, err
+			}
+
+			y, err := p.bytes(e.Y)
+			return append(x, y...), err
+		}
+	case *ast.ParenExpr:
+		return p.bytes(e.X)
+	case *ast.Ident:
+		if value, ok := p.values[e.Name]; ok {
+			return p.bytes(value)
+		}
+	case *ast.CallExpr:
+		// Conversions to string or []byte.
+		if len(e.Args) == 1 {
+			return p.bytes(e.Args[0])
+		}
+	case *ast.CompositeLit:
+		var data = make([]byte, 0, len(e.Elts))
+		for _, elt := range e.Elts {
+			lit, ok := elt.(*ast.BasicLit)
+			if !ok || lit.Kind != token.INT {
+				return nil, fmt.Errorf("unexpected byte %s", types.ExprString(elt))
+			}
+
+			b, err := strconv.ParseUint(lit.Value, 0, 8)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, byte(b))
+		}
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("unexpected expression %s", types.ExprString(expr))
+}
+
+// indexPath returns the path of a type in its file descriptor, given by the
+// []int literal returned by the Descriptor methods.
+func indexPath(expr ast.Expr) ([]int, bool) {
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok || len(lit.Elts) == 0 {
+		return nil, false
+	}
+
+	var path []int
+	for _, elt := range lit.Elts {
+		b, ok := elt.(*ast.BasicLit)
+		if !ok || b.Kind != token.INT {
+			return nil, false
+		}
+
+		n, err := strconv.Atoi(b.Value)
+		if err != nil {
+			return nil, false
+		}
+		path = append(path, n)
+	}
+	return path, true
+}
+
+// typeName returns the full name of the message or enum at the given path of
+// the given file. The path of messages holds the index of the message in the
+// file followed by the indexes of the nested messages, and the path of enums
+// ends with the index of the enum in the file or message before it.
+func typeName(file *descriptorpb.FileDescriptorProto, path []int, enum bool) (string, bool) {
+	var (
+		name     = file.GetPackage()
+		messages = file.MessageType
+		enums    = file.EnumType
+	)
+
+	last := len(path)
+	if enum {
+		last--
+	}
+
+	for _, i := range path[:last] {
+		if i < 0 || i >= len(messages) {
+			return "", false
+		}
+
+		msg := messages[i]
+		name = join(name, msg.GetName())
+		messages, enums = msg.NestedType, msg.EnumType
+	}
+
+	if !enum {
+		return name, true
+	}
+
+	i := path[last]
+	if i < 0 || i >= len(enums) {
+		return "", false
+	}
+	return join(name, enums[i].GetName()), true
+}
+
+func join(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func receiverName(recv *ast.FieldList) string {
+	typ := recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
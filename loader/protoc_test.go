@@ -0,0 +1,64 @@
+package loader
+
+import (
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtocTypes(t *testing.T) {
+	require := require.New(t)
+	l := New()
+
+	pbTypes, err := l.ProtocTypes("google.golang.org/protobuf/types/descriptorpb")
+	require.Nil(err)
+
+	typ := pbTypes["DescriptorProto_ExtensionRange"]
+	require.NotNil(typ)
+	require.Equal("google.protobuf.DescriptorProto.ExtensionRange", typ.Name)
+	require.False(typ.Enum)
+	require.Equal("google/protobuf/descriptor.proto", typ.File.GetName())
+
+	typ = pbTypes["FieldDescriptorProto_Type"]
+	require.NotNil(typ)
+	require.Equal("google.protobuf.FieldDescriptorProto.Type", typ.Name)
+	require.True(typ.Enum)
+
+	gogoTypes, err := l.ProtocTypes("github.com/gogo/protobuf/types")
+	require.Nil(err)
+
+	typ = gogoTypes["Timestamp"]
+	require.NotNil(typ)
+	require.Equal("google.protobuf.Timestamp", typ.Name)
+	require.Equal("google/protobuf/timestamp.proto", typ.File.GetName())
+
+	typ = gogoTypes["NullValue"]
+	require.NotNil(typ)
+	require.Equal("google.protobuf.NullValue", typ.Name)
+	require.True(typ.Enum)
+
+	none, err := l.ProtocTypes(projectPkg("fixtures/subpkg"))
+	require.Nil(err)
+	require.Empty(none)
+}
+
+func TestIsProtocMessage(t *testing.T) {
+	require := require.New(t)
+
+	pkg := types.NewPackage("foo", "foo")
+	msg := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Msg", nil), types.NewStruct(nil, nil), nil)
+	recv := types.NewVar(token.NoPos, pkg, "m", types.NewPointer(msg))
+	msg.AddMethod(types.NewFunc(token.NoPos, pkg, "ProtoMessage", types.NewSignatureType(recv, nil, nil, nil, nil, false)))
+
+	require.True(IsProtocMessage(msg))
+	require.True(IsProtocMessage(types.NewPointer(msg)))
+
+	point, err := New().Load(projectPkg("fixtures/subpkg"))
+	require.Nil(err)
+
+	typ := point.Types.Scope().Lookup("Point").Type()
+	require.False(IsProtocMessage(typ))
+	require.False(IsProtocMessage(types.NewPointer(typ)))
+}
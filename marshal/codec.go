@@ -0,0 +1,605 @@
+package marshal
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"gopkg.in/src-d/proteus.v1/internal/gen"
+	"gopkg.in/src-d/proteus.v1/loader"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+)
+
+// Wire types of the protobuf wire format.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// codec writes the values of a Go type as the values of a protobuf type in
+// the protobuf wire format and reads them back.
+type codec struct {
+	wireType int
+	// packed reports whether repeated values are packed.
+	packed bool
+	// fixed is the size of every value, if all of them have the same size.
+	fixed int
+	// size returns the expression of the size of the value v, without its
+	// tag.
+	size func(v string) string
+	// marshal returns the statements that write the value v right before i
+	// in dAtA, moving i to its start.
+	marshal func(v string) string
+	// unmarshal returns the statements that read a value from data at idx,
+	// moving idx past it, and pass the expression of the value to set.
+	unmarshal func(data, idx string, set func(value string) string) string
+	// nonZero returns the expression reporting whether v is not the zero
+	// value, which is not written by fields without presence, or it is nil
+	// if every value is written.
+	nonZero func(v string) string
+}
+
+// holder is the way a value that may not be set is held by a Go type, e.g.
+// a pointer or a database/sql Null* type.
+type holder struct {
+	// isSet returns the expression reporting whether the value held by v is
+	// set, or nil if it is always set.
+	isSet func(v string) string
+	// get returns the expression of the value held by v.
+	get func(v string) string
+	// set returns the statements that pass to assign the expression of a
+	// holder of the given value.
+	set func(value string, assign func(string) string) string
+}
+
+// plain holds the values themselves, which are always set.
+var plain = &holder{
+	get: func(v string) string { return v },
+	set: func(value string, assign func(string) string) string {
+		return assign(value)
+	},
+}
+
+// pointer returns the holder of the values held by pointers, which are not
+// set if they are nil.
+func (c *context) pointer() *holder {
+	return &holder{
+		isSet: func(v string) string { return v + " != nil" },
+		get:   func(v string) string { return "*" + v },
+		set: func(value string, assign func(string) string) string {
+			if token.IsIdentifier(value) {
+				return assign("&" + value)
+			}
+
+			return c.Block(func(suffix string) string {
+				val := "val" + suffix
+				return fmt.Sprintf("%s := %s\n%s", val, value, assign("&"+val))
+			})
+		},
+	}
+}
+
+// sqlNull returns the holder of the values held by the given database/sql
+// Null* type in its field with the given name.
+func (c *context) sqlNull(named *types.Named, field string) *holder {
+	return &holder{
+		isSet: func(v string) string { return v + ".Valid" },
+		get:   func(v string) string { return v + "." + field },
+		set: func(value string, assign func(string) string) string {
+			return assign(fmt.Sprintf("%s{%s: %s, Valid: true}", c.TypeString(named), field, value))
+		},
+	}
+}
+
+// nonZero returns the holder of the values held by the given holder that
+// are only set if they are not the zero value of the given codec, as fields
+// without presence do not write their zero values.
+func (c *context) nonZero(h *holder, codec *codec) *holder {
+	return &holder{
+		isSet: func(v string) string {
+			return fmt.Sprintf("%s && %s", h.isSet(v), codec.nonZero(h.get(v)))
+		},
+		get: h.get,
+		set: func(value string, assign func(string) string) string {
+			return c.Block(func(suffix string) string {
+				val := "val" + suffix
+				return fmt.Sprintf("if %s := %s; %s {\n%s}\n", val, value, codec.nonZero(val), h.set(val, assign))
+			})
+		},
+	}
+}
+
+// holder returns the holder of the given Go type and the type of the values
+// it holds.
+func (c *context) holder(typ types.Type) (*holder, types.Type) {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		return c.pointer(), ptr.Elem()
+	}
+
+	if named, ok := typ.(*types.Named); ok {
+		if f := gen.SQLNullValue(named); f != nil {
+			return c.sqlNull(named, f.Name()), f.Type()
+		}
+	}
+
+	return plain, typ
+}
+
+// elem returns the holder of the given Go type and the codec of the values
+// it holds for the given protobuf type of a field of the given message.
+func (c *context) elem(msg *protobuf.Message, typ types.Type, pt protobuf.Type) (*holder, *codec, error) {
+	h, elem := c.holder(typ)
+	codec, err := c.codec(msg, elem, pt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, codec, nil
+}
+
+// wrapperTypes are the protobuf types of the values wrapped by the
+// google.protobuf wrapper types.
+var wrapperTypes = map[string]string{
+	"DoubleValue": "double",
+	"FloatValue":  "float",
+	"Int64Value":  "int64",
+	"UInt64Value": "uint64",
+	"Int32Value":  "int32",
+	"UInt32Value": "uint32",
+	"BoolValue":   "bool",
+	"StringValue": "string",
+	"BytesValue":  "bytes",
+}
+
+// codec returns the codec of the given Go type for the given protobuf type
+// of a field of the given message.
+func (c *context) codec(msg *protobuf.Message, typ types.Type, pt protobuf.Type) (*codec, error) {
+	if alias, ok := pt.(*protobuf.Alias); ok {
+		pt = alias.Underlying
+	}
+
+	switch pt := pt.(type) {
+	case *protobuf.Basic:
+		return c.scalar(typ, pt.Name)
+	case *protobuf.Named:
+		if pt.Package == "google.protobuf" {
+			if _, ok := wrapperTypes[pt.Name]; ok {
+				return c.wrapper(typ, pt.Name)
+			}
+
+			if pt.Name == timestamp || pt.Name == duration {
+				return c.time(typ, pt.Name)
+			}
+			break
+		}
+
+		if pt.Package == "" {
+			return c.nested(msg, typ, pt.Name)
+		}
+
+		named, ok := typ.(*types.Named)
+		if !ok || loader.IsProtocMessage(named) {
+			break
+		}
+
+		switch named.Underlying().(type) {
+		case *types.Struct:
+			if named.TypeArgs().Len() > 0 {
+				return c.instance(named, pt.Name)
+			}
+			return c.message(named), nil
+		case *types.Basic:
+			return c.scalar(typ, "int32")
+		}
+	}
+
+	return nil, fmt.Errorf("marshaling of %s as %s is not supported", typ, pt)
+}
+
+// scalar returns the codec of the given Go type for the given protobuf
+// scalar type.
+func (c *context) scalar(typ types.Type, name string) (*codec, error) {
+	var kind types.BasicInfo
+	switch u := typ.Underlying().(type) {
+	case *types.Basic:
+		kind = u.Info()
+	case *types.Slice:
+		if b, ok := u.Elem().Underlying().(*types.Basic); ok && b.Kind() == types.Byte && name == "bytes" {
+			return c.bytes(typ, true), nil
+		}
+	}
+
+	var (
+		conv = func(expr string) string {
+			return c.convert(typ, types.Typ[types.Uint64], expr)
+		}
+		codec *codec
+	)
+	switch {
+	case name == "string" && kind&types.IsString != 0:
+		return c.bytes(typ, false), nil
+	case name == "bool" && kind&types.IsBoolean != 0:
+		codec = c.varint(
+			func(v string) string { return "1" },
+			func(v string) string {
+				return fmt.Sprintf("i--\nif %s {\ndAtA[i] = 1\n} else {\ndAtA[i] = 0\n}\n", v)
+			},
+			func(x string) string { return c.convert(typ, types.Typ[types.Bool], x+" != 0") },
+		)
+		codec.fixed = 1
+		codec.nonZero = func(v string) string { return v }
+		return codec, nil
+	case kind&types.IsInteger == 0:
+		if kind&types.IsFloat == 0 || (name != "double" && name != "float") {
+			return nil, fmt.Errorf("marshaling of %s as %s is not supported", typ, name)
+		}
+	}
+
+	switch name {
+	case "int32", "int64", "uint32", "uint64":
+		codec = c.varint(
+			func(v string) string { return fmt.Sprintf("sizeVarintProteus(uint64(%s))", v) },
+			func(v string) string {
+				return fmt.Sprintf("i = encodeVarintProteus(dAtA, i, uint64(%s))\n", v)
+			},
+			conv,
+		)
+	case "sint32", "sint64":
+		codec = c.varint(
+			func(v string) string {
+				return fmt.Sprintf("sizeVarintProteus(encodeZigZagProteus(int64(%s)))", v)
+			},
+			func(v string) string {
+				return fmt.Sprintf("i = encodeVarintProteus(dAtA, i, encodeZigZagProteus(int64(%s)))\n", v)
+			},
+			func(x string) string {
+				return c.convert(typ, types.Typ[types.Int64], fmt.Sprintf("decodeZigZagProteus(%s)", x))
+			},
+		)
+	case "fixed32", "sfixed32", "float":
+		codec = c.fixed(typ, name, 32)
+	case "fixed64", "sfixed64", "double":
+		codec = c.fixed(typ, name, 64)
+	default:
+		return nil, fmt.Errorf("marshaling of %s as %s is not supported", typ, name)
+	}
+
+	codec.nonZero = func(v string) string { return v + " != 0" }
+	return codec, nil
+}
+
+// varint returns the codec of the values written as varints with the given
+// size and marshal functions, and converted from the read varint x with the
+// given conversion.
+func (c *context) varint(size, marshal func(v string) string, conv func(x string) string) *codec {
+	return &codec{
+		wireType: wireVarint,
+		packed:   true,
+		size:     size,
+		marshal:  marshal,
+		unmarshal: func(data, idx string, set func(string) string) string {
+			return c.Block(func(suffix string) string {
+				x, n := "x"+suffix, "n"+suffix
+				return fmt.Sprintf(
+					"%s, %s, err := consumeVarintProteus(%s[%s:])\nif err != nil {\nreturn err\n}\n%s += %s\n%s",
+					x, n, data, idx, idx, n, set(conv(x)),
+				)
+			})
+		},
+	}
+}
+
+// fixed returns the codec of the given Go type for the given protobuf scalar
+// type with a fixed size of the given number of bits.
+func (c *context) fixed(typ types.Type, name string, bits int) *codec {
+	var (
+		wireType = wireFixed32
+		put      = "PutUint32"
+		toBits   = func(v string) string { return fmt.Sprintf("uint32(%s)", v) }
+		fromBits = func(x string) string { return x }
+		from     = types.Typ[types.Uint32]
+	)
+	if bits == 64 {
+		wireType, put, from = wireFixed64, "PutUint64", types.Typ[types.Uint64]
+		toBits = func(v string) string { return fmt.Sprintf("uint64(%s)", v) }
+	}
+
+	switch name {
+	case "sfixed32", "sfixed64":
+		from = types.Typ[types.Int32]
+		if bits == 64 {
+			from = types.Typ[types.Int64]
+		}
+		fromBits = func(x string) string { return fmt.Sprintf("int%d(%s)", bits, x) }
+	case "float", "double":
+		from = types.Typ[types.Float32]
+		if bits == 64 {
+			from = types.Typ[types.Float64]
+		}
+		math := c.AddImport("math", "math")
+		toBits = func(v string) string {
+			return fmt.Sprintf("%s.Float%dbits(%s)", math, bits, c.convert(from, typ, v))
+		}
+		fromBits = func(x string) string {
+			return fmt.Sprintf("%s.Float%dfrombits(%s)", math, bits, x)
+		}
+	}
+
+	return &codec{
+		wireType: wireType,
+		packed:   true,
+		fixed:    bits / 8,
+		size:     func(string) string { return fmt.Sprint(bits / 8) },
+		marshal: func(v string) string {
+			return fmt.Sprintf(
+				"i -= %d\nbinary.LittleEndian.%s(dAtA[i:], %s)\n",
+				bits/8, put, toBits(v),
+			)
+		},
+		unmarshal: func(data, idx string, set func(string) string) string {
+			return c.Block(func(suffix string) string {
+				x, n := "x"+suffix, "n"+suffix
+				value := c.convert(typ, from, fromBits(x))
+				return fmt.Sprintf(
+					"%s, %s, err := consumeFixed%dProteus(%s[%s:])\nif err != nil {\nreturn err\n}\n%s += %s\n%s",
+					x, n, bits, data, idx, idx, n, set(value),
+				)
+			})
+		},
+	}
+}
+
+// bytes returns the codec of the given Go type, whose values are either
+// strings or byte slices, as a protobuf string or bytes.
+func (c *context) bytes(typ types.Type, isSlice bool) *codec {
+	codec := &codec{
+		wireType: wireBytes,
+		size:     func(v string) string { return fmt.Sprintf("sizeBytesProteus(len(%s))", v) },
+		marshal: func(v string) string {
+			return fmt.Sprintf(
+				"i -= len(%s)\ncopy(dAtA[i:], %s)\ni = encodeVarintProteus(dAtA, i, uint64(len(%s)))\n",
+				v, v, v,
+			)
+		},
+		unmarshal: func(data, idx string, set func(string) string) string {
+			return c.Block(func(suffix string) string {
+				b, n := "b"+suffix, "n"+suffix
+				value := c.convert(typ, types.NewSlice(types.Typ[types.Byte]), b)
+				if isSlice {
+					value = c.convert(typ, types.NewSlice(types.Typ[types.Byte]), fmt.Sprintf("append([]byte{}, %s...)", b))
+				}
+				return fmt.Sprintf(
+					"%s, %s, err := consumeBytesProteus(%s[%s:])\nif err != nil {\nreturn err\n}\n%s += %s\n%s",
+					b, n, data, idx, idx, n, set(value),
+				)
+			})
+		},
+		nonZero: func(v string) string { return fmt.Sprintf("len(%s) > 0", v) },
+	}
+	return codec
+}
+
+// embedded returns the codec of the values written as embedded messages,
+// whose size, written bytes and read values are returned by the given
+// expressions. The values are read into a variable of the given type and
+// converted with the given conversion.
+func (c *context) embedded(
+	valType func() string,
+	size func(v string) string,
+	marshal func(v string) string,
+	unmarshal func(data, val string) string,
+	conv func(val string) string,
+) *codec {
+	return &codec{
+		wireType: wireBytes,
+		size: func(v string) string {
+			return fmt.Sprintf("sizeBytesProteus(%s)", size(v))
+		},
+		marshal: func(v string) string {
+			return fmt.Sprintf(
+				"{\nsize, err := %s\nif err != nil {\nreturn 0, err\n}\ni -= size\ni = encodeVarintProteus(dAtA, i, uint64(size))\n}\n",
+				marshal(v),
+			)
+		},
+		unmarshal: func(data, idx string, set func(string) string) string {
+			return c.Block(func(suffix string) string {
+				b, n, val := "b"+suffix, "n"+suffix, "val"+suffix
+				return fmt.Sprintf(
+					"%s, %s, err := consumeBytesProteus(%s[%s:])\nif err != nil {\nreturn err\n}\n%s += %s\n"+
+						"var %s %s\nif err := %s; err != nil {\nreturn err\n}\n%s",
+					b, n, data, idx, idx, n, val, valType(), unmarshal(b, val), set(conv(val)),
+				)
+			})
+		},
+	}
+}
+
+// message returns the codec of the given Go struct, which uses the methods
+// of the struct.
+func (c *context) message(named *types.Named) *codec {
+	return c.embedded(
+		func() string { return c.TypeString(named) },
+		func(v string) string { return recv(v) + ".ProtoSize()" },
+		func(v string) string { return recv(v) + ".MarshalToSizedBuffer(dAtA[:i])" },
+		func(data, val string) string { return fmt.Sprintf("%s.Unmarshal(%s)", val, data) },
+		noConv,
+	)
+}
+
+// funcCodec returns the codec of the given Go type, which uses the functions
+// named after the given name that take the values of the type, or pointers
+// to them if byRef is true. The values are converted with the given
+// conversion.
+func (c *context) funcCodec(typ types.Type, name string, byRef bool, conv func(string) string) *codec {
+	ref := func(v string) string { return v }
+	if byRef {
+		ref = addr
+	}
+
+	return c.embedded(
+		func() string { return c.TypeString(typ) },
+		func(v string) string { return fmt.Sprintf("size%sProteus(%s)", name, ref(v)) },
+		func(v string) string { return fmt.Sprintf("marshal%sProteus(dAtA[:i], %s)", name, ref(v)) },
+		func(data, val string) string { return fmt.Sprintf("unmarshal%sProteus(%s, &%s)", name, data, val) },
+		conv,
+	)
+}
+
+// nested returns the codec of the given anonymous Go struct for the message
+// with the given name nested in the given message.
+func (c *context) nested(msg *protobuf.Message, typ types.Type, name string) (*codec, error) {
+	var nested *protobuf.Message
+	for _, m := range msg.Messages {
+		if m.Name == name {
+			nested = m
+		}
+	}
+
+	if _, ok := typ.(*types.Struct); !ok || nested == nil {
+		return nil, fmt.Errorf("marshaling of %s as nested message %s is not supported", typ, name)
+	}
+
+	return c.messageFuncs(nested, typ, nested.GoName()), nil
+}
+
+// instance returns the codec of the given instantiation of a generic struct
+// for the message with the given name declared for it.
+func (c *context) instance(named *types.Named, name string) (*codec, error) {
+	msg := c.findMessage(name)
+	if msg == nil || msg.Generic == "" {
+		return nil, fmt.Errorf("there is no message %s for %s", name, named)
+	}
+
+	return c.messageFuncs(msg, named, name), nil
+}
+
+// messageFuncs returns the codec of the given Go type for the given message
+// that uses the functions named after the given name, writing them if they
+// have not been written yet.
+func (c *context) messageFuncs(msg *protobuf.Message, typ types.Type, name string) *codec {
+	if !c.helpers[name] {
+		c.helpers[name] = true
+		c.writeMessageFuncs(msg, typ, name)
+	}
+
+	return c.funcCodec(typ, name, true, noConv)
+}
+
+// wrapper returns the codec of the given Go type for the google.protobuf
+// wrapper type with the given name, writing the functions of the wrapper if
+// they have not been written yet.
+func (c *context) wrapper(typ types.Type, name string) (*codec, error) {
+	scalar := wrapperTypes[name]
+	value, err := c.scalar(typ, scalar)
+	if err != nil {
+		return nil, err
+	}
+
+	pbType := pbScalarTypes[scalar]
+	if !c.helpers[name] {
+		c.helpers[name] = true
+		codec, _ := c.scalar(pbType, scalar)
+		c.writeWrapperFuncs(name, pbType, codec)
+	}
+
+	var (
+		toPb   = func(v string) string { return v }
+		fromPb = noConv
+	)
+	if !types.Identical(typ, pbType) {
+		toPb = func(v string) string { return fmt.Sprintf("%s(%s)", c.TypeString(pbType), v) }
+		fromPb = func(v string) string { return fmt.Sprintf("%s(%s)", c.TypeString(typ), v) }
+	}
+
+	codec := c.funcCodec(pbType, name, false, fromPb)
+	size, marshal := codec.size, codec.marshal
+	codec.size = func(v string) string { return size(toPb(v)) }
+	codec.marshal = func(v string) string { return marshal(toPb(v)) }
+	codec.nonZero = value.nonZero
+	return codec, nil
+}
+
+// writeWrapperFuncs writes the functions that marshal and unmarshal the
+// values of the given Go type as the google.protobuf wrapper type with the
+// given name, whose value is written with the given codec.
+func (c *context) writeWrapperFuncs(name string, typ types.Type, codec *codec) {
+	var (
+		fields = []*fieldCodec{c.singular(1, "Value", "v", "*v", plain, codec)}
+		buf    = &c.funcs
+		goType = c.TypeString(typ)
+	)
+	fmt.Fprintf(buf, "func marshal%sProteus(dAtA []byte, v %s) (int, error) {\n", name, goType)
+	buf.WriteString(marshalBody(fields))
+	buf.WriteString("}\n\n")
+
+	fmt.Fprintf(buf, "func size%sProteus(v %s) (n int) {\n", name, goType)
+	buf.WriteString(sizeBody(fields))
+	buf.WriteString("}\n\n")
+
+	fmt.Fprintf(buf, "func unmarshal%sProteus(dAtA []byte, v *%s) error {\n", name, goType)
+	buf.WriteString(unmarshalBody(fields))
+	buf.WriteString("}\n\n")
+}
+
+// time returns the codec of the given Go type for the google.protobuf
+// Timestamp or Duration type, which must be time.Time or time.Duration.
+func (c *context) time(typ types.Type, name string) (*codec, error) {
+	goName := "Time"
+	if name == duration {
+		goName = duration
+	}
+
+	named, ok := typ.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != "time" || named.Obj().Name() != goName {
+		return nil, fmt.Errorf("marshaling of %s as google.protobuf.%s is not supported", typ, name)
+	}
+
+	c.helpers[name] = true
+	return c.funcCodec(typ, name, false, noConv), nil
+}
+
+// pbScalarTypes are the Go types of the values of the protobuf scalar types
+// wrapped by the google.protobuf wrapper types.
+var pbScalarTypes = map[string]types.Type{
+	"double": types.Typ[types.Float64],
+	"float":  types.Typ[types.Float32],
+	"int32":  types.Typ[types.Int32],
+	"int64":  types.Typ[types.Int64],
+	"uint32": types.Typ[types.Uint32],
+	"uint64": types.Typ[types.Uint64],
+	"bool":   types.Typ[types.Bool],
+	"string": types.Typ[types.String],
+	"bytes":  types.NewSlice(types.Typ[types.Byte]),
+}
+
+// convert returns the given expression of the given type from converted to
+// the given Go type, unless they are the same.
+func (c *context) convert(typ, from types.Type, expr string) string {
+	if types.Identical(typ, from) {
+		return expr
+	}
+	return fmt.Sprintf("%s(%s)", c.TypeString(typ), expr)
+}
+
+func noConv(v string) string {
+	return v
+}
+
+// recv returns the expression to call the pointer methods of v with, which
+// is addressable.
+func recv(v string) string {
+	if strings.HasPrefix(v, "*") {
+		return v[1:]
+	}
+	return v
+}
+
+// addr returns the expression of the address of v, which is addressable.
+func addr(v string) string {
+	if strings.HasPrefix(v, "*") {
+		return v[1:]
+	}
+	return "&" + v
+}
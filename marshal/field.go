@@ -0,0 +1,493 @@
+package marshal
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// fieldCodec writes a field of a Go struct as a field of its message and
+// reads it back.
+type fieldCodec struct {
+	// size returns the statements that add the size of the field to n.
+	size func() string
+	// marshal returns the statements that write the field right before i in
+	// dAtA, moving i to its start.
+	marshal func() string
+	// cases returns the cases of the switch over the field numbers that
+	// read the field from dAtA at iNdEx, with the wire type in wireType.
+	cases func() string
+}
+
+// fieldCodecs returns the codecs of the fields and oneofs of the given
+// message, whose Go struct is of the given type. The ones that cannot be
+// marshaled are reported and skipped.
+func (c *context) fieldCodecs(msg *protobuf.Message, st types.Type) []*fieldCodec {
+	var fields []*fieldCodec
+	for _, f := range msg.Fields {
+		codec, err := c.fieldCodec(msg, st, f)
+		if err != nil {
+			report.Warn("field %q of message %q cannot be marshaled, ignoring it: %s", f.Name, msg.Name, err)
+			continue
+		}
+
+		fields = append(fields, codec)
+	}
+
+	for _, o := range msg.Oneofs {
+		codec, err := c.oneof(msg, st, o)
+		if err != nil {
+			report.Warn("oneof %q of message %q cannot be marshaled, ignoring it: %s", o.Name, msg.Name, err)
+			continue
+		}
+
+		fields = append(fields, codec)
+	}
+
+	return fields
+}
+
+// fieldCodec returns the codec of the Go struct field of the given struct
+// type for the given field of the given message.
+func (c *context) fieldCodec(msg *protobuf.Message, st types.Type, f *protobuf.Field) (*fieldCodec, error) {
+	v, err := c.Field(st, f.GoName)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		src = "m." + f.GoName
+		typ = v.Type()
+	)
+	if m, ok := f.Type.(*protobuf.Map); ok {
+		return c.mapField(msg, f, src, typ, m)
+	}
+
+	if !f.Repeated {
+		h, codec, err := c.elem(msg, typ, f.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		if h.isSet != nil && codec.nonZero != nil && !hasPresence(f) {
+			h = c.nonZero(h, codec)
+		}
+		return c.singular(f.Pos, f.GoName, src, src, h, codec), nil
+	}
+
+	slice, ok := typ.Underlying().(*types.Slice)
+	if !ok {
+		return nil, fmt.Errorf("repeated type %s is not a slice", typ)
+	}
+
+	h, codec, err := c.elem(msg, slice.Elem(), f.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	if codec.packed && h == plain {
+		return c.packed(f.Pos, f.GoName, src, codec), nil
+	}
+	return c.repeated(f.Pos, f.GoName, src, h, codec), nil
+}
+
+// hasPresence reports whether the given field tells apart its values that
+// are not set from its zero value, which the scalars and enums that are not
+// optional do not.
+func hasPresence(f *protobuf.Field) bool {
+	if f.Optional {
+		return true
+	}
+
+	named, ok := f.Type.(*protobuf.Named)
+	return ok && (named.Package == "google.protobuf" || named.Package == "")
+}
+
+// singular returns the codec of a field with the given number that holds a
+// single value in src and reads it into dst. Values that are not set are not
+// written, nor the zero values of fields without presence.
+func (c *context) singular(num int, name, src, dst string, h *holder, codec *codec) *fieldCodec {
+	var (
+		value = h.get(src)
+		key   = tag(num, codec.wireType)
+		cond  string
+	)
+	if h.isSet != nil {
+		cond = h.isSet(src)
+	} else if codec.nonZero != nil {
+		cond = codec.nonZero(value)
+	}
+
+	return &fieldCodec{
+		size: func() string {
+			return ifCond(cond, fmt.Sprintf("n += %d + %s\n", len(key), codec.size(value)))
+		},
+		marshal: func() string {
+			return ifCond(cond, codec.marshal(value)+writeTag(key))
+		},
+		cases: func() string {
+			set := func(value string) string {
+				return h.set(value, assign(dst))
+			}
+			return fmt.Sprintf(
+				"case %d:\n%s%s",
+				num, checkWireType(name, codec.wireType), codec.unmarshal("dAtA", "iNdEx", set),
+			)
+		},
+	}
+}
+
+// packed returns the codec of a repeated field with the given number whose
+// values in the slice src are packed. Values that are not packed are read
+// too, as parsers must accept both.
+func (c *context) packed(num int, name, src string, codec *codec) *fieldCodec {
+	key := tag(num, wireBytes)
+	appendTo := appendAssign(src)
+
+	return &fieldCodec{
+		size: func() string {
+			return c.Block(func(suffix string) string {
+				l, x := "l"+suffix, "x"+suffix
+				if codec.fixed > 0 {
+					return fmt.Sprintf(
+						"if len(%s) > 0 {\nn += %d + sizeBytesProteus(len(%s) * %d)\n}\n",
+						src, len(key), src, codec.fixed,
+					)
+				}
+
+				return fmt.Sprintf(
+					"if len(%s) > 0 {\n%s := 0\nfor _, %s := range %s {\n%s += %s\n}\nn += %d + sizeBytesProteus(%s)\n}\n",
+					src, l, x, src, l, codec.size(x), len(key), l,
+				)
+			})
+		},
+		marshal: func() string {
+			return c.Block(func(suffix string) string {
+				j, k := "j"+suffix, "k"+suffix
+				return fmt.Sprintf(
+					"if len(%s) > 0 {\n%s := i\nfor %s := len(%s) - 1; %s >= 0; %s-- {\n%s}\n"+
+						"i = encodeVarintProteus(dAtA, i, uint64(%s-i))\n%s}\n",
+					src, j, k, src, k, k, codec.marshal(fmt.Sprintf("%s[%s]", src, k)), j, writeTag(key),
+				)
+			})
+		},
+		cases: func() string {
+			return c.Block(func(suffix string) string {
+				b, n, j := "b"+suffix, "n"+suffix, "j"+suffix
+				return fmt.Sprintf(
+					"case %d:\nswitch wireType {\ncase %d:\n"+
+						"%s, %s, err := consumeBytesProteus(dAtA[iNdEx:])\nif err != nil {\nreturn err\n}\niNdEx += %s\n"+
+						"for %s := 0; %s < len(%s); {\n%s}\ncase %d:\n%sdefault:\n%s}\n",
+					num, wireBytes,
+					b, n, n,
+					j, j, b, codec.unmarshal(b, j, appendTo), codec.wireType,
+					codec.unmarshal("dAtA", "iNdEx", appendTo), wrongWireType(name),
+				)
+			})
+		},
+	}
+}
+
+// repeated returns the codec of a repeated field with the given number whose
+// values are held in the slice src, writing a key for every value.
+func (c *context) repeated(num int, name, src string, h *holder, codec *codec) *fieldCodec {
+	key := tag(num, codec.wireType)
+	return &fieldCodec{
+		size: func() string {
+			if h.isSet == nil && codec.fixed > 0 {
+				return fmt.Sprintf("n += len(%s) * %d\n", src, len(key)+codec.fixed)
+			}
+
+			return c.Block(func(suffix string) string {
+				x := "x" + suffix
+				return fmt.Sprintf(
+					"for _, %s := range %s {\n%s}\n",
+					x, src, ifSet(h, x, fmt.Sprintf("n += %d + %s\n", len(key), codec.size(h.get(x)))),
+				)
+			})
+		},
+		marshal: func() string {
+			return c.Block(func(suffix string) string {
+				k := "k" + suffix
+				x := fmt.Sprintf("%s[%s]", src, k)
+				return fmt.Sprintf(
+					"for %s := len(%s) - 1; %s >= 0; %s-- {\n%s}\n",
+					k, src, k, k, ifSet(h, x, codec.marshal(h.get(x))+writeTag(key)),
+				)
+			})
+		},
+		cases: func() string {
+			set := func(value string) string {
+				return h.set(value, appendAssign(src))
+			}
+			return fmt.Sprintf(
+				"case %d:\n%s%s",
+				num, checkWireType(name, codec.wireType), codec.unmarshal("dAtA", "iNdEx", set),
+			)
+		},
+	}
+}
+
+// mapField returns the codec of the given map field of the given message,
+// whose values are held in the Go map src of the given type. Every entry is
+// written as a message with the key in its field 1 and the value in its
+// field 2.
+func (c *context) mapField(msg *protobuf.Message, f *protobuf.Field, src string, typ types.Type, pt *protobuf.Map) (*fieldCodec, error) {
+	m, ok := typ.Underlying().(*types.Map)
+	if !ok {
+		return nil, fmt.Errorf("map type %s is not a map", typ)
+	}
+
+	keyCodec, err := c.codec(msg, m.Key(), pt.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	h, valCodec, err := c.elem(msg, m.Elem(), pt.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		key    = tag(f.Pos, wireBytes)
+		keyTag = tag(1, keyCodec.wireType)
+		valTag = tag(2, valCodec.wireType)
+	)
+	return &fieldCodec{
+		size: func() string {
+			return c.Block(func(suffix string) string {
+				k, x, l := "k"+suffix, "x"+suffix, "l"+suffix
+				if keyCodec.fixed > 0 {
+					k = "_"
+				}
+				if valCodec.fixed > 0 && h.isSet == nil {
+					x = "_"
+				}
+
+				return fmt.Sprintf(
+					"for %s {\n%s := %d + %s\n%sn += %d + sizeBytesProteus(%s)\n}\n",
+					rangeClause(k, x, src), l, len(keyTag), keyCodec.size(k),
+					ifSet(h, x, fmt.Sprintf("%s += %d + %s\n", l, len(valTag), valCodec.size(h.get(x)))),
+					len(key), l,
+				)
+			})
+		},
+		marshal: func() string {
+			return c.Block(func(suffix string) string {
+				k, x, j := "k"+suffix, "x"+suffix, "j"+suffix
+				return fmt.Sprintf(
+					"for %s, %s := range %s {\n%s := i\n%s%s%s"+
+						"i = encodeVarintProteus(dAtA, i, uint64(%s-i))\n%s}\n",
+					k, x, src, j,
+					ifSet(h, x, valCodec.marshal(h.get(x))+writeTag(valTag)),
+					keyCodec.marshal(k), writeTag(keyTag),
+					j, writeTag(key),
+				)
+			})
+		},
+		cases: func() string {
+			return c.Block(func(suffix string) string {
+				var (
+					b, n, j  = "b" + suffix, "n" + suffix, "j" + suffix
+					k, x     = "k" + suffix, "x" + suffix
+					entryKey = "key" + suffix
+				)
+				return fmt.Sprintf(
+					"case %d:\n%s"+
+						"%s, %s, err := consumeBytesProteus(dAtA[iNdEx:])\nif err != nil {\nreturn err\n}\niNdEx += %s\n"+
+						"var %s %s\nvar %s %s\n"+
+						"for %s := 0; %s < len(%s); {\n"+
+						"%s, %s, err := consumeVarintProteus(%s[%s:])\nif err != nil {\nreturn err\n}\n%s += %s\n"+
+						"switch %s {\ncase %#x:\n%scase %#x:\n%sdefault:\n"+
+						"%s, err := skipProteus(%s[%s:], int(%s&0x7))\nif err != nil {\nreturn err\n}\n%s += %s\n}\n}\n"+
+						"if %s == nil {\n%s = make(%s)\n}\n%s[%s] = %s\n",
+					f.Pos, checkWireType(f.GoName, wireBytes),
+					b, n, n,
+					k, c.TypeString(m.Key()), x, c.TypeString(m.Elem()),
+					j, j, b,
+					entryKey, n, b, j, j, n,
+					entryKey, keyTag[0], keyCodec.unmarshal(b, j, assign(k)),
+					valTag[0], valCodec.unmarshal(b, j, func(value string) string {
+						return h.set(value, assign(x))
+					}),
+					n, b, j, entryKey, j, n,
+					src, src, c.TypeString(typ), src, k, x,
+				)
+			})
+		},
+	}, nil
+}
+
+// oneof returns the codec of the Go interface field of the given struct type
+// for the given oneof of the given message. The values of the
+// implementations of the interface are written in the fields of the oneof
+// declared for them.
+func (c *context) oneof(msg *protobuf.Message, st types.Type, oneof *protobuf.Oneof) (*fieldCodec, error) {
+	if _, err := c.Field(st, oneof.GoName); err != nil {
+		return nil, err
+	}
+
+	type oneofCase struct {
+		*codec
+		h    *holder
+		typ  types.Type
+		num  int
+		name string
+	}
+
+	var cases []*oneofCase
+	for _, f := range oneof.Fields {
+		impl, ok := f.Type.Source().(*scanner.Named)
+		if !ok {
+			return nil, fmt.Errorf("field %q is not a named type", f.Name)
+		}
+
+		obj, ok := c.Lookup(impl.Path, impl.Name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("type %s is not found", impl)
+		}
+
+		// Both values and pointers implement the interface if the
+		// implementation is not nullable.
+		typs := []types.Type{types.NewPointer(obj.Type())}
+		if !impl.IsNullable() {
+			typs = append([]types.Type{obj.Type()}, typs...)
+		}
+
+		for _, typ := range typs {
+			h, codec, err := c.elem(msg, typ, f.Type)
+			if err != nil {
+				return nil, err
+			}
+
+			cases = append(cases, &oneofCase{codec, h, typ, f.Pos, f.Name})
+		}
+	}
+
+	src := "m." + oneof.GoName
+	typeSwitch := func(write func(cs *oneofCase, x string) string) string {
+		return c.Block(func(suffix string) string {
+			x := "x" + suffix
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, "switch %s := %s.(type) {\n", x, src)
+			for _, cs := range cases {
+				fmt.Fprintf(&buf, "case %s:\n%s", c.TypeString(cs.typ), write(cs, x))
+			}
+			buf.WriteString("}\n")
+			return buf.String()
+		})
+	}
+
+	return &fieldCodec{
+		size: func() string {
+			return typeSwitch(func(cs *oneofCase, x string) string {
+				key := tag(cs.num, cs.wireType)
+				return ifSet(cs.h, x, fmt.Sprintf("n += %d + %s\n", len(key), cs.size(cs.h.get(x))))
+			})
+		},
+		marshal: func() string {
+			return typeSwitch(func(cs *oneofCase, x string) string {
+				return ifSet(cs.h, x, cs.codec.marshal(cs.h.get(x))+writeTag(tag(cs.num, cs.wireType)))
+			})
+		},
+		cases: func() string {
+			var buf bytes.Buffer
+			seen := make(map[int]bool)
+			for _, cs := range cases {
+				// Values and pointers share the field, which is read back
+				// into values.
+				if seen[cs.num] {
+					continue
+				}
+				seen[cs.num] = true
+
+				set := func(value string) string {
+					return cs.h.set(value, assign(src))
+				}
+				fmt.Fprintf(
+					&buf, "case %d:\n%s%s",
+					cs.num, checkWireType(cs.name, cs.wireType), cs.unmarshal("dAtA", "iNdEx", set),
+				)
+			}
+			return buf.String()
+		},
+	}, nil
+}
+
+// tag returns the bytes of the key of the field with the given number and
+// wire type.
+func tag(num, wireType int) []byte {
+	var key []byte
+	v := uint64(num)<<3 | uint64(wireType)
+	for v >= 0x80 {
+		key = append(key, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(key, byte(v))
+}
+
+// writeTag returns the statements that write the given key right before i
+// in dAtA.
+func writeTag(key []byte) string {
+	var buf bytes.Buffer
+	for i := len(key) - 1; i >= 0; i-- {
+		fmt.Fprintf(&buf, "i--\ndAtA[i] = %#x\n", key[i])
+	}
+	return buf.String()
+}
+
+// checkWireType returns the statements that fail if the wire type of the
+// field with the given name is not the given one.
+func checkWireType(name string, wireType int) string {
+	return fmt.Sprintf("if wireType != %d {\n%s}\n", wireType, wrongWireType(name))
+}
+
+func wrongWireType(name string) string {
+	return fmt.Sprintf("return fmt.Errorf(\"proto: wrong wireType = %%d for field %s\", wireType)\n", name)
+}
+
+// ifCond returns the given statements guarded by the given condition, if
+// any.
+func ifCond(cond, stmts string) string {
+	if cond == "" {
+		return stmts
+	}
+	return fmt.Sprintf("if %s {\n%s}\n", cond, stmts)
+}
+
+// ifSet returns the given statements guarded by the condition of the value
+// held by v being set, if it may not be.
+func ifSet(h *holder, v, stmts string) string {
+	if h.isSet == nil {
+		return stmts
+	}
+	return ifCond(h.isSet(v), stmts)
+}
+
+// rangeClause returns the range clause over src with the given key and value
+// variables, which are omitted if they are blank.
+func rangeClause(k, x, src string) string {
+	switch {
+	case x != "_":
+		return fmt.Sprintf("%s, %s := range %s", k, x, src)
+	case k != "_":
+		return fmt.Sprintf("%s := range %s", k, src)
+	}
+	return "range " + src
+}
+
+func assign(dst string) func(string) string {
+	return func(value string) string {
+		return fmt.Sprintf("%s = %s\n", dst, value)
+	}
+}
+
+func appendAssign(dst string) func(string) string {
+	return func(value string) string {
+		return fmt.Sprintf("%s = append(%s, %s)\n", dst, dst, value)
+	}
+}
@@ -0,0 +1,248 @@
+package marshal
+
+import (
+	"bytes"
+	"path"
+)
+
+// writeHelpers writes the functions used by the generated methods to read
+// and write the protobuf wire format, importing the packages they need.
+func (c *context) writeHelpers(buf *bytes.Buffer) {
+	for _, p := range []string{"encoding/binary", "errors", "fmt", "io", "math/bits"} {
+		c.AddImport(p, path.Base(p))
+	}
+	buf.WriteString(helpers)
+
+	if c.helpers[timestamp] {
+		c.AddImport("time", "time")
+		buf.WriteString(timestampHelpers)
+	}
+
+	if c.helpers[duration] {
+		c.AddImport("time", "time")
+		buf.WriteString(durationHelpers)
+	}
+}
+
+const (
+	timestamp = "Timestamp"
+	duration  = "Duration"
+)
+
+const helpers = `var errIntOverflowProteus = errors.New("proto: integer overflow")
+
+func sizeVarintProteus(x uint64) int {
+	return (bits.Len64(x|1) + 6) / 7
+}
+
+func sizeBytesProteus(l int) int {
+	return sizeVarintProteus(uint64(l)) + l
+}
+
+func encodeVarintProteus(dAtA []byte, offset int, v uint64) int {
+	offset -= sizeVarintProteus(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+
+func encodeZigZagProteus(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func decodeZigZagProteus(x uint64) int64 {
+	return int64(x>>1) ^ -int64(x&1)
+}
+
+func consumeVarintProteus(dAtA []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(dAtA); i++ {
+		if i >= 10 {
+			return 0, 0, errIntOverflowProteus
+		}
+
+		b := dAtA[i]
+		v |= uint64(b&0x7f) << (7 * uint(i))
+		if b < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, io.ErrUnexpectedEOF
+}
+
+func consumeFixed32Proteus(dAtA []byte) (uint32, int, error) {
+	if len(dAtA) < 4 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	return binary.LittleEndian.Uint32(dAtA), 4, nil
+}
+
+func consumeFixed64Proteus(dAtA []byte) (uint64, int, error) {
+	if len(dAtA) < 8 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	return binary.LittleEndian.Uint64(dAtA), 8, nil
+}
+
+func consumeBytesProteus(dAtA []byte) ([]byte, int, error) {
+	l, n, err := consumeVarintProteus(dAtA)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if l > uint64(len(dAtA)-n) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	return dAtA[n : n+int(l)], n + int(l), nil
+}
+
+func skipProteus(dAtA []byte, wireType int) (int, error) {
+	var (
+		n   int
+		err error
+	)
+	switch wireType {
+	case 0:
+		_, n, err = consumeVarintProteus(dAtA)
+	case 1:
+		_, n, err = consumeFixed64Proteus(dAtA)
+	case 2:
+		_, n, err = consumeBytesProteus(dAtA)
+	case 5:
+		_, n, err = consumeFixed32Proteus(dAtA)
+	default:
+		err = fmt.Errorf("proto: illegal wireType %d", wireType)
+	}
+	return n, err
+}
+
+`
+
+const timestampHelpers = `func sizeTimestampProteus(t time.Time) (n int) {
+	if s := t.Unix(); s != 0 {
+		n += 1 + sizeVarintProteus(uint64(s))
+	}
+	if ns := t.Nanosecond(); ns != 0 {
+		n += 1 + sizeVarintProteus(uint64(ns))
+	}
+	return n
+}
+
+func marshalTimestampProteus(dAtA []byte, t time.Time) (int, error) {
+	i := len(dAtA)
+	if ns := t.Nanosecond(); ns != 0 {
+		i = encodeVarintProteus(dAtA, i, uint64(ns))
+		i--
+		dAtA[i] = 0x10
+	}
+	if s := t.Unix(); s != 0 {
+		i = encodeVarintProteus(dAtA, i, uint64(s))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func unmarshalTimestampProteus(dAtA []byte, t *time.Time) error {
+	var s, ns int64
+	for iNdEx := 0; iNdEx < len(dAtA); {
+		key, n, err := consumeVarintProteus(dAtA[iNdEx:])
+		if err != nil {
+			return err
+		}
+		iNdEx += n
+
+		switch key {
+		case 0x8, 0x10:
+			x, n, err := consumeVarintProteus(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+
+			if key == 0x8 {
+				s = int64(x)
+			} else {
+				ns = int64(int32(x))
+			}
+		default:
+			n, err := skipProteus(dAtA[iNdEx:], int(key&0x7))
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+		}
+	}
+
+	*t = time.Unix(s, ns).UTC()
+	return nil
+}
+
+`
+
+const durationHelpers = `func sizeDurationProteus(d time.Duration) (n int) {
+	if s := int64(d / time.Second); s != 0 {
+		n += 1 + sizeVarintProteus(uint64(s))
+	}
+	if ns := int32(d % time.Second); ns != 0 {
+		n += 1 + sizeVarintProteus(uint64(ns))
+	}
+	return n
+}
+
+func marshalDurationProteus(dAtA []byte, d time.Duration) (int, error) {
+	i := len(dAtA)
+	if ns := int32(d % time.Second); ns != 0 {
+		i = encodeVarintProteus(dAtA, i, uint64(ns))
+		i--
+		dAtA[i] = 0x10
+	}
+	if s := int64(d / time.Second); s != 0 {
+		i = encodeVarintProteus(dAtA, i, uint64(s))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func unmarshalDurationProteus(dAtA []byte, d *time.Duration) error {
+	var s, ns int64
+	for iNdEx := 0; iNdEx < len(dAtA); {
+		key, n, err := consumeVarintProteus(dAtA[iNdEx:])
+		if err != nil {
+			return err
+		}
+		iNdEx += n
+
+		switch key {
+		case 0x8, 0x10:
+			x, n, err := consumeVarintProteus(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+
+			if key == 0x8 {
+				s = int64(x)
+			} else {
+				ns = int64(int32(x))
+			}
+		default:
+			n, err := skipProteus(dAtA[iNdEx:], int(key&0x7))
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+		}
+	}
+
+	*d = time.Duration(s)*time.Second + time.Duration(ns)
+	return nil
+}
+
+`
@@ -0,0 +1,258 @@
+package marshal // import "gopkg.in/src-d/proteus.v1/marshal"
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"path/filepath"
+
+	"gopkg.in/src-d/proteus.v1/internal/gen"
+	"gopkg.in/src-d/proteus.v1/loader"
+	"gopkg.in/src-d/proteus.v1/output"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/report"
+)
+
+// Generator generates the methods that marshal the Go structs of a package
+// to the protobuf wire format of their messages and unmarshal them back,
+// without the need of protoc or any protobuf code generator.
+//
+// For every struct of the package with a message in the proto file, the
+// methods Marshal, MarshalTo, MarshalToSizedBuffer, ProtoSize and Unmarshal
+// are generated. They are the methods the gogo protobuf generators declare
+// for the proto files generated for the protobuf.TargetGogo target, so the
+// structs can be used with the gogo protobuf runtime and gRPC as if they were
+// generated by them. Methods already declared by the struct are not
+// generated, so they can be customized.
+//
+// Anonymous structs and instantiations of generic structs cannot have
+// methods, so they are marshaled by unexported functions instead, named after
+// their message, e.g. marshalUser_MetaProteus.
+//
+// A single file per package will be generated containing all the methods
+// along with the functions they use, which only depend on the standard
+// library. The file will be written to the directory of the package and it
+// will be named "marshal.proteus.go".
+type Generator struct {
+	loader *loader.Loader
+	output output.Output
+}
+
+// NewGenerator creates a new Generator, which writes the files to disk.
+func NewGenerator() *Generator {
+	return &Generator{loader.New(), output.Disk}
+}
+
+// SetOutput sets the output the generated files are written to.
+func (g *Generator) SetOutput(o output.Output) {
+	g.output = o
+}
+
+// Generate creates a new file in the package at the given path with the
+// methods that marshal and unmarshal the Go structs of the package with the
+// messages of the given proto package.
+func (g *Generator) Generate(proto *protobuf.Package, path string) error {
+	pkg, err := g.loader.Load(path)
+	if err != nil {
+		return err
+	}
+
+	ctx := newContext(proto, pkg.Types)
+	var buf bytes.Buffer
+	for _, m := range proto.Messages {
+		g.writeMessageMethods(ctx, &buf, m)
+	}
+
+	if buf.Len() == 0 {
+		report.Warn("no messages to marshal in the given proto file, not generating anything")
+		return nil
+	}
+
+	buf.Write(ctx.funcs.Bytes())
+	ctx.writeHelpers(&buf)
+
+	src, err := format.Source(append(ctx.Header(), buf.Bytes()...))
+	if err != nil {
+		return fmt.Errorf("unable to format the marshal methods of package %s: %s", path, err)
+	}
+
+	return g.output.WriteFile(filepath.Join(pkg.Dir, "marshal.proteus.go"), src)
+}
+
+// writeMessageMethods writes the methods that marshal and unmarshal the Go
+// struct of the given message. Messages without a Go struct in the package,
+// such as the ones generated for the arguments and results of RPCs, are
+// skipped.
+func (g *Generator) writeMessageMethods(ctx *context, buf *bytes.Buffer, msg *protobuf.Message) {
+	named := ctx.messageType(msg)
+	if named == nil {
+		return
+	}
+
+	declared := make(map[string]bool)
+	for i := 0; i < named.NumMethods(); i++ {
+		declared[named.Method(i).Name()] = true
+	}
+
+	if declared["Marshal"] && declared["MarshalTo"] && declared["MarshalToSizedBuffer"] &&
+		declared["ProtoSize"] && declared["Unmarshal"] {
+		return
+	}
+
+	var (
+		fields = ctx.fieldCodecs(msg, named)
+		recv   = "m *" + named.Obj().Name()
+	)
+	if !declared["Marshal"] {
+		fmt.Fprintf(buf, "func (%s) Marshal() (dAtA []byte, err error) {\n", recv)
+		buf.WriteString("size := m.ProtoSize()\ndAtA = make([]byte, size)\n")
+		buf.WriteString("n, err := m.MarshalToSizedBuffer(dAtA[:size])\n")
+		buf.WriteString("if err != nil {\nreturn nil, err\n}\nreturn dAtA[:n], nil\n}\n\n")
+	}
+
+	if !declared["MarshalTo"] {
+		fmt.Fprintf(buf, "func (%s) MarshalTo(dAtA []byte) (int, error) {\n", recv)
+		buf.WriteString("size := m.ProtoSize()\nreturn m.MarshalToSizedBuffer(dAtA[:size])\n}\n\n")
+	}
+
+	if !declared["MarshalToSizedBuffer"] {
+		fmt.Fprintf(buf, "func (%s) MarshalToSizedBuffer(dAtA []byte) (int, error) {\n", recv)
+		buf.WriteString(marshalBody(fields))
+		buf.WriteString("}\n\n")
+	}
+
+	if !declared["ProtoSize"] {
+		fmt.Fprintf(buf, "func (%s) ProtoSize() (n int) {\n", recv)
+		buf.WriteString("if m == nil {\nreturn 0\n}\n")
+		buf.WriteString(sizeBody(fields))
+		buf.WriteString("}\n\n")
+	}
+
+	if !declared["Unmarshal"] {
+		fmt.Fprintf(buf, "func (%s) Unmarshal(dAtA []byte) error {\n", recv)
+		buf.WriteString(unmarshalBody(fields))
+		buf.WriteString("}\n\n")
+	}
+}
+
+// writeMessageFuncs writes the functions that marshal and unmarshal the
+// values of the given Go type, which is a struct, as the given message. The
+// functions are named after the given name.
+func (c *context) writeMessageFuncs(msg *protobuf.Message, typ types.Type, name string) {
+	var (
+		fields = c.fieldCodecs(msg, typ)
+		param  = "m *" + c.TypeString(typ)
+		buf    = &c.funcs
+	)
+	fmt.Fprintf(buf, "func marshal%sProteus(dAtA []byte, %s) (int, error) {\n", name, param)
+	buf.WriteString(marshalBody(fields))
+	buf.WriteString("}\n\n")
+
+	fmt.Fprintf(buf, "func size%sProteus(%s) (n int) {\n", name, param)
+	buf.WriteString(sizeBody(fields))
+	buf.WriteString("}\n\n")
+
+	fmt.Fprintf(buf, "func unmarshal%sProteus(dAtA []byte, %s) error {\n", name, param)
+	buf.WriteString(unmarshalBody(fields))
+	buf.WriteString("}\n\n")
+}
+
+// marshalBody returns the statements that write the given fields at the end
+// of dAtA and return the number of bytes written. Fields are written
+// backwards, so the last one is written first.
+func marshalBody(fields []*fieldCodec) string {
+	var buf bytes.Buffer
+	buf.WriteString("i := len(dAtA)\n")
+	for i := len(fields) - 1; i >= 0; i-- {
+		buf.WriteString(fields[i].marshal())
+	}
+	buf.WriteString("return len(dAtA) - i, nil\n")
+	return buf.String()
+}
+
+// sizeBody returns the statements that compute the size of the given fields
+// into n and return it.
+func sizeBody(fields []*fieldCodec) string {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		buf.WriteString(f.size())
+	}
+	buf.WriteString("return n\n")
+	return buf.String()
+}
+
+// unmarshalBody returns the statements that read the given fields from dAtA,
+// skipping the unknown ones.
+func unmarshalBody(fields []*fieldCodec) string {
+	var buf bytes.Buffer
+	buf.WriteString("for iNdEx := 0; iNdEx < len(dAtA); {\n")
+	buf.WriteString("key, n, err := consumeVarintProteus(dAtA[iNdEx:])\n")
+	buf.WriteString("if err != nil {\nreturn err\n}\niNdEx += n\n")
+	buf.WriteString("wireType := int(key & 0x7)\nswitch key >> 3 {\n")
+	for _, f := range fields {
+		buf.WriteString(f.cases())
+	}
+	buf.WriteString("default:\nn, err := skipProteus(dAtA[iNdEx:], wireType)\n")
+	buf.WriteString("if err != nil {\nreturn err\n}\niNdEx += n\n}\n}\nreturn nil\n")
+	return buf.String()
+}
+
+type context struct {
+	*gen.File
+	proto *protobuf.Package
+	pkg   *types.Package
+	// funcs are the functions that marshal and unmarshal the messages that
+	// cannot have methods.
+	funcs bytes.Buffer
+	// helpers are the names of the functions written to funcs, and the
+	// names of the optional helpers used.
+	helpers map[string]bool
+}
+
+func newContext(proto *protobuf.Package, pkg *types.Package) *context {
+	return &context{
+		File:    gen.NewFile(pkg),
+		proto:   proto,
+		pkg:     pkg,
+		helpers: make(map[string]bool),
+	}
+}
+
+// messageType returns the Go struct of the given message, if it is declared
+// in the package. Instantiations of generic structs are not returned, as
+// they cannot have methods.
+func (c *context) messageType(msg *protobuf.Message) *types.Named {
+	if msg.Generic != "" {
+		return nil
+	}
+
+	obj, ok := c.pkg.Scope().Lookup(msg.Name).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+
+	named, ok := obj.Type().(*types.Named)
+	if !ok || named.TypeParams().Len() > 0 {
+		return nil
+	}
+
+	if _, ok := named.Underlying().(*types.Struct); !ok {
+		return nil
+	}
+	return named
+}
+
+// findMessage returns the message of the proto package with the given name.
+func (c *context) findMessage(name string) *protobuf.Message {
+	if c.proto == nil {
+		return nil
+	}
+
+	for _, m := range c.proto.Messages {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,618 @@
+package marshal
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/resolver"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+type MarshalSuite struct {
+	suite.Suite
+	g *Generator
+}
+
+func (s *MarshalSuite) SetupTest() {
+	s.g = NewGenerator()
+}
+
+func (s *MarshalSuite) TestFieldCodecs() {
+	ctx := newContext(nil, s.fakePkg())
+	foo := ctx.pkg.Scope().Lookup("Foo").Type()
+	msg := &protobuf.Message{Name: "Foo"}
+
+	cases := []struct {
+		field         *protobuf.Field
+		size, marshal string
+	}{
+		{
+			&protobuf.Field{Name: "name", GoName: "Name", Pos: 1, Type: protobuf.NewBasic("string")},
+			"if len(m.Name) > 0 {\nn += 1 + sizeBytesProteus(len(m.Name))\n}\n",
+			"if len(m.Name) > 0 {\ni -= len(m.Name)\ncopy(dAtA[i:], m.Name)\ni = encodeVarintProteus(dAtA, i, uint64(len(m.Name)))\ni--\ndAtA[i] = 0xa\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "age", GoName: "Age", Pos: 2, Type: protobuf.NewBasic("int64")},
+			"if m.Age != 0 {\nn += 1 + sizeVarintProteus(uint64(m.Age))\n}\n",
+			"if m.Age != 0 {\ni = encodeVarintProteus(dAtA, i, uint64(m.Age))\ni--\ndAtA[i] = 0x10\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "nick", GoName: "Nick", Pos: 20, Type: protobuf.NewBasic("string")},
+			"if m.Nick != nil && len(*m.Nick) > 0 {\nn += 2 + sizeBytesProteus(len(*m.Nick))\n}\n",
+			"if m.Nick != nil && len(*m.Nick) > 0 {\ni -= len(*m.Nick)\ncopy(dAtA[i:], *m.Nick)\ni = encodeVarintProteus(dAtA, i, uint64(len(*m.Nick)))\ni--\ndAtA[i] = 0x1\ni--\ndAtA[i] = 0xa2\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "ok", GoName: "OK", Pos: 3, Type: protobuf.NewBasic("bool")},
+			"if m.OK {\nn += 1 + 1\n}\n",
+			"if m.OK {\ni--\nif m.OK {\ndAtA[i] = 1\n} else {\ndAtA[i] = 0\n}\ni--\ndAtA[i] = 0x18\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "ratio", GoName: "Ratio", Pos: 4, Type: protobuf.NewBasic("double")},
+			"if m.Ratio != 0 {\nn += 1 + 8\n}\n",
+			"if m.Ratio != 0 {\ni -= 8\nbinary.LittleEndian.PutUint64(dAtA[i:], math.Float64bits(m.Ratio))\ni--\ndAtA[i] = 0x21\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "kind", GoName: "Kind", Pos: 5, Type: protobuf.NewNamed("fake", "Kind")},
+			"if m.Kind != 0 {\nn += 1 + sizeVarintProteus(uint64(m.Kind))\n}\n",
+			"if m.Kind != 0 {\ni = encodeVarintProteus(dAtA, i, uint64(m.Kind))\ni--\ndAtA[i] = 0x28\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "kinds", GoName: "Kinds", Pos: 6, Type: protobuf.NewNamed("fake", "Kind"), Repeated: true},
+			"if len(m.Kinds) > 0 {\nl := 0\nfor _, x := range m.Kinds {\nl += sizeVarintProteus(uint64(x))\n}\nn += 1 + sizeBytesProteus(l)\n}\n",
+			"if len(m.Kinds) > 0 {\nj := i\nfor k := len(m.Kinds) - 1; k >= 0; k-- {\ni = encodeVarintProteus(dAtA, i, uint64(m.Kinds[k]))\n}\ni = encodeVarintProteus(dAtA, i, uint64(j-i))\ni--\ndAtA[i] = 0x32\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "bar", GoName: "Bar", Pos: 7, Type: protobuf.NewNamed("fake", "Bar")},
+			"if m.Bar != nil {\nn += 1 + sizeBytesProteus(m.Bar.ProtoSize())\n}\n",
+			"if m.Bar != nil {\n{\nsize, err := m.Bar.MarshalToSizedBuffer(dAtA[:i])\nif err != nil {\nreturn 0, err\n}\ni -= size\ni = encodeVarintProteus(dAtA, i, uint64(size))\n}\ni--\ndAtA[i] = 0x3a\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "bars", GoName: "Bars", Pos: 8, Type: protobuf.NewNamed("fake", "Bar"), Repeated: true},
+			"for _, x := range m.Bars {\nn += 1 + sizeBytesProteus(x.ProtoSize())\n}\n",
+			"for k := len(m.Bars) - 1; k >= 0; k-- {\n{\nsize, err := m.Bars[k].MarshalToSizedBuffer(dAtA[:i])\nif err != nil {\nreturn 0, err\n}\ni -= size\ni = encodeVarintProteus(dAtA, i, uint64(size))\n}\ni--\ndAtA[i] = 0x42\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "at", GoName: "At", Pos: 9, Type: protobuf.NewNamed("google.protobuf", "Timestamp")},
+			"n += 1 + sizeBytesProteus(sizeTimestampProteus(m.At))\n",
+			"{\nsize, err := marshalTimestampProteus(dAtA[:i], m.At)\nif err != nil {\nreturn 0, err\n}\ni -= size\ni = encodeVarintProteus(dAtA, i, uint64(size))\n}\ni--\ndAtA[i] = 0x4a\n",
+		},
+		{
+			&protobuf.Field{Name: "note", GoName: "Note", Pos: 10, Type: protobuf.NewNamed("google.protobuf", "StringValue")},
+			"if m.Note.Valid {\nn += 1 + sizeBytesProteus(sizeStringValueProteus(m.Note.String))\n}\n",
+			"if m.Note.Valid {\n{\nsize, err := marshalStringValueProteus(dAtA[:i], m.Note.String)\nif err != nil {\nreturn 0, err\n}\ni -= size\ni = encodeVarintProteus(dAtA, i, uint64(size))\n}\ni--\ndAtA[i] = 0x52\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "flags", GoName: "Flags", Pos: 11, Type: protobuf.NewMap(protobuf.NewBasic("string"), protobuf.NewBasic("bool"))},
+			"for k := range m.Flags {\nl := 1 + sizeBytesProteus(len(k))\nl += 1 + 1\nn += 1 + sizeBytesProteus(l)\n}\n",
+			"for k, x := range m.Flags {\nj := i\ni--\nif x {\ndAtA[i] = 1\n} else {\ndAtA[i] = 0\n}\ni--\ndAtA[i] = 0x10\ni -= len(k)\ncopy(dAtA[i:], k)\ni = encodeVarintProteus(dAtA, i, uint64(len(k)))\ni--\ndAtA[i] = 0xa\ni = encodeVarintProteus(dAtA, i, uint64(j-i))\ni--\ndAtA[i] = 0x5a\n}\n",
+		},
+		{
+			&protobuf.Field{Name: "meta", GoName: "Meta", Pos: 12, Type: protobuf.NewNamed("", "Meta")},
+			"n += 1 + sizeBytesProteus(sizeFoo_MetaProteus(&m.Meta))\n",
+			"{\nsize, err := marshalFoo_MetaProteus(dAtA[:i], &m.Meta)\nif err != nil {\nreturn 0, err\n}\ni -= size\ni = encodeVarintProteus(dAtA, i, uint64(size))\n}\ni--\ndAtA[i] = 0x62\n",
+		},
+	}
+
+	msg.AddMessage(&protobuf.Message{
+		Name: "Meta",
+		Fields: []*protobuf.Field{
+			{Name: "name", GoName: "Name", Pos: 1, Type: protobuf.NewBasic("string")},
+		},
+	})
+
+	for _, c := range cases {
+		codec, err := ctx.fieldCodec(msg, foo, c.field)
+		s.NoError(err, c.field.Name)
+		s.Equal(c.size, codec.size(), c.field.Name)
+		s.Equal(c.marshal, codec.marshal(), c.field.Name)
+	}
+
+	nick := &protobuf.Field{Name: "nick", GoName: "Nick", Pos: 20, Type: protobuf.NewBasic("string")}
+	codec, err := ctx.fieldCodec(msg, foo, nick)
+	s.NoError(err)
+	s.Contains(codec.cases(), "if val1 := string(b); len(val1) > 0 {\nm.Nick = &val1\n}\n", "zero values of fields without presence are not set")
+
+	nick.Optional = true
+	codec, err = ctx.fieldCodec(msg, foo, nick)
+	s.NoError(err)
+	s.Equal("if m.Nick != nil {\nn += 2 + sizeBytesProteus(len(*m.Nick))\n}\n", codec.size(), "zero values of optional fields are written")
+}
+
+func (s *MarshalSuite) TestFieldCodecsNotSupported() {
+	ctx := newContext(nil, s.fakePkg())
+	foo := ctx.pkg.Scope().Lookup("Foo").Type()
+
+	fields := []*protobuf.Field{
+		{Name: "missing", GoName: "Missing", Type: protobuf.NewBasic("string")},
+		{Name: "name", GoName: "Name", Type: protobuf.NewBasic("int64")},
+		{Name: "at", GoName: "At", Type: protobuf.NewNamed("google.protobuf", "Duration")},
+		{Name: "meta", GoName: "Meta", Type: protobuf.NewNamed("", "Missing")},
+		{Name: "data", GoName: "Data", Type: protobuf.NewNamed("google.protobuf", "Struct")},
+		{Name: "kinds", GoName: "Kind", Type: protobuf.NewNamed("fake", "Kind"), Repeated: true},
+	}
+
+	for _, f := range fields {
+		_, err := ctx.fieldCodec(&protobuf.Message{Name: "Foo"}, foo, f)
+		s.Error(err, f.Name)
+	}
+}
+
+const expectedMessageMethods = `package fake
+
+func (m *Bar) Marshal() (dAtA []byte, err error) {
+	size := m.ProtoSize()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Bar) MarshalTo(dAtA []byte) (int, error) {
+	size := m.ProtoSize()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Bar) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	if len(m.Name) > 0 {
+		i -= len(m.Name)
+		copy(dAtA[i:], m.Name)
+		i = encodeVarintProteus(dAtA, i, uint64(len(m.Name)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Bar) ProtoSize() (n int) {
+	if m == nil {
+		return 0
+	}
+	if len(m.Name) > 0 {
+		n += 1 + sizeBytesProteus(len(m.Name))
+	}
+	return n
+}
+
+func (m *Bar) Unmarshal(dAtA []byte) error {
+	for iNdEx := 0; iNdEx < len(dAtA); {
+		key, n, err := consumeVarintProteus(dAtA[iNdEx:])
+		if err != nil {
+			return err
+		}
+		iNdEx += n
+		wireType := int(key & 0x7)
+		switch key >> 3 {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			b, n, err := consumeBytesProteus(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+			m.Name = string(b)
+		default:
+			n, err := skipProteus(dAtA[iNdEx:], wireType)
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+		}
+	}
+	return nil
+}
+
+func (m *Drawing) Marshal() (dAtA []byte, err error) {
+	size := m.ProtoSize()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Drawing) MarshalTo(dAtA []byte) (int, error) {
+	size := m.ProtoSize()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Drawing) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	switch x := m.Shape.(type) {
+	case Circle:
+		{
+			size, err := x.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintProteus(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0xa
+	case *Circle:
+		if x != nil {
+			{
+				size, err := x.MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintProteus(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
+		}
+	case *Square:
+		if x != nil {
+			{
+				size, err := x.MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintProteus(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Drawing) ProtoSize() (n int) {
+	if m == nil {
+		return 0
+	}
+	switch x := m.Shape.(type) {
+	case Circle:
+		n += 1 + sizeBytesProteus(x.ProtoSize())
+	case *Circle:
+		if x != nil {
+			n += 1 + sizeBytesProteus(x.ProtoSize())
+		}
+	case *Square:
+		if x != nil {
+			n += 1 + sizeBytesProteus(x.ProtoSize())
+		}
+	}
+	return n
+}
+
+func (m *Drawing) Unmarshal(dAtA []byte) error {
+	for iNdEx := 0; iNdEx < len(dAtA); {
+		key, n, err := consumeVarintProteus(dAtA[iNdEx:])
+		if err != nil {
+			return err
+		}
+		iNdEx += n
+		wireType := int(key & 0x7)
+		switch key >> 3 {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field circle", wireType)
+			}
+			b, n, err := consumeBytesProteus(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+			var val Circle
+			if err := val.Unmarshal(b); err != nil {
+				return err
+			}
+			m.Shape = val
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field square", wireType)
+			}
+			b, n, err := consumeBytesProteus(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+			var val Square
+			if err := val.Unmarshal(b); err != nil {
+				return err
+			}
+			m.Shape = &val
+		default:
+			n, err := skipProteus(dAtA[iNdEx:], wireType)
+			if err != nil {
+				return err
+			}
+			iNdEx += n
+		}
+	}
+	return nil
+}
+
+func (m *Baz) Marshal() (dAtA []byte, err error) {
+	size := m.ProtoSize()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Baz) MarshalTo(dAtA []byte) (int, error) {
+	size := m.ProtoSize()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+`
+
+func (s *MarshalSuite) TestWriteMessageMethods() {
+	ctx := newContext(nil, s.fakePkg())
+
+	var buf bytes.Buffer
+	s.g.writeMessageMethods(ctx, &buf, &protobuf.Message{
+		Name: "Bar",
+		Fields: []*protobuf.Field{
+			{Name: "name", GoName: "Name", Pos: 1, Type: protobuf.NewBasic("string")},
+			{Name: "missing", GoName: "Missing", Pos: 2, Type: protobuf.NewBasic("string")},
+		},
+	})
+	s.g.writeMessageMethods(ctx, &buf, &protobuf.Message{Name: "FooRequest"})
+	s.g.writeMessageMethods(ctx, &buf, &protobuf.Message{Name: "Page"})
+	s.g.writeMessageMethods(ctx, &buf, &protobuf.Message{Name: "Custom"})
+
+	circle := protobuf.NewNamed("fake", "Circle")
+	circle.SetSource(scanner.NewNamed("fake", "Circle"))
+	square := protobuf.NewNamed("fake", "Square")
+	squareSrc := scanner.NewNamed("fake", "Square")
+	squareSrc.SetNullable(true)
+	square.SetSource(squareSrc)
+	s.g.writeMessageMethods(ctx, &buf, &protobuf.Message{
+		Name: "Drawing",
+		Oneofs: []*protobuf.Oneof{{
+			Name:   "shape",
+			GoName: "Shape",
+			Fields: []*protobuf.Field{
+				{Name: "circle", Pos: 1, Type: circle},
+				{Name: "square", Pos: 2, Type: square},
+			},
+		}},
+	})
+	s.g.writeMessageMethods(ctx, &buf, &protobuf.Message{Name: "Baz"})
+
+	s.Equal(expectedMessageMethods, s.render(ctx, &buf))
+}
+
+func (s *MarshalSuite) TestGenerate() {
+	pkg := "gopkg.in/src-d/proteus.v1/fixtures/subpkg"
+	scanner, err := scanner.New(pkg)
+	s.Nil(err)
+
+	pkgs, err := scanner.Scan()
+	s.Nil(err)
+
+	r := resolver.New()
+	r.Resolve(pkgs)
+
+	t := protobuf.NewTransformer()
+	t.SetMarshalers(true)
+	proto, err := t.Transform(pkgs[0])
+	s.Nil(err)
+	s.Nil(s.g.Generate(proto, pkg))
+
+	path := projectPath("fixtures/subpkg/marshal.proteus.go")
+	defer func() {
+		s.Nil(os.Remove(path))
+	}()
+
+	// The generated file must compile along with the package, and only
+	// the struct declared in it gets methods.
+	fs := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range []string{"foo.go", "marshal.proteus.go"} {
+		f, err := parser.ParseFile(fs, projectPath(filepath.Join("fixtures/subpkg", name)), nil, 0)
+		s.Nil(err)
+		files = append(files, f)
+	}
+
+	config := types.Config{Importer: importer.Default()}
+	typesPkg, err := config.Check(pkg, fs, files, nil)
+	s.Nil(err)
+
+	point := types.NewPointer(typesPkg.Scope().Lookup("Point").Type())
+	for _, method := range []string{"Marshal", "MarshalTo", "MarshalToSizedBuffer", "ProtoSize", "Unmarshal"} {
+		obj, _, _ := types.LookupFieldOrMethod(point, true, typesPkg, method)
+		s.NotNil(obj, method)
+	}
+	s.Nil(typesPkg.Scope().Lookup("GeneratedRequest"))
+}
+
+// TestGenerateRoundTrip generates the marshal methods of a fixture with
+// every kind of field, and runs its tests, which marshal and unmarshal its
+// messages with google.golang.org/protobuf using the generated descriptor
+// set.
+func (s *MarshalSuite) TestGenerateRoundTrip() {
+	if testing.Short() {
+		s.T().Skip("the round trip tests of the fixture are not run in short mode")
+	}
+
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		s.T().Skip("go command not found")
+	}
+
+	pkg := "gopkg.in/src-d/proteus.v1/fixtures/roundtrip"
+	sc, err := scanner.New(pkg)
+	s.Nil(err)
+
+	pkgs, err := sc.Scan()
+	s.Nil(err)
+
+	r := resolver.New()
+	r.Resolve(pkgs)
+
+	var (
+		structs     = protobuf.NewTypeSet()
+		enums       = protobuf.NewTypeSet()
+		stringEnums = protobuf.NewTypeSet()
+		ifaces      = make(map[string]*scanner.Interface)
+	)
+	for _, st := range pkgs[0].Structs {
+		structs.Add(pkgs[0].Path, st.Name)
+	}
+	for _, e := range pkgs[0].Enums {
+		enums.Add(pkgs[0].Path, e.Name)
+		if e.IsString {
+			stringEnums.Add(pkgs[0].Path, e.Name)
+		}
+	}
+	for _, i := range pkgs[0].Interfaces {
+		ifaces[pkgs[0].Path+"."+i.Name] = i
+	}
+
+	t := protobuf.NewTransformer()
+	t.SetMarshalers(true)
+	t.SetStructSet(structs)
+	t.SetEnumSet(enums)
+	t.SetStringEnumSet(stringEnums)
+	t.SetInterfaces(ifaces)
+	proto, err := t.Transform(pkgs[0])
+	s.Nil(err)
+
+	dir, err := ioutil.TempDir("", "proteus")
+	s.Nil(err)
+	defer os.RemoveAll(dir)
+
+	s.Nil(protobuf.NewGenerator(dir).Generate(proto))
+	s.Nil(s.g.Generate(proto, pkg))
+
+	path := projectPath("fixtures/roundtrip/marshal.proteus.go")
+	defer func() {
+		s.Nil(os.Remove(path))
+	}()
+
+	cmd := exec.Command(goCmd, "test", "-count=1", "-tags", "roundtrip", ".")
+	cmd.Dir = filepath.Dir(path)
+	cmd.Env = append(os.Environ(), "PROTEUS_DESCRIPTOR_SET="+filepath.Join(dir, proto.Path, protobuf.DescriptorSetFileName))
+	out, err := cmd.CombinedOutput()
+	s.Nil(err, string(out))
+}
+
+const testPkg = `package fake
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Kind int
+
+type Bar struct {
+	Name string
+}
+
+type Page[T any] struct {
+	Items []T
+}
+
+type Foo struct {
+	Name  string
+	Age   int
+	Nick  *string
+	OK    bool
+	Ratio float64
+	Kind  Kind
+	Kinds []Kind
+	Bar   *Bar
+	Bars  []Bar
+	At    time.Time
+	Note  sql.NullString
+	Flags map[string]bool
+	Meta  struct{ Name string }
+	Data  map[string]interface{}
+}
+
+type Shape interface{ isShape() }
+
+type Circle struct {
+	Radius int
+}
+
+func (Circle) isShape() {}
+
+type Square struct {
+	Side int
+}
+
+func (*Square) isShape() {}
+
+type Drawing struct {
+	Shape Shape
+}
+
+type Custom struct{}
+
+func (*Custom) Marshal() ([]byte, error)                      { return nil, nil }
+func (*Custom) MarshalTo([]byte) (int, error)                 { return 0, nil }
+func (*Custom) MarshalToSizedBuffer([]byte) (int, error)      { return 0, nil }
+func (*Custom) ProtoSize() int                                { return 0 }
+func (*Custom) Unmarshal([]byte) error                        { return nil }
+
+type Baz struct{}
+
+func (*Baz) MarshalToSizedBuffer([]byte) (int, error) { return 0, nil }
+func (*Baz) ProtoSize() int                           { return 0 }
+func (*Baz) Unmarshal([]byte) error                   { return nil }
+`
+
+func (s *MarshalSuite) fakePkg() *types.Package {
+	fs := token.NewFileSet()
+
+	f, err := parser.ParseFile(fs, "src.go", testPkg, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	config := types.Config{
+		FakeImportC: true,
+		Importer:    importer.Default(),
+	}
+
+	pkg, err := config.Check("fake", fs, []*ast.File{f}, nil)
+	s.Nil(err)
+	return pkg
+}
+
+func (s *MarshalSuite) render(ctx *context, buf *bytes.Buffer) string {
+	src, err := format.Source(append(ctx.Header(), buf.Bytes()...))
+	s.Nil(err)
+	return string(src)
+}
+
+func TestMarshalSuite(t *testing.T) {
+	suite.Run(t, new(MarshalSuite))
+}
+
+// projectPath returns the absolute path of the given file of the project,
+// found from the directory of the package being tested.
+func projectPath(path string) string {
+	abs, err := filepath.Abs(filepath.Join("..", path))
+	if err != nil {
+		panic(err)
+	}
+	return abs
+}
@@ -0,0 +1,93 @@
+package output // import "gopkg.in/src-d/proteus.v1/output"
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Output is the destination of the files written by the generators.
+type Output interface {
+	// WriteFile writes a file with the given path and contents, replacing
+	// it if it already exists.
+	WriteFile(path string, data []byte) error
+}
+
+// Disk is the Output that writes the files to disk, in directories that must
+// already exist. It is the one used by default.
+var Disk Output = disk{}
+
+// NewDisk creates an Output that writes the files to disk under the given
+// base path, which must exist. The directories of the files that do not
+// exist are created with the mode of the base path, which is the mode of
+// the files as well.
+func NewDisk(basePath string) Output {
+	return disk{basePath}
+}
+
+type disk struct {
+	basePath string
+}
+
+func (d disk) WriteFile(path string, data []byte) error {
+	if d.basePath == "" {
+		return ioutil.WriteFile(path, data, 0666)
+	}
+
+	fi, err := os.Stat(d.basePath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), fi.Mode()); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, fi.Mode())
+}
+
+// Memory is an Output that keeps the files in memory, by their path.
+type Memory map[string][]byte
+
+// NewMemory creates a new empty Memory.
+func NewMemory() Memory {
+	return make(Memory)
+}
+
+// WriteFile keeps a copy of the given contents as the file with the given
+// path.
+func (m Memory) WriteFile(path string, data []byte) error {
+	m[filepath.Clean(path)] = append([]byte(nil), data...)
+	return nil
+}
+
+// Paths returns the paths of the files, sorted.
+func (m Memory) Paths() []string {
+	var paths = make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// WriterFunc is an Output that writes every file to the writer returned by
+// the function for its path, which is closed once the file is written.
+type WriterFunc func(path string) (io.WriteCloser, error)
+
+// WriteFile writes the given contents to the writer for the given path.
+func (f WriterFunc) WriteFile(path string, data []byte) error {
+	w, err := f(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,94 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisk(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "proteus")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "generated.proto")
+	require.NoError(Disk.WriteFile(path, []byte("foo")))
+	require.NoError(Disk.WriteFile(path, []byte("bar")))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	require.Equal("bar", string(data))
+
+	err = Disk.WriteFile(filepath.Join(dir, "foo", "generated.proto"), data)
+	require.True(os.IsNotExist(err), "directories are not created")
+}
+
+func TestNewDisk(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "proteus")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base")
+	out := NewDisk(base)
+	path := filepath.Join(base, "foo", "bar", "generated.proto")
+	err = out.WriteFile(path, []byte("foo"))
+	require.True(os.IsNotExist(err), "the base path must exist")
+
+	require.NoError(os.Mkdir(base, 0750))
+	require.NoError(os.Chmod(base, 0750))
+	require.NoError(out.WriteFile(path, []byte("foo")))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	require.Equal("foo", string(data))
+
+	for _, p := range []string{filepath.Dir(path), path} {
+		fi, err := os.Stat(p)
+		require.NoError(err)
+		require.Equal(os.FileMode(0750), fi.Mode().Perm(), "mode of %s", p)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	require := require.New(t)
+	m := NewMemory()
+
+	data := []byte("foo")
+	require.NoError(m.WriteFile("foo/./bar/generated.proto", data))
+	require.NoError(m.WriteFile("baz/generated.proto", []byte("baz")))
+	data[0] = 'g'
+
+	require.Equal([]string{"baz/generated.proto", "foo/bar/generated.proto"}, m.Paths())
+	require.Equal("foo", string(m["foo/bar/generated.proto"]), "contents are copied")
+}
+
+type closer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestWriterFunc(t *testing.T) {
+	require := require.New(t)
+
+	var w closer
+	out := WriterFunc(func(path string) (io.WriteCloser, error) {
+		require.Equal("foo/generated.proto", path)
+		return &w, nil
+	})
+
+	require.NoError(out.WriteFile("foo/generated.proto", []byte("foo")))
+	require.Equal("foo", w.String())
+	require.True(w.closed)
+}
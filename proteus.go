@@ -1,6 +1,9 @@
 package proteus
 
 import (
+	"gopkg.in/src-d/proteus.v1/convert"
+	"gopkg.in/src-d/proteus.v1/marshal"
+	"gopkg.in/src-d/proteus.v1/output"
 	"gopkg.in/src-d/proteus.v1/protobuf"
 	"gopkg.in/src-d/proteus.v1/resolver"
 	"gopkg.in/src-d/proteus.v1/rpc"
@@ -11,12 +14,51 @@ import (
 type Options struct {
 	BasePath string
 	Packages []string
+	// Nullable is the way fields holding values that may not be set, such
+	// as pointers to basic types, are generated.
+	Nullable protobuf.NullableMode
+	// Mappings are the custom mappings of Go types to protobuf types, which
+	// take precedence over the default ones. The mapped Go types are allowed
+	// even if their packages are not scanned.
+	Mappings protobuf.TypeMappings
+	// CustomTypes are the full names of the Go types of packages that are
+	// not scanned that are allowed anyway, e.g. "github.com/google/uuid.UUID".
+	// They must have a mapping, or they are ignored.
+	CustomTypes []string
+	// CustomPackages are the import paths of the packages that are not
+	// scanned whose types are allowed anyway. Their types must have a
+	// mapping, or they are ignored.
+	CustomPackages []string
+	// Discover are the import path prefixes of the packages that are
+	// scanned if the types of the given packages refer to their types, even
+	// though they are not in Packages. Only the types that are referenced are
+	// generated. If empty, no package is discovered.
+	Discover []string
+	// Target is the protobuf code generator the proto files are generated
+	// for. By default, they are generated for the gogo protobuf generators.
+	Target protobuf.Target
+	// Output is where the generated files are written to. If nil, they are
+	// written to disk.
+	Output output.Output
+}
+
+// output returns the output of the options or, if it is nil, the given
+// default one.
+func (o Options) output(def output.Output) output.Output {
+	if o.Output == nil {
+		return def
+	}
+	return o.Output
 }
 
 type generator func(*scanner.Package, *protobuf.Package) error
 
-func transformToProtobuf(packages []string, generate generator) error {
-	scanner, err := scanner.New(packages...)
+// preparer is called with the transformer and the scanned packages before
+// any package is transformed.
+type preparer func(*protobuf.Transformer, []*scanner.Package) error
+
+func transformToProtobuf(options Options, prepare preparer, generate generator) error {
+	scanner, err := scanner.New(options.Packages...)
 	if err != nil {
 		return err
 	}
@@ -26,14 +68,53 @@ func transformToProtobuf(packages []string, generate generator) error {
 		return err
 	}
 
+	t := protobuf.NewTransformer()
+	t.SetMappings(options.Mappings)
+
 	r := resolver.New()
+	for name := range options.Mappings {
+		r.AddCustomTypes(name)
+	}
+	r.AddCustomTypes(options.CustomTypes...)
+	r.AddCustomPackages(options.CustomPackages...)
+	r.SetMappingChecker(t.HasMapping)
+	if len(options.Discover) > 0 {
+		r.SetDiscovery(scanner.ScanPackage, options.Discover...)
+		if pkgs, err = r.Discover(pkgs); err != nil {
+			return err
+		}
+	}
+
+	if protoc := protocMappings(pkgs, options); len(protoc) > 0 {
+		var mappings = make(protobuf.TypeMappings, len(options.Mappings)+len(protoc))
+		for name, typ := range options.Mappings {
+			mappings[name] = typ
+		}
+
+		for name, typ := range protoc {
+			mappings[name] = typ
+			r.AddCustomTypes(name)
+		}
+		t.SetMappings(mappings)
+	}
 	r.Resolve(pkgs)
 
-	t := protobuf.NewTransformer()
 	t.SetStructSet(createStructTypeSet(pkgs))
 	t.SetEnumSet(createEnumTypeSet(pkgs))
+	t.SetStringEnumSet(createStringEnumTypeSet(pkgs))
+	t.SetInterfaces(createInterfaces(pkgs))
+	if prepare != nil {
+		if err := prepare(t, pkgs); err != nil {
+			return err
+		}
+	}
+
 	for _, p := range pkgs {
-		pkg := t.Transform(p)
+		pkg, err := t.Transform(p)
+		if err != nil {
+			return err
+		}
+
 		if err := generate(p, pkg); err != nil {
 			return err
 		}
@@ -62,19 +143,144 @@ func createEnumTypeSet(pkgs []*scanner.Package) protobuf.TypeSet {
 	return ts
 }
 
-// GenerateProtos generates proto files for the given options.
+func createStringEnumTypeSet(pkgs []*scanner.Package) protobuf.TypeSet {
+	ts := protobuf.NewTypeSet()
+	for _, p := range pkgs {
+		for _, e := range p.Enums {
+			if e.IsString {
+				ts.Add(p.Path, e.Name)
+			}
+		}
+	}
+	return ts
+}
+
+func createInterfaces(pkgs []*scanner.Package) map[string]*scanner.Interface {
+	ifaces := make(map[string]*scanner.Interface)
+	for _, p := range pkgs {
+		for _, i := range p.Interfaces {
+			ifaces[p.Path+"."+i.Name] = i
+		}
+	}
+	return ifaces
+}
+
+// GenerateProtos generates proto files for the given options. The numbers
+// assigned to fields and enum values are read from and written to the
+// proteus.lock file next to the generated proto of every package, so they
+// stay the same across generations.
 func GenerateProtos(options Options) error {
 	g := protobuf.NewGenerator(options.BasePath)
-	return transformToProtobuf(options.Packages, func(_ *scanner.Package, pkg *protobuf.Package) error {
+	g.SetOutput(options.output(output.NewDisk(options.BasePath)))
+	return transformToProtobuf(options, lockedPreparer(g, options, options.Target), func(_ *scanner.Package, pkg *protobuf.Package) error {
 		return g.Generate(pkg)
 	})
 }
 
+// lockedPreparer returns the preparer that sets the locks read by the given
+// generator, along with the nullable mode of the given options and the given
+// target.
+func lockedPreparer(g *protobuf.Generator, options Options, target protobuf.Target) preparer {
+	return func(t *protobuf.Transformer, pkgs []*scanner.Package) error {
+		var paths = make([]string, len(pkgs))
+		for i, p := range pkgs {
+			paths[i] = p.Path
+		}
+
+		locks, err := g.ReadLocks(paths)
+		if err != nil {
+			return err
+		}
+
+		t.SetLocks(locks)
+		t.SetNullableMode(options.Nullable)
+		t.SetTarget(target)
+		return nil
+	}
+}
+
+// GenerateMarshalers generates the proto files for the given options, for
+// the protobuf.TargetGogo target, along with the methods that marshal the Go
+// types of every package to the protobuf wire format and unmarshal them back.
+// As the methods are generated from the same numbers the proto files are,
+// neither protoc nor any protobuf code generator are needed.
+func GenerateMarshalers(options Options) error {
+	var (
+		protos   = protobuf.NewGenerator(options.BasePath)
+		marshals = marshal.NewGenerator()
+	)
+	protos.SetOutput(options.output(output.NewDisk(options.BasePath)))
+	marshals.SetOutput(options.output(output.Disk))
+	prepare := lockedPreparer(protos, options, protobuf.TargetGogo)
+	return transformToProtobuf(options, func(t *protobuf.Transformer, pkgs []*scanner.Package) error {
+		t.SetMarshalers(true)
+		return prepare(t, pkgs)
+	}, func(p *scanner.Package, pkg *protobuf.Package) error {
+		if err := protos.Generate(pkg); err != nil {
+			return err
+		}
+		return marshals.Generate(pkg, p.Path)
+	})
+}
+
 // GenerateRPCServer generates the gRPC server implementation of the given
 // packages.
 func GenerateRPCServer(packages []string) error {
+	return GenerateRPCServerWithOptions(Options{Packages: packages})
+}
+
+// GenerateRPCServerWithOptions generates the gRPC server implementation of the
+// packages of the given options, which must use the same mappings the proto
+// files were generated with. The base path and nullable mode are ignored.
+func GenerateRPCServerWithOptions(options Options) error {
 	g := rpc.NewGenerator()
-	return transformToProtobuf(packages, func(p *scanner.Package, pkg *protobuf.Package) error {
+	g.SetOutput(options.output(output.Disk))
+	return transformToProtobuf(options, nil, func(p *scanner.Package, pkg *protobuf.Package) error {
+		return g.Generate(pkg, p.Path)
+	})
+}
+
+// GenerateConverters generates the functions that convert between the Go
+// types of the packages of the given options and the Go types generated by
+// protoc-gen-go for their proto files, which must have been generated for
+// the protobuf.TargetGolang target with the same options. The base path is
+// ignored.
+func GenerateConverters(options Options) error {
+	g := convert.NewGenerator()
+	g.SetOutput(options.output(output.Disk))
+	return transformToProtobuf(options, func(t *protobuf.Transformer, _ []*scanner.Package) error {
+		t.SetNullableMode(options.Nullable)
+		t.SetTarget(protobuf.TargetGolang)
+		return nil
+	}, func(p *scanner.Package, pkg *protobuf.Package) error {
 		return g.Generate(pkg, p.Path)
 	})
 }
+
+// GenerateInMemory generates the proto files of the packages of the given
+// options, along with their gRPC server implementation if they are generated
+// for the protobuf.TargetGogo target or their conversion functions if they
+// are generated for the protobuf.TargetGolang target, and returns all the
+// generated files by path instead of writing them. The output of the options
+// is ignored. The locks are still read from the base path on disk, so the
+// numbers of the generated protos are the same GenerateProtos would write.
+func GenerateInMemory(options Options) (output.Memory, error) {
+	files := output.NewMemory()
+	options.Output = files
+	if err := GenerateProtos(options); err != nil {
+		return nil, err
+	}
+
+	switch options.Target {
+	case protobuf.TargetGogo:
+		if err := GenerateRPCServerWithOptions(options); err != nil {
+			return nil, err
+		}
+	case protobuf.TargetGolang:
+		if err := GenerateConverters(options); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
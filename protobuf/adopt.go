@@ -0,0 +1,339 @@
+package protobuf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/src-d/proteus.v1/report"
+)
+
+// Adopter builds the locks of packages transformed by proteus from the
+// schemas they replace, such as hand-written proto files read with
+// ReadProto, so the numbers of their fields and enum values are kept and the
+// generated proto files are compatible in the wire with the old ones.
+type Adopter struct {
+	messages map[string]*Message
+	enums    map[string]*Enum
+	renames  map[string]string
+	// schemas are the builders of the descriptors of the schemas declaring
+	// the messages, by their full name.
+	schemas map[string]*descriptorBuilder
+}
+
+// NewAdopter creates a new Adopter of the messages and enums of the given
+// schemas. If several schemas declare a message or enum with the same name,
+// the first one is adopted.
+func NewAdopter(schemas ...*Package) *Adopter {
+	a := &Adopter{
+		messages: make(map[string]*Message),
+		enums:    make(map[string]*Enum),
+		renames:  make(map[string]string),
+		schemas:  make(map[string]*descriptorBuilder),
+	}
+
+	for _, s := range schemas {
+		b := newDescriptorBuilder(s, nil)
+		for _, m := range s.Messages {
+			a.addMessage(b, m)
+		}
+
+		for _, e := range s.Enums {
+			if _, ok := a.enums[e.Name]; !ok {
+				a.enums[e.Name] = e
+			}
+		}
+	}
+
+	return a
+}
+
+func (a *Adopter) addMessage(b *descriptorBuilder, m *Message) {
+	if _, ok := a.messages[m.FullName()]; !ok {
+		a.messages[m.FullName()] = m
+		a.schemas[m.FullName()] = b
+	}
+
+	for _, nested := range m.Messages {
+		a.addMessage(b, nested)
+	}
+}
+
+// Rename makes the message or enum with the given name, e.g. User or
+// User.Meta, adopt the one of the schemas with the old name, instead of the
+// one with the same name.
+func (a *Adopter) Rename(name, old string) {
+	a.renames[name] = old
+}
+
+func (a *Adopter) oldName(name string) string {
+	if old, ok := a.renames[name]; ok {
+		return old
+	}
+	return name
+}
+
+// Adopt returns a lock for the given package, which must be transformed
+// without locks, with the numbers of the fields and enum values of the
+// messages and enums of the schemas with the same name. Fields and values
+// are matched by their name or, if there is none with the same name, by their
+// name ignoring case and underscores, so user_id matches userId. It is an
+// error if several old names match a name that way. Fields whose type is not
+// compatible in the wire with the type of the old field they match are not
+// adopted, with a warning. The numbers and names of the old fields and values
+// that are not adopted are reserved, so they are not used again. Messages
+// generated for the parameters and results of RPCs are not adopted, as their
+// fields are always numbered by position.
+func (a *Adopter) Adopt(pkg *Package) (*Lock, error) {
+	var generated = make(map[string]bool)
+	for _, rpc := range pkg.RPCs {
+		for _, t := range []Type{rpc.Input, rpc.Output} {
+			if n, ok := t.(*Named); ok && n.Generated {
+				generated[n.Name] = true
+			}
+		}
+	}
+
+	lock := NewLock()
+	b := newDescriptorBuilder(pkg, nil)
+	for _, m := range pkg.Messages {
+		if !generated[m.Name] {
+			if err := a.adoptMessage(lock, b, m); err != nil {
+				return nil, err
+			}
+		} else if _, ok := a.messages[a.oldName(m.Name)]; ok {
+			report.Warn("message %s holds the parameters of an RPC, its fields are numbered by position and can not be adopted", m.Name)
+		}
+	}
+
+	for _, e := range pkg.Enums {
+		old, ok := a.enums[a.oldName(e.Name)]
+		if !ok {
+			report.Warn("enum %s of package %s is not in the adopted schemas", e.Name, pkg.Name)
+			continue
+		}
+
+		var values = make(map[string]int)
+		for _, v := range old.Values {
+			values[v.Name] = int(v.Value)
+		}
+
+		var names = make([]string, len(e.Values))
+		for i, v := range e.Values {
+			names[i] = v.Name
+		}
+
+		matches, err := matchNames(names, values)
+		if err != nil {
+			return nil, fmt.Errorf("unable to adopt enum %s: %s", e.Name, err)
+		}
+
+		l := lock.Enum(e.Name)
+		for _, v := range e.Values {
+			if name, ok := matches[v.Name]; ok {
+				l.setValue(v.Name, values[name])
+				delete(values, name)
+			}
+		}
+
+		reserveUnmatched(l, values)
+		for _, r := range old.Reserved {
+			l.reserveNumber(int(r))
+		}
+		for _, n := range old.ReservedNames {
+			l.reserveName(n)
+		}
+	}
+
+	return lock, nil
+}
+
+// adoptMessage adopts the given message, and its nested messages, declared
+// in the package whose descriptors are built with the given builder.
+func (a *Adopter) adoptMessage(lock *Lock, b *descriptorBuilder, msg *Message) error {
+	for _, nested := range msg.Messages {
+		if err := a.adoptMessage(lock, b, nested); err != nil {
+			return err
+		}
+	}
+
+	oldName := a.oldName(msg.FullName())
+	old, ok := a.messages[oldName]
+	if !ok {
+		report.Warn("message %s is not in the adopted schemas", msg.FullName())
+		return nil
+	}
+
+	var (
+		numbers   = make(map[string]int)
+		oldFields = make(map[string]*Field)
+		names     []string
+	)
+	for _, f := range messageFields(old) {
+		numbers[f.Name] = f.Pos
+		oldFields[f.Name] = f
+	}
+	for _, f := range messageFields(msg) {
+		names = append(names, f.Name)
+	}
+
+	matches, err := matchNames(names, numbers)
+	if err != nil {
+		return fmt.Errorf("unable to adopt message %s: %s", msg.FullName(), err)
+	}
+
+	l := lock.Message(msg.FullName())
+	for _, f := range messageFields(msg) {
+		name, ok := matches[f.Name]
+		if !ok {
+			continue
+		}
+
+		oldType := fieldDescriptor(a.schemas[oldName], oldName, oldFields[name])
+		newType := a.adoptedDescriptor(b, msg.FullName(), f, a.schemas[oldName])
+		if !compatibleTypes(oldType, newType) {
+			report.Warn("field %s of message %s is not adopted, its type %s is not compatible in the wire with the type %s of field %s", f.Name, msg.FullName(), fieldType(newType), fieldType(oldType), name)
+			l.reserveNumber(numbers[name])
+			delete(numbers, name)
+			continue
+		}
+
+		if name != f.Name && f.GoName != "" {
+			report.Warn("field %s of message %s was named %s, add name=%s to the proteus tag of field %s to keep its name", f.Name, msg.FullName(), name, name, f.GoName)
+		}
+
+		l.Set(f.Name, numbers[name])
+		delete(numbers, name)
+	}
+
+	reserveUnmatched(l, numbers)
+	for _, r := range old.Reserved {
+		l.reserveNumber(int(r))
+	}
+	for _, n := range old.ReservedNames {
+		l.reserveName(n)
+	}
+	return nil
+}
+
+// fieldDescriptor returns the descriptor of the type of the given field of
+// the message with the given full name, declared in the package whose
+// descriptors are built with the given builder.
+func fieldDescriptor(b *descriptorBuilder, msg string, f *Field) *descriptorpb.FieldDescriptorProto {
+	if m, ok := f.Type.(*Map); ok {
+		return mapDescriptor(
+			fieldDescriptor(b, msg, &Field{Type: m.Key}),
+			fieldDescriptor(b, msg, &Field{Type: m.Value}),
+		)
+	}
+
+	desc := &descriptorpb.FieldDescriptorProto{Name: proto.String(f.Name)}
+	if f.Repeated {
+		desc.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	}
+	b.setType(desc, b.prefix+"."+msg, f.Type)
+	return desc
+}
+
+// mapDescriptor returns the descriptor of the type of a map field with the
+// given key and value types. Its type is named after them, so it is only
+// compatible with maps of the same types.
+func mapDescriptor(key, value *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+		TypeName: proto.String(fmt.Sprintf("map<%s, %s>", fieldType(key), fieldType(value))),
+	}
+}
+
+// adoptedDescriptor returns the descriptor of the type of the given field,
+// as fieldDescriptor does, with the names of the messages and enums of its
+// package replaced with the ones they adopt in the schema whose descriptors
+// are built with the given builder, so it can be compared with the types of
+// the fields of the schema.
+func (a *Adopter) adoptedDescriptor(b *descriptorBuilder, msg string, f *Field, schema *descriptorBuilder) *descriptorpb.FieldDescriptorProto {
+	if m, ok := f.Type.(*Map); ok {
+		return mapDescriptor(
+			a.adoptedDescriptor(b, msg, &Field{Type: m.Key}, schema),
+			a.adoptedDescriptor(b, msg, &Field{Type: m.Value}, schema),
+		)
+	}
+
+	desc := fieldDescriptor(b, msg, f)
+	name := desc.GetTypeName()
+	if _, ok := b.kinds[name]; ok {
+		old := a.oldName(strings.TrimPrefix(name, b.prefix+"."))
+		desc.TypeName = proto.String(schema.prefix + "." + old)
+	}
+	return desc
+}
+
+// messageFields returns the fields of the message, including the ones of its
+// oneofs.
+func messageFields(msg *Message) []*Field {
+	fields := append([]*Field(nil), msg.Fields...)
+	for _, o := range msg.Oneofs {
+		fields = append(fields, o.Fields...)
+	}
+	return fields
+}
+
+// matchNames returns the names among the old ones matched by the given
+// names, indexed by them. Names are matched by the old name that is the same
+// or, if there is none, the one that only differs from it in case and
+// underscores, which is an error if there are several.
+func matchNames(names []string, old map[string]int) (map[string]string, error) {
+	var (
+		matches = make(map[string]string)
+		matched = make(map[string]bool)
+	)
+	for _, n := range names {
+		if _, ok := old[n]; ok {
+			matches[n] = n
+			matched[n] = true
+		}
+	}
+
+	for _, n := range names {
+		if _, ok := matches[n]; ok {
+			continue
+		}
+
+		var candidates []string
+		for o := range old {
+			if !matched[o] && normalizeName(o) == normalizeName(n) {
+				candidates = append(candidates, o)
+			}
+		}
+
+		switch len(candidates) {
+		case 0:
+		case 1:
+			matches[n] = candidates[0]
+			matched[candidates[0]] = true
+		default:
+			sort.Strings(candidates)
+			return nil, fmt.Errorf("%s matches several names: %s", n, strings.Join(candidates, ", "))
+		}
+	}
+
+	return matches, nil
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+// reserveUnmatched reserves the given numbers along with their names.
+func reserveUnmatched(l *NumberLock, numbers map[string]int) {
+	var names = make([]string, 0, len(numbers))
+	for n := range numbers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		l.reserve(numbers[n], n)
+	}
+}
@@ -0,0 +1,120 @@
+package protobuf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const adoptedProto = `syntax = "proto3";
+package legacy;
+
+message Person {
+	reserved 3;
+	reserved "nick";
+	string name = 2;
+	int64 userId = 5;
+	string email = 7;
+	Address address = 9;
+	oneof contact {
+		string phone = 10;
+	}
+
+	message Address {
+		string street = 1;
+	}
+}
+
+message DoRequest {
+	string arg1 = 3;
+}
+
+enum Kind {
+	KIND_UNKNOWN = 0;
+	ADMIN = 4;
+	GUEST = 5;
+}
+`
+
+func TestAdopt(t *testing.T) {
+	require := require.New(t)
+
+	schema, err := ReadProto(strings.NewReader(adoptedProto))
+	require.NoError(err)
+
+	user := &Message{
+		Name: "User",
+		Fields: []*Field{
+			{Name: "name", GoName: "Name", Type: NewBasic("string"), Pos: 1},
+			{Name: "user_id", GoName: "UserID", Type: NewBasic("int64"), Pos: 2},
+			{Name: "age", GoName: "Age", Type: NewBasic("int32"), Pos: 3},
+			{Name: "email", GoName: "Email", Type: NewBasic("int64"), Pos: 4},
+			{Name: "address", GoName: "Address", Type: NewNamed("foo", "User.Address"), Pos: 5},
+		},
+		Oneofs: []*Oneof{
+			{Name: "contact", Fields: []*Field{
+				{Name: "phone", Type: NewBasic("string"), Pos: 6},
+			}},
+		},
+	}
+	user.AddMessage(&Message{
+		Name:   "Address",
+		Fields: []*Field{{Name: "street", Type: NewBasic("string"), Pos: 1}},
+	})
+
+	pkg := &Package{
+		Name: "foo",
+		Messages: []*Message{user, {Name: "Other"}, {
+			Name:   "DoRequest",
+			Fields: []*Field{{Name: "arg1", Type: NewBasic("string"), Pos: 1}},
+		}},
+		RPCs: []*RPC{
+			{Name: "Do", Input: NewGeneratedNamed("foo", "DoRequest"), Output: NewNamed("foo", "Other")},
+		},
+		Enums: []*Enum{
+			{Name: "Kind", Values: []*EnumValue{
+				{Name: "KIND_UNKNOWN"},
+				{Name: "ADMIN", Value: 1},
+				{Name: "OWNER", Value: 2},
+			}},
+		},
+	}
+
+	a := NewAdopter(schema)
+	a.Rename("User", "Person")
+	a.Rename("User.Address", "Person.Address")
+	lock, err := a.Adopt(pkg)
+	require.NoError(err)
+
+	require.Equal(&NumberLock{
+		Numbers:       map[string]int{"name": 2, "user_id": 5, "address": 9, "phone": 10},
+		Reserved:      []int{3, 7},
+		ReservedNames: []string{"nick"},
+	}, lock.Messages["User"], "email is not adopted, as its type changed")
+	require.Equal(&NumberLock{
+		Numbers: map[string]int{"street": 1},
+	}, lock.Messages["User.Address"])
+	require.NotContains(lock.Messages, "Other")
+	require.NotContains(lock.Messages, "DoRequest")
+
+	require.Equal(&NumberLock{
+		Numbers:       map[string]int{"KIND_UNKNOWN": 0, "ADMIN": 4},
+		Reserved:      []int{5},
+		ReservedNames: []string{"GUEST"},
+	}, lock.Enums["Kind"])
+
+	require.Equal(11, lock.Messages["User"].Number("age", 1))
+}
+
+func TestMatchNames(t *testing.T) {
+	require := require.New(t)
+	old := map[string]int{"user_id": 1, "userId": 2, "Name": 3}
+
+	matches, err := matchNames([]string{"UserID", "user_id", "name"}, old)
+	require.NoError(err)
+	require.Equal(map[string]string{"user_id": "user_id", "UserID": "userId", "name": "Name"}, matches)
+
+	_, err = matchNames([]string{"USERID", "name"}, old)
+	require.EqualError(err, "USERID matches several names: userId, user_id")
+}
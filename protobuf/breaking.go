@@ -0,0 +1,291 @@
+package protobuf
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// BreakingChange is a change between two revisions of a proto file that
+// makes them incompatible in the wire, so data written with one of them can
+// not be read with the other, unless it is WireCompatible.
+type BreakingChange struct {
+	// Name is the full name of the declaration that changed, without the
+	// leading dot.
+	Name string
+	// Reason describes the change.
+	Reason string
+	// WireCompatible reports whether the change is still compatible in the
+	// wire, such as a renamed field, which only breaks the code using the
+	// generated types and the JSON encoding of the messages.
+	WireCompatible bool
+}
+
+func (c *BreakingChange) String() string {
+	if c.WireCompatible {
+		return fmt.Sprintf("%s: %s (compatible in the wire)", c.Name, c.Reason)
+	}
+	return fmt.Sprintf("%s: %s", c.Name, c.Reason)
+}
+
+// BreakingChanges returns the changes from the old to the new revision of
+// the descriptor of a proto file that are not compatible in the wire:
+//
+//   - fields whose number is used by another field of a type encoded
+//     differently, whose number changes or that are removed without
+//     reserving their number,
+//   - fields whose type changes to one that is encoded differently,
+//   - enum values that are removed,
+//   - RPCs that are removed or renamed and RPCs whose request or response
+//     type changes.
+//
+// Removed messages and enums are not breaking by themselves, but the fields
+// that refer to them are. Fields renamed without changing their number and
+// the way their type is encoded are returned as well, with WireCompatible
+// set, as they still break the generated code.
+func BreakingChanges(old, new *descriptorpb.FileDescriptorProto) []*BreakingChange {
+	c := &breakingChecker{}
+
+	var (
+		scope    = new.GetPackage()
+		messages = make(map[string]*descriptorpb.DescriptorProto)
+		enums    = make(map[string]*descriptorpb.EnumDescriptorProto)
+	)
+	for _, m := range new.MessageType {
+		messages[m.GetName()] = m
+	}
+	for _, e := range new.EnumType {
+		enums[e.GetName()] = e
+	}
+
+	for _, m := range old.MessageType {
+		if nm, ok := messages[m.GetName()]; ok {
+			c.message(join(scope, m.GetName()), m, nm)
+		}
+	}
+
+	for _, e := range old.EnumType {
+		if ne, ok := enums[e.GetName()]; ok {
+			c.enum(join(scope, e.GetName()), e, ne)
+		}
+	}
+
+	services := make(map[string]*descriptorpb.ServiceDescriptorProto)
+	for _, s := range new.Service {
+		services[s.GetName()] = s
+	}
+
+	for _, s := range old.Service {
+		c.service(join(scope, s.GetName()), s, services[s.GetName()])
+	}
+
+	return c.changes
+}
+
+type breakingChecker struct {
+	changes []*BreakingChange
+}
+
+func (c *breakingChecker) report(name, format string, args ...interface{}) {
+	c.changes = append(c.changes, &BreakingChange{
+		Name:   name,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// reportCompatible reports a change that is still compatible in the wire.
+func (c *breakingChecker) reportCompatible(name, format string, args ...interface{}) {
+	c.changes = append(c.changes, &BreakingChange{
+		Name:           name,
+		Reason:         fmt.Sprintf(format, args...),
+		WireCompatible: true,
+	})
+}
+
+func (c *breakingChecker) message(name string, old, new *descriptorpb.DescriptorProto) {
+	var (
+		byNumber = make(map[int32]*descriptorpb.FieldDescriptorProto)
+		byName   = make(map[string]*descriptorpb.FieldDescriptorProto)
+	)
+	for _, f := range new.Field {
+		byNumber[f.GetNumber()] = f
+		byName[f.GetName()] = f
+	}
+
+	for _, f := range old.Field {
+		field := join(name, f.GetName())
+		reused, ok := byNumber[f.GetNumber()]
+		if ok && reused.GetName() == f.GetName() {
+			if !compatibleTypes(f, reused) {
+				c.report(field, "type changed from %s to %s", fieldType(f), fieldType(reused))
+			}
+			continue
+		}
+
+		nf, found := byName[f.GetName()]
+		switch {
+		case ok && !compatibleTypes(f, reused):
+			c.report(field, "number %d is reused by field %s", f.GetNumber(), reused.GetName())
+		case ok && !found:
+			c.reportCompatible(field, "field was renamed to %s", reused.GetName())
+		}
+
+		if found {
+			c.report(field, "number changed from %d to %d", f.GetNumber(), nf.GetNumber())
+		} else if !ok && !isReservedField(new, f.GetNumber()) {
+			c.report(field, "field was removed without reserving its number %d", f.GetNumber())
+		}
+	}
+
+	var (
+		messages = make(map[string]*descriptorpb.DescriptorProto)
+		enums    = make(map[string]*descriptorpb.EnumDescriptorProto)
+	)
+	for _, m := range new.NestedType {
+		messages[m.GetName()] = m
+	}
+	for _, e := range new.EnumType {
+		enums[e.GetName()] = e
+	}
+
+	for _, m := range old.NestedType {
+		if nm, ok := messages[m.GetName()]; ok {
+			c.message(join(name, m.GetName()), m, nm)
+		}
+	}
+
+	for _, e := range old.EnumType {
+		if ne, ok := enums[e.GetName()]; ok {
+			c.enum(join(name, e.GetName()), e, ne)
+		}
+	}
+}
+
+func isReservedField(msg *descriptorpb.DescriptorProto, n int32) bool {
+	for _, r := range msg.ReservedRange {
+		if n >= r.GetStart() && n < r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+// enum reports the values of the old enum whose number is not in the new
+// one.
+func (c *breakingChecker) enum(name string, old, new *descriptorpb.EnumDescriptorProto) {
+	numbers := make(map[int32]bool)
+	for _, v := range new.Value {
+		numbers[v.GetNumber()] = true
+	}
+
+	for _, v := range old.Value {
+		if !numbers[v.GetNumber()] {
+			c.report(name, "value %s (%d) was removed", v.GetName(), v.GetNumber())
+		}
+	}
+}
+
+func (c *breakingChecker) service(name string, old, new *descriptorpb.ServiceDescriptorProto) {
+	methods := make(map[string]*descriptorpb.MethodDescriptorProto)
+	if new != nil {
+		for _, m := range new.Method {
+			methods[m.GetName()] = m
+		}
+	}
+
+	for _, m := range old.Method {
+		method := join(name, m.GetName())
+		nm, ok := methods[m.GetName()]
+		if !ok {
+			c.report(method, "rpc was removed or renamed")
+			continue
+		}
+
+		if m.GetInputType() != nm.GetInputType() {
+			c.report(method, "request type changed from %s to %s", trimDot(m.GetInputType()), trimDot(nm.GetInputType()))
+		}
+
+		if m.GetOutputType() != nm.GetOutputType() {
+			c.report(method, "response type changed from %s to %s", trimDot(m.GetOutputType()), trimDot(nm.GetOutputType()))
+		}
+	}
+}
+
+// wireTypes are the groups of scalar types whose values are encoded the same
+// way, so a field can change from one to another of the same group.
+var wireTypes = map[descriptorpb.FieldDescriptorProto_Type]string{
+	descriptorpb.FieldDescriptorProto_TYPE_INT32:    "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_INT64:    "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_UINT32:   "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_UINT64:   "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_BOOL:     "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_ENUM:     "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_SINT32:   "zigzag",
+	descriptorpb.FieldDescriptorProto_TYPE_SINT64:   "zigzag",
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED32:  "fixed32",
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED32: "fixed32",
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED64:  "fixed64",
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED64: "fixed64",
+	descriptorpb.FieldDescriptorProto_TYPE_STRING:   "bytes",
+	descriptorpb.FieldDescriptorProto_TYPE_BYTES:    "bytes",
+}
+
+// compatibleTypes reports whether the values of both fields are encoded the
+// same way. Fields of messages are only compatible if the messages have the
+// same name, and so are the fields of enums, unless one of them is a scalar.
+func compatibleTypes(old, new *descriptorpb.FieldDescriptorProto) bool {
+	if isRepeated(old) != isRepeated(new) {
+		return false
+	}
+
+	if old.GetTypeName() != "" && new.GetTypeName() != "" {
+		return old.GetTypeName() == new.GetTypeName()
+	}
+
+	if old.GetTypeName() != "" || new.GetTypeName() != "" {
+		// A message, or a type whose kind is not known, can only be
+		// compatible with an enum turned into a scalar or the other way
+		// around.
+		if old.GetType() != descriptorpb.FieldDescriptorProto_TYPE_ENUM &&
+			new.GetType() != descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+			return false
+		}
+	}
+
+	if old.GetType() == new.GetType() {
+		return true
+	}
+
+	group, ok := wireTypes[old.GetType()]
+	return ok && group == wireTypes[new.GetType()]
+}
+
+func isRepeated(f *descriptorpb.FieldDescriptorProto) bool {
+	return f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+}
+
+// fieldType returns the type of the given field as it is written in a proto
+// file.
+func fieldType(f *descriptorpb.FieldDescriptorProto) string {
+	typ := trimDot(f.GetTypeName())
+	if typ == "" {
+		typ = strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+	}
+
+	if isRepeated(f) {
+		return "repeated " + typ
+	}
+	return typ
+}
+
+func join(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func trimDot(name string) string {
+	return strings.TrimPrefix(name, ".")
+}
@@ -0,0 +1,91 @@
+package protobuf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func breakingPackage(fields []*Field, values []*EnumValue, rpcs []*RPC) *Package {
+	return &Package{
+		Name: "foo",
+		Path: "foo",
+		Messages: []*Message{
+			{Name: "Pony", Fields: fields, Reserved: []uint{10}},
+			{Name: "Other"},
+		},
+		Enums: []*Enum{
+			{Name: "Race", Values: values},
+		},
+		RPCs: rpcs,
+	}
+}
+
+func TestBreakingChanges(t *testing.T) {
+	require := require.New(t)
+	g := NewGenerator("")
+
+	old := g.Descriptor(breakingPackage(
+		[]*Field{
+			{Name: "name", Type: NewBasic("string"), Pos: 1},
+			{Name: "age", Type: NewBasic("int32"), Pos: 2},
+			{Name: "race", Type: NewNamed("foo", "Race"), Pos: 3},
+			{Name: "tags", Type: NewBasic("string"), Pos: 4, Repeated: true},
+			{Name: "owner", Type: NewNamed("foo", "Other"), Pos: 5},
+			{Name: "weight", Type: NewBasic("double"), Pos: 6},
+			{Name: "height", Type: NewBasic("double"), Pos: 7},
+			{Name: "legacy", Type: NewBasic("bool"), Pos: 10},
+			{Name: "counts", Type: NewMap(NewBasic("string"), NewBasic("int32")), Pos: 11},
+			{Name: "color", Type: NewBasic("string"), Pos: 12},
+		},
+		[]*EnumValue{{Name: "UNKNOWN"}, {Name: "PINK", Value: 1}, {Name: "RED", Value: 2}},
+		[]*RPC{
+			{Name: "GetPony", Input: NewNamed("foo", "Other"), Output: NewNamed("foo", "Pony")},
+			{Name: "ListPonies", Input: NewNamed("foo", "Other"), Output: NewNamed("foo", "Pony")},
+			{Name: "DeletePony", Input: NewNamed("foo", "Pony"), Output: NewNamed("foo", "Other")},
+		},
+	))
+
+	new := g.Descriptor(breakingPackage(
+		[]*Field{
+			{Name: "name", Type: NewBasic("bytes"), Pos: 1},
+			{Name: "age", Type: NewBasic("int64"), Pos: 2},
+			{Name: "race", Type: NewBasic("int32"), Pos: 3},
+			{Name: "tags", Type: NewBasic("string"), Pos: 4},
+			{Name: "owner", Type: NewBasic("string"), Pos: 5},
+			{Name: "nick", Type: NewBasic("string"), Pos: 6},
+			{Name: "height", Type: NewBasic("double"), Pos: 8},
+			{Name: "counts", Type: NewMap(NewBasic("string"), NewBasic("string")), Pos: 11},
+			{Name: "colour", Type: NewBasic("bytes"), Pos: 12},
+		},
+		[]*EnumValue{{Name: "UNKNOWN"}, {Name: "CRIMSON", Value: 2}},
+		[]*RPC{
+			{Name: "GetPony", Input: NewNamed("foo", "Other"), Output: NewNamed("foo", "Pony")},
+			{Name: "FindPonies", Input: NewNamed("foo", "Other"), Output: NewNamed("foo", "Pony")},
+			{Name: "DeletePony", Input: NewNamed("foo", "Other"), Output: NewNamed("foo", "Pony")},
+		},
+	))
+
+	var changes []string
+	for _, c := range BreakingChanges(old, new) {
+		changes = append(changes, c.String())
+	}
+
+	require.Equal([]string{
+		"foo.Pony.tags: type changed from repeated string to string",
+		"foo.Pony.owner: type changed from foo.Other to string",
+		"foo.Pony.weight: number 6 is reused by field nick",
+		"foo.Pony.height: number changed from 7 to 8",
+		"foo.Pony.color: field was renamed to colour (compatible in the wire)",
+		"foo.Pony.CountsEntry.value: type changed from int32 to string",
+		"foo.Race: value PINK (1) was removed",
+		"foo.FooService.ListPonies: rpc was removed or renamed",
+		"foo.FooService.DeletePony: request type changed from foo.Pony to foo.Other",
+		"foo.FooService.DeletePony: response type changed from foo.Other to foo.Pony",
+	}, changes)
+
+	require.Empty(BreakingChanges(old, old))
+
+	removed := g.Descriptor(breakingPackage(nil, nil, nil))
+	require.Len(BreakingChanges(old, removed), 15, "field legacy has a reserved number")
+}
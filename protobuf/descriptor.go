@@ -0,0 +1,798 @@
+package protobuf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gogo "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"gopkg.in/src-d/proteus.v1/report"
+
+	// The gogoproto options and the well-known types that can be imported
+	// by the generated files are registered, so their descriptors are
+	// known.
+	_ "github.com/gogo/protobuf/gogoproto"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// DescriptorSetFileName is the name of the file with the binary
+// FileDescriptorSet written next to every generated proto file.
+const DescriptorSetFileName = "generated.protoset"
+
+// Numbers of the fields of the descriptors the declarations are in, which
+// make up the paths of their locations in the source code info.
+const (
+	fileMessagePath   = 4
+	fileEnumPath      = 5
+	fileServicePath   = 6
+	messageFieldPath  = 2
+	messageNestedPath = 3
+	messageEnumPath   = 4
+	messageOneofPath  = 8
+	enumValuePath     = 2
+	serviceMethodPath = 2
+)
+
+var basicTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double":   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"int64":    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint64":   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"int32":    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"fixed64":  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	"fixed32":  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	"bool":     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string":   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	"uint32":   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"sfixed32": descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	"sfixed64": descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	"sint32":   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	"sint64":   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+}
+
+// DescriptorSet returns the FileDescriptorSet with the descriptor of the
+// proto file of the given package, preceded by the descriptors of the files
+// it imports, directly or not, as protoc does with --include_imports. Only
+// the imports whose descriptor is known are included: the well-known types,
+// the gogoproto options, the imported files of the package and the packages
+// generated before by the generator.
+// The rest are reported and left out.
+func (g *Generator) DescriptorSet(pkg *Package) *descriptorpb.FileDescriptorSet {
+	return g.descriptorSet(pkg, render(pkg))
+}
+
+func (g *Generator) descriptorSet(pkg *Package, src []byte) *descriptorpb.FileDescriptorSet {
+	deps := g.imports(pkg)
+	file := newDescriptorBuilder(pkg, deps).build(src)
+	return &descriptorpb.FileDescriptorSet{File: append(deps, file)}
+}
+
+// Descriptor returns the descriptor of the proto file of the given package,
+// with the comments of its declarations in the source code info.
+func (g *Generator) Descriptor(pkg *Package) *descriptorpb.FileDescriptorProto {
+	set := g.DescriptorSet(pkg)
+	return set.File[len(set.File)-1]
+}
+
+// imports returns the descriptors of the files imported by the given package
+// and the files they import, sorted so every file comes after its imports.
+func (g *Generator) imports(pkg *Package) []*descriptorpb.FileDescriptorProto {
+	var (
+		files []*descriptorpb.FileDescriptorProto
+		seen  = make(map[string]bool)
+		visit func(string)
+	)
+	visit = func(path string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+
+		file := pkg.ImportedFiles[path]
+		if file == nil {
+			file = g.importedFile(path)
+		}
+
+		if file == nil {
+			report.Warn("descriptor of %s is not known, it will not be included in the descriptor set", path)
+			return
+		}
+
+		for _, dep := range file.Dependency {
+			visit(dep)
+		}
+		files = append(files, file)
+	}
+
+	for _, p := range pkg.Imports {
+		visit(p)
+	}
+	return files
+}
+
+// importedFile returns the descriptor of the imported file with the given
+// path, or nil if it is not known.
+func (g *Generator) importedFile(path string) *descriptorpb.FileDescriptorProto {
+	if file, ok := g.descriptors[path]; ok {
+		return file
+	}
+
+	if path == gogoImport {
+		file, err := gogoDescriptor()
+		if err != nil {
+			report.Warn("unable to read the descriptor of %s: %s", path, err)
+			return nil
+		}
+		return file
+	}
+
+	if file, err := protoregistry.GlobalFiles.FindFileByPath(path); err == nil {
+		return protodesc.ToFileDescriptorProto(file)
+	}
+	return nil
+}
+
+// gogoDescriptor returns the descriptor of the file declaring the gogoproto
+// options, which is registered by gogo protobuf as gogo.proto.
+func gogoDescriptor() (*descriptorpb.FileDescriptorProto, error) {
+	r, err := gzip.NewReader(bytes.NewReader(gogo.FileDescriptor("gogo.proto")))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	file := new(descriptorpb.FileDescriptorProto)
+	if err := proto.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+
+	file.Name = proto.String(gogoImport)
+	return file, nil
+}
+
+// descriptorBuilder builds the descriptor of the proto file of a package.
+type descriptorBuilder struct {
+	pkg *Package
+	// prefix is the prefix of the full names of the declarations of the
+	// package, which is its name with a leading dot, if it has one.
+	prefix string
+	// kinds are the types of the messages and enums declared in the file
+	// and the files it imports by their full name with a leading dot.
+	kinds map[string]descriptorpb.FieldDescriptorProto_Type
+	// files are the imported files, where the extensions used as options
+	// are looked for.
+	files *protoregistry.Files
+	spans *spanFinder
+	locs  []*descriptorpb.SourceCodeInfo_Location
+}
+
+func newDescriptorBuilder(pkg *Package, deps []*descriptorpb.FileDescriptorProto) *descriptorBuilder {
+	b := &descriptorBuilder{
+		pkg:   pkg,
+		kinds: make(map[string]descriptorpb.FieldDescriptorProto_Type),
+		files: new(protoregistry.Files),
+	}
+	if pkg.Name != "" {
+		b.prefix = "." + pkg.Name
+	}
+
+	for _, m := range pkg.Messages {
+		b.addMessageKinds(b.prefix, m)
+	}
+	for _, e := range pkg.Enums {
+		b.kinds[b.prefix+"."+e.Name] = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+	}
+
+	for _, dep := range deps {
+		b.addFileKinds(dep)
+
+		file, err := protodesc.FileOptions{AllowUnresolvable: true}.New(dep, b.files)
+		if err == nil {
+			err = b.files.RegisterFile(file)
+		}
+
+		if err != nil {
+			report.Warn("unable to resolve the options declared in %s: %s", dep.GetName(), err)
+		}
+	}
+
+	return b
+}
+
+func (b *descriptorBuilder) addMessageKinds(scope string, msg *Message) {
+	name := scope + "." + msg.Name
+	b.kinds[name] = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	for _, m := range msg.Messages {
+		b.addMessageKinds(name, m)
+	}
+	for _, e := range msg.Enums {
+		b.kinds[name+"."+e.Name] = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+	}
+}
+
+func (b *descriptorBuilder) addFileKinds(file *descriptorpb.FileDescriptorProto) {
+	var addMessages func(string, []*descriptorpb.DescriptorProto)
+	addMessages = func(scope string, msgs []*descriptorpb.DescriptorProto) {
+		for _, m := range msgs {
+			name := scope + "." + m.GetName()
+			b.kinds[name] = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+			addMessages(name, m.NestedType)
+			for _, e := range m.EnumType {
+				b.kinds[name+"."+e.GetName()] = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+			}
+		}
+	}
+
+	var scope string
+	if file.GetPackage() != "" {
+		scope = "." + file.GetPackage()
+	}
+	addMessages(scope, file.MessageType)
+	for _, e := range file.EnumType {
+		b.kinds[scope+"."+e.GetName()] = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+	}
+}
+
+// build returns the descriptor of the file, whose source is the given one.
+// The declarations are visited in the same order they are written, so the
+// spans of their locations are found in order.
+func (b *descriptorBuilder) build(src []byte) *descriptorpb.FileDescriptorProto {
+	pkg := b.pkg
+	b.spans = newSpanFinder(src)
+	b.locs = []*descriptorpb.SourceCodeInfo_Location{{Span: b.spans.file()}}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(filepath.Join(pkg.Path, "generated.proto")),
+		Dependency: pkg.Imports,
+		Syntax:     proto.String("proto3"),
+	}
+	if pkg.Name != "" {
+		file.Package = proto.String(pkg.Name)
+	}
+
+	if opts := new(descriptorpb.FileOptions); b.setOptions(opts, pkg.Options, "package "+pkg.Name) {
+		file.Options = opts
+	}
+
+	for i, msg := range pkg.Messages {
+		file.MessageType = append(file.MessageType, b.message(msg, b.prefix, path(fileMessagePath, i)))
+	}
+
+	for i, enum := range pkg.Enums {
+		file.EnumType = append(file.EnumType, b.enum(enum, path(fileEnumPath, i)))
+	}
+
+	if len(pkg.RPCs) > 0 {
+		file.Service = append(file.Service, b.service(path(fileServicePath, 0)))
+	}
+
+	file.SourceCodeInfo = &descriptorpb.SourceCodeInfo{Location: b.locs}
+	return file
+}
+
+func (b *descriptorBuilder) message(msg *Message, scope string, p []int32) *descriptorpb.DescriptorProto {
+	b.locate(p, msg.Docs, b.spans.find(prefixed("message "+msg.Name+" {"), true))
+
+	var (
+		name = scope + "." + msg.Name
+		desc = &descriptorpb.DescriptorProto{
+			Name:         proto.String(msg.Name),
+			ReservedName: msg.ReservedNames,
+		}
+	)
+	if opts := new(descriptorpb.MessageOptions); b.setOptions(opts, msg.Options, "message "+msg.Name) {
+		desc.Options = opts
+	}
+
+	for _, r := range msg.Reserved {
+		desc.ReservedRange = append(desc.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{
+			Start: proto.Int32(int32(r)),
+			End:   proto.Int32(int32(r) + 1),
+		})
+	}
+
+	for i, m := range msg.Messages {
+		desc.NestedType = append(desc.NestedType, b.message(m, name, path(p, messageNestedPath, i)))
+	}
+
+	for i, e := range msg.Enums {
+		desc.EnumType = append(desc.EnumType, b.enum(e, path(p, messageEnumPath, i)))
+	}
+
+	for _, f := range msg.Fields {
+		desc.Field = append(desc.Field, b.field(desc, name, f, path(p, messageFieldPath, len(desc.Field))))
+	}
+
+	for i, o := range msg.Oneofs {
+		b.locate(path(p, messageOneofPath, i), o.Docs, b.spans.find(prefixed("oneof "+o.Name+" {"), true))
+		desc.OneofDecl = append(desc.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(o.Name)})
+		for _, f := range o.Fields {
+			field := b.field(desc, name, f, path(p, messageFieldPath, len(desc.Field)))
+			field.OneofIndex = proto.Int32(int32(i))
+			desc.Field = append(desc.Field, field)
+		}
+	}
+
+	// Every proto3 optional field is in its own synthetic oneof, declared
+	// after the rest.
+	for _, f := range desc.Field {
+		if f.GetProto3Optional() {
+			f.OneofIndex = proto.Int32(int32(len(desc.OneofDecl)))
+			desc.OneofDecl = append(desc.OneofDecl, &descriptorpb.OneofDescriptorProto{
+				Name: proto.String("_" + f.GetName()),
+			})
+		}
+	}
+
+	return desc
+}
+
+// field returns the descriptor of the given field of the given message,
+// whose full name is scope. The entries of map fields are nested in the
+// message.
+func (b *descriptorBuilder) field(msg *descriptorpb.DescriptorProto, scope string, f *Field, p []int32) *descriptorpb.FieldDescriptorProto {
+	b.locate(p, f.Docs, b.spans.find(fieldLine(f.Name, f.Pos), false))
+
+	desc := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(f.Name),
+		Number:   proto.Int32(int32(f.Pos)),
+		JsonName: proto.String(jsonName(f.Name)),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	if f.Repeated {
+		desc.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	}
+	if f.Optional {
+		desc.Proto3Optional = proto.Bool(true)
+	}
+
+	if m, ok := f.Type.(*Map); ok {
+		entry := b.mapEntry(scope, f.Name, m)
+		msg.NestedType = append(msg.NestedType, entry)
+		desc.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		desc.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		desc.TypeName = proto.String(scope + "." + entry.GetName())
+	} else {
+		b.setType(desc, scope, f.Type)
+	}
+
+	// json_name is declared with the options of the field in the proto
+	// file, but it is a field of the descriptor, not of its options.
+	var options = make(Options, len(f.Options))
+	for name, v := range f.Options {
+		if name == "json_name" {
+			desc.JsonName = proto.String(rawValue(v))
+			continue
+		}
+		options[name] = v
+	}
+
+	if opts := new(descriptorpb.FieldOptions); b.setOptions(opts, options, "field "+f.Name) {
+		desc.Options = opts
+	}
+
+	return desc
+}
+
+// mapEntry returns the descriptor of the message declared implicitly for the
+// entries of the map field with the given name of the message whose full
+// name is scope.
+func (b *descriptorBuilder) mapEntry(scope, name string, m *Map) *descriptorpb.DescriptorProto {
+	entry := &descriptorpb.DescriptorProto{
+		Name:    proto.String(mapEntryName(name)),
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+	}
+
+	for i, typ := range []Type{m.Key, m.Value} {
+		name := [...]string{"key", "value"}[i]
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(int32(i + 1)),
+			JsonName: proto.String(name),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		b.setType(field, scope+"."+entry.GetName(), typ)
+		entry.Field = append(entry.Field, field)
+	}
+
+	return entry
+}
+
+// setType sets the type of the given field, which is declared in the
+// message whose full name is scope. The type of fields of messages or enums
+// declared in files that are not known is left unset, as only their name is
+// known.
+func (b *descriptorBuilder) setType(field *descriptorpb.FieldDescriptorProto, scope string, typ Type) {
+	if alias, ok := typ.(*Alias); ok {
+		typ = alias.Underlying
+	}
+
+	switch t := typ.(type) {
+	case *Basic:
+		if kind, ok := basicTypes[t.Name]; ok {
+			field.Type = kind.Enum()
+		} else {
+			report.Warn("type %s of field %s is not a protobuf scalar type", t.Name, field.GetName())
+		}
+	case *Named:
+		name := b.typeName(scope, t)
+		field.TypeName = proto.String(name)
+		if kind, ok := b.kinds[name]; ok {
+			field.Type = kind.Enum()
+		}
+	}
+}
+
+// typeName returns the full name of the given named type referred to in the
+// message whose full name is scope. Types without package are looked for in
+// the scope and the ones enclosing it, as protoc does.
+func (b *descriptorBuilder) typeName(scope string, t *Named) string {
+	if t.Package != "" {
+		return "." + t.Package + "." + t.Name
+	}
+
+	for s := scope; ; s = s[:strings.LastIndex(s, ".")] {
+		if _, ok := b.kinds[s+"."+t.Name]; ok || len(s) <= len(b.prefix) {
+			return s + "." + t.Name
+		}
+	}
+}
+
+func (b *descriptorBuilder) enum(enum *Enum, p []int32) *descriptorpb.EnumDescriptorProto {
+	b.locate(p, enum.Docs, b.spans.find(prefixed("enum "+enum.Name+" {"), true))
+
+	desc := &descriptorpb.EnumDescriptorProto{
+		Name:         proto.String(enum.Name),
+		ReservedName: enum.ReservedNames,
+	}
+	if opts := new(descriptorpb.EnumOptions); b.setOptions(opts, enum.Options, "enum "+enum.Name) {
+		desc.Options = opts
+	}
+
+	for _, r := range enum.Reserved {
+		desc.ReservedRange = append(desc.ReservedRange, &descriptorpb.EnumDescriptorProto_EnumReservedRange{
+			Start: proto.Int32(r),
+			End:   proto.Int32(r),
+		})
+	}
+
+	for i, v := range enum.Values {
+		b.locate(path(p, enumValuePath, i), v.Docs, b.spans.find(prefixed(fmt.Sprintf("%s = %d", v.Name, v.Value)), false))
+		value := &descriptorpb.EnumValueDescriptorProto{
+			Name:   proto.String(v.Name),
+			Number: proto.Int32(v.Value),
+		}
+		if opts := new(descriptorpb.EnumValueOptions); b.setOptions(opts, v.Options, "enum value "+v.Name) {
+			value.Options = opts
+		}
+		desc.Value = append(desc.Value, value)
+	}
+
+	return desc
+}
+
+func (b *descriptorBuilder) service(p []int32) *descriptorpb.ServiceDescriptorProto {
+	name := b.pkg.ServiceName()
+	b.locate(p, nil, b.spans.find(prefixed("service "+name+" {"), true))
+
+	desc := &descriptorpb.ServiceDescriptorProto{Name: proto.String(name)}
+	for i, rpc := range b.pkg.RPCs {
+		b.locate(path(p, serviceMethodPath, i), rpc.Docs, b.spans.find(prefixed("rpc "+rpc.Name+" ("), len(rpc.Options) > 0))
+		method := &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(rpc.Name),
+			InputType:  proto.String(b.rpcType(rpc.Input)),
+			OutputType: proto.String(b.rpcType(rpc.Output)),
+		}
+		if opts := new(descriptorpb.MethodOptions); b.setOptions(opts, rpc.Options, "rpc "+rpc.Name) {
+			method.Options = opts
+		}
+		desc.Method = append(desc.Method, method)
+	}
+
+	return desc
+}
+
+func (b *descriptorBuilder) rpcType(typ Type) string {
+	if named, ok := typ.(*Named); ok {
+		return b.typeName(b.prefix, named)
+	}
+	return typ.String()
+}
+
+// setOptions sets the given options in the given options message of a
+// descriptor of the given declaration and reports whether any was set.
+// Options that cannot be set are reported and skipped.
+func (b *descriptorBuilder) setOptions(msg proto.Message, options Options, decl string) bool {
+	var set bool
+	m := msg.ProtoReflect()
+	for _, opt := range options.Sorted() {
+		if err := b.setOption(m, opt); err != nil {
+			report.Warn("option %s of %s cannot be written to the descriptor, ignoring it: %s", opt.Name, decl, err)
+		} else {
+			set = true
+		}
+	}
+	return set
+}
+
+// setOption sets the given option in the given options message. Options
+// enclosed in parentheses are extensions, which must be declared in one of
+// the imported files. Options whose value is a message can be followed by
+// the path of the field of the message that is set, such as
+// (validate.rules).string.min_len.
+func (b *descriptorBuilder) setOption(m protoreflect.Message, opt *Option) error {
+	option, subfields := splitOptionName(opt.Name)
+
+	var field protoreflect.FieldDescriptor
+	if strings.HasPrefix(option, "(") && strings.HasSuffix(option, ")") {
+		name := protoreflect.FullName(strings.TrimPrefix(option[1:len(option)-1], "."))
+		desc, err := b.files.FindDescriptorByName(name)
+		if err != nil {
+			return fmt.Errorf("extension %s is not declared in any imported file", name)
+		}
+
+		xd, ok := desc.(protoreflect.ExtensionDescriptor)
+		if !ok || xd.ContainingMessage().FullName() != m.Descriptor().FullName() {
+			return fmt.Errorf("%s is not an extension of %s", name, m.Descriptor().FullName())
+		}
+		field = dynamicpb.NewExtensionType(xd).TypeDescriptor()
+	} else if field = m.Descriptor().Fields().ByName(protoreflect.Name(option)); field == nil {
+		return fmt.Errorf("%s has no option %s", m.Descriptor().FullName(), option)
+	}
+
+	// The path is resolved before setting anything, so no empty messages
+	// are left behind if it is not valid.
+	var fields = []protoreflect.FieldDescriptor{field}
+	for _, name := range subfields {
+		if field.IsList() || field.IsMap() || field.Message() == nil {
+			return fmt.Errorf("%s is not a message", field.FullName())
+		}
+
+		parent := field.Message()
+		if field = parent.Fields().ByName(protoreflect.Name(name)); field == nil {
+			return fmt.Errorf("%s has no field %s", parent.FullName(), name)
+		}
+		fields = append(fields, field)
+	}
+
+	if field.IsList() || field.Message() != nil {
+		return errors.New("only options with a single scalar value are supported")
+	}
+
+	val, err := optionValue(field, opt.Value)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields[:len(fields)-1] {
+		m = m.Mutable(f).Message()
+	}
+	m.Set(field, val)
+	return nil
+}
+
+// splitOptionName splits the given option name into the name of the option
+// and the path of the field of its value, if any. For example,
+// (validate.rules).string.min_len is split into (validate.rules) and
+// [string min_len].
+func splitOptionName(name string) (string, []string) {
+	idx := strings.Index(name, ".")
+	if strings.HasPrefix(name, "(") {
+		idx = strings.Index(name, ")") + 1
+	}
+
+	if idx <= 0 || idx >= len(name) || name[idx] != '.' {
+		return name, nil
+	}
+	return name[:idx], strings.Split(name[idx+1:], ".")
+}
+
+// optionValue returns the value of the given option field written in the
+// given option value.
+func optionValue(field protoreflect.FieldDescriptor, v OptionValue) (protoreflect.Value, error) {
+	raw := rawValue(v)
+	var err error
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(raw)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(raw), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(raw)), nil
+	case protoreflect.EnumKind:
+		value := field.Enum().Values().ByName(protoreflect.Name(raw))
+		if value == nil {
+			return protoreflect.Value{}, fmt.Errorf("%s is not a value of %s", raw, field.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(value.Number()), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(raw, 0, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		n, err = strconv.ParseInt(raw, 0, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		n, err = strconv.ParseUint(raw, 0, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var n uint64
+		n, err = strconv.ParseUint(raw, 0, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = strconv.ParseFloat(raw, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		var f float64
+		f, err = strconv.ParseFloat(raw, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	}
+
+	return protoreflect.Value{}, fmt.Errorf("options of kind %s are not supported", field.Kind())
+}
+
+// rawValue returns the given option value as it is written, without the
+// quotes of string values.
+func rawValue(v OptionValue) string {
+	if s, ok := v.(StringValue); ok {
+		return s.val
+	}
+	return v.String()
+}
+
+// locate adds the location of the declaration with the given path, docs and
+// span to the source code info. Declarations that are not found in the
+// source are not located.
+func (b *descriptorBuilder) locate(p []int32, docs []string, span []int32) {
+	if span == nil {
+		return
+	}
+
+	loc := &descriptorpb.SourceCodeInfo_Location{Path: p, Span: span}
+	if len(docs) > 0 {
+		loc.LeadingComments = proto.String(" " + strings.Join(docs, "\n ") + "\n")
+	}
+	b.locs = append(b.locs, loc)
+}
+
+// spanFinder finds the spans of the declarations in the source of a proto
+// file written by the generator. Declarations are looked for in the order
+// they are written, each one after the previous one.
+type spanFinder struct {
+	lines []string
+	next  int
+}
+
+func newSpanFinder(src []byte) *spanFinder {
+	return &spanFinder{lines: strings.Split(string(src), "\n")}
+}
+
+// file returns the span of the whole file.
+func (f *spanFinder) file() []int32 {
+	last := len(f.lines) - 1
+	return []int32{0, 0, int32(last), column(f.lines[last])}
+}
+
+// find returns the span of the next declaration whose line, without
+// indentation, matches. If block is true, the span ends at the closing brace
+// of the declaration. It returns nil if there is no such declaration.
+func (f *spanFinder) find(match func(string) bool, block bool) []int32 {
+	for i := f.next; i < len(f.lines); i++ {
+		line := f.lines[i]
+		text := strings.TrimLeft(line, "\t ")
+		if strings.HasPrefix(text, "//") || !match(text) {
+			continue
+		}
+
+		f.next = i + 1
+		indent := line[:len(line)-len(text)]
+		if block {
+			for j := i + 1; j < len(f.lines); j++ {
+				if f.lines[j] == indent+"}" {
+					return []int32{int32(i), column(indent), int32(j), column(f.lines[j])}
+				}
+			}
+		}
+		return []int32{int32(i), column(indent), column(line)}
+	}
+	return nil
+}
+
+func prefixed(prefix string) func(string) bool {
+	return func(text string) bool {
+		return strings.HasPrefix(text, prefix)
+	}
+}
+
+// fieldLine returns the matcher of the line declaring the field with the
+// given name and number.
+func fieldLine(name string, pos int) func(string) bool {
+	decl := fmt.Sprintf(" %s = %d", name, pos)
+	return func(text string) bool {
+		return strings.Contains(text, decl+";") || strings.Contains(text, decl+" [")
+	}
+}
+
+// column returns the column at the end of the given text, with tabs
+// advancing to the next multiple of 8, as protoc counts them.
+func column(text string) int32 {
+	var col int32
+	for _, r := range text {
+		if r == '\t' {
+			col += 8 - col%8
+		} else {
+			col++
+		}
+	}
+	return col
+}
+
+// path returns a new path made of the given path, if any, and elements.
+func path(elems ...interface{}) []int32 {
+	var p []int32
+	for _, e := range elems {
+		switch e := e.(type) {
+		case []int32:
+			p = append(p, e...)
+		case int:
+			p = append(p, int32(e))
+		}
+	}
+	return p
+}
+
+// jsonName returns the JSON name protoc assigns to the field with the given
+// name, which is the name in lower camel case.
+func jsonName(name string) string {
+	var (
+		buf   strings.Builder
+		upper bool
+	)
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+
+		if upper && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// mapEntryName returns the name of the message declared implicitly for the
+// entries of the map field with the given name, as protoc does.
+func mapEntryName(name string) string {
+	json := jsonName(name)
+	if json == "" {
+		return "Entry"
+	}
+	return strings.ToUpper(json[:1]) + json[1:] + "Entry"
+}
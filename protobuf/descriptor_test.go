@@ -0,0 +1,394 @@
+package protobuf
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"gopkg.in/src-d/proteus.v1/report"
+)
+
+var mockDescriptorPkg = &Package{
+	Name: "foo.bar",
+	Path: "foo/bar",
+	Imports: []string{
+		"google/protobuf/timestamp.proto",
+		gogoImport,
+	},
+	Options: Options{
+		"go_package":                 NewStringValue("bar"),
+		"(gogoproto.sizer_all)":      NewLiteralValue("false"),
+		"(gogoproto.unknown_option)": NewLiteralValue("true"),
+	},
+	Messages: []*Message{
+		mockMsg,
+		{
+			Docs:    []string{"User is a user."},
+			Name:    "User",
+			Options: Options{"(gogoproto.typedecl)": NewLiteralValue("false")},
+			Messages: []*Message{
+				{
+					Name: "Meta",
+					Fields: []*Field{
+						{Name: "kind", Type: NewNamed("", "Kind"), Pos: 1},
+					},
+				},
+			},
+			Enums: []*Enum{
+				{Name: "Kind", Values: []*EnumValue{{Name: "UNKNOWN"}}},
+			},
+			Fields: []*Field{
+				{Name: "meta", Type: NewNamed("", "Meta"), Pos: 1},
+				{Name: "nick", Type: NewBasic("string"), Pos: 2, Optional: true},
+				{
+					Name: "tag_counts",
+					Type: NewMap(NewBasic("string"), NewBasic("int64")),
+					Pos:  3,
+					Options: Options{
+						"(gogoproto.nullable)": NewLiteralValue("false"),
+					},
+				},
+			},
+			Oneofs: []*Oneof{
+				{
+					Docs: []string{"Pony the user owns"},
+					Name: "pet",
+					Fields: []*Field{
+						{Name: "pony", Type: NewNamed("foo.bar", "Pony"), Pos: 4},
+						{Name: "race", Type: NewNamed("foo.bar", "PonyRace"), Pos: 5},
+					},
+				},
+			},
+		},
+	},
+	Enums: []*Enum{mockEnum},
+	RPCs: []*RPC{
+		{
+			Docs:   []string{"GetOwner returns the owner of a pony"},
+			Name:   "GetOwner",
+			Input:  NewNamed("foo.bar", "Pony"),
+			Output: NewNamed("foo.bar", "User"),
+		},
+	},
+}
+
+func (s *GenSuite) TestDescriptorSet() {
+	set := s.g.DescriptorSet(mockDescriptorPkg)
+
+	var names []string
+	for _, f := range set.File {
+		names = append(names, f.GetName())
+	}
+	s.Equal([]string{
+		"google/protobuf/timestamp.proto",
+		"google/protobuf/descriptor.proto",
+		gogoImport,
+		"foo/bar/generated.proto",
+	}, names, "imports come before the files importing them")
+
+	files, err := protodesc.NewFiles(set)
+	s.Nil(err)
+
+	desc, err := files.FindDescriptorByName("foo.bar.User")
+	s.Nil(err)
+
+	user := desc.(protoreflect.MessageDescriptor)
+	s.Equal(protoreflect.FullName("foo.bar.User.Kind"), user.Messages().ByName("Meta").Fields().ByName("kind").Enum().FullName())
+	s.Equal(protoreflect.FullName("foo.bar.User.Meta"), user.Fields().ByName("meta").Message().FullName())
+	s.True(user.Fields().ByName("nick").HasOptionalKeyword())
+	s.True(user.Fields().ByName("tag_counts").IsMap())
+	s.Equal("tagCounts", user.Fields().ByName("tag_counts").JSONName())
+	s.Equal(protoreflect.Name("pet"), user.Fields().ByName("race").ContainingOneof().Name())
+	s.Equal(protoreflect.FullName("foo.bar.PonyRace"), user.Fields().ByName("race").Enum().FullName())
+	s.Equal(protoreflect.Name("_nick"), user.Oneofs().Get(1).Name())
+
+	desc, err = files.FindDescriptorByName("foo.bar.BarService.GetOwner")
+	s.Nil(err)
+	s.Equal(protoreflect.FullName("foo.bar.User"), desc.(protoreflect.MethodDescriptor).Output().FullName())
+
+	file := set.File[len(set.File)-1]
+	s.Equal("bar", file.GetOptions().GetGoPackage())
+	s.Equal(false, s.extension(files, file.GetOptions(), "gogoproto.sizer_all"))
+	s.Equal(false, s.extension(files, file.MessageType[1].GetOptions(), "gogoproto.typedecl"))
+	s.Equal(false, s.extension(files, file.MessageType[1].Field[2].GetOptions(), "gogoproto.nullable"))
+	s.Nil(file.MessageType[0].GetOptions(), "unknown options are ignored")
+	s.Nil(file.MessageType[0].Field[0].GetOptions(), "unknown options are ignored")
+}
+
+func (s *GenSuite) TestDescriptorSetImportedFiles() {
+	address := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("shared/address.proto"),
+		Package: proto.String("shared"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Address")},
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			{
+				Name:  proto.String("Kind"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{{Name: proto.String("HOME"), Number: proto.Int32(0)}},
+			},
+		},
+	}
+
+	pkg := &Package{Name: "foo", Path: "foo"}
+	pkg.Import(&ProtoType{Package: "shared", Name: "Address", Import: "shared/address.proto", File: address})
+	pkg.Import(&ProtoType{Package: "shared", Name: "Kind", Import: "shared/address.proto", File: address})
+	pkg.Messages = []*Message{
+		{
+			Name: "User",
+			Fields: []*Field{
+				{Name: "address", Type: NewNamed("shared", "Address"), Pos: 1},
+				{Name: "kind", Type: NewNamed("shared", "Kind"), Pos: 2},
+			},
+		},
+	}
+	s.Equal([]string{"shared/address.proto"}, pkg.Imports)
+
+	set := s.g.DescriptorSet(pkg)
+	s.Len(set.File, 2)
+	s.Equal(address, set.File[0])
+
+	files, err := protodesc.NewFiles(set)
+	s.Nil(err)
+
+	desc, err := files.FindDescriptorByName("foo.User")
+	s.Nil(err)
+
+	user := desc.(protoreflect.MessageDescriptor)
+	s.Equal(protoreflect.FullName("shared.Address"), user.Fields().ByName("address").Message().FullName())
+	s.Equal(protoreflect.FullName("shared.Kind"), user.Fields().ByName("kind").Enum().FullName())
+}
+
+func (s *GenSuite) TestDescriptorSetOptionPaths() {
+	rules := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("validate/validate.proto"),
+		Package:    proto.String("validate"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Syntax:     proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("FieldRules"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:     proto.String("string"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".validate.StringRules"),
+				}},
+			},
+			{
+				Name: proto.String("StringRules"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:   proto.String("min_len"),
+						Number: proto.Int32(1),
+						Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:   descriptorpb.FieldDescriptorProto_TYPE_UINT64.Enum(),
+					},
+					{
+						Name:   proto.String("max_len"),
+						Number: proto.Int32(2),
+						Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:   descriptorpb.FieldDescriptorProto_TYPE_UINT64.Enum(),
+					},
+				},
+			},
+		},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("rules"),
+			Number:   proto.Int32(1071),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			TypeName: proto.String(".validate.FieldRules"),
+			Extendee: proto.String(".google.protobuf.FieldOptions"),
+		}},
+	}
+
+	pkg := &Package{
+		Name:          "foo",
+		Path:          "foo",
+		Imports:       []string{"validate/validate.proto"},
+		ImportedFiles: map[string]*descriptorpb.FileDescriptorProto{"validate/validate.proto": rules},
+		Messages: []*Message{
+			{
+				Name: "User",
+				Fields: []*Field{
+					{
+						Name: "name",
+						Type: NewBasic("string"),
+						Pos:  1,
+						Options: Options{
+							"(validate.rules).string.min_len": NewLiteralValue("1"),
+							"(validate.rules).string.max_len": NewLiteralValue("5"),
+							"(validate.rules).string.foo":     NewLiteralValue("1"),
+						},
+					},
+					{
+						Name:    "nick",
+						Type:    NewBasic("string"),
+						Pos:     2,
+						Options: Options{"(validate.rules).bytes.min_len": NewLiteralValue("1")},
+					},
+				},
+			},
+		},
+		RPCs: []*RPC{
+			{
+				Name:    "GetUser",
+				Input:   NewNamed("foo", "User"),
+				Output:  NewNamed("foo", "User"),
+				Options: Options{"deprecated": NewLiteralValue("true")},
+			},
+		},
+	}
+
+	set := s.g.DescriptorSet(pkg)
+	files, err := protodesc.NewFiles(set)
+	s.Nil(err)
+
+	desc, err := files.FindDescriptorByName("foo.User")
+	s.Nil(err)
+
+	fields := desc.(protoreflect.MessageDescriptor).Fields()
+	name := s.extension(files, fields.ByName("name").Options().(proto.Message), "validate.rules").(protoreflect.Message)
+	str := name.Get(name.Descriptor().Fields().ByName("string")).Message()
+	s.Equal(uint64(1), str.Get(str.Descriptor().Fields().ByName("min_len")).Uint())
+	s.Equal(uint64(5), str.Get(str.Descriptor().Fields().ByName("max_len")).Uint())
+
+	s.Nil(set.File[len(set.File)-1].MessageType[0].Field[1].Options, "invalid paths set nothing")
+
+	method, err := files.FindDescriptorByName("foo.FooService.GetUser")
+	s.Nil(err)
+	s.True(method.Options().(*descriptorpb.MethodOptions).GetDeprecated())
+}
+
+func (s *GenSuite) TestDescriptorSetJSONName() {
+	report.TestMode()
+	defer report.EndTestMode()
+
+	// Fields tagged with json=userId have a json_name option.
+	pkg := &Package{
+		Name: "foo",
+		Path: "foo",
+		Messages: []*Message{
+			{
+				Name: "User",
+				Fields: []*Field{
+					{
+						Name: "user_id",
+						Type: NewBasic("string"),
+						Pos:  1,
+						Options: Options{
+							"json_name":  NewStringValue("userId"),
+							"deprecated": NewLiteralValue("true"),
+						},
+					},
+					{Name: "nick_name", Type: NewBasic("string"), Pos: 2},
+				},
+			},
+		},
+	}
+
+	set := s.g.DescriptorSet(pkg)
+	files, err := protodesc.NewFiles(set)
+	s.Nil(err)
+
+	desc, err := files.FindDescriptorByName("foo.User")
+	s.Nil(err)
+
+	fields := desc.(protoreflect.MessageDescriptor).Fields()
+	s.Equal("userId", fields.ByName("user_id").JSONName())
+	s.True(fields.ByName("user_id").Options().(*descriptorpb.FieldOptions).GetDeprecated())
+	s.Equal("nickName", fields.ByName("nick_name").JSONName())
+	s.Len(report.MessageStack(), 0, "json_name is not written as an option")
+}
+
+func (s *GenSuite) extension(files *protoregistry.Files, opts proto.Message, name string) interface{} {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	s.Nil(err)
+
+	xt := dynamicpb.NewExtensionType(desc.(protoreflect.ExtensionDescriptor))
+	s.True(opts.ProtoReflect().Has(xt.TypeDescriptor()), "option %s is set", name)
+	return opts.ProtoReflect().Get(xt.TypeDescriptor()).Interface()
+}
+
+func (s *GenSuite) TestDescriptorComments() {
+	files, err := protodesc.NewFiles(s.g.DescriptorSet(mockDescriptorPkg))
+	s.Nil(err)
+
+	fd, err := files.FindFileByPath("foo/bar/generated.proto")
+	s.Nil(err)
+
+	comments := make(map[string]string)
+
+	locs := fd.SourceLocations()
+	for _, desc := range []protoreflect.Descriptor{
+		fd.Messages().ByName("Pony"),
+		fd.Messages().ByName("Pony").Fields().ByName("name"),
+		fd.Messages().ByName("Pony").Fields().ByName("nick_names"),
+		fd.Messages().ByName("User"),
+		fd.Messages().ByName("User").Oneofs().ByName("pet"),
+		fd.Enums().ByName("PonyRace"),
+		fd.Enums().ByName("PonyRace").Values().ByName("PINK_CUTIE"),
+		fd.Services().ByName("BarService").Methods().ByName("GetOwner"),
+	} {
+		comments[string(desc.FullName())] = locs.ByDescriptor(desc).LeadingComments
+	}
+
+	s.Equal(map[string]string{
+		"foo.bar.Pony":                " Pony is so fancy\n and so fluffy\n",
+		"foo.bar.Pony.name":           " Name of the pony\n",
+		"foo.bar.Pony.nick_names":     " All the fancy nicknames the pony has\n",
+		"foo.bar.User":                " User is a user.\n",
+		"foo.bar.User.pet":            " Pony the user owns\n",
+		"foo.bar.PonyRace":            " Possible pony races\n",
+		"foo.bar.PINK_CUTIE":          " Pink cutie\n",
+		"foo.bar.BarService.GetOwner": " GetOwner returns the owner of a pony\n",
+	}, comments)
+
+	loc := locs.ByDescriptor(fd.Messages().ByName("Pony").Fields().ByName("race"))
+	lines := strings.Split(string(render(mockDescriptorPkg)), "\n")
+	s.Equal("\tfoo.bar.PonyRace race = 3;", lines[loc.StartLine])
+	s.Equal(8, loc.StartColumn)
+	s.Equal(loc.StartLine, loc.EndLine)
+}
+
+func (s *GenSuite) TestGenerateDescriptorSet() {
+	s.Nil(s.g.Generate(&Package{
+		Name: "foo",
+		Path: "foo",
+		Messages: []*Message{
+			{Name: "Foo", Fields: []*Field{{Name: "name", Type: NewBasic("string"), Pos: 1}}},
+		},
+	}))
+
+	s.Nil(s.g.Generate(&Package{
+		Name:    "bar",
+		Path:    "bar",
+		Imports: []string{"foo/generated.proto"},
+		Messages: []*Message{
+			{Name: "Bar", Fields: []*Field{{Name: "foo", Type: NewNamed("foo", "Foo"), Pos: 1}}},
+		},
+	}))
+
+	data, err := ioutil.ReadFile(filepath.Join(s.path, "bar", DescriptorSetFileName))
+	s.Nil(err)
+
+	var set descriptorpb.FileDescriptorSet
+	s.Nil(proto.Unmarshal(data, &set))
+	s.Len(set.File, 2, "descriptors of generated packages are included")
+
+	files, err := protodesc.NewFiles(&set)
+	s.Nil(err)
+
+	desc, err := files.FindDescriptorByName("bar.Bar")
+	s.Nil(err)
+	s.Equal(protoreflect.FullName("foo.Foo"), desc.(protoreflect.MessageDescriptor).Fields().ByName("foo").Message().FullName())
+}
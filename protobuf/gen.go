@@ -3,27 +3,70 @@ package protobuf
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/src-d/proteus.v1/output"
 	"gopkg.in/src-d/proteus.v1/report"
 )
 
 // Generator is in charge of generating the .proto files and write them
-// to disk in a file at the given path.
+// to its output, to disk by default, in a file at the given path.
 type Generator struct {
 	basePath string
+	output   output.Output
+	// descriptors are the descriptors of the files generated by the
+	// generator, by their name, which are included in the descriptor sets
+	// of the files importing them.
+	descriptors map[string]*descriptorpb.FileDescriptorProto
 }
 
-// NewGenerator creates a new Generator with the given base path.
+// NewGenerator creates a new Generator with the given base path, which
+// writes the files to disk under it.
 func NewGenerator(basePath string) *Generator {
-	return &Generator{basePath}
+	return &Generator{basePath, output.NewDisk(basePath), make(map[string]*descriptorpb.FileDescriptorProto)}
+}
+
+// SetOutput sets the output the generated files are written to.
+func (g *Generator) SetOutput(o output.Output) {
+	g.output = o
 }
 
 // Generate generates the proto3 .proto file of the given package and
-// writes it to disk.
+// writes it to the output. A binary FileDescriptorSet with the descriptor of the
+// file and the ones of the files it imports is written next to it. If the
+// package has a lock, it is written next to it too.
 func (g *Generator) Generate(pkg *Package) error {
+	src := render(pkg)
+	if err := g.writeFile(pkg.Path, "generated.proto", src); err != nil {
+		return err
+	}
+
+	set := g.descriptorSet(pkg, src)
+	file := set.File[len(set.File)-1]
+	g.descriptors[file.GetName()] = file
+
+	data, err := proto.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("unable to marshal descriptor set of package %s: %s", pkg.Path, err)
+	}
+
+	if err := g.writeFile(pkg.Path, DescriptorSetFileName, data); err != nil {
+		return err
+	}
+
+	if pkg.Lock != nil {
+		return g.WriteLock(pkg.Path, pkg.Lock)
+	}
+
+	return nil
+}
+
+// render returns the proto3 source of the .proto file of the given package.
+func render(pkg *Package) []byte {
 	var buf bytes.Buffer
 	buf.WriteString(`syntax = "proto3";` + "\n")
 
@@ -47,27 +90,53 @@ func (g *Generator) Generate(pkg *Package) error {
 		writeService(&buf, pkg)
 	}
 
-	return g.writeFile(pkg.Path, buf.Bytes())
+	return buf.Bytes()
 }
 
-func (g *Generator) writeFile(path string, data []byte) error {
-	path = filepath.Join(g.basePath, path)
-	fi, err := os.Stat(g.basePath)
-	if err != nil {
+func (g *Generator) writeFile(path, name string, data []byte) error {
+	file := filepath.Join(g.basePath, path, name)
+	if err := g.output.WriteFile(file, data); err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(path, fi.Mode()); err != nil {
-		return err
+	report.Info("Generated file: %s", file)
+	return nil
+}
+
+// ReadLocks reads the locks of the given Go packages from the base path on
+// disk, whatever the output of the generator is. A new empty lock is
+// returned for packages that have not been generated with a lock before.
+func (g *Generator) ReadLocks(paths []string) (Locks, error) {
+	var locks = make(Locks, len(paths))
+	for _, p := range paths {
+		f, err := os.Open(filepath.Join(g.basePath, p, LockFileName))
+		if os.IsNotExist(err) {
+			locks[p] = NewLock()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		lock, err := ReadLock(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read lock of package %s: %s", p, err)
+		}
+		locks[p] = lock
 	}
 
-	file := filepath.Join(path, "generated.proto")
-	if err := ioutil.WriteFile(file, data, fi.Mode()); err != nil {
+	return locks, nil
+}
+
+// WriteLock writes the given lock of the Go package with the given path to
+// its proteus.lock file, as Generate does with the lock of the package.
+func (g *Generator) WriteLock(path string, lock *Lock) error {
+	var buf bytes.Buffer
+	if err := lock.Write(&buf); err != nil {
 		return err
 	}
 
-	report.Info("Generated proto: %s", file)
-	return nil
+	return g.output.WriteFile(filepath.Join(g.basePath, path, LockFileName), buf.Bytes())
 }
 
 func writePackageData(buf *bytes.Buffer, pkg *Package) {
@@ -88,44 +157,84 @@ func writeMessage(buf *bytes.Buffer, msg *Message) {
 	writeDocs(buf, msg.Docs, false)
 	buf.WriteString(fmt.Sprintf("message %s {\n", msg.Name))
 	writeOptions(buf, msg.Options, true)
+	var reserved = make([]int64, len(msg.Reserved))
+	for i, r := range msg.Reserved {
+		reserved[i] = int64(r)
+	}
+	writeReserved(buf, reserved, msg.ReservedNames)
 
-	if len(msg.Reserved) > 0 {
-		buf.WriteString("\treserved ")
-
-		for i, p := range msg.Reserved {
-			if i > 0 {
-				buf.WriteString(", ")
-			}
-			buf.WriteString(fmt.Sprint(p))
-		}
+	for _, m := range msg.Messages {
+		writeIndented(buf, func(buf *bytes.Buffer) {
+			writeMessage(buf, m)
+		})
+	}
 
-		buf.WriteString(";\n")
+	for _, e := range msg.Enums {
+		writeIndented(buf, func(buf *bytes.Buffer) {
+			writeEnum(buf, e)
+		})
 	}
 
 	for _, f := range msg.Fields {
-		writeDocs(buf, f.Docs, true)
-		buf.WriteRune('\t')
-		if f.Repeated {
-			buf.WriteString("repeated ")
+		writeField(buf, f, "\t")
+	}
+
+	for _, o := range msg.Oneofs {
+		writeDocs(buf, o.Docs, true)
+		buf.WriteString(fmt.Sprintf("\toneof %s {\n", o.Name))
+		for _, f := range o.Fields {
+			writeField(buf, f, "\t\t")
 		}
+		buf.WriteString("\t}\n")
+	}
 
-		buf.WriteString(f.Type.String())
+	buf.WriteString("}\n")
+}
 
-		buf.WriteString(fmt.Sprintf(" %s = %d", f.Name, f.Pos))
-		if len(f.Options) > 0 {
-			buf.WriteRune(' ')
-			writeFieldOptions(buf, f.Options)
+// writeIndented writes what the given func writes indented one level more,
+// which is used to write the declarations nested in a message.
+func writeIndented(buf *bytes.Buffer, write func(*bytes.Buffer)) {
+	var nested bytes.Buffer
+	write(&nested)
+	for _, line := range strings.SplitAfter(nested.String(), "\n") {
+		if line != "" {
+			buf.WriteRune('\t')
+			buf.WriteString(line)
 		}
-		buf.WriteString(";\n")
 	}
+}
 
-	buf.WriteString("}\n")
+func writeField(buf *bytes.Buffer, f *Field, indent string) {
+	for _, d := range f.Docs {
+		buf.WriteString(fmt.Sprintf("%s// %s\n", indent, d))
+	}
+
+	buf.WriteString(indent)
+	if f.Repeated {
+		buf.WriteString("repeated ")
+	} else if f.Optional {
+		buf.WriteString("optional ")
+	}
+
+	buf.WriteString(f.Type.String())
+
+	buf.WriteString(fmt.Sprintf(" %s = %d", f.Name, f.Pos))
+	if len(f.Options) > 0 {
+		buf.WriteRune(' ')
+		writeFieldOptions(buf, f.Options)
+	}
+	buf.WriteString(";\n")
 }
 
 func writeEnum(buf *bytes.Buffer, enum *Enum) {
 	writeDocs(buf, enum.Docs, false)
 	buf.WriteString(fmt.Sprintf("enum %s {\n", enum.Name))
 	writeOptions(buf, enum.Options, true)
+	var reserved = make([]int64, len(enum.Reserved))
+	for i, r := range enum.Reserved {
+		reserved[i] = int64(r)
+	}
+	writeReserved(buf, reserved, enum.ReservedNames)
 
 	for _, v := range enum.Values {
 		writeDocs(buf, v.Docs, true)
@@ -140,6 +249,34 @@ func writeEnum(buf *bytes.Buffer, enum *Enum) {
 	buf.WriteString("}\n")
 }
 
+func writeReserved(buf *bytes.Buffer, reserved []int64, names []string) {
+	if len(reserved) > 0 {
+		buf.WriteString("\treserved ")
+
+		for i, p := range reserved {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(fmt.Sprint(p))
+		}
+
+		buf.WriteString(";\n")
+	}
+
+	if len(names) > 0 {
+		buf.WriteString("\treserved ")
+
+		for i, n := range names {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(fmt.Sprintf("%q", n))
+		}
+
+		buf.WriteString(";\n")
+	}
+}
+
 func writeOptions(buf *bytes.Buffer, options Options, indent bool) {
 	for _, opt := range options.Sorted() {
 		if indent {
@@ -176,11 +313,21 @@ func writeService(buf *bytes.Buffer, pkg *Package) {
 	for _, rpc := range pkg.RPCs {
 		writeDocs(buf, rpc.Docs, true)
 		buf.WriteString(fmt.Sprintf(
-			"\trpc %s (%s) returns (%s);\n",
+			"\trpc %s (%s) returns (%s)",
 			rpc.Name,
 			rpc.Input,
 			rpc.Output,
 		))
+
+		if len(rpc.Options) > 0 {
+			buf.WriteString(" {\n")
+			writeIndented(buf, func(buf *bytes.Buffer) {
+				writeOptions(buf, rpc.Options, true)
+			})
+			buf.WriteString("\t}\n")
+		} else {
+			buf.WriteString(";\n")
+		}
 	}
 	buf.WriteString("}\n\n")
 }
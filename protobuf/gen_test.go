@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/proteus.v1/output"
 )
 
 func TestGenerator(t *testing.T) {
@@ -60,6 +61,8 @@ func (s *GenSuite) TestWriteOptions() {
 const expectedEnum = `// Possible pony races
 enum PonyRace {
 	option is_cute = true;
+	reserved 2, -1;
+	reserved "BLUE_FURY";
 	// Pink cutie
 	PINK_CUTIE = 0;
 	RED_FURY = 1 [bar = "baz", foo = true];
@@ -72,6 +75,8 @@ var mockEnum = &Enum{
 	Options: Options{
 		"is_cute": NewLiteralValue("true"),
 	},
+	Reserved:      []int32{2, -1},
+	ReservedNames: []string{"BLUE_FURY"},
 	Values: []*EnumValue{
 		{
 			Docs:  []string{"Pink cutie"},
@@ -99,6 +104,7 @@ const expectedMsg = `// Pony is so fancy
 message Pony {
 	option is_cute = true;
 	reserved 5, 6;
+	reserved "color", "age";
 	// Name of the pony
 	string name = 1 [bar = "baz", foo = true];
 	// Time the pony was born
@@ -119,7 +125,8 @@ var mockMsg = &Message{
 	Options: Options{
 		"is_cute": NewLiteralValue("true"),
 	},
-	Reserved: []uint{5, 6},
+	Reserved:      []uint{5, 6},
+	ReservedNames: []string{"color", "age"},
 	Fields: []*Field{
 		{
 			Docs: []string{
@@ -160,6 +167,101 @@ func (s *GenSuite) TestWriteMessage() {
 	s.Equal(expectedMsg, s.buf.String())
 }
 
+const expectedMsgWithOneof = `message Drawing {
+	string name = 1;
+	// Shape that is drawn
+	oneof shape {
+		foo.bar.Circle circle = 2;
+		// Squares are circles too
+		foo.bar.Square square = 3;
+	}
+}
+`
+
+func (s *GenSuite) TestWriteMessageWithOneof() {
+	writeMessage(s.buf, &Message{
+		Name: "Drawing",
+		Fields: []*Field{
+			{Name: "name", Type: NewBasic("string"), Pos: 1},
+		},
+		Oneofs: []*Oneof{
+			{
+				Docs: []string{"Shape that is drawn"},
+				Name: "shape",
+				Fields: []*Field{
+					{Name: "circle", Type: NewNamed("foo.bar", "Circle"), Pos: 2},
+					{
+						Docs: []string{"Squares are circles too"},
+						Name: "square",
+						Type: NewNamed("foo.bar", "Square"),
+						Pos:  3,
+					},
+				},
+			},
+		},
+	})
+	s.Equal(expectedMsgWithOneof, s.buf.String())
+}
+
+const expectedMsgWithNested = `message User {
+	option (gogoproto.typedecl) = false;
+	// Meta holds metadata.
+	message Meta {
+		message Source {
+			string url = 1;
+		}
+		Source source = 1;
+		Kind kind = 2;
+	}
+	enum Kind {
+		UNKNOWN = 0;
+	}
+	Meta meta = 1;
+}
+`
+
+func (s *GenSuite) TestWriteMessageWithNested() {
+	writeMessage(s.buf, &Message{
+		Name:    "User",
+		Options: Options{"(gogoproto.typedecl)": NewLiteralValue("false")},
+		Messages: []*Message{
+			{
+				Docs: []string{"Meta holds metadata."},
+				Name: "Meta",
+				Messages: []*Message{
+					{
+						Name: "Source",
+						Fields: []*Field{
+							{Name: "url", Type: NewBasic("string"), Pos: 1},
+						},
+					},
+				},
+				Fields: []*Field{
+					{Name: "source", Type: NewNamed("", "Source"), Pos: 1},
+					{Name: "kind", Type: NewNamed("", "Kind"), Pos: 2},
+				},
+			},
+		},
+		Enums: []*Enum{
+			{Name: "Kind", Values: []*EnumValue{{Name: "UNKNOWN"}}},
+		},
+		Fields: []*Field{
+			{Name: "meta", Type: NewNamed("", "Meta"), Pos: 1},
+		},
+	})
+	s.Equal(expectedMsgWithNested, s.buf.String())
+}
+
+func (s *GenSuite) TestWriteFieldOptional() {
+	writeField(s.buf, &Field{
+		Name:     "nick",
+		Type:     NewBasic("string"),
+		Pos:      2,
+		Optional: true,
+	}, "\t")
+	s.Equal("\toptional string nick = 2;\n", s.buf.String())
+}
+
 var mockRpcs = []*RPC{
 	{
 		Docs:   []string{"DoFoo does a lot of Foo"},
@@ -192,6 +294,33 @@ func (s *GenSuite) TestWriteService() {
 	s.Equal(expectedService, s.buf.String())
 }
 
+const expectedServiceWithOptions = `service BarService {
+	rpc DoFoo (foo.bar.DoFooRequest) returns (foo.bar.DoFooResponse) {
+		option deprecated = true;
+		option idempotency_level = NO_SIDE_EFFECTS;
+	}
+}
+
+`
+
+func (s *GenSuite) TestWriteServiceWithOptions() {
+	writeService(s.buf, &Package{
+		Name: "foo.bar",
+		RPCs: []*RPC{
+			{
+				Name:   "DoFoo",
+				Input:  NewNamed("foo.bar", "DoFooRequest"),
+				Output: NewNamed("foo.bar", "DoFooResponse"),
+				Options: Options{
+					"deprecated":        NewLiteralValue("true"),
+					"idempotency_level": NewLiteralValue("NO_SIDE_EFFECTS"),
+				},
+			},
+		},
+	})
+	s.Equal(expectedServiceWithOptions, s.buf.String())
+}
+
 var expectedProto = fmt.Sprintf(`syntax = "proto3";
 package foo.bar;
 
@@ -219,3 +348,48 @@ func (s *GenSuite) TestGenerate() {
 
 	s.Equal(expectedProto, string(bytes))
 }
+
+func (s *GenSuite) TestGenerateToOutput() {
+	files := output.NewMemory()
+	s.g.SetOutput(files)
+
+	lock := NewLock()
+	lock.Message("Pony").Set("name", 1)
+	s.Nil(s.g.Generate(&Package{
+		Name:     "foo.bar",
+		Path:     "foo/bar",
+		Imports:  []string{"google/protobuf/timestamp.proto"},
+		Messages: []*Message{mockMsg},
+		Enums:    []*Enum{mockEnum},
+		Options:  Options{"foo": NewLiteralValue("true")},
+		RPCs:     mockRpcs,
+		Lock:     lock,
+	}))
+
+	dir := filepath.Join(s.path, "foo", "bar")
+	s.Equal([]string{
+		filepath.Join(dir, "generated.proto"),
+		filepath.Join(dir, DescriptorSetFileName),
+		filepath.Join(dir, LockFileName),
+	}, files.Paths())
+	s.Equal(expectedProto, string(files[filepath.Join(dir, "generated.proto")]))
+
+	_, err := os.Stat(dir)
+	s.True(os.IsNotExist(err), "nothing is written to disk")
+}
+
+func (s *GenSuite) TestGenerateWithLock() {
+	lock := NewLock()
+	lock.Message("Pony").Set("name", 1)
+
+	err := s.g.Generate(&Package{
+		Name: "foo.bar",
+		Lock: lock,
+	})
+	s.Nil(err)
+
+	locks, err := s.g.ReadLocks([]string{"", "baz"})
+	s.Nil(err)
+	s.Equal(lock, locks[""])
+	s.Equal(NewLock(), locks["baz"], "missing locks are empty")
+}
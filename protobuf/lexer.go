@@ -0,0 +1,356 @@
+package protobuf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenFloat
+	tokenString
+	tokenSymbol
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of file"
+	case tokenIdent:
+		return "identifier"
+	case tokenInt:
+		return "integer"
+	case tokenFloat:
+		return "number"
+	case tokenString:
+		return "string"
+	}
+	return "symbol"
+}
+
+// token is a token of a proto file.
+type token struct {
+	kind tokenKind
+	// text is the text of the token as it is written, except for strings,
+	// whose text is their unquoted value.
+	text      string
+	line, col int
+	// docs are the lines of the comments right before the token, with no
+	// blank line in between, without the comment markers.
+	docs []string
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return t.kind.String()
+	}
+	if t.kind == tokenString {
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lexer splits the source of a proto file in tokens.
+type lexer struct {
+	src       []rune
+	pos       int
+	line, col int
+	// lastLine is the line of the last token, used to tell apart the
+	// comments after it in the same line, which are not docs of the next
+	// token.
+	lastLine int
+}
+
+// tokenize returns all the tokens of the given source, ending with a
+// tokenEOF token.
+func tokenize(src string) ([]token, error) {
+	l := &lexer{src: []rune(src), line: 1, col: 1}
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, tok)
+		if tok.kind == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) errorf(line, col int, format string, args ...interface{}) error {
+	return fmt.Errorf("%d:%d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func (l *lexer) peek(offset int) rune {
+	if l.pos+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos+offset]
+}
+
+func (l *lexer) advance() rune {
+	r := l.src[l.pos]
+	l.pos++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+// skip skips the whitespace and comments before the next token and returns
+// the docs of the token.
+func (l *lexer) skip() ([]string, error) {
+	var (
+		docs []string
+		// newlines is the number of line breaks since the last comment.
+		newlines int
+	)
+	for l.pos < len(l.src) {
+		r := l.peek(0)
+		switch {
+		case r == '\n':
+			newlines++
+			if newlines > 1 {
+				docs = nil
+			}
+			l.advance()
+		case unicode.IsSpace(r):
+			l.advance()
+		case r == '/' && (l.peek(1) == '/' || l.peek(1) == '*'):
+			line := l.line
+			text, err := l.comment()
+			if err != nil {
+				return nil, err
+			}
+
+			if line == l.lastLine {
+				// Trailing comment of the previous token.
+				continue
+			}
+
+			docs = append(docs, text...)
+			newlines = 0
+		default:
+			return docs, nil
+		}
+	}
+	return docs, nil
+}
+
+// comment reads a comment and returns its lines.
+func (l *lexer) comment() ([]string, error) {
+	line, col := l.line, l.col
+	l.advance()
+	if l.advance() == '/' {
+		var buf strings.Builder
+		for l.pos < len(l.src) && l.peek(0) != '\n' {
+			buf.WriteRune(l.advance())
+		}
+		return []string{trimComment(buf.String())}, nil
+	}
+
+	var buf strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return nil, l.errorf(line, col, "comment is not terminated")
+		}
+
+		if l.peek(0) == '*' && l.peek(1) == '/' {
+			l.advance()
+			l.advance()
+			break
+		}
+		buf.WriteRune(l.advance())
+	}
+
+	var lines []string
+	for _, text := range strings.Split(buf.String(), "\n") {
+		text = strings.TrimSpace(text)
+		text = strings.TrimPrefix(text, "*")
+		if text = trimComment(text); text != "" || len(lines) > 0 {
+			lines = append(lines, text)
+		}
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// trimComment removes the space after the comment marker and the trailing
+// spaces of a line of a comment.
+func trimComment(text string) string {
+	return strings.TrimRightFunc(strings.TrimPrefix(text, " "), unicode.IsSpace)
+}
+
+func (l *lexer) next() (token, error) {
+	docs, err := l.skip()
+	if err != nil {
+		return token{}, err
+	}
+
+	tok := token{line: l.line, col: l.col, docs: docs}
+	if l.pos >= len(l.src) {
+		tok.kind = tokenEOF
+		return tok, nil
+	}
+
+	r := l.peek(0)
+	start := l.pos
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		for l.pos < len(l.src) && (l.peek(0) == '_' || unicode.IsLetter(l.peek(0)) || unicode.IsDigit(l.peek(0))) {
+			l.advance()
+		}
+		tok.kind = tokenIdent
+	case unicode.IsDigit(r) || r == '.' && unicode.IsDigit(l.peek(1)):
+		tok.kind = l.number()
+	case r == '"' || r == '\'':
+		text, err := l.string()
+		if err != nil {
+			return token{}, err
+		}
+		tok.kind = tokenString
+		tok.text = text
+	default:
+		l.advance()
+		tok.kind = tokenSymbol
+	}
+
+	if tok.kind != tokenString {
+		tok.text = string(l.src[start:l.pos])
+	}
+	l.lastLine = l.line
+	return tok, nil
+}
+
+// number reads an integer or floating point number and returns its kind.
+func (l *lexer) number() tokenKind {
+	kind := tokenInt
+	if l.peek(0) == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X') {
+		l.advance()
+		l.advance()
+		for isHexDigit(l.peek(0)) {
+			l.advance()
+		}
+		return kind
+	}
+
+	for {
+		r := l.peek(0)
+		switch {
+		case unicode.IsDigit(r):
+		case r == '.':
+			kind = tokenFloat
+		case r == 'e' || r == 'E':
+			kind = tokenFloat
+			if l.peek(1) == '+' || l.peek(1) == '-' {
+				l.advance()
+			}
+		default:
+			return kind
+		}
+		l.advance()
+	}
+}
+
+func isHexDigit(r rune) bool {
+	return unicode.IsDigit(r) || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
+
+// string reads a string literal and returns its value.
+func (l *lexer) string() (string, error) {
+	line, col := l.line, l.col
+	quote := l.advance()
+
+	var buf strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.peek(0) == '\n' {
+			return "", l.errorf(line, col, "string is not terminated")
+		}
+
+		r := l.advance()
+		if r == quote {
+			return buf.String(), nil
+		}
+
+		if r != '\\' {
+			buf.WriteRune(r)
+			continue
+		}
+
+		if err := l.escape(&buf); err != nil {
+			return "", err
+		}
+	}
+}
+
+var escapes = map[rune]rune{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t',
+	'v': '\v', '\\': '\\', '\'': '\'', '"': '"', '?': '?',
+}
+
+// escape reads the escape sequence after a backslash in a string and writes
+// the character it stands for.
+func (l *lexer) escape(buf *strings.Builder) error {
+	line, col := l.line, l.col-1
+	if l.pos >= len(l.src) {
+		return l.errorf(line, col, "string is not terminated")
+	}
+
+	r := l.advance()
+	if e, ok := escapes[r]; ok {
+		buf.WriteRune(e)
+		return nil
+	}
+
+	var (
+		digits string
+		base   = 16
+		size   int
+	)
+	switch {
+	case r == 'x' || r == 'X':
+		size = 2
+	case r == 'u':
+		size = 4
+	case r == 'U':
+		size = 8
+	case r >= '0' && r <= '7':
+		digits, base, size = string(r), 8, 2
+	default:
+		return l.errorf(line, col, "unknown escape sequence \\%c", r)
+	}
+
+	for i := 0; i < size && l.pos < len(l.src); i++ {
+		next := l.peek(0)
+		if base == 8 && (next < '0' || next > '7') || base == 16 && !isHexDigit(next) {
+			break
+		}
+		digits += string(l.advance())
+	}
+
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return l.errorf(line, col, "invalid escape sequence \\%c%s", r, digits)
+	}
+
+	if r == 'u' || r == 'U' {
+		buf.WriteRune(rune(n))
+	} else {
+		buf.WriteByte(byte(n))
+	}
+	return nil
+}
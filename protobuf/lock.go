@@ -0,0 +1,249 @@
+package protobuf
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// LockFileName is the name of the file, written next to the generated.proto
+// file of every package, that records the numbers assigned to fields and
+// enum values.
+const LockFileName = "proteus.lock"
+
+// Lock records the numbers assigned to the fields of every message and to the
+// values of every enum of a package, so they can be kept stable across
+// generations even if the Go source code is reordered. Numbers that are no
+// longer in use are kept as reserved and never assigned again.
+type Lock struct {
+	Messages map[string]*NumberLock `json:"messages,omitempty"`
+	Enums    map[string]*NumberLock `json:"enums,omitempty"`
+}
+
+// Locks is a set of locks indexed by the Go package path they belong to.
+type Locks map[string]*Lock
+
+// NewLock creates a new empty lock.
+func NewLock() *Lock {
+	return &Lock{
+		Messages: make(map[string]*NumberLock),
+		Enums:    make(map[string]*NumberLock),
+	}
+}
+
+// ReadLock decodes a lock from the given reader.
+func ReadLock(r io.Reader) (*Lock, error) {
+	l := NewLock()
+	if err := json.NewDecoder(r).Decode(l); err != nil {
+		return nil, err
+	}
+
+	if l.Messages == nil {
+		l.Messages = make(map[string]*NumberLock)
+	}
+
+	if l.Enums == nil {
+		l.Enums = make(map[string]*NumberLock)
+	}
+
+	return l, nil
+}
+
+// Write encodes the lock to the given writer.
+func (l *Lock) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Message returns the lock of the message with the given name, creating it
+// if it does not exist yet.
+func (l *Lock) Message(name string) *NumberLock {
+	return lockFor(l.Messages, name)
+}
+
+// Enum returns the lock of the enum with the given name, creating it if it
+// does not exist yet.
+func (l *Lock) Enum(name string) *NumberLock {
+	return lockFor(l.Enums, name)
+}
+
+func lockFor(locks map[string]*NumberLock, name string) *NumberLock {
+	if lock, ok := locks[name]; ok {
+		return lock
+	}
+
+	lock := &NumberLock{Numbers: make(map[string]int)}
+	locks[name] = lock
+	return lock
+}
+
+// NumberLock records the numbers assigned to the names of a message or enum.
+// For messages, names are field names and for enums, value names.
+type NumberLock struct {
+	Numbers       map[string]int `json:"numbers"`
+	Reserved      []int          `json:"reserved,omitempty"`
+	ReservedNames []string       `json:"reserved_names,omitempty"`
+}
+
+// Number returns the number locked for the given name. If the name has no
+// number yet, the first number greater than all the numbers ever used is
+// locked and returned. The first number to be assigned is first.
+func (l *NumberLock) Number(name string, first int) int {
+	if n, ok := l.Numbers[name]; ok {
+		return n
+	}
+
+	n := first
+	for _, used := range l.Numbers {
+		if used >= n {
+			n = used + 1
+		}
+	}
+
+	for _, used := range l.Reserved {
+		if used >= n {
+			n = used + 1
+		}
+	}
+
+	l.Numbers[name] = n
+	l.ReservedNames = removeString(l.ReservedNames, name)
+	return n
+}
+
+// Set locks the given number for the given name. If the number was locked
+// for another name or reserved, it is taken from it. If the name had another
+// number, that number is reserved.
+func (l *NumberLock) Set(name string, n int) {
+	if old, ok := l.Numbers[name]; ok && old != n && !containsInt(l.Reserved, old) {
+		l.Reserved = append(l.Reserved, old)
+		sort.Slice(l.Reserved, func(i, j int) bool {
+			return l.Reserved[i] < l.Reserved[j]
+		})
+	}
+
+	for other, m := range l.Numbers {
+		if m == n && other != name {
+			delete(l.Numbers, other)
+		}
+	}
+
+	l.Numbers[name] = n
+	l.Reserved = removeInt(l.Reserved, n)
+	l.ReservedNames = removeString(l.ReservedNames, name)
+}
+
+// setValue locks the given value for the given name. Unlike Set, several
+// names may share the same value, as aliased enum values do.
+func (l *NumberLock) setValue(name string, v int) {
+	l.Numbers[name] = v
+	l.Reserved = removeInt(l.Reserved, v)
+	l.ReservedNames = removeString(l.ReservedNames, name)
+}
+
+// Retain removes all names that are not in the given list, reserving their
+// numbers and names.
+func (l *NumberLock) Retain(names []string) {
+	var keep = make(map[string]struct{}, len(names))
+	for _, n := range names {
+		keep[n] = struct{}{}
+	}
+
+	var removed []string
+	for name := range l.Numbers {
+		if _, ok := keep[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+
+	for _, name := range removed {
+		l.reserve(l.Numbers[name], name)
+		delete(l.Numbers, name)
+	}
+}
+
+func (l *NumberLock) reserve(n int, name string) {
+	if !containsInt(l.Reserved, n) {
+		l.Reserved = append(l.Reserved, n)
+		sort.Slice(l.Reserved, func(i, j int) bool {
+			return l.Reserved[i] < l.Reserved[j]
+		})
+	}
+
+	if !containsString(l.ReservedNames, name) {
+		l.ReservedNames = append(l.ReservedNames, name)
+		sort.Strings(l.ReservedNames)
+	}
+}
+
+// reserveNumber reserves the given number unless it is locked for a name.
+func (l *NumberLock) reserveNumber(n int) {
+	for _, used := range l.Numbers {
+		if used == n {
+			return
+		}
+	}
+
+	if !containsInt(l.Reserved, n) {
+		l.Reserved = append(l.Reserved, n)
+		sort.Slice(l.Reserved, func(i, j int) bool {
+			return l.Reserved[i] < l.Reserved[j]
+		})
+	}
+}
+
+// reserveName reserves the given name unless it has a number locked.
+func (l *NumberLock) reserveName(name string) {
+	if _, ok := l.Numbers[name]; ok {
+		return
+	}
+
+	if !containsString(l.ReservedNames, name) {
+		l.ReservedNames = append(l.ReservedNames, name)
+		sort.Strings(l.ReservedNames)
+	}
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeInt(list []int, n int) []int {
+	var result []int
+	for _, v := range list {
+		if v != n {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func removeString(list []string, s string) []string {
+	var result []string
+	for _, v := range list {
+		if v != s {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package protobuf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumberLock(t *testing.T) {
+	require := require.New(t)
+	l := NewLock().Message("Foo")
+
+	require.Equal(1, l.Number("foo", 1))
+	require.Equal(2, l.Number("bar", 1))
+	require.Equal(1, l.Number("foo", 1), "number is reused")
+
+	l.Set("baz", 10)
+	require.Equal(11, l.Number("qux", 1))
+
+	l.Retain([]string{"foo", "qux"})
+	require.Equal([]int{2, 10}, l.Reserved)
+	require.Equal([]string{"bar", "baz"}, l.ReservedNames)
+	require.Equal(map[string]int{"foo": 1, "qux": 11}, l.Numbers)
+
+	l.Retain(nil)
+	require.Equal([]int{1, 2, 10, 11}, l.Reserved)
+	require.Equal(12, l.Number("bar", 1), "reserved numbers are never reused")
+	require.Equal([]string{"baz", "foo", "qux"}, l.ReservedNames)
+}
+
+func TestLockReadWrite(t *testing.T) {
+	require := require.New(t)
+	lock := NewLock()
+	lock.Message("Foo").Number("bar", 1)
+	lock.Message("Foo").Retain(nil)
+	lock.Enum("Baz").Number("QUX", 0)
+
+	var buf bytes.Buffer
+	require.NoError(lock.Write(&buf))
+
+	result, err := ReadLock(&buf)
+	require.NoError(err)
+	require.Equal(lock, result)
+
+	_, err = ReadLock(bytes.NewBufferString("{"))
+	require.Error(err)
+
+	result, err = ReadLock(bytes.NewBufferString("{}"))
+	require.NoError(err)
+	require.Equal(NewLock(), result)
+}
@@ -1,9 +1,14 @@
 package protobuf
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 // ProtoType represents a protobuf type. It can optionally have a
@@ -15,6 +20,9 @@ type ProtoType struct {
 	Import  string
 	// GoImport represents the go package to import to use this type.
 	GoImport string
+	// File is the descriptor of the imported file, if it is known and it
+	// is neither well-known nor generated by proteus.
+	File *descriptorpb.FileDescriptorProto
 	// Decorators define a set of function to apply to each field, message and
 	// package that contain a field with this type.
 	Decorators Decorators
@@ -24,6 +32,9 @@ type ProtoType struct {
 	// protobuf and the warning message "%s becomes B", then the reported message
 	// will be "A becomes B"
 	Warn string
+	// freeForm is whether the Go type holds free-form values, such as
+	// interface{}.
+	freeForm bool
 }
 
 func (pt *ProtoType) Decorate(p *Package, m *Message, f *Field) {
@@ -176,6 +187,24 @@ var DefaultMappings = TypeMappings{
 			},
 		),
 	},
+	"interface{}":              freeFormType("Value"),
+	"map[string]interface{}":   freeFormType("Struct"),
+	"encoding/json.RawMessage": freeFormType("Value"),
+	// json.RawMessage is an alias of jsontext.Value since encoding/json
+	// is implemented on top of encoding/json/v2.
+	"encoding/json/jsontext.Value": freeFormType("Value"),
+}
+
+// freeFormType returns the mapping to the given google.protobuf well-known
+// type of a Go type holding free-form values, such as interface{}.
+func freeFormType(name string) *ProtoType {
+	return &ProtoType{
+		Name:     name,
+		Package:  "google.protobuf",
+		Import:   "google/protobuf/struct.proto",
+		GoImport: "github.com/gogo/protobuf/types",
+		freeForm: true,
+	}
 }
 
 // ToGoOutPath returns the set of import mappings for the --go_out family of options.
@@ -188,12 +217,120 @@ func (t TypeMappings) ToGoOutPath() string {
 	}
 	sort.Strings(keys)
 
+	var seen = make(map[string]struct{})
 	for _, k := range keys {
 		value := t[k]
 		if value.Import != "" && value.GoImport != "" {
-			strs = append(strs, fmt.Sprintf("M%s=%s", value.Import, value.GoImport))
+			m := fmt.Sprintf("M%s=%s", value.Import, value.GoImport)
+			if _, ok := seen[m]; !ok {
+				seen[m] = struct{}{}
+				strs = append(strs, m)
+			}
 		}
 	}
 
 	return strings.Join(strs, ",")
 }
+
+// MappingConfig is the declarative mapping of a Go type to a protobuf type, as
+// written in a mappings file.
+type MappingConfig struct {
+	// Name is the name of the protobuf type.
+	Name string `json:"name"`
+	// Package is the protobuf package of the type, if any.
+	Package string `json:"package,omitempty"`
+	// Basic reports whether the protobuf type is a scalar type.
+	Basic bool `json:"basic,omitempty"`
+	// Import is the proto file to import to use the type.
+	Import string `json:"import,omitempty"`
+	// GoImport is the Go package of the code generated for the type.
+	GoImport string `json:"goImport,omitempty"`
+	// CastType is the Go type the fields are cast to with the
+	// gogoproto.casttype option.
+	CastType string `json:"casttype,omitempty"`
+	// CustomType is the Go type of the fields set with the
+	// gogoproto.customtype option.
+	CustomType string `json:"customtype,omitempty"`
+	// Nullable sets the gogoproto.nullable option of the fields, if given.
+	Nullable *bool `json:"nullable,omitempty"`
+	// Warn is the warning shown when the type is mapped, see ProtoType.
+	Warn string `json:"warn,omitempty"`
+}
+
+// ProtoType returns the protobuf type of the mapping, with the decorators
+// adding the gogoproto options of the mapping to the fields.
+func (c *MappingConfig) ProtoType() *ProtoType {
+	var decorators Decorators
+	if c.CastType != "" {
+		decorators = append(decorators, CastToBasicType(c.CastType)...)
+	}
+
+	if c.CustomType != "" || c.Nullable != nil {
+		customType, nullable := c.CustomType, c.Nullable
+		decorators = append(decorators, func(p *Package, m *Message, f *Field) {
+			if f.Options == nil {
+				f.Options = make(Options)
+			}
+
+			if customType != "" {
+				f.Options["(gogoproto.customtype)"] = NewStringValue(customType)
+			}
+
+			if nullable != nil {
+				f.Options["(gogoproto.nullable)"] = NewLiteralValue(fmt.Sprint(*nullable))
+			}
+		})
+	}
+
+	return &ProtoType{
+		Package:    c.Package,
+		Basic:      c.Basic,
+		Name:       c.Name,
+		Import:     c.Import,
+		GoImport:   c.GoImport,
+		Decorators: decorators,
+		Warn:       c.Warn,
+	}
+}
+
+func (c *MappingConfig) validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("the name of the protobuf type is required")
+	case c.Basic && c.Package != "":
+		return errors.New("basic types cannot have a package")
+	case c.Basic && c.Import != "":
+		return errors.New("basic types cannot have an import")
+	case c.CastType != "" && c.CustomType != "":
+		return errors.New("casttype and customtype cannot be used together")
+	}
+	return nil
+}
+
+// ReadMappings decodes the type mappings of a mappings file from the given
+// reader. The file is a JSON object with the mapping of every Go type, see
+// MappingConfig, indexed by the name of the Go type, e.g.
+// "github.com/google/uuid.UUID".
+func ReadMappings(r io.Reader) (TypeMappings, error) {
+	var configs map[string]*MappingConfig
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&configs); err != nil {
+		return nil, err
+	}
+
+	var mappings = make(TypeMappings, len(configs))
+	for name, c := range configs {
+		if c == nil {
+			return nil, fmt.Errorf("mapping of type %s is empty", name)
+		}
+
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("invalid mapping of type %s: %s", name, err)
+		}
+
+		mappings[name] = c.ProtoType()
+	}
+
+	return mappings, nil
+}
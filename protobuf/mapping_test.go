@@ -1,9 +1,11 @@
 package protobuf
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_timeTimeDecorator(t *testing.T) {
@@ -60,4 +62,75 @@ func TestToGoOutPath(t *testing.T) {
 		"typB": &ProtoType{Import: "b", GoImport: "2"},
 		"typC": &ProtoType{Import: "c", GoImport: "3"},
 	}.ToGoOutPath())
+
+	// Repeated
+	assert.Equal(t, "Ma=1", TypeMappings{
+		"typA": &ProtoType{Import: "a", GoImport: "1"},
+		"typB": &ProtoType{Import: "a", GoImport: "1"},
+	}.ToGoOutPath())
+}
+
+func TestReadMappings(t *testing.T) {
+	m, err := ReadMappings(strings.NewReader(`{
+		"github.com/google/uuid.UUID": {
+			"name": "string",
+			"basic": true,
+			"customtype": "github.com/google/uuid.UUID",
+			"nullable": false
+		},
+		"github.com/shopspring/decimal.Decimal": {
+			"name": "Decimal",
+			"package": "money",
+			"import": "money/decimal.proto",
+			"goImport": "example.com/money",
+			"warn": "type %s is generated as money.Decimal"
+		},
+		"example.com/ids.ID": {
+			"name": "int64",
+			"basic": true,
+			"casttype": "example.com/ids.ID"
+		}
+	}`))
+	require.NoError(t, err)
+	require.Len(t, m, 3)
+
+	uuid := m["github.com/google/uuid.UUID"]
+	assert.Equal(t, NewBasic("string"), uuid.Type())
+	f := new(Field)
+	uuid.Decorate(&Package{}, &Message{}, f)
+	assert.Equal(t, Options{
+		"(gogoproto.customtype)": NewStringValue("github.com/google/uuid.UUID"),
+		"(gogoproto.nullable)":   NewLiteralValue("false"),
+	}, f.Options)
+
+	decimal := m["github.com/shopspring/decimal.Decimal"]
+	assert.Equal(t, NewNamed("money", "Decimal"), decimal.Type())
+	assert.Equal(t, "money/decimal.proto", decimal.Import)
+	assert.Equal(t, "example.com/money", decimal.GoImport)
+	assert.Equal(t, "type %s is generated as money.Decimal", decimal.Warn)
+	assert.Empty(t, decimal.Decorators)
+
+	id := m["example.com/ids.ID"]
+	f = new(Field)
+	id.Decorate(&Package{}, &Message{}, f)
+	assert.Equal(t, Options{
+		"(gogoproto.casttype)": NewStringValue("example.com/ids.ID"),
+	}, f.Options)
+}
+
+func TestReadMappingsInvalid(t *testing.T) {
+	cases := map[string]string{
+		"no name":          `{"a.B": {"basic": true}}`,
+		"basic package":    `{"a.B": {"name": "string", "basic": true, "package": "foo"}}`,
+		"basic import":     `{"a.B": {"name": "string", "basic": true, "import": "foo.proto"}}`,
+		"cast and custom":  `{"a.B": {"name": "string", "casttype": "a.B", "customtype": "a.B"}}`,
+		"empty":            `{"a.B": null}`,
+		"unknown field":    `{"a.B": {"name": "string", "foo": 1}}`,
+		"invalid document": `[]`,
+	}
+
+	for name, c := range cases {
+		_, err := ReadMappings(strings.NewReader(c))
+		assert.Error(t, err, name)
+	}
 }
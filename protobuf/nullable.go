@@ -0,0 +1,143 @@
+package protobuf
+
+import (
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// NullableMode is the way fields holding values that may not be set, which
+// are pointers to basic types and the database/sql Null* types, are generated.
+type NullableMode int
+
+const (
+	// NullableAsScalar generates them as plain scalars, so an unset value
+	// cannot be told apart from the zero value. This is the default.
+	NullableAsScalar NullableMode = iota
+	// NullableAsOptional generates them as proto3 optional scalars.
+	NullableAsOptional
+	// NullableAsWrapper generates them as google.protobuf wrapper types,
+	// e.g. google.protobuf.StringValue.
+	NullableAsWrapper
+)
+
+// WrapperMappings are the google.protobuf wrapper types of the protobuf
+// scalar types.
+var WrapperMappings = TypeMappings{
+	"double": wrapperType("DoubleValue"),
+	"float":  wrapperType("FloatValue"),
+	"int64":  wrapperType("Int64Value"),
+	"uint64": wrapperType("UInt64Value"),
+	"int32":  wrapperType("Int32Value"),
+	"uint32": wrapperType("UInt32Value"),
+	"bool":   wrapperType("BoolValue"),
+	"string": wrapperType("StringValue"),
+}
+
+// wrapperGoTypes are the Go types of the values of the wrapper types of the
+// protobuf scalar types when they are generated as pointers.
+var wrapperGoTypes = map[string]string{
+	"double": "float64",
+	"float":  "float32",
+	"int64":  "int64",
+	"uint64": "uint64",
+	"int32":  "int32",
+	"uint32": "uint32",
+	"bool":   "bool",
+	"string": "string",
+}
+
+func wrapperType(name string) *ProtoType {
+	return &ProtoType{
+		Name:     name,
+		Package:  "google.protobuf",
+		Import:   "google/protobuf/wrappers.proto",
+		GoImport: "github.com/gogo/protobuf/types",
+	}
+}
+
+// SetNullableMode sets the way the fields holding values that may not be set
+// are generated. Only the fields of messages declared for structs are
+// affected.
+func (t *Transformer) SetNullableMode(mode NullableMode) {
+	t.nullableMode = mode
+}
+
+// nullableBasic returns the Go type of the value held by the given type of a
+// field of the given message if it has to be transformed as a nullable value,
+// or an empty string otherwise.
+func (t *Transformer) nullableBasic(msg *Message, typ scanner.Type) string {
+	switch ty := typ.(type) {
+	case *scanner.Named:
+		return scanner.SQLNullTypes[ty.String()]
+	case *scanner.Basic:
+		// Basic types always report themselves as nullable, so the
+		// pointer has to be checked on the base type.
+		if t.nullableMode != NullableAsScalar && ty.BaseType.IsNullable() &&
+			!ty.IsRepeated() && isDeclaredByUser(msg) {
+			return ty.Name
+		}
+	}
+	return ""
+}
+
+// transformNullable converts the type of a field holding a nullable value of
+// the given Go type according to the nullable mode of the transformer.
+// Pointers to basic types keep their Go type in the generated code, either
+// because optional scalars are pointers or by using the wktpointer option with
+// wrapper types.
+func (t *Transformer) transformNullable(pkg *Package, msg *Message, f *Field, typ scanner.Type, goType string) Type {
+	protoType := t.findMapping(goType)
+	if protoType == nil {
+		report.Warn("type %q is not defined in the mappings, ignoring", goType)
+		return nil
+	}
+
+	isSQL := scanner.IsSQLNull(typ)
+	if isSQL {
+		// The Null* types cannot be cast to the Go type gogo protobuf
+		// generates for the protobuf type, nor do they implement the
+		// methods it requires to use them as custom types.
+		t.unsupportedByGogo("field %q of message %q cannot be a %s in the code generated by gogo protobuf", f.Name, msg.Name, typ)
+	}
+
+	switch {
+	case typ.IsRepeated() || !protoType.Basic:
+		// Repeated fields cannot be optional nor wrapped, and messages can
+		// already be unset.
+	case t.nullableMode == NullableAsWrapper:
+		wrapper, ok := WrapperMappings[protoType.Name]
+		if !ok {
+			break
+		}
+
+		pkg.Import(wrapper)
+		if !isSQL {
+			// The wktpointer option makes gogo protobuf declare the field
+			// as a pointer to the Go type of the wrapper, which cannot be
+			// cast to a pointer to any other type.
+			if wrapperGoTypes[protoType.Name] != goType {
+				t.unsupportedByGogo("field %q of message %q cannot be a *%s in the code generated by gogo protobuf, which declares it as a *%s", f.Name, msg.Name, goType, wrapperGoTypes[protoType.Name])
+			}
+
+			if f.Options == nil {
+				f.Options = make(Options)
+			}
+			f.Options["(gogoproto.wktpointer)"] = NewLiteralValue("true")
+		}
+
+		n := wrapper.Type()
+		n.SetSource(typ)
+		return n
+	case t.nullableMode == NullableAsOptional:
+		f.Optional = true
+	}
+
+	if !isSQL {
+		return t.transformType(pkg, typ, msg, f)
+	}
+
+	pkg.Import(protoType)
+	result := protoType.Type()
+	result.SetSource(typ)
+	return result
+}
@@ -0,0 +1,798 @@
+package protobuf
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/proteus.v1/report"
+)
+
+// maxReservedRange is the greatest number of numbers of a reserved range
+// that are read, as they are kept one by one.
+const maxReservedRange = 1000
+
+// ReadProto reads a proto3 file into a Package, which has no path. The
+// comments right before the declarations are read as their docs.
+//
+// Besides the proto files generated by proteus, hand-written ones can be
+// read, with some limitations: only one service is expected, streaming RPCs
+// are read as unary ones, options of services and oneofs, extensions and
+// reserved ranges of more than 1000 numbers are ignored with a warning, and
+// proto2 files are not supported.
+func ReadProto(r io.Reader) (*Package, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := tokenize(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	pkg, err := p.file()
+	if err != nil {
+		return nil, err
+	}
+
+	p.resolve(pkg)
+	return pkg, nil
+}
+
+// parser reads a Package from the tokens of a proto file.
+type parser struct {
+	tokens []token
+	pos    int
+	// named are the named types read, whose package is set once the whole
+	// file is read.
+	named []*Named
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the given keyword or symbol.
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokenIdent || t.kind == tokenSymbol) && t.text == text
+}
+
+// accept skips the next token if it is the given keyword or symbol and
+// reports whether it was.
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if t := p.next(); (t.kind != tokenIdent && t.kind != tokenSymbol) || t.text != text {
+		return unexpected(t, fmt.Sprintf("%q", text))
+	}
+	return nil
+}
+
+func unexpected(t token, expected string) error {
+	return errorf(t, "expected %s, found %s", expected, t)
+}
+
+func errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("%d:%d: %s", t.line, t.col, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return "", unexpected(t, "identifier")
+	}
+	return t.text, nil
+}
+
+// fullIdent reads a name made of identifiers separated by dots, which may
+// start with a dot if it is fully qualified.
+func (p *parser) fullIdent() (string, error) {
+	var name string
+	if p.accept(".") {
+		name = "."
+	}
+
+	for {
+		ident, err := p.ident()
+		if err != nil {
+			return "", err
+		}
+
+		name += ident
+		if !p.accept(".") {
+			return name, nil
+		}
+		name += "."
+	}
+}
+
+func (p *parser) int() (int64, error) {
+	neg := p.accept("-")
+	t := p.next()
+	if t.kind != tokenInt {
+		return 0, unexpected(t, "integer")
+	}
+
+	text := t.text
+	if neg {
+		text = "-" + text
+	}
+
+	n, err := strconv.ParseInt(text, 0, 64)
+	if err != nil {
+		return 0, errorf(t, "invalid integer %s", text)
+	}
+	return n, nil
+}
+
+func (p *parser) string() (string, error) {
+	t := p.next()
+	if t.kind != tokenString {
+		return "", unexpected(t, "string")
+	}
+	return t.text, nil
+}
+
+func (p *parser) file() (*Package, error) {
+	if t := p.peek(); !p.accept("syntax") {
+		return nil, errorf(t, "only proto3 files are supported and the syntax is not given")
+	}
+
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	syntax, err := p.string()
+	if err != nil {
+		return nil, err
+	}
+
+	if syntax != "proto3" {
+		return nil, errorf(t, "only proto3 files are supported, found syntax %q", syntax)
+	}
+
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+
+	pkg := new(Package)
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokenEOF:
+			return pkg, nil
+		case t.text == ";":
+		case t.text == "package":
+			if pkg.Name, err = p.fullIdent(); err != nil {
+				return nil, err
+			}
+			err = p.expect(";")
+		case t.text == "import":
+			if !p.accept("weak") {
+				p.accept("public")
+			}
+
+			var path string
+			if path, err = p.string(); err != nil {
+				return nil, err
+			}
+			pkg.Imports = append(pkg.Imports, path)
+			err = p.expect(";")
+		case t.text == "option":
+			err = p.option(&pkg.Options)
+		case t.text == "message":
+			var msg *Message
+			if msg, err = p.message(t.docs); err == nil {
+				pkg.Messages = append(pkg.Messages, msg)
+			}
+		case t.text == "enum":
+			var enum *Enum
+			if enum, err = p.enum(t.docs); err == nil {
+				pkg.Enums = append(pkg.Enums, enum)
+			}
+		case t.text == "service":
+			err = p.service(pkg)
+		case t.text == "extend":
+			report.Warn("extensions are not supported, ignoring the ones declared at %d:%d", t.line, t.col)
+			err = p.skipBlock()
+		default:
+			return nil, unexpected(t, "declaration")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// skipBlock skips everything until the end of the next block.
+func (p *parser) skipBlock() error {
+	for !p.accept("{") {
+		if t := p.next(); t.kind == tokenEOF {
+			return unexpected(t, `"{"`)
+		}
+	}
+
+	for depth := 1; depth > 0; {
+		t := p.next()
+		switch {
+		case t.kind == tokenEOF:
+			return unexpected(t, `"}"`)
+		case t.kind == tokenSymbol && t.text == "{":
+			depth++
+		case t.kind == tokenSymbol && t.text == "}":
+			depth--
+		}
+	}
+	return nil
+}
+
+// skipStatement skips everything until the end of the statement.
+func (p *parser) skipStatement() error {
+	for !p.accept(";") {
+		if t := p.next(); t.kind == tokenEOF {
+			return unexpected(t, `";"`)
+		}
+	}
+	return nil
+}
+
+// option reads an option statement, after the option keyword, into the given
+// options.
+func (p *parser) option(options *Options) error {
+	name, value, err := p.optionValue()
+	if err != nil {
+		return err
+	}
+
+	setOption(options, name, value)
+	return p.expect(";")
+}
+
+func setOption(options *Options, name string, value OptionValue) {
+	if *options == nil {
+		*options = make(Options)
+	}
+	(*options)[name] = value
+}
+
+// optionValue reads the name and the value of an option.
+func (p *parser) optionValue() (string, OptionValue, error) {
+	var name string
+	if p.accept("(") {
+		ident, err := p.fullIdent()
+		if err != nil {
+			return "", nil, err
+		}
+
+		if err := p.expect(")"); err != nil {
+			return "", nil, err
+		}
+		name = "(" + ident + ")"
+	} else {
+		ident, err := p.ident()
+		if err != nil {
+			return "", nil, err
+		}
+		name = ident
+	}
+
+	for p.accept(".") {
+		ident, err := p.ident()
+		if err != nil {
+			return "", nil, err
+		}
+		name += "." + ident
+	}
+
+	if err := p.expect("="); err != nil {
+		return "", nil, err
+	}
+
+	value, err := p.constant()
+	return name, value, err
+}
+
+// constant reads the value of an option. Strings are read as StringValue
+// and the rest as LiteralValue, including aggregate values of message
+// options, whose tokens are joined with spaces.
+func (p *parser) constant() (OptionValue, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString:
+		val := t.text
+		for p.peek().kind == tokenString {
+			val += p.next().text
+		}
+		return NewStringValue(val), nil
+	case t.kind == tokenInt || t.kind == tokenFloat:
+		return NewLiteralValue(t.text), nil
+	case t.kind == tokenIdent:
+		name := t.text
+		for p.accept(".") {
+			ident, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			name += "." + ident
+		}
+		return NewLiteralValue(name), nil
+	case t.text == "-" || t.text == "+":
+		n := p.next()
+		if n.kind != tokenInt && n.kind != tokenFloat && n.kind != tokenIdent {
+			return nil, unexpected(n, "number")
+		}
+		return NewLiteralValue(t.text + n.text), nil
+	case t.text == "{":
+		var parts = []string{"{"}
+		for depth := 1; depth > 0; {
+			t := p.next()
+			switch {
+			case t.kind == tokenEOF:
+				return nil, unexpected(t, `"}"`)
+			case t.kind == tokenString:
+				parts = append(parts, strconv.Quote(t.text))
+				continue
+			case t.text == "{":
+				depth++
+			case t.text == "}":
+				depth--
+			}
+			parts = append(parts, t.text)
+		}
+		return NewLiteralValue(strings.Join(parts, " ")), nil
+	}
+
+	return nil, unexpected(t, "constant")
+}
+
+// fieldOptions reads the options of a field or enum value, if any.
+func (p *parser) fieldOptions(options *Options) error {
+	if !p.accept("[") {
+		return nil
+	}
+
+	for {
+		name, value, err := p.optionValue()
+		if err != nil {
+			return err
+		}
+		setOption(options, name, value)
+
+		if p.accept("]") {
+			return nil
+		}
+
+		if err := p.expect(","); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) message(docs []string) (*Message, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{Docs: docs, Name: name}
+	for !p.accept("}") {
+		t := p.peek()
+		switch {
+		case t.kind == tokenEOF:
+			return nil, unexpected(t, `"}"`)
+		case p.accept(";"):
+		case p.accept("message"):
+			var nested *Message
+			if nested, err = p.message(t.docs); err == nil {
+				msg.AddMessage(nested)
+			}
+		case p.accept("enum"):
+			var enum *Enum
+			if enum, err = p.enum(t.docs); err == nil {
+				msg.Enums = append(msg.Enums, enum)
+			}
+		case p.accept("option"):
+			err = p.option(&msg.Options)
+		case p.accept("oneof"):
+			err = p.oneof(msg, t.docs)
+		case p.accept("reserved"):
+			err = p.reserved(name, func(n int64) {
+				msg.Reserve(uint(n))
+			}, msg.ReserveName)
+		case p.accept("extensions"):
+			report.Warn("extension ranges are not supported, ignoring the ones of message %s", name)
+			err = p.skipStatement()
+		case p.accept("extend"):
+			report.Warn("extensions are not supported, ignoring the ones declared in message %s", name)
+			err = p.skipBlock()
+		default:
+			var f *Field
+			if f, err = p.field(t.docs); err == nil {
+				msg.Fields = append(msg.Fields, f)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+func (p *parser) field(docs []string) (*Field, error) {
+	f := &Field{Docs: docs}
+	if t := p.peek(); p.accept("repeated") {
+		f.Repeated = true
+	} else if p.accept("optional") {
+		f.Optional = true
+	} else if p.is("required") || p.is("group") {
+		return nil, errorf(t, "%s fields are not supported in proto3", t.text)
+	}
+
+	var err error
+	if f.Type, err = p.fieldType(); err != nil {
+		return nil, err
+	}
+
+	if f.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	pos, err := p.int()
+	if err != nil {
+		return nil, err
+	}
+	f.Pos = int(pos)
+
+	if err := p.fieldOptions(&f.Options); err != nil {
+		return nil, err
+	}
+
+	return f, p.expect(";")
+}
+
+// fieldType reads the type of a field. Named types are kept to set their
+// package once the whole file is read.
+func (p *parser) fieldType() (Type, error) {
+	if p.is("map") && p.tokens[p.pos+1].text == "<" {
+		p.next()
+		p.next()
+		key, err := p.fieldType()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+
+		value, err := p.fieldType()
+		if err != nil {
+			return nil, err
+		}
+		return NewMap(key, value), p.expect(">")
+	}
+
+	name, err := p.fullIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := basicTypes[name]; ok {
+		return NewBasic(name), nil
+	}
+
+	named := NewNamed("", name)
+	p.named = append(p.named, named)
+	return named, nil
+}
+
+func (p *parser) oneof(msg *Message, docs []string) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	oneof := &Oneof{Docs: docs, Name: name}
+	for !p.accept("}") {
+		t := p.peek()
+		switch {
+		case t.kind == tokenEOF:
+			return unexpected(t, `"}"`)
+		case p.accept(";"):
+		case p.accept("option"):
+			report.Warn("options of oneofs are not supported, ignoring the ones of oneof %s", name)
+			err = p.skipStatement()
+		default:
+			var f *Field
+			if f, err = p.field(t.docs); err == nil {
+				oneof.Fields = append(oneof.Fields, f)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	msg.Oneofs = append(msg.Oneofs, oneof)
+	return nil
+}
+
+// reserved reads a reserved statement, after the reserved keyword, of the
+// declaration with the given name, and calls the given functions with every
+// reserved number and name.
+func (p *parser) reserved(decl string, number func(int64), name func(string)) error {
+	for {
+		if p.peek().kind == tokenString {
+			n, err := p.string()
+			if err != nil {
+				return err
+			}
+			name(n)
+		} else if err := p.reservedRange(decl, number); err != nil {
+			return err
+		}
+
+		if p.accept(";") {
+			return nil
+		}
+
+		if err := p.expect(","); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) reservedRange(decl string, number func(int64)) error {
+	start, err := p.int()
+	if err != nil {
+		return err
+	}
+
+	end := start
+	if p.accept("to") {
+		if p.accept("max") {
+			end = -1
+		} else if end, err = p.int(); err != nil {
+			return err
+		}
+	}
+
+	if end < start || end-start >= maxReservedRange {
+		report.Warn("reserved range from %d of %s is too large, only %d is reserved", start, decl, start)
+		end = start
+	}
+
+	for n := start; n <= end; n++ {
+		number(n)
+	}
+	return nil
+}
+
+func (p *parser) enum(docs []string) (*Enum, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	enum := &Enum{Docs: docs, Name: name}
+	for !p.accept("}") {
+		t := p.peek()
+		switch {
+		case t.kind == tokenEOF:
+			return nil, unexpected(t, `"}"`)
+		case p.accept(";"):
+		case p.accept("option"):
+			err = p.option(&enum.Options)
+		case p.accept("reserved"):
+			err = p.reserved(name, func(n int64) {
+				enum.Reserve(int32(n))
+			}, enum.ReserveName)
+		default:
+			var v *EnumValue
+			if v, err = p.enumValue(t.docs); err == nil {
+				enum.Values = append(enum.Values, v)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return enum, nil
+}
+
+func (p *parser) enumValue(docs []string) (*EnumValue, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	n, err := p.int()
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(int32(n)) != n {
+		return nil, errorf(t, "value %d of %s is out of range", n, name)
+	}
+
+	v := &EnumValue{Docs: docs, Name: name, Value: int32(n)}
+	if err := p.fieldOptions(&v.Options); err != nil {
+		return nil, err
+	}
+
+	return v, p.expect(";")
+}
+
+// service reads a service, after the service keyword, whose RPCs are added
+// to the given package.
+func (p *parser) service(pkg *Package) error {
+	t := p.peek()
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+
+	if len(pkg.RPCs) > 0 {
+		report.Warn("only one service per package is supported, the RPCs of service %s at %d:%d are merged with the previous ones", name, t.line, t.col)
+	}
+
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	for !p.accept("}") {
+		t := p.peek()
+		switch {
+		case t.kind == tokenEOF:
+			return unexpected(t, `"}"`)
+		case p.accept(";"):
+		case p.accept("option"):
+			report.Warn("options of services are not supported, ignoring the ones of service %s", name)
+			err = p.skipStatement()
+		case p.accept("rpc"):
+			var rpc *RPC
+			if rpc, err = p.rpc(t.docs); err == nil {
+				pkg.RPCs = append(pkg.RPCs, rpc)
+			}
+		default:
+			return unexpected(t, "rpc")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) rpc(docs []string) (*RPC, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	rpc := &RPC{Docs: docs, Name: name}
+	if rpc.Input, err = p.rpcType(name); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("returns"); err != nil {
+		return nil, err
+	}
+
+	if rpc.Output, err = p.rpcType(name); err != nil {
+		return nil, err
+	}
+
+	if !p.accept("{") {
+		return rpc, p.expect(";")
+	}
+
+	for !p.accept("}") {
+		if err := p.expect("option"); err != nil {
+			return nil, err
+		}
+
+		if err := p.option(&rpc.Options); err != nil {
+			return nil, err
+		}
+	}
+
+	return rpc, nil
+}
+
+// rpcType reads the request or response type of the RPC with the given name.
+func (p *parser) rpcType(rpc string) (Type, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	if p.accept("stream") {
+		report.Warn("streaming is not supported, RPC %s is read as a unary RPC", rpc)
+	}
+
+	name, err := p.fullIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	named := NewNamed("", name)
+	p.named = append(p.named, named)
+	return named, p.expect(")")
+}
+
+// resolve sets the package of the named types read. Types qualified with
+// the package of the file followed by one of its messages or enums, or just
+// with one of them, are types of the file, and the package of the rest is
+// everything before the last dot of their name.
+func (p *parser) resolve(pkg *Package) {
+	declared := make(map[string]bool)
+	for _, m := range pkg.Messages {
+		declared[m.Name] = true
+	}
+	for _, e := range pkg.Enums {
+		declared[e.Name] = true
+	}
+
+	for _, n := range p.named {
+		name := strings.TrimPrefix(n.Name, ".")
+		rest := strings.TrimPrefix(name, pkg.Name+".")
+		switch {
+		case pkg.Name != "" && rest != name && declared[strings.Split(rest, ".")[0]]:
+			n.Package, n.Name = pkg.Name, rest
+		case declared[strings.Split(name, ".")[0]] || !strings.Contains(name, "."):
+			n.Name = name
+		default:
+			i := strings.LastIndex(name, ".")
+			n.Package, n.Name = name[:i], name[i+1:]
+		}
+	}
+}
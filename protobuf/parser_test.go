@@ -0,0 +1,208 @@
+package protobuf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadProtoRendered(t *testing.T) {
+	require := require.New(t)
+
+	pkg := &Package{
+		Name:     "foo.bar",
+		Imports:  []string{"google/protobuf/timestamp.proto"},
+		Messages: []*Message{mockMsg},
+		Enums:    []*Enum{mockEnum},
+		Options:  Options{"foo": NewLiteralValue("true")},
+		RPCs:     mockRpcs,
+	}
+
+	read, err := ReadProto(strings.NewReader(expectedProto))
+	require.NoError(err)
+	require.Equal(pkg, read)
+	require.Equal(expectedProto, string(render(read)))
+}
+
+func TestReadProtoRenderedNested(t *testing.T) {
+	require := require.New(t)
+
+	src := `syntax = "proto3";
+package foo;
+
+` + expectedMsgWithOneof + "\n" + expectedMsgWithNested + "\n"
+
+	pkg, err := ReadProto(strings.NewReader(src))
+	require.NoError(err)
+	require.Equal(src, string(render(pkg)))
+
+	meta := pkg.Messages[1].Messages[0]
+	require.Equal("User.Meta.Source", meta.Messages[0].FullName())
+	require.Equal(NewNamed("", "Kind"), meta.Fields[1].Type)
+	require.Equal(NewNamed("foo.bar", "Circle"), pkg.Messages[0].Oneofs[0].Fields[0].Type)
+}
+
+const handWrittenProto = `// Package comment, which is not the docs of anything.
+
+syntax = "proto3";
+
+package foo.bar;
+
+import "google/protobuf/timestamp.proto";
+import public "other.proto";
+
+option go_package = "github.com/foo/bar" "/baz";
+option (my.opt).nested = -1.5;
+option (agg) = { name: "x" inner { a: 1 } };
+
+/*
+ * Pony is a pony.
+ */
+message Pony {
+	reserved 2, 5 to 7, 9 to max;
+	reserved "color";
+	extensions 100 to 199;
+
+	// Name is the name.
+	string name = 1 [deprecated = true, (my.field) = 'quoted\tstring']; // Trailing comment.
+
+	map<string, .foo.bar.Pony.Meta> metas = 3;
+	repeated bar.Pony.Meta list = 4;
+	optional google.protobuf.Timestamp born_at = 8;
+	Race race = 10;
+	other.Thing thing = 0x0B;
+
+	message Meta {
+		int32 id = 1;
+	}
+
+	oneof kind {
+		option (my.oneof) = true;
+		string unicorn = 12;
+	}
+}
+
+enum Race {
+	option allow_alias = true;
+	reserved -2 to -1;
+	UNKNOWN = 0;
+	// Earth ponies are strong.
+	EARTH = 1 [deprecated = true];
+	NEGATIVE = -3;
+}
+
+service PonyService {
+	option deprecated = true;
+
+	// GetPony returns a pony.
+	rpc GetPony (Pony) returns (stream .foo.bar.Pony);
+	rpc Other (other.Thing) returns (Pony) {
+		option idempotency_level = NO_SIDE_EFFECTS;
+	}
+}
+`
+
+func TestReadProto(t *testing.T) {
+	require := require.New(t)
+
+	pkg, err := ReadProto(strings.NewReader(handWrittenProto))
+	require.NoError(err)
+
+	require.Equal("foo.bar", pkg.Name)
+	require.Equal([]string{"google/protobuf/timestamp.proto", "other.proto"}, pkg.Imports)
+	require.Equal(Options{
+		"go_package":      NewStringValue("github.com/foo/bar/baz"),
+		"(my.opt).nested": NewLiteralValue("-1.5"),
+		"(agg)":           NewLiteralValue(`{ name : "x" inner { a : 1 } }`),
+	}, pkg.Options)
+
+	require.Len(pkg.Messages, 1)
+	msg := pkg.Messages[0]
+	require.Equal("Pony", msg.Name)
+	require.Equal([]string{"Pony is a pony."}, msg.Docs)
+	require.Equal([]uint{2, 5, 6, 7, 9}, msg.Reserved)
+	require.Equal([]string{"color"}, msg.ReservedNames)
+
+	require.Equal([]*Field{
+		{
+			Docs: []string{"Name is the name."},
+			Name: "name",
+			Type: NewBasic("string"),
+			Pos:  1,
+			Options: Options{
+				"deprecated": NewLiteralValue("true"),
+				"(my.field)": NewStringValue("quoted\tstring"),
+			},
+		},
+		{
+			Name: "metas",
+			Type: NewMap(NewBasic("string"), NewNamed("foo.bar", "Pony.Meta")),
+			Pos:  3,
+		},
+		{Name: "list", Type: NewNamed("bar.Pony", "Meta"), Pos: 4, Repeated: true},
+		{Name: "born_at", Type: NewNamed("google.protobuf", "Timestamp"), Pos: 8, Optional: true},
+		{Name: "race", Type: NewNamed("", "Race"), Pos: 10},
+		{Name: "thing", Type: NewNamed("other", "Thing"), Pos: 11},
+	}, msg.Fields)
+
+	require.Len(msg.Messages, 1)
+	require.Equal("Pony.Meta", msg.Messages[0].FullName())
+	require.Len(msg.Oneofs, 1)
+	require.Equal("kind", msg.Oneofs[0].Name)
+	require.Len(msg.Oneofs[0].Fields, 1)
+
+	require.Len(pkg.Enums, 1)
+	enum := pkg.Enums[0]
+	require.Equal([]int32{-2, -1}, enum.Reserved)
+	require.Equal(Options{"allow_alias": NewLiteralValue("true")}, enum.Options)
+	require.Equal([]*EnumValue{
+		{Name: "UNKNOWN"},
+		{
+			Docs:    []string{"Earth ponies are strong."},
+			Name:    "EARTH",
+			Value:   1,
+			Options: Options{"deprecated": NewLiteralValue("true")},
+		},
+		{Name: "NEGATIVE", Value: -3},
+	}, enum.Values)
+
+	require.Equal([]*RPC{
+		{
+			Docs:   []string{"GetPony returns a pony."},
+			Name:   "GetPony",
+			Input:  NewNamed("", "Pony"),
+			Output: NewNamed("foo.bar", "Pony"),
+		},
+		{
+			Name:    "Other",
+			Input:   NewNamed("other", "Thing"),
+			Output:  NewNamed("", "Pony"),
+			Options: Options{"idempotency_level": NewLiteralValue("NO_SIDE_EFFECTS")},
+		},
+	}, pkg.RPCs)
+}
+
+func TestReadProtoErrors(t *testing.T) {
+	cases := []struct {
+		src string
+		err string
+	}{
+		{`package foo;`, "1:1: only proto3 files are supported and the syntax is not given"},
+		{`syntax = "proto2";`, `1:10: only proto3 files are supported, found syntax "proto2"`},
+		{"syntax = \"proto3\";\nmessage Foo {\n\trequired string a = 1;\n}", "3:2: required fields are not supported in proto3"},
+		{"syntax = \"proto3\";\nmessage Foo {\n\tstring a = 1\n}", `4:1: expected ";", found "}"`},
+		{"syntax = \"proto3\";\nmessage Foo {", `2:14: expected "}", found end of file`},
+		{"syntax = \"proto3\";\nenum Foo { A = 3000000000; }", "2:16: value 3000000000 of A is out of range"},
+		{"syntax = \"proto3\";\nimport foo;", "2:8: expected string, found \"foo\""},
+		{"syntax = \"proto3\";\n/* foo", "2:1: comment is not terminated"},
+		{"syntax = \"proto3\";\noption foo = \"bar;", "2:14: string is not terminated"},
+		{"syntax = \"proto3\";\noption foo = \"\\q\";", `2:15: unknown escape sequence \q`},
+		{"syntax = \"proto3\";\nfoo", `2:1: expected declaration, found "foo"`},
+	}
+
+	for _, c := range cases {
+		_, err := ReadProto(strings.NewReader(c.src))
+		require.EqualError(t, err, c.err, c.src)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 
+	"google.golang.org/protobuf/types/descriptorpb"
 	"gopkg.in/src-d/proteus.v1/scanner"
 )
 
@@ -18,6 +19,13 @@ type Package struct {
 	Messages []*Message
 	Enums    []*Enum
 	RPCs     []*RPC
+	// Lock contains the numbers assigned to fields and enum values of the
+	// package. It is nil if numbers are assigned by position.
+	Lock *Lock
+	// ImportedFiles are the descriptors of the imported files that are
+	// neither well-known nor generated by proteus, indexed by their path,
+	// such as the files of the Go types generated by protoc.
+	ImportedFiles map[string]*descriptorpb.FileDescriptorProto
 }
 
 // Import tries to import the given protobuf type to the current package.
@@ -26,6 +34,13 @@ func (p *Package) Import(typ *ProtoType) {
 	if typ.Import != "" && !p.isImported(typ.Import) {
 		p.Imports = append(p.Imports, typ.Import)
 	}
+
+	if typ.File != nil {
+		if p.ImportedFiles == nil {
+			p.ImportedFiles = make(map[string]*descriptorpb.FileDescriptorProto)
+		}
+		p.ImportedFiles[typ.File.GetName()] = typ.File
+	}
 }
 
 // ImportFromPath adds a new import from a Go path.
@@ -54,11 +69,45 @@ func (p *Package) ServiceName() string {
 
 // Message is the representation of a Protobuf message.
 type Message struct {
-	Docs     []string
-	Name     string
-	Reserved []uint
-	Options  Options
-	Fields   []*Field
+	Docs          []string
+	Name          string
+	Reserved      []uint
+	ReservedNames []string
+	Options       Options
+	Fields        []*Field
+	Oneofs        []*Oneof
+	// Messages are the messages nested in the message.
+	Messages []*Message
+	// Enums are the enums nested in the message.
+	Enums []*Enum
+	// Generic is the Go type, e.g. Page[User], of the instantiated generic
+	// struct the message is declared for, if any.
+	Generic string
+
+	// parent is the message the message is nested in, if any.
+	parent *Message
+}
+
+// AddMessage nests the given message in the message.
+func (m *Message) AddMessage(nested *Message) {
+	nested.parent = m
+	m.Messages = append(m.Messages, nested)
+}
+
+// FullName returns the name of the message qualified with the names of the
+// messages it is nested in, e.g. Foo.Bar.
+func (m *Message) FullName() string {
+	if m.parent == nil {
+		return m.Name
+	}
+	return m.parent.FullName() + "." + m.Name
+}
+
+// GoName returns the name of the Go type of the message, which is the name of
+// the message joined with the names of the messages it is nested in by an
+// underscore, e.g. Foo_Bar.
+func (m *Message) GoName() string {
+	return strings.Replace(m.FullName(), ".", "_", -1)
 }
 
 // Reserve reserves a position in the message.
@@ -77,16 +126,40 @@ func (m *Message) isReserved(pos uint) bool {
 	return false
 }
 
+// ReserveName reserves a field name in the message.
+func (m *Message) ReserveName(name string) {
+	if !containsString(m.ReservedNames, name) {
+		m.ReservedNames = append(m.ReservedNames, name)
+	}
+}
+
 // Field is the representation of a protobuf message field.
 type Field struct {
-	Docs     []string
-	Name     string
+	Docs []string
+	Name string
+	// GoName is the name of the Go struct field the field is declared for,
+	// if any.
+	GoName   string
 	Pos      int
 	Repeated bool
+	// Optional reports whether the field is a proto3 optional field.
+	Optional bool
 	Type     Type
 	Options  Options
 }
 
+// Oneof is the representation of a protobuf oneof, a set of fields of a
+// message of which only one can be set at the same time.
+type Oneof struct {
+	Docs []string
+	Name string
+	// GoName is the name of the Go struct field the oneof is declared for.
+	GoName string
+	Fields []*Field
+	// Src is the scanner type of the interface the oneof is generated from.
+	Src scanner.Type
+}
+
 // Options are the set of options given to a field, message or enum value.
 type Options map[string]OptionValue
 
@@ -170,7 +243,9 @@ type Named struct {
 	Src       scanner.Type
 }
 
-// NewNamed creates a new Named type given its package and name.
+// NewNamed creates a new Named type given its package and name. If the
+// package is empty, the type is referred to just by its name, as it is done
+// with nested messages.
 func NewNamed(pkg, name string) *Named {
 	return &Named{pkg, name, false, nil}
 }
@@ -182,6 +257,9 @@ func NewGeneratedNamed(pkg, name string) *Named {
 }
 
 func (n Named) String() string {
+	if n.Package == "" {
+		return n.Name
+	}
 	return fmt.Sprintf("%s.%s", n.Package, n.Name)
 }
 
@@ -309,17 +387,45 @@ func (*Alias) isType() {}
 
 // Enum is the representation of a protobuf enumeration.
 type Enum struct {
-	Docs    []string
-	Name    string
-	Options Options
-	Values  []*EnumValue
+	Docs          []string
+	Name          string
+	Reserved      []int32
+	ReservedNames []string
+	Options       Options
+	Values        []*EnumValue
+}
+
+// Reserve reserves a value in the enum.
+func (e *Enum) Reserve(val int32) {
+	if !e.isReserved(val) {
+		e.Reserved = append(e.Reserved, val)
+	}
+}
+
+func (e *Enum) isReserved(val int32) bool {
+	for _, r := range e.Reserved {
+		if r == val {
+			return true
+		}
+	}
+	return false
+}
+
+// ReserveName reserves a value name in the enum.
+func (e *Enum) ReserveName(name string) {
+	if !containsString(e.ReservedNames, name) {
+		e.ReservedNames = append(e.ReservedNames, name)
+	}
 }
 
 // EnumValue is a single value in an enumeration.
 type EnumValue struct {
-	Docs    []string
-	Name    string
-	Value   uint
+	Docs []string
+	Name string
+	// GoName is the name of the Go constant the value is declared for, if
+	// any.
+	GoName  string
+	Value   int32
 	Options Options
 }
 
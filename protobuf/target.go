@@ -0,0 +1,120 @@
+package protobuf
+
+import (
+	"strings"
+)
+
+// Target is the protobuf code generator the proto files are generated for.
+type Target int
+
+const (
+	// TargetGogo generates proto files for the gogo protobuf generators,
+	// whose generated code uses the Go types of the scanned packages thanks
+	// to the gogoproto options. This is the default.
+	TargetGogo Target = iota
+	// TargetGolang generates plain proto3 files, without gogoproto options,
+	// for the official Go protobuf generator, protoc-gen-go. As it generates
+	// its own Go types, they are generated in a separate Go package, see
+	// GolangPackage.
+	TargetGolang
+)
+
+// gogoImport is the proto file declaring the gogoproto options.
+const gogoImport = "github.com/gogo/protobuf/gogoproto/gogo.proto"
+
+// SetTarget sets the protobuf code generator the packages are transformed
+// for.
+func (t *Transformer) SetTarget(target Target) {
+	t.target = target
+}
+
+// SetMarshalers sets whether the code marshaling the Go types of the
+// transformed packages is generated by proteus, with the marshal package,
+// instead of by the gogo protobuf generators. Go types the code generated by
+// gogo protobuf does not support are only an error for the gogo target if it
+// is not.
+func (t *Transformer) SetMarshalers(marshalers bool) {
+	t.marshalers = marshalers
+}
+
+// unsupportedByGogo records an error of the transformation if the code of
+// the package is generated by the gogo protobuf generators, as the code they
+// would generate for the given field does not compile.
+func (t *Transformer) unsupportedByGogo(format string, args ...interface{}) {
+	if t.target == TargetGogo && !t.marshalers {
+		t.fail(format+", use the golang target or generate the marshal methods with proteus instead", args...)
+	}
+}
+
+// GolangPackage returns the import path and the name of the Go package in
+// which protoc-gen-go generates the code of the proto file of the Go package
+// with the given import path and name. It is a package inside of it named
+// after it with a pb suffix, e.g. example.com/users/userspb.
+func GolangPackage(path, name string) (importPath, pkgName string) {
+	pkgName = name + "pb"
+	return path + "/" + pkgName, pkgName
+}
+
+// removeGogo removes the gogoproto options from the given package and all of
+// its messages, fields, enums and RPCs, along with the import of the proto
+// file declaring them.
+func removeGogo(pkg *Package) {
+	var imports []string
+	for _, i := range pkg.Imports {
+		if i != gogoImport {
+			imports = append(imports, i)
+		}
+	}
+	pkg.Imports = imports
+
+	removeGogoOptions(pkg.Options)
+	for _, m := range pkg.Messages {
+		removeGogoFromMessage(m)
+	}
+
+	for _, e := range pkg.Enums {
+		removeGogoFromEnum(e)
+	}
+
+	for _, rpc := range pkg.RPCs {
+		removeGogoOptions(rpc.Options)
+	}
+}
+
+func removeGogoFromMessage(m *Message) {
+	removeGogoOptions(m.Options)
+	for _, f := range m.Fields {
+		if f != nil {
+			removeGogoOptions(f.Options)
+		}
+	}
+
+	for _, o := range m.Oneofs {
+		for _, f := range o.Fields {
+			removeGogoOptions(f.Options)
+		}
+	}
+
+	for _, nested := range m.Messages {
+		removeGogoFromMessage(nested)
+	}
+
+	for _, e := range m.Enums {
+		removeGogoFromEnum(e)
+	}
+}
+
+func removeGogoFromEnum(e *Enum) {
+	removeGogoOptions(e.Options)
+	for _, v := range e.Values {
+		removeGogoOptions(v.Options)
+	}
+}
+
+func removeGogoOptions(opts Options) {
+	for name := range opts {
+		if strings.HasPrefix(name, "(gogoproto.") {
+			delete(opts, name)
+		}
+	}
+}
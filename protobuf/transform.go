@@ -1,8 +1,10 @@
 package protobuf
 
 import (
-	"bytes"
 	"fmt"
+	"go/constant"
+	"math"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -22,9 +24,16 @@ import (
 // corresponding type mapping, and then the default mappings to give the user
 // ability to override any kind of type.
 type Transformer struct {
-	mappings  TypeMappings
-	structSet TypeSet
-	enumSet   TypeSet
+	mappings      TypeMappings
+	structSet     TypeSet
+	enumSet       TypeSet
+	stringEnumSet TypeSet
+	interfaces    map[string]*scanner.Interface
+	locks         Locks
+	nullableMode  NullableMode
+	target        Target
+	marshalers    bool
+	errs          []string
 }
 
 // NewTransformer creates a new transformer instance.
@@ -63,14 +72,61 @@ func (t *Transformer) SetEnumSet(ts TypeSet) {
 	t.enumSet = ts
 }
 
-// Transform converts a scanned package to a protobuf package.
-func (t *Transformer) Transform(p *scanner.Package) *Package {
+// SetStringEnumSet sets the passed TypeSet as a known list of enums whose
+// underlying type is string.
+func (t *Transformer) SetStringEnumSet(ts TypeSet) {
+	t.stringEnumSet = ts
+}
+
+// IsStringEnum checks if the given pkg path and name is a known enum whose
+// underlying type is string.
+func (t *Transformer) IsStringEnum(pkg, name string) bool {
+	return t.stringEnumSet.Contains(pkg, name)
+}
+
+// SetInterfaces sets the interfaces whose struct fields are generated as
+// oneofs, indexed by their qualified name, e.g. foo/bar.Baz.
+func (t *Transformer) SetInterfaces(ifaces map[string]*scanner.Interface) {
+	t.interfaces = ifaces
+}
+
+// interfaceOf returns the interface of the given type if its fields are
+// generated as oneofs, or nil otherwise.
+func (t *Transformer) interfaceOf(typ scanner.Type) *scanner.Interface {
+	named, ok := typ.(*scanner.Named)
+	if !ok {
+		return nil
+	}
+	return t.interfaces[named.String()]
+}
+
+// SetLocks sets the locks used to number the fields and enum values of the
+// transformed packages. If no locks are set, fields and values are numbered
+// by their position. Packages without a lock get a new one, which is
+// populated during the transformation.
+func (t *Transformer) SetLocks(l Locks) {
+	t.locks = l
+}
+
+// Transform converts a scanned package to a protobuf package. An error is
+// returned if the package cannot be converted, or if the code generated for
+// it by the protobuf generator of the target would not compile.
+func (t *Transformer) Transform(p *scanner.Package) (*Package, error) {
+	t.errs = nil
 	pkg := &Package{
 		Name:    toProtobufPkg(p.Path),
 		Path:    p.Path,
-		Imports: []string{"github.com/gogo/protobuf/gogoproto/gogo.proto"},
+		Imports: []string{gogoImport},
 		Options: t.defaultOptionsForPackage(p),
 	}
+	pkg.Options = setOptions(pkg, pkg.Options, p.Docs)
+
+	if t.locks != nil {
+		if _, ok := t.locks[p.Path]; !ok {
+			t.locks[p.Path] = NewLock()
+		}
+		pkg.Lock = t.locks[p.Path]
+	}
 
 	for _, s := range p.Structs {
 		msg := t.transformStruct(pkg, s)
@@ -78,7 +134,7 @@ func (t *Transformer) Transform(p *scanner.Package) *Package {
 	}
 
 	for _, e := range p.Enums {
-		enum := t.transformEnum(e)
+		enum := t.transformEnum(pkg, e)
 		pkg.Enums = append(pkg.Enums, enum)
 	}
 
@@ -90,7 +146,23 @@ func (t *Transformer) Transform(p *scanner.Package) *Package {
 		}
 	}
 
-	return pkg
+	if t.target == TargetGolang {
+		removeGogo(pkg)
+		importPath, name := GolangPackage(p.Path, p.Name)
+		pkg.Options["go_package"] = NewStringValue(importPath + ";" + name)
+	}
+
+	if len(t.errs) > 0 {
+		return nil, fmt.Errorf("unable to transform package %s:\n\t%s", p.Path, strings.Join(t.errs, "\n\t"))
+	}
+
+	return pkg, nil
+}
+
+// fail records an error of the transformation of the current package, which
+// is returned by Transform once the whole package is transformed.
+func (t *Transformer) fail(format string, args ...interface{}) {
+	t.errs = append(t.errs, fmt.Sprintf(format, args...))
 }
 
 func (t *Transformer) transformFunc(pkg *Package, f *scanner.Func, names nameSet) *RPC {
@@ -127,6 +199,7 @@ func (t *Transformer) transformFunc(pkg *Package, f *scanner.Func, names nameSet
 		return nil
 	}
 
+	rpc.Options = setOptions(pkg, rpc.Options, f.Docs)
 	return rpc
 }
 
@@ -143,7 +216,7 @@ func (t *Transformer) transformTypeList(pkg *Package, types []scanner.Type, name
 	// - there is more than one element
 	// - there is one element and it is repeated, as this is not supported in protobuf
 	// - there is one element and it is not a message, as protobuf expects messages as input/output
-	if len(types) != 1 || types[0].IsRepeated() || !isNamed(types[0]) {
+	if len(types) != 1 || types[0].IsRepeated() || !isNamed(types[0]) || t.isEnumType(types[0]) {
 		msgName := name + msgNameSuffix
 		if _, ok := names[msgName]; ok {
 			report.Warn("tried to register message %s, but there is already a message with that name. RPC %s will not be generated", msgName, name)
@@ -176,27 +249,177 @@ func capitalize(s string) string {
 	return strings.ToUpper(s[0:1]) + s[1:len(s)]
 }
 
-func (t *Transformer) transformEnum(e *scanner.Enum) *Enum {
+func (t *Transformer) transformEnum(pkg *Package, e *scanner.Enum) *Enum {
 	enum := &Enum{
 		Docs:    e.Doc,
 		Name:    e.Name,
 		Options: t.defaultOptionsForScannedEnum(e),
 	}
+	enum.Options = setOptions(pkg, enum.Options, e.Docs)
 
+	var lock *NumberLock
+	if pkg.Lock != nil {
+		lock = pkg.Lock.Enum(e.Name)
+	}
+
+	var (
+		zero    []*EnumValue
+		values  []*EnumValue
+		seen    = make(map[int32]struct{})
+		strVals = newStringEnumValues(lock)
+	)
 	for i, v := range e.Values {
-		enum.Values = append(enum.Values, &EnumValue{
-			Docs:  v.Doc,
-			Name:  toUpperSnakeCase(v.Name),
-			Value: uint(i),
+		val, ok := enumValue(v, i)
+		if e.IsString {
+			val, ok = strVals.value(v), true
+		}
+
+		if !ok {
+			report.Warn("value %q of enum %q does not fit in an int32, ignoring value", v.Name, e.Name)
+			continue
+		}
+
+		if _, ok := seen[val]; ok {
+			enum.Options["allow_alias"] = NewLiteralValue("true")
+		}
+		seen[val] = struct{}{}
+
+		value := &EnumValue{
+			Docs:   v.Doc,
+			Name:   toUpperSnakeCase(v.Name),
+			GoName: v.Name,
+			Value:  val,
 			Options: Options{
 				"(gogoproto.enumvalue_customname)": NewStringValue(v.Name),
 			},
+		}
+		value.Options = setOptions(pkg, value.Options, v.Docs)
+
+		if val == 0 {
+			zero = append(zero, value)
+		} else {
+			values = append(values, value)
+		}
+	}
+
+	// proto3 requires the first value of an enum to be zero.
+	if len(zero) == 0 {
+		zero = append(zero, &EnumValue{
+			Name: toUpperSnakeCase(e.Name) + "_UNSPECIFIED",
 		})
 	}
+	enum.Values = append(zero, values...)
+
+	if lock != nil {
+		t.lockEnum(lock, enum)
+	}
+
 	return enum
 }
 
+// StringEnumTypeName returns the name of the Go type declared in the
+// generated protobuf code for the enum with the given name whose underlying
+// type is string. As the values of the Go enum are strings, they cannot be
+// used as protobuf enum values, so a different type is needed.
+func StringEnumTypeName(name string) string {
+	return name + "Proto"
+}
+
+// stringEnumValues assigns protobuf values to the values of an enum whose
+// underlying type is string. The empty string is always 0, constants with
+// the same value are aliases and the rest get the numbers in the lock, if
+// any, or are numbered by their position starting at 1.
+type stringEnumValues struct {
+	lock   *NumberLock
+	values map[string]int32
+	next   int32
+}
+
+func newStringEnumValues(lock *NumberLock) *stringEnumValues {
+	return &stringEnumValues{
+		lock:   lock,
+		values: make(map[string]int32),
+		next:   1,
+	}
+}
+
+func (s *stringEnumValues) value(v *scanner.EnumValue) int32 {
+	str := v.Name
+	if v.Value != nil && v.Value.Kind() == constant.String {
+		str = constant.StringVal(v.Value)
+	}
+
+	if str == "" {
+		return 0
+	}
+
+	if n, ok := s.values[str]; ok {
+		return n
+	}
+
+	n := s.next
+	if s.lock != nil {
+		n = int32(s.lock.Number(toUpperSnakeCase(v.Name), 1))
+	} else {
+		s.next++
+	}
+
+	s.values[str] = n
+	return n
+}
+
+// enumValue returns the protobuf value of the given enum value, which is its
+// constant value for integer enums and its position otherwise. It returns
+// false if the value does not fit in an int32.
+func enumValue(v *scanner.EnumValue, pos int) (int32, bool) {
+	if v.Value == nil || v.Value.Kind() != constant.Int {
+		return int32(pos), true
+	}
+
+	n, exact := constant.Int64Val(v.Value)
+	if !exact || n < math.MinInt32 || n > math.MaxInt32 {
+		return 0, false
+	}
+
+	return int32(n), true
+}
+
+// lockEnum records the values of the enum in the lock and reserves the names
+// and values of the enum that are no longer in use.
+func (t *Transformer) lockEnum(lock *NumberLock, enum *Enum) {
+	var (
+		names = make([]string, 0, len(enum.Values))
+		used  = make(map[int]struct{})
+	)
+	for _, v := range enum.Values {
+		if n, ok := lock.Numbers[v.Name]; ok && n != int(v.Value) {
+			report.Warn("value %q of enum %q changed from %d to %d", v.Name, enum.Name, n, v.Value)
+		}
+
+		lock.setValue(v.Name, int(v.Value))
+		names = append(names, v.Name)
+		used[int(v.Value)] = struct{}{}
+	}
+
+	lock.Retain(names)
+	for _, r := range lock.Reserved {
+		if _, ok := used[r]; !ok {
+			enum.Reserve(int32(r))
+		}
+	}
+
+	for _, n := range lock.ReservedNames {
+		enum.ReserveName(n)
+	}
+}
+
 func (t *Transformer) defaultOptionsForScannedEnum(e *scanner.Enum) (opts Options) {
+	if e.IsString {
+		return Options{
+			"(gogoproto.enum_customname)": NewStringValue(StringEnumTypeName(e.Name)),
+		}
+	}
+
 	opts = Options{
 		"(gogoproto.enumdecl)":            NewLiteralValue("false"),
 		"(gogoproto.goproto_enum_prefix)": NewLiteralValue("false"),
@@ -210,25 +433,176 @@ func (t *Transformer) defaultOptionsForScannedEnum(e *scanner.Enum) (opts Option
 }
 
 func (t *Transformer) transformStruct(pkg *Package, s *scanner.Struct) *Message {
+	return t.transformMessage(pkg, s, nil)
+}
+
+// transformMessage converts the given struct to a message, which is nested in
+// the given parent message if it is not nil.
+func (t *Transformer) transformMessage(pkg *Package, s *scanner.Struct, parent *Message) *Message {
 	msg := &Message{
 		Docs:    s.Doc,
 		Name:    s.Name,
 		Options: t.defaultOptionsForScannedMessage(s),
+		Generic: s.Generic,
+	}
+	msg.Options = setOptions(pkg, msg.Options, s.Docs)
+
+	if parent != nil {
+		parent.AddMessage(msg)
+	}
+
+	var lock *NumberLock
+	if pkg.Lock != nil {
+		lock = pkg.Lock.Message(msg.FullName())
+	}
+
+	var explicit = make(map[int]struct{})
+	for _, f := range s.Fields {
+		if f.Pos > 0 && t.interfaceOf(f.Type) != nil {
+			report.Warn("field %q of struct %q is a oneof, ignoring its number", f.Name, s.Name)
+		} else if f.Pos > 0 {
+			explicit[f.Pos] = struct{}{}
+			if lock != nil {
+				name := protoFieldName(f)
+				if n, ok := lock.Numbers[name]; ok && n != f.Pos {
+					report.Warn("field %q of struct %q changed its number from %d to %d", f.Name, s.Name, n, f.Pos)
+				} else if containsInt(lock.Reserved, f.Pos) {
+					report.Warn("field %q of struct %q uses the reserved number %d", f.Name, s.Name, f.Pos)
+				}
+				lock.Set(name, f.Pos)
+			}
+		}
 	}
 
+	var (
+		names = make([]string, 0, len(s.Fields))
+		used  = make(map[int]struct{})
+	)
 	for i, f := range s.Fields {
-		field := t.transformField(pkg, msg, f, i+1)
+		if iface := t.interfaceOf(f.Type); iface != nil {
+			// The code generated by gogo protobuf expects the field to
+			// hold the oneof wrapper types it declares.
+			t.unsupportedByGogo("field %q of struct %q is a oneof, which the code generated by gogo protobuf cannot hold in a Go interface", f.Name, s.Name)
+			oneof := t.transformOneof(pkg, f, iface, func(name string) int {
+				var pos int
+				if lock != nil {
+					pos = lock.Number(name, 1)
+				} else {
+					pos = nextFreePos(i+1, explicit, used)
+				}
+				used[pos] = struct{}{}
+				names = append(names, name)
+				return pos
+			})
+			msg.Oneofs = append(msg.Oneofs, oneof)
+			continue
+		}
+
+		name := protoFieldName(f)
+		pos := f.Pos
+		if pos == 0 {
+			if lock != nil {
+				pos = lock.Number(name, 1)
+			} else {
+				pos = nextFreePos(i+1, explicit, used)
+			}
+		}
+
+		used[pos] = struct{}{}
+		names = append(names, name)
+		field := t.transformField(pkg, msg, f, pos)
 		if field == nil {
-			msg.Reserve(uint(i) + 1)
+			msg.Reserve(uint(pos))
 			report.Warn("field %q of struct %q has an invalid type, ignoring field but reserving its position", f.Name, s.Name)
 		} else {
 			msg.Fields = append(msg.Fields, field)
 		}
 	}
 
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			t.fail("struct %q has several fields named %q, including the fields of its oneofs", s.Name, name)
+		}
+		seen[name] = struct{}{}
+	}
+
+	if lock != nil {
+		lock.Retain(names)
+		for _, r := range lock.Reserved {
+			msg.Reserve(uint(r))
+		}
+		for _, n := range lock.ReservedNames {
+			msg.ReserveName(n)
+		}
+	}
+
 	return msg
 }
 
+// transformOneof converts a struct field whose type is an interface to a
+// oneof with a field for every implementation of the interface, named after
+// the oneof and the implementation, e.g. shape_circle, so several oneofs of
+// the same interface can be in the same message. The number of every field
+// is given by pos.
+func (t *Transformer) transformOneof(pkg *Package, f *scanner.Field, iface *scanner.Interface, pos func(name string) int) *Oneof {
+	oneof := &Oneof{
+		Docs:   f.Doc,
+		Name:   protoFieldName(f),
+		GoName: f.Name,
+		Src:    f.Type,
+	}
+
+	if len(f.Options) > 0 || len(iface.Options) > 0 {
+		report.Warn("oneof %q of field %q cannot have options, ignoring them", oneof.Name, f.Name)
+	}
+
+	// Oneofs cannot have a custom name, so the Go name given to them by the
+	// protobuf generator must be the name of the field.
+	if generator.CamelCase(oneof.Name) != f.Name {
+		report.Warn("oneof %q will not have the same Go name as field %q", oneof.Name, f.Name)
+	}
+
+	for _, impl := range iface.Implementations {
+		name := oneof.Name + "_" + toLowerSnakeCase(impl.Name)
+		pkg.ImportFromPath(impl.Path)
+		typ := NewNamed(toProtobufPkg(impl.Path), impl.Name)
+		typ.SetSource(impl)
+		oneof.Fields = append(oneof.Fields, &Field{
+			Name: name,
+			Pos:  pos(name),
+			Type: typ,
+		})
+	}
+
+	return oneof
+}
+
+// nextFreePos returns the first position, starting at pos, that is not in any
+// of the given sets of positions.
+func nextFreePos(pos int, sets ...map[int]struct{}) int {
+	for {
+		var taken bool
+		for _, set := range sets {
+			if _, ok := set[pos]; ok {
+				taken = true
+				break
+			}
+		}
+
+		if !taken {
+			return pos
+		}
+		pos++
+	}
+}
+
+// protoFieldName returns the name of the protobuf field for the given struct
+// field, which is the name set in its tag or its name in snake case.
+func protoFieldName(f *scanner.Field) string {
+	return f.ProtobufName()
+}
+
 func (t *Transformer) defaultOptionsForScannedMessage(s *scanner.Struct) (opts Options) {
 	opts = Options{
 		"(gogoproto.typedecl)":        NewLiteralValue("false"),
@@ -250,7 +624,8 @@ func (t *Transformer) transformField(pkg *Package, msg *Message, field *scanner.
 
 	f := &Field{
 		Docs:     field.Doc,
-		Name:     toLowerSnakeCase(field.Name),
+		Name:     protoFieldName(field),
+		GoName:   field.Name,
 		Options:  t.defaultOptionsForStructField(field),
 		Pos:      pos,
 		Repeated: repeated,
@@ -262,6 +637,11 @@ func (t *Transformer) transformField(pkg *Package, msg *Message, field *scanner.
 	if isByteSlice(field.Type) {
 		typ = NewBasic("bytes")
 		f.Repeated = false
+	} else if goType := t.nullableBasic(msg, field.Type); goType != "" {
+		typ = t.transformNullable(pkg, msg, f, field.Type, goType)
+		if typ == nil {
+			return nil
+		}
 	} else {
 		typ = t.transformType(pkg, field.Type, msg, f)
 		if typ == nil {
@@ -270,16 +650,54 @@ func (t *Transformer) transformField(pkg *Package, msg *Message, field *scanner.
 	}
 
 	f.Type = typ
+	f.Options = setOptions(pkg, f.Options, field.Docs)
 
 	return f
 }
 
+// setOptions returns the given default options of a declaration with the
+// options set in its documentation, which take precedence, and imports in
+// the package the files imported in it.
+func setOptions(pkg *Package, opts Options, docs scanner.Docs) Options {
+	for _, i := range docs.Imports {
+		pkg.Import(&ProtoType{Import: i})
+	}
+
+	if len(docs.Options) == 0 {
+		return opts
+	}
+
+	if opts == nil {
+		opts = make(Options)
+	}
+
+	for name, value := range docs.Options {
+		opts[name] = newOptionValue(value)
+	}
+	return opts
+}
+
+// newOptionValue returns the option value written as the given one in a
+// proto file, which is a string if it is quoted.
+func newOptionValue(value string) OptionValue {
+	if strings.HasPrefix(value, `"`) {
+		if s, err := strconv.Unquote(value); err == nil {
+			return NewStringValue(s)
+		}
+	}
+	return NewLiteralValue(value)
+}
+
 func (t *Transformer) defaultOptionsForStructField(field *scanner.Field) Options {
 	opts := make(Options)
-	if generator.CamelCase(toLowerSnakeCase(field.Name)) != field.Name {
+	if generator.CamelCase(protoFieldName(field)) != field.Name {
 		opts["(gogoproto.customname)"] = NewStringValue(field.Name)
 	}
 
+	if field.JSONName != "" {
+		opts["json_name"] = NewStringValue(field.JSONName)
+	}
+
 	if t.needsNotNullableOption(field.Type) {
 		opts["(gogoproto.nullable)"] = NewLiteralValue("false")
 	}
@@ -292,7 +710,9 @@ func (t *Transformer) needsNotNullableOption(typ scanner.Type) bool {
 
 	switch ty := typ.(type) {
 	case *scanner.Named:
-		return !isNullable && !t.IsEnum(ty.Path, ty.Name)
+		return !isNullable && !t.IsEnum(ty.Path, ty.Name) && !scanner.IsSQLNull(ty)
+	case *scanner.Anonymous:
+		return !isNullable
 	case *scanner.Alias:
 		return t.needsNotNullableOption(ty.Underlying)
 	case *scanner.Map:
@@ -312,22 +732,60 @@ func (t *Transformer) transformType(pkg *Package, typ scanner.Type, msg *Message
 	case *scanner.Named:
 		protoType := t.findMapping(ty.String())
 		if protoType != nil {
-			pkg.Import(protoType)
-			protoType.Decorate(pkg, msg, field)
+			t.useMapping(pkg, msg, field, protoType)
 			n := protoType.Type()
 			n.SetSource(ty)
 			return n
 		}
 
+		// The Go type of messages declared by the user is used as is, so
+		// their fields cannot hold protobuf enum values if the Go enum is
+		// a string.
+		if t.target == TargetGogo && t.IsStringEnum(ty.Path, ty.Name) && isDeclaredByUser(msg) {
+			b := NewBasic("string")
+			b.SetSource(ty)
+			if field.Options == nil {
+				field.Options = make(Options)
+			}
+
+			if !ty.IsRepeated() {
+				field.Options["(gogoproto.casttype)"] = NewStringValue(castType(pkg, b))
+			}
+			return b
+		}
+
+		// Messages declared for instantiations of generic structs are
+		// cast to the instantiated Go type.
+		if ty.Generic != "" && !ty.IsRepeated() {
+			if hasQualifiedTypeArgs(ty.Generic) {
+				t.unsupportedByGogo("field %q of message %q cannot be cast to %s by the code generated by gogo protobuf, as casttype can only refer to types of one package", field.Name, msg.Name, ty.Generic)
+			}
+
+			if field.Options == nil {
+				field.Options = make(Options)
+			}
+			field.Options["(gogoproto.casttype)"] = NewStringValue(ty.Generic)
+		}
+
 		pkg.ImportFromPath(ty.Path)
 		n := NewNamed(toProtobufPkg(ty.Path), ty.Name)
 		n.SetSource(ty)
 		return n
+	case *scanner.Anonymous:
+		// Anonymous structs are declared as messages nested in the
+		// message of the field, named after the Go name of the field.
+		if isDeclaredByUser(msg) {
+			t.unsupportedByGogo("field %q of message %q is an anonymous struct, on which the code generated by gogo protobuf cannot declare methods", field.Name, msg.Name)
+		}
+
+		nested := t.transformNested(pkg, msg, ty, goFieldName(field))
+		n := NewNamed("", nested.Name)
+		n.SetSource(ty)
+		return n
 	case *scanner.Basic:
 		protoType := t.findMapping(ty.Name)
 		if protoType != nil {
-			pkg.Import(protoType)
-			protoType.Decorate(pkg, msg, field)
+			t.useMapping(pkg, msg, field, protoType)
 			b := protoType.Type()
 			b.SetSource(ty)
 			return b
@@ -335,6 +793,16 @@ func (t *Transformer) transformType(pkg *Package, typ scanner.Type, msg *Message
 
 		report.Warn("basic type %q is not defined in the mappings, ignoring", ty.Name)
 	case *scanner.Map:
+		// Maps of free-form values, such as map[string]interface{}, can be
+		// mapped as a whole.
+		protoType := t.findMapping(ty.String())
+		if protoType != nil && !ty.Value.IsRepeated() {
+			t.useMapping(pkg, msg, field, protoType)
+			n := protoType.Type()
+			n.SetSource(ty)
+			return n
+		}
+
 		m := NewMap(
 			t.transformType(pkg, ty.Key, msg, field),
 			t.transformType(pkg, ty.Value, msg, field),
@@ -361,6 +829,36 @@ func (t *Transformer) transformType(pkg *Package, typ scanner.Type, msg *Message
 	return nil
 }
 
+// transformNested declares the given anonymous struct as a message with the
+// given name nested in the given message.
+func (t *Transformer) transformNested(pkg *Package, msg *Message, a *scanner.Anonymous, name string) *Message {
+	s := *a.Struct
+	s.Name = name
+	return t.transformMessage(pkg, &s, msg)
+}
+
+// goFieldName returns the name of the Go struct field of the given field.
+func goFieldName(f *Field) string {
+	if name, ok := f.Options["(gogoproto.customname)"].(StringValue); ok {
+		return name.val
+	}
+	return generator.CamelCase(f.Name)
+}
+
+// isDeclaredByUser reports whether the Go type of the message is declared by
+// the user instead of the generated protobuf code.
+func isDeclaredByUser(msg *Message) bool {
+	return msg.Options["(gogoproto.typedecl)"] == NewLiteralValue("false")
+}
+
+// hasQualifiedTypeArgs reports whether any of the type arguments of the
+// given instantiated Go type, e.g. Page[example.com/users.User], is declared
+// in another package.
+func hasQualifiedTypeArgs(generic string) bool {
+	i := strings.Index(generic, "[")
+	return i >= 0 && strings.ContainsAny(generic[i:], "./")
+}
+
 func castType(pkg *Package, typ Type) string {
 	switch t := typ.Source().(type) {
 	case *scanner.Named:
@@ -372,6 +870,32 @@ func castType(pkg *Package, typ Type) string {
 	return typ.Source().TypeString()
 }
 
+// HasMapping reports whether the Go type with the given name, e.g. "time.Time"
+// or "github.com/google/uuid.UUID", is mapped to a protobuf type, either by
+// the custom mappings or the default ones.
+func (t *Transformer) HasMapping(name string) bool {
+	if _, ok := t.mappings[name]; ok {
+		return true
+	}
+
+	_, ok := DefaultMappings[name]
+	return ok
+}
+
+// useMapping imports the given mapped type in the given package and runs its
+// decorators for the given field of the given message.
+func (t *Transformer) useMapping(pkg *Package, msg *Message, field *Field, protoType *ProtoType) {
+	pkg.Import(protoType)
+	protoType.Decorate(pkg, msg, field)
+
+	// The Go type of messages declared by the user is used as is, and free
+	// form values can neither be cast to the Go type gogo protobuf generates
+	// for the well-known type nor used as its custom types.
+	if protoType.freeForm && isDeclaredByUser(msg) {
+		t.unsupportedByGogo("field %q of message %q cannot hold the free-form values of %s in the code generated by gogo protobuf", field.Name, msg.Name, protoType.Type())
+	}
+}
+
 func (t *Transformer) findMapping(name string) *ProtoType {
 	typ := t.mappings[name]
 	if typ == nil {
@@ -413,6 +937,11 @@ func isNamed(typ scanner.Type) bool {
 	return ok
 }
 
+func (t *Transformer) isEnumType(typ scanner.Type) bool {
+	n, ok := typ.(*scanner.Named)
+	return ok && t.IsEnum(n.Path, n.Name)
+}
+
 func isCtx(typ scanner.Type) bool {
 	if ctx, ok := typ.(*scanner.Named); ok {
 		return ctx.Path == "context" && ctx.Name == "Context"
@@ -453,16 +982,7 @@ func toProtobufPkg(path string) string {
 }
 
 func toLowerSnakeCase(s string) string {
-	var buf bytes.Buffer
-	var lastWasUpper bool
-	for i, r := range s {
-		if unicode.IsUpper(r) && i != 0 && !lastWasUpper {
-			buf.WriteRune('_')
-		}
-		lastWasUpper = unicode.IsUpper(r)
-		buf.WriteRune(unicode.ToLower(r))
-	}
-	return buf.String()
+	return scanner.ToLowerSnakeCase(s)
 }
 
 func toUpperSnakeCase(s string) string {
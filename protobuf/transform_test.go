@@ -2,6 +2,7 @@ package protobuf
 
 import (
 	"fmt"
+	"go/constant"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -144,6 +145,17 @@ func (s *TransformerSuite) TestFindMapping() {
 	}
 }
 
+func (s *TransformerSuite) TestHasMapping() {
+	s.t.SetMappings(TypeMappings{
+		"net/url.URL": &ProtoType{Name: "string", Basic: true},
+	})
+
+	s.True(s.t.HasMapping("net/url.URL"), "custom mapping")
+	s.True(s.t.HasMapping("time.Time"), "default mapping")
+	s.False(s.t.HasMapping("net/url.Userinfo"), "not mapped")
+	s.Len(report.MessageStack(), 0)
+}
+
 func (s *TransformerSuite) TestFindMappingWithWarn() {
 	s.t.SetMappings(TypeMappings{
 		"url.URL": &ProtoType{Name: "string", Basic: true},
@@ -460,6 +472,645 @@ func (s *TransformerSuite) TestTransformStructIsStringer() {
 	s.Equal(NewLiteralValue("false"), msg.Options["(gogoproto.goproto_getters)"], "should drop getters by default")
 }
 
+func (s *TransformerSuite) TestTransformStructWithLock() {
+	pkg := &Package{Lock: NewLock()}
+	st := &scanner.Struct{
+		Name: "Foo",
+		Fields: []*scanner.Field{
+			{Name: "Foo", Type: scanner.NewBasic("string")},
+			{Name: "Bar", Type: scanner.NewBasic("int")},
+			{Name: "Baz", Type: scanner.NewBasic("bool")},
+		},
+	}
+
+	msg := s.t.transformStruct(pkg, st)
+	s.Equal(3, len(msg.Fields))
+	s.Equal(1, msg.Fields[0].Pos)
+	s.Equal(2, msg.Fields[1].Pos)
+	s.Equal(3, msg.Fields[2].Pos)
+	s.Equal(0, len(msg.Reserved))
+
+	st.Fields = []*scanner.Field{
+		{Name: "Baz", Type: scanner.NewBasic("bool")},
+		{Name: "Qux", Type: scanner.NewBasic("string")},
+		{Name: "Foo", Type: scanner.NewBasic("string")},
+	}
+
+	msg = s.t.transformStruct(pkg, st)
+	s.Equal(3, len(msg.Fields))
+	s.Equal("baz", msg.Fields[0].Name)
+	s.Equal(3, msg.Fields[0].Pos, "existing fields keep their number")
+	s.Equal("qux", msg.Fields[1].Name)
+	s.Equal(4, msg.Fields[1].Pos, "new fields get a new number")
+	s.Equal("foo", msg.Fields[2].Name)
+	s.Equal(1, msg.Fields[2].Pos, "existing fields keep their number")
+	s.Equal([]uint{2}, msg.Reserved, "removed field number is reserved")
+	s.Equal([]string{"bar"}, msg.ReservedNames, "removed field name is reserved")
+
+	st.Fields = append(st.Fields, &scanner.Field{Name: "Bar", Type: scanner.NewBasic("int")})
+	msg = s.t.transformStruct(pkg, st)
+	s.Equal(4, len(msg.Fields))
+	s.Equal(5, msg.Fields[3].Pos, "re-added field does not reuse its old number")
+	s.Equal([]uint{2}, msg.Reserved)
+	s.Equal(0, len(msg.ReservedNames))
+}
+
+func (s *TransformerSuite) TestTransformStructTags() {
+	st := &scanner.Struct{
+		Name: "Foo",
+		Fields: []*scanner.Field{
+			{Name: "Foo", Type: scanner.NewBasic("string")},
+			{Name: "UserID", Type: scanner.NewBasic("string"), Pos: 2, ProtoName: "user_id", JSONName: "userId"},
+			{Name: "Baz", Type: scanner.NewBasic("bool")},
+			{Name: "Qux", Type: scanner.NewBasic("bool"), Pos: 10},
+		},
+	}
+
+	msg := s.t.transformStruct(&Package{}, st)
+	s.Equal(4, len(msg.Fields))
+	s.Equal("foo", msg.Fields[0].Name)
+	s.Equal(1, msg.Fields[0].Pos)
+	s.Equal("user_id", msg.Fields[1].Name)
+	s.Equal(2, msg.Fields[1].Pos)
+	s.Equal(Options{
+		"(gogoproto.customname)": NewStringValue("UserID"),
+		"json_name":              NewStringValue("userId"),
+	}, msg.Fields[1].Options)
+	s.Equal("baz", msg.Fields[2].Name)
+	s.Equal(3, msg.Fields[2].Pos)
+	s.Equal("qux", msg.Fields[3].Name)
+	s.Equal(10, msg.Fields[3].Pos)
+
+	st.Fields[0].Pos = 3
+	msg = s.t.transformStruct(&Package{}, st)
+	s.Equal(3, msg.Fields[0].Pos)
+	s.Equal(2, msg.Fields[1].Pos)
+	s.Equal(4, msg.Fields[2].Pos, "positions in tags are skipped")
+	s.Equal(10, msg.Fields[3].Pos)
+}
+
+func (s *TransformerSuite) TestTransformStructTagsWithLock() {
+	pkg := &Package{Lock: NewLock()}
+	st := &scanner.Struct{
+		Name: "Foo",
+		Fields: []*scanner.Field{
+			{Name: "Foo", Type: scanner.NewBasic("string")},
+			{Name: "Bar", Type: scanner.NewBasic("string")},
+		},
+	}
+	s.t.transformStruct(pkg, st)
+
+	st.Fields[1].Pos = 1
+	msg := s.t.transformStruct(pkg, st)
+	s.Equal("foo", msg.Fields[0].Name)
+	s.Equal(3, msg.Fields[0].Pos, "number is taken by the one in the tag")
+	s.Equal("bar", msg.Fields[1].Name)
+	s.Equal(1, msg.Fields[1].Pos)
+	s.Equal([]uint{2}, msg.Reserved)
+	s.Equal(0, len(msg.ReservedNames))
+}
+
+func (s *TransformerSuite) TestTransformStructOneof() {
+	circle := scanner.NewNamed("shapes", "Circle").(*scanner.Named)
+	square := scanner.NewNamed("shapes", "Square").(*scanner.Named)
+	s.t.SetInterfaces(map[string]*scanner.Interface{
+		"shapes.Shape": {Name: "Shape", Implementations: []*scanner.Named{circle, square}},
+	})
+
+	st := &scanner.Struct{
+		Name: "Drawing",
+		Fields: []*scanner.Field{
+			{Name: "Name", Type: scanner.NewBasic("string")},
+			{Name: "Shape", Type: scanner.NewNamed("shapes", "Shape"), Pos: 7},
+			{Name: "Color", Type: scanner.NewBasic("string")},
+		},
+	}
+
+	pkg := &Package{Path: "drawings"}
+	msg := s.t.transformStruct(pkg, st)
+	s.Equal(2, len(msg.Fields))
+	s.Equal(1, msg.Fields[0].Pos)
+	s.Equal("color", msg.Fields[1].Name)
+	s.Equal(4, msg.Fields[1].Pos, "oneof fields take a number each")
+
+	s.Equal(1, len(msg.Oneofs))
+	oneof := msg.Oneofs[0]
+	s.Equal("shape", oneof.Name)
+	s.Equal("Shape", oneof.GoName)
+	s.Equal(st.Fields[1].Type, oneof.Src)
+	s.Equal(2, len(oneof.Fields))
+	s.assertField(oneof.Fields[0], "shape_circle", NewNamed("shapes", "Circle"))
+	s.Equal(2, oneof.Fields[0].Pos, "number in tag is ignored")
+	s.assertSource(oneof.Fields[0].Type, circle)
+	s.assertField(oneof.Fields[1], "shape_square", NewNamed("shapes", "Square"))
+	s.Equal(3, oneof.Fields[1].Pos)
+	s.Equal([]string{"shapes/generated.proto"}, pkg.Imports)
+
+	pkg = &Package{Path: "drawings", Lock: NewLock()}
+	s.t.transformStruct(pkg, st)
+	s.Equal(
+		map[string]int{"name": 1, "shape_circle": 2, "shape_square": 3, "color": 4},
+		pkg.Lock.Message("Drawing").Numbers,
+		"oneof fields are locked",
+	)
+}
+
+func (s *TransformerSuite) TestTransformStructOneofsOfSameInterface() {
+	s.t.SetTarget(TargetGolang)
+	s.t.SetInterfaces(map[string]*scanner.Interface{
+		"shapes.Shape": {Name: "Shape", Implementations: []*scanner.Named{
+			scanner.NewNamed("shapes", "Circle").(*scanner.Named),
+			scanner.NewNamed("shapes", "Square").(*scanner.Named),
+		}},
+	})
+
+	st := &scanner.Struct{
+		Name: "Move",
+		Fields: []*scanner.Field{
+			{Name: "From", Type: scanner.NewNamed("shapes", "Shape")},
+			{Name: "To", Type: scanner.NewNamed("shapes", "Shape")},
+		},
+	}
+
+	pkg := &Package{Path: "drawings", Lock: NewLock()}
+	msg := s.t.transformStruct(pkg, st)
+	s.Equal(2, len(msg.Oneofs))
+	s.Equal("from_circle", msg.Oneofs[0].Fields[0].Name)
+	s.Equal("from_square", msg.Oneofs[0].Fields[1].Name)
+	s.Equal("to_circle", msg.Oneofs[1].Fields[0].Name)
+	s.Equal("to_square", msg.Oneofs[1].Fields[1].Name)
+	s.Equal(
+		map[string]int{"from_circle": 1, "from_square": 2, "to_circle": 3, "to_square": 4},
+		pkg.Lock.Message("Move").Numbers,
+	)
+
+	s.t.errs = nil
+	st.Fields = append(st.Fields, &scanner.Field{Name: "FromCircle", Type: scanner.NewBasic("string")})
+	s.t.transformStruct(&Package{Path: "drawings"}, st)
+	s.Equal([]string{`struct "Move" has several fields named "from_circle", including the fields of its oneofs`}, s.t.errs)
+}
+
+func (s *TransformerSuite) TestTransformOneofGogo() {
+	s.t.SetInterfaces(map[string]*scanner.Interface{
+		"drawings.Shape": {Name: "Shape", Implementations: []*scanner.Named{
+			scanner.NewNamed("drawings", "Circle").(*scanner.Named),
+		}},
+	})
+
+	p := &scanner.Package{
+		Path: "drawings",
+		Name: "drawings",
+		Structs: []*scanner.Struct{
+			{Name: "Circle"},
+			{
+				Name: "Drawing",
+				Fields: []*scanner.Field{
+					{Name: "Shape", Type: scanner.NewNamed("drawings", "Shape")},
+				},
+			},
+		},
+	}
+
+	_, err := s.t.Transform(p)
+	s.Error(err)
+	s.Contains(err.Error(), `field "Shape" of struct "Drawing" is a oneof`)
+
+	s.t.SetMarshalers(true)
+	_, err = s.t.Transform(p)
+	s.NoError(err, "marshal methods generated by proteus support oneofs")
+
+	s.t.SetMarshalers(false)
+	s.t.SetTarget(TargetGolang)
+	_, err = s.t.Transform(p)
+	s.NoError(err)
+}
+
+func (s *TransformerSuite) TestTransformStructGeneric() {
+	page := scanner.NewNamed("foo", "PageUser").(*scanner.Named)
+	page.Generic = "Page[User]"
+	pages := scanner.NewNamed("foo", "PageUser").(*scanner.Named)
+	pages.Generic = "Page[User]"
+	pages.SetRepeated(true)
+
+	msg := s.t.transformStruct(&Package{Path: "foo"}, &scanner.Struct{
+		Name:    "PageUser",
+		Generic: "Page[User]",
+	})
+	s.Equal("Page[User]", msg.Generic)
+
+	msg = s.t.transformStruct(&Package{Path: "foo"}, &scanner.Struct{
+		Name: "Users",
+		Fields: []*scanner.Field{
+			{Name: "Page", Type: page},
+			{Name: "Pages", Type: pages},
+		},
+	})
+	s.assertType(NewNamed("foo", "PageUser"), msg.Fields[0].Type, "page")
+	s.Equal(Options{
+		"(gogoproto.casttype)": NewStringValue("Page[User]"),
+		"(gogoproto.nullable)": NewLiteralValue("false"),
+	}, msg.Fields[0].Options)
+	s.Equal(Options{
+		"(gogoproto.nullable)": NewLiteralValue("false"),
+	}, msg.Fields[1].Options, "repeated types cannot be cast")
+	s.Empty(s.t.errs)
+
+	other := scanner.NewNamed("foo", "PageUser").(*scanner.Named)
+	other.Generic = "example.com/lib.Page[example.com/users.User]"
+	st := &scanner.Struct{
+		Name:   "Users",
+		Fields: []*scanner.Field{{Name: "Page", Type: other}},
+	}
+	s.t.transformStruct(&Package{Path: "foo"}, st)
+	s.Equal([]string{
+		`field "page" of message "Users" cannot be cast to example.com/lib.Page[example.com/users.User] by the code generated by gogo protobuf, as casttype can only refer to types of one package, use the golang target or generate the marshal methods with proteus instead`,
+	}, s.t.errs)
+
+	s.t.errs = nil
+	s.t.SetMarshalers(true)
+	s.t.transformStruct(&Package{Path: "foo"}, st)
+	s.Empty(s.t.errs)
+}
+
+func TestHasQualifiedTypeArgs(t *testing.T) {
+	require.False(t, hasQualifiedTypeArgs("Page[User]"))
+	require.False(t, hasQualifiedTypeArgs("Page[map[string][]*User]"))
+	require.False(t, hasQualifiedTypeArgs("example.com/lib.Page[User]"))
+	require.True(t, hasQualifiedTypeArgs("Page[example.com/users.User]"))
+	require.True(t, hasQualifiedTypeArgs("Pair[User, time.Time]"))
+}
+
+func (s *TransformerSuite) TestTransformStructAnonymous() {
+	source := scanner.NewAnonymous(&scanner.Struct{
+		Fields: []*scanner.Field{
+			{Name: "URL", Type: scanner.NewBasic("string")},
+		},
+	})
+	source.SetNullable(true)
+	meta := scanner.NewAnonymous(&scanner.Struct{
+		Fields: []*scanner.Field{
+			{Name: "Source", Type: source},
+		},
+	})
+
+	pkg := &Package{Path: "foo", Lock: NewLock()}
+	msg := s.t.transformStruct(pkg, &scanner.Struct{
+		Name: "User",
+		Fields: []*scanner.Field{
+			{Name: "Name", Type: scanner.NewBasic("string")},
+			{Name: "Meta", Type: meta},
+		},
+	})
+
+	s.Len(msg.Fields, 2)
+	s.assertType(NewNamed("", "Meta"), msg.Fields[1].Type, "meta")
+	s.Equal(Options{
+		"(gogoproto.nullable)": NewLiteralValue("false"),
+	}, msg.Fields[1].Options)
+
+	s.Len(msg.Messages, 1)
+	nested := msg.Messages[0]
+	s.Equal("Meta", nested.Name)
+	s.Equal("User.Meta", nested.FullName())
+	s.Equal("User_Meta", nested.GoName())
+	s.Equal(s.t.defaultOptionsForScannedMessage(&scanner.Struct{}), nested.Options)
+	s.assertType(NewNamed("", "Source"), nested.Fields[0].Type, "source")
+	s.Equal(Options{}, nested.Fields[0].Options, "pointers are nullable")
+
+	s.Len(nested.Messages, 1)
+	s.Equal("User_Meta_Source", nested.Messages[0].GoName())
+	s.Equal("url", nested.Messages[0].Fields[0].Name)
+	s.Equal(Options{
+		"(gogoproto.customname)": NewStringValue("URL"),
+	}, nested.Messages[0].Fields[0].Options)
+
+	_, ok := pkg.Lock.Messages["User.Meta.Source"]
+	s.True(ok, "nested messages are locked by their full name")
+
+	s.Equal([]string{
+		`field "meta" of message "User" is an anonymous struct, on which the code generated by gogo protobuf cannot declare methods, use the golang target or generate the marshal methods with proteus instead`,
+		`field "source" of message "Meta" is an anonymous struct, on which the code generated by gogo protobuf cannot declare methods, use the golang target or generate the marshal methods with proteus instead`,
+	}, s.t.errs)
+
+	s.t.errs = nil
+	s.t.SetMarshalers(true)
+	s.t.transformStruct(&Package{Path: "foo", Lock: NewLock()}, &scanner.Struct{
+		Name:   "User",
+		Fields: []*scanner.Field{{Name: "Meta", Type: meta}},
+	})
+	s.Empty(s.t.errs, "marshal methods generated by proteus support anonymous structs")
+
+	s.t.SetMarshalers(false)
+	s.t.SetTarget(TargetGolang)
+	s.t.transformStruct(&Package{Path: "foo", Lock: NewLock()}, &scanner.Struct{
+		Name:   "User",
+		Fields: []*scanner.Field{{Name: "Meta", Type: meta}},
+	})
+	s.Empty(s.t.errs)
+}
+
+func (s *TransformerSuite) TestTransformStructNullable() {
+	ptr := func(name string) scanner.Type {
+		t := scanner.NewBasic(name)
+		t.SetNullable(true)
+		return t
+	}
+
+	st := &scanner.Struct{
+		Name: "User",
+		Fields: []*scanner.Field{
+			{Name: "Nick", Type: ptr("string")},
+			{Name: "Age", Type: ptr("int")},
+			{Name: "Email", Type: scanner.NewNamed("database/sql", "NullString")},
+			{Name: "Seen", Type: scanner.NewNamed("database/sql", "NullTime")},
+		},
+	}
+
+	cases := []struct {
+		mode     NullableMode
+		types    []string
+		optional []bool
+		options  []Options
+		imports  []string
+	}{
+		{
+			NullableAsScalar,
+			[]string{"string", "int64", "string", "google.protobuf.Timestamp"},
+			[]bool{false, false, false, false},
+			[]Options{
+				{},
+				{"(gogoproto.casttype)": NewStringValue("int")},
+				{},
+				{},
+			},
+			[]string{"google/protobuf/timestamp.proto"},
+		},
+		{
+			NullableAsOptional,
+			[]string{"string", "int64", "string", "google.protobuf.Timestamp"},
+			[]bool{true, true, true, false},
+			[]Options{
+				{},
+				{"(gogoproto.casttype)": NewStringValue("int")},
+				{},
+				{},
+			},
+			[]string{"google/protobuf/timestamp.proto"},
+		},
+		{
+			NullableAsWrapper,
+			[]string{
+				"google.protobuf.StringValue",
+				"google.protobuf.Int64Value",
+				"google.protobuf.StringValue",
+				"google.protobuf.Timestamp",
+			},
+			[]bool{false, false, false, false},
+			[]Options{
+				{"(gogoproto.wktpointer)": NewLiteralValue("true")},
+				{"(gogoproto.wktpointer)": NewLiteralValue("true")},
+				{},
+				{},
+			},
+			[]string{"google/protobuf/wrappers.proto", "google/protobuf/timestamp.proto"},
+		},
+	}
+
+	defer s.t.SetNullableMode(NullableAsScalar)
+	for _, c := range cases {
+		s.t.SetNullableMode(c.mode)
+		pkg := &Package{Path: "foo"}
+		msg := s.t.transformStruct(pkg, st)
+		s.Len(msg.Fields, len(c.types))
+		for i, f := range msg.Fields {
+			s.Equal(c.types[i], f.Type.String(), "type of %s in mode %d", f.Name, c.mode)
+			s.Equal(c.optional[i], f.Optional, "optional %s in mode %d", f.Name, c.mode)
+			s.Equal(c.options[i], f.Options, "options of %s in mode %d", f.Name, c.mode)
+		}
+		s.Equal(c.imports, pkg.Imports, "imports in mode %d", c.mode)
+	}
+
+	s.t.errs = nil
+	s.t.transformStruct(&Package{Path: "foo"}, st)
+	s.Equal([]string{
+		`field "age" of message "User" cannot be a *int in the code generated by gogo protobuf, which declares it as a *int64, use the golang target or generate the marshal methods with proteus instead`,
+		`field "email" of message "User" cannot be a database/sql.NullString in the code generated by gogo protobuf, use the golang target or generate the marshal methods with proteus instead`,
+		`field "seen" of message "User" cannot be a database/sql.NullTime in the code generated by gogo protobuf, use the golang target or generate the marshal methods with proteus instead`,
+	}, s.t.errs)
+
+	s.t.errs = nil
+	s.t.SetTarget(TargetGolang)
+	s.t.transformStruct(&Package{Path: "foo"}, st)
+	s.Empty(s.t.errs)
+
+	s.t.SetNullableMode(NullableAsWrapper)
+	msg := s.t.createMessageFromTypes(&Package{}, "FooRequest", []scanner.Type{ptr("string")}, "arg")
+	s.Equal("string", msg.Fields[0].Type.String(), "generated messages are not affected")
+}
+
+func (s *TransformerSuite) TestTransformStructWrapperGoTypes() {
+	ptr := func(name string) scanner.Type {
+		t := scanner.NewBasic(name)
+		t.SetNullable(true)
+		return t
+	}
+
+	st := &scanner.Struct{
+		Name: "User",
+		Fields: []*scanner.Field{
+			{Name: "Age", Type: ptr("int8")},
+			{Name: "Port", Type: ptr("uint16")},
+			{Name: "Score", Type: ptr("float32")},
+			{Name: "Count", Type: ptr("int32")},
+			{Name: "Rate", Type: ptr("float64")},
+		},
+	}
+
+	defer s.t.SetNullableMode(NullableAsScalar)
+	s.t.SetNullableMode(NullableAsWrapper)
+	msg := s.t.transformStruct(&Package{Path: "foo"}, st)
+	s.Len(msg.Fields, 5)
+	s.Equal("google.protobuf.Int32Value", msg.Fields[0].Type.String())
+	s.Equal("google.protobuf.UInt32Value", msg.Fields[1].Type.String())
+	s.Equal("google.protobuf.FloatValue", msg.Fields[2].Type.String())
+	s.Equal([]string{
+		`field "age" of message "User" cannot be a *int8 in the code generated by gogo protobuf, which declares it as a *int32, use the golang target or generate the marshal methods with proteus instead`,
+		`field "port" of message "User" cannot be a *uint16 in the code generated by gogo protobuf, which declares it as a *uint32, use the golang target or generate the marshal methods with proteus instead`,
+	}, s.t.errs, "only pointers to the Go types of the wrappers are supported")
+
+	s.t.errs = nil
+	s.t.SetMarshalers(true)
+	defer s.t.SetMarshalers(false)
+	s.t.transformStruct(&Package{Path: "foo"}, st)
+	s.Empty(s.t.errs, "marshal methods generated by proteus cast the values")
+}
+
+func (s *TransformerSuite) TestTransformStructFreeForm() {
+	list := scanner.NewBasic("interface{}")
+	list.SetRepeated(true)
+
+	pkg := &Package{Path: "foo"}
+	msg := s.t.transformStruct(pkg, &scanner.Struct{
+		Name: "Event",
+		Fields: []*scanner.Field{
+			{Name: "Payload", Type: scanner.NewBasic("interface{}")},
+			{Name: "Props", Type: scanner.NewMap(scanner.NewBasic("string"), scanner.NewBasic("interface{}"))},
+			{Name: "Raw", Type: scanner.NewNamed("encoding/json", "RawMessage")},
+			{Name: "Lists", Type: scanner.NewMap(scanner.NewBasic("string"), list)},
+		},
+	})
+
+	s.Len(msg.Fields, 4)
+	s.assertType(NewNamed("google.protobuf", "Value"), msg.Fields[0].Type, "payload")
+	s.assertType(NewNamed("google.protobuf", "Struct"), msg.Fields[1].Type, "props")
+	s.assertType(NewNamed("google.protobuf", "Value"), msg.Fields[2].Type, "raw")
+	s.Equal("map<string, google.protobuf.Value>", msg.Fields[3].Type.String(), "maps of repeated values are not structs")
+	s.Equal([]string{"google/protobuf/struct.proto"}, pkg.Imports)
+	s.Equal([]string{
+		`field "payload" of message "Event" cannot hold the free-form values of google.protobuf.Value in the code generated by gogo protobuf, use the golang target or generate the marshal methods with proteus instead`,
+		`field "props" of message "Event" cannot hold the free-form values of google.protobuf.Struct in the code generated by gogo protobuf, use the golang target or generate the marshal methods with proteus instead`,
+		`field "raw" of message "Event" cannot hold the free-form values of google.protobuf.Value in the code generated by gogo protobuf, use the golang target or generate the marshal methods with proteus instead`,
+		`field "lists" of message "Event" cannot hold the free-form values of google.protobuf.Value in the code generated by gogo protobuf, use the golang target or generate the marshal methods with proteus instead`,
+	}, s.t.errs)
+
+	s.t.errs = nil
+	s.t.createMessageFromTypes(&Package{}, "FooRequest", []scanner.Type{scanner.NewBasic("interface{}")}, "arg")
+	s.Empty(s.t.errs, "generated messages hold the Go type of the well-known type")
+}
+
+func (s *TransformerSuite) TestTransformEnumWithLock() {
+	pkg := &Package{Lock: NewLock()}
+	e := &scanner.Enum{
+		Name: "Foo",
+		Values: []*scanner.EnumValue{
+			mkIntEnumVal("Foo", 0),
+			mkIntEnumVal("Bar", 1),
+			mkIntEnumVal("Baz", 2),
+			mkIntEnumVal("Qux", 3),
+		},
+	}
+	s.t.transformEnum(pkg, e)
+
+	e.Values = []*scanner.EnumValue{
+		mkIntEnumVal("Foo", 0),
+		mkIntEnumVal("Baz", 2),
+		mkIntEnumVal("Quux", 3),
+	}
+	enum := s.t.transformEnum(pkg, e)
+	s.Equal(3, len(enum.Values))
+	s.Equal([]int32{1}, enum.Reserved, "values still in use are not reserved")
+	s.Equal([]string{"BAR", "QUX"}, enum.ReservedNames)
+
+	e.Values = append(e.Values, mkIntEnumVal("Bar", 1))
+	enum = s.t.transformEnum(pkg, e)
+	s.Equal(4, len(enum.Values))
+	s.Equal(0, len(enum.Reserved), "re-added values are no longer reserved")
+	s.Equal([]string{"QUX"}, enum.ReservedNames)
+}
+
+func (s *TransformerSuite) TestTransformEnumValues() {
+	enum := s.t.transformEnum(&Package{}, &scanner.Enum{
+		Name: "PageSize",
+		Values: []*scanner.EnumValue{
+			mkIntEnumVal("Negative", -1),
+			mkIntEnumVal("Mobile", 320),
+			mkIntEnumVal("Tablet", 768),
+			mkIntEnumVal("Small", 768),
+			mkIntEnumVal("Desktop", 1024),
+			mkIntEnumVal("Huge", 1<<40),
+		},
+	})
+
+	s.Equal(6, len(enum.Values), "too big values are ignored")
+	s.assertEnumVal(enum.Values[0], "PAGE_SIZE_UNSPECIFIED", 0, "")
+	s.Equal(0, len(enum.Values[0].Options))
+	s.assertEnumVal(enum.Values[1], "NEGATIVE", -1, "")
+	s.assertEnumVal(enum.Values[2], "MOBILE", 320, "")
+	s.assertEnumVal(enum.Values[3], "TABLET", 768, "")
+	s.assertEnumVal(enum.Values[4], "SMALL", 768, "")
+	s.assertEnumVal(enum.Values[5], "DESKTOP", 1024, "")
+	s.Equal(NewLiteralValue("true"), enum.Options["allow_alias"])
+}
+
+func (s *TransformerSuite) TestTransformEnumZeroFirst() {
+	enum := s.t.transformEnum(&Package{}, &scanner.Enum{
+		Name: "Flag",
+		Values: []*scanner.EnumValue{
+			mkIntEnumVal("Negative", -1),
+			mkIntEnumVal("None", 0),
+			mkIntEnumVal("A", 1),
+			mkIntEnumVal("B", 2),
+			mkIntEnumVal("C", 4),
+		},
+	})
+
+	s.Equal(5, len(enum.Values))
+	s.assertEnumVal(enum.Values[0], "NONE", 0, "")
+	s.assertEnumVal(enum.Values[1], "NEGATIVE", -1, "")
+	s.assertEnumVal(enum.Values[4], "C", 4, "")
+	s.NotContains(enum.Options, "allow_alias")
+}
+
+func (s *TransformerSuite) TestTransformStringEnum() {
+	e := &scanner.Enum{
+		Name:     "Color",
+		IsString: true,
+		Values: []*scanner.EnumValue{
+			{Name: "Red", Value: constant.MakeString("red")},
+			{Name: "Blue", Value: constant.MakeString("blue")},
+			{Name: "Azure", Value: constant.MakeString("blue")},
+		},
+	}
+
+	enum := s.t.transformEnum(&Package{}, e)
+	s.Equal(Options{
+		"(gogoproto.enum_customname)": NewStringValue("ColorProto"),
+		"allow_alias":                 NewLiteralValue("true"),
+	}, enum.Options)
+	s.Equal(4, len(enum.Values))
+	s.assertEnumVal(enum.Values[0], "COLOR_UNSPECIFIED", 0, "")
+	s.assertEnumVal(enum.Values[1], "RED", 1, "")
+	s.assertEnumVal(enum.Values[2], "BLUE", 2, "")
+	s.assertEnumVal(enum.Values[3], "AZURE", 2, "")
+
+	e.Values = []*scanner.EnumValue{
+		{Name: "None", Value: constant.MakeString("")},
+		{Name: "Blue", Value: constant.MakeString("blue")},
+		{Name: "Green", Value: constant.MakeString("green")},
+	}
+
+	pkg := &Package{Lock: NewLock()}
+	pkg.Lock.Enum("Color").Set("GREEN", 5)
+	enum = s.t.transformEnum(pkg, e)
+	s.NotContains(enum.Options, "allow_alias")
+	s.Equal(3, len(enum.Values))
+	s.assertEnumVal(enum.Values[0], "NONE", 0, "")
+	s.assertEnumVal(enum.Values[1], "BLUE", 6, "")
+	s.assertEnumVal(enum.Values[2], "GREEN", 5, "")
+}
+
+func (s *TransformerSuite) TestTransformStringEnumField() {
+	s.t.SetStringEnumSet(TypeSet{"foo": {"Color": struct{}{}}})
+
+	st := &scanner.Struct{
+		Name: "Foo",
+		Fields: []*scanner.Field{
+			{Name: "Color", Type: scanner.NewNamed("foo", "Color")},
+		},
+	}
+
+	msg := s.t.transformStruct(&Package{Path: "foo"}, st)
+	s.Equal(1, len(msg.Fields))
+	s.assertType(NewBasic("string"), msg.Fields[0].Type, "color")
+	s.Equal(NewStringValue("Color"), msg.Fields[0].Options["(gogoproto.casttype)"])
+
+	msg = s.t.createMessageFromTypes(&Package{Path: "foo"}, "FooRequest", []scanner.Type{
+		scanner.NewNamed("foo", "Color"),
+	}, "arg")
+	s.Equal(1, len(msg.Fields))
+	s.assertType(NewNamed("foo", "Color"), msg.Fields[0].Type, "arg1")
+}
+
 func (s *TransformerSuite) TestTransformFuncMultiple() {
 	fn := &scanner.Func{
 		Name: "DoFoo",
@@ -602,6 +1253,26 @@ func (s *TransformerSuite) TestTransformFunc1NamedArg() {
 	s.assertSource(rpc.Output, fn.Output[0])
 }
 
+func (s *TransformerSuite) TestTransformFuncEnumArg() {
+	s.t.SetEnumSet(TypeSet{"foo": {"Color": struct{}{}}})
+	fn := &scanner.Func{
+		Name: "DoFoo",
+		Input: []scanner.Type{
+			scanner.NewNamed("foo", "Color"),
+		},
+		Output: []scanner.Type{
+			scanner.NewNamed("foo", "Color"),
+		},
+	}
+	pkg := &Package{Path: "foo"}
+	rpc := s.t.transformFunc(pkg, fn, nameSet{})
+
+	s.NotNil(rpc)
+	s.assertType(NewGeneratedNamed("foo", "DoFooRequest"), rpc.Input, "enums are wrapped in a message")
+	s.assertType(NewGeneratedNamed("foo", "DoFooResponse"), rpc.Output, "enums are wrapped in a message")
+	s.Equal(2, len(pkg.Messages))
+}
+
 func (s *TransformerSuite) TestTransformFuncReceiver() {
 	fn := &scanner.Func{
 		Name:     "DoFoo",
@@ -669,7 +1340,7 @@ func (s *TransformerSuite) TestTransformFuncRepeatedSingle() {
 }
 
 func (s *TransformerSuite) TestTransformEnum() {
-	enum := s.t.transformEnum(&scanner.Enum{
+	enum := s.t.transformEnum(&Package{}, &scanner.Enum{
 		Docs: mkDocs("foo bar baz"),
 		Name: "Foo",
 		Values: []*scanner.EnumValue{
@@ -690,7 +1361,7 @@ func (s *TransformerSuite) TestTransformEnum() {
 }
 
 func (s *TransformerSuite) TestTransformEnumIsStringer() {
-	enum := s.t.transformEnum(&scanner.Enum{
+	enum := s.t.transformEnum(&Package{}, &scanner.Enum{
 		Name: "Foo",
 		Values: []*scanner.EnumValue{
 			mkEnumVal("fooo bar", "Foo"),
@@ -712,7 +1383,8 @@ func (s *TransformerSuite) TestTransformEnumIsStringer() {
 
 func (s *TransformerSuite) TestTransform() {
 	pkgs := s.fixtures()
-	pkg := s.t.Transform(pkgs[0])
+	pkg, err := s.t.Transform(pkgs[0])
+	s.NoError(err)
 
 	s.Equal("gopkg.in.srcd.proteus.v1.fixtures", pkg.Name)
 	s.Equal("gopkg.in/src-d/proteus.v1/fixtures", pkg.Path)
@@ -728,7 +1400,8 @@ func (s *TransformerSuite) TestTransform() {
 	s.Equal(5, len(pkg.Messages))
 	s.Equal(0, len(pkg.RPCs))
 
-	pkg = s.t.Transform(pkgs[1])
+	pkg, err = s.t.Transform(pkgs[1])
+	s.NoError(err)
 	s.Equal("gopkg.in.srcd.proteus.v1.fixtures.subpkg", pkg.Name)
 	s.Equal("gopkg.in/src-d/proteus.v1/fixtures/subpkg", pkg.Path)
 	s.Equal(NewStringValue("subpkg"), pkg.Options["go_package"])
@@ -752,6 +1425,117 @@ func (s *TransformerSuite) TestTransform() {
 	s.Equal(4, len(pkg.RPCs))
 }
 
+func (s *TransformerSuite) TestTransformOptions() {
+	pkg, err := s.t.Transform(&scanner.Package{
+		Docs: scanner.Docs{
+			Options: map[string]string{"java_multiple_files": "true"},
+			Imports: []string{"validate/validate.proto"},
+		},
+		Path: "foo",
+		Name: "foo",
+		Structs: []*scanner.Struct{
+			{
+				Docs: scanner.Docs{Options: map[string]string{
+					"deprecated":                  "true",
+					"(gogoproto.goproto_getters)": "true",
+				}},
+				Name: "User",
+				Fields: []*scanner.Field{
+					{
+						Docs: scanner.Docs{Options: map[string]string{
+							"(validate.rules).string.min_len": "1",
+							"(foo.bar)":                       `"a \"b\""`,
+						}},
+						Name: "Name",
+						Type: scanner.NewBasic("string"),
+					},
+				},
+			},
+		},
+		Enums: []*scanner.Enum{
+			{
+				Docs: scanner.Docs{Imports: []string{"other.proto", "validate/validate.proto"}},
+				Name: "Role",
+				Values: []*scanner.EnumValue{
+					{Name: "Admin"},
+					{Docs: scanner.Docs{Options: map[string]string{"deprecated": "true"}}, Name: "Guest"},
+				},
+			},
+		},
+		Funcs: []*scanner.Func{
+			{
+				Docs:   scanner.Docs{Options: map[string]string{"idempotency_level": "NO_SIDE_EFFECTS"}},
+				Name:   "GetUser",
+				Input:  []scanner.Type{scanner.NewBasic("int")},
+				Output: []scanner.Type{scanner.NewNamed("foo", "User")},
+			},
+		},
+	})
+	s.NoError(err)
+
+	s.Equal([]string{
+		"github.com/gogo/protobuf/gogoproto/gogo.proto",
+		"validate/validate.proto",
+		"other.proto",
+	}, pkg.Imports)
+	s.Equal(NewLiteralValue("true"), pkg.Options["java_multiple_files"])
+	s.Equal(NewStringValue("foo"), pkg.Options["go_package"])
+
+	msg := pkg.Messages[0]
+	s.Equal(NewLiteralValue("true"), msg.Options["deprecated"])
+	s.Equal(NewLiteralValue("true"), msg.Options["(gogoproto.goproto_getters)"], "options override the defaults")
+	s.Equal(NewLiteralValue("false"), msg.Options["(gogoproto.typedecl)"])
+	s.Equal(NewLiteralValue("1"), msg.Fields[0].Options["(validate.rules).string.min_len"])
+	s.Equal(NewStringValue(`a "b"`), msg.Fields[0].Options["(foo.bar)"])
+
+	values := pkg.Enums[0].Values
+	s.NotContains(values[0].Options, "deprecated")
+	s.Equal(NewLiteralValue("true"), values[1].Options["deprecated"])
+
+	s.Equal(Options{"idempotency_level": NewLiteralValue("NO_SIDE_EFFECTS")}, pkg.RPCs[0].Options)
+}
+
+func (s *TransformerSuite) TestTransformGolangTarget() {
+	pkgs := s.fixtures()
+	s.t.SetTarget(TargetGolang)
+	pkg, err := s.t.Transform(pkgs[0])
+	s.NoError(err)
+
+	s.Equal(NewStringValue("gopkg.in/src-d/proteus.v1/fixtures/foopb;foopb"), pkg.Options["go_package"])
+	s.Equal([]string{
+		"google/protobuf/timestamp.proto",
+		"gopkg.in/src-d/proteus.v1/fixtures/subpkg/generated.proto",
+	}, pkg.Imports)
+
+	assertNoGogo := func(opts Options) {
+		for name := range opts {
+			s.False(strings.HasPrefix(name, "(gogoproto."), "option %s should be removed", name)
+		}
+	}
+
+	assertNoGogo(pkg.Options)
+	for _, m := range pkg.Messages {
+		assertNoGogo(m.Options)
+		for _, f := range m.Fields {
+			assertNoGogo(f.Options)
+			s.NotEmpty(f.GoName)
+		}
+	}
+
+	for _, e := range pkg.Enums {
+		assertNoGogo(e.Options)
+		for _, v := range e.Values {
+			assertNoGogo(v.Options)
+		}
+	}
+}
+
+func TestGolangPackage(t *testing.T) {
+	path, name := GolangPackage("example.com/users", "users")
+	require.Equal(t, "example.com/users/userspb", path)
+	require.Equal(t, "userspb", name)
+}
+
 func hasString(str string, coll []string) bool {
 	for _, s := range coll {
 		if s == str {
@@ -814,7 +1598,7 @@ func (s *TransformerSuite) assertFieldToField(expected, actual *Field, name stri
 	s.Equal(expected.Options, actual.Options, fmt.Sprintf("Options in %s", name))
 }
 
-func (s *TransformerSuite) assertEnumVal(v *EnumValue, name string, val uint, doc string) {
+func (s *TransformerSuite) assertEnumVal(v *EnumValue, name string, val int32, doc string) {
 	s.Equal(name, v.Name)
 	s.Equal(val, v.Value)
 	s.Equal(doc, strings.Join(v.Docs, "\n"))
@@ -847,8 +1631,15 @@ func nullable(t scanner.Type) scanner.Type {
 
 func mkEnumVal(doc, name string) *scanner.EnumValue {
 	return &scanner.EnumValue{
-		mkDocs(doc),
-		name,
+		Docs: mkDocs(doc),
+		Name: name,
+	}
+}
+
+func mkIntEnumVal(name string, val int64) *scanner.EnumValue {
+	return &scanner.EnumValue{
+		Name:  name,
+		Value: constant.MakeInt64(val),
 	}
 }
 
@@ -0,0 +1,135 @@
+package proteus
+
+import (
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/proteus.v1/loader"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// protocMappings returns the mappings of the Go types generated by protoc of
+// packages that are not scanned that the given packages refer to. They are
+// mapped to the messages and enums they were generated for, importing the
+// .proto files that declare them instead of generating them again. Types
+// that already have one of the mappings of the given options, that are
+// allowed as custom types by them or that belong to the standard library are
+// left as they are.
+func protocMappings(pkgs []*scanner.Package, options Options) protobuf.TypeMappings {
+	var (
+		allowed  = make(map[string]bool)
+		external = make(map[string]map[string]bool)
+		visit    func(scanner.Type)
+	)
+	for _, p := range pkgs {
+		allowed[p.Path] = true
+	}
+	for _, p := range options.CustomPackages {
+		allowed[p] = true
+	}
+	for _, t := range options.CustomTypes {
+		allowed[t] = true
+	}
+
+	visit = func(typ scanner.Type) {
+		switch t := typ.(type) {
+		case *scanner.Named:
+			name := t.String()
+			if t.Path == "" || allowed[t.Path] || allowed[name] ||
+				options.Mappings[name] != nil || protobuf.DefaultMappings[name] != nil ||
+				loader.IsStandard(t.Path) {
+				return
+			}
+
+			if external[t.Path] == nil {
+				external[t.Path] = make(map[string]bool)
+			}
+			external[t.Path][t.Name] = true
+		case *scanner.Map:
+			visit(t.Key)
+			visit(t.Value)
+		case *scanner.Anonymous:
+			for _, f := range t.Struct.Fields {
+				visit(f.Type)
+			}
+		}
+	}
+
+	for _, p := range pkgs {
+		for _, s := range p.Structs {
+			for _, f := range s.Fields {
+				visit(f.Type)
+			}
+		}
+
+		for _, f := range p.Funcs {
+			for _, typ := range append(f.Input, f.Output...) {
+				visit(typ)
+			}
+		}
+	}
+
+	if len(external) == 0 {
+		return nil
+	}
+
+	var paths = make([]string, 0, len(external))
+	for path := range external {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var (
+		l      = loader.New()
+		result = make(protobuf.TypeMappings)
+	)
+	for _, path := range paths {
+		// Packages without files generated by protoc have no types, which
+		// are reported by the resolver if they are not allowed.
+		types, err := l.ProtocTypes(path)
+		if err != nil {
+			report.Warn("unable to find the types generated by protoc of package %s: %s", path, err)
+			continue
+		}
+
+		for name := range external[path] {
+			if typ, ok := types[name]; ok {
+				result[path+"."+name] = protocMapping(path, typ)
+			}
+		}
+	}
+
+	return result
+}
+
+// protocMapping returns the mapping of the Go type generated by protoc in
+// the package with the given path for the given type.
+func protocMapping(path string, typ *loader.ProtocType) *protobuf.ProtoType {
+	var (
+		pkg  = typ.File.GetPackage()
+		name = typ.Name
+	)
+	if pkg != "" {
+		name = strings.TrimPrefix(name, pkg+".")
+	}
+
+	mapping := &protobuf.ProtoType{
+		Package:  pkg,
+		Name:     name,
+		Import:   typ.File.GetName(),
+		GoImport: path,
+		File:     typ.File,
+	}
+
+	// Enums are never nullable, but the Go types of enums of packages that
+	// are not scanned are not known to be enums.
+	if typ.Enum {
+		mapping.Decorators = protobuf.NewDecorators(func(p *protobuf.Package, m *protobuf.Message, f *protobuf.Field) {
+			delete(f.Options, "(gogoproto.nullable)")
+		})
+	}
+
+	return mapping
+}
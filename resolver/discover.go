@@ -0,0 +1,199 @@
+package resolver
+
+import (
+	"strings"
+
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// PackageScanner scans the package with the given import path.
+type PackageScanner func(path string) (*scanner.Package, error)
+
+// SetDiscovery enables the discovery of the packages that are referenced by
+// the types of the scanned packages, which are scanned with the given
+// function. Only the packages whose import path starts with one of the given
+// prefixes are discovered. See Discover.
+func (r *Resolver) SetDiscovery(scan PackageScanner, prefixes ...string) {
+	r.scan = scan
+	r.discoverPrefixes = prefixes
+}
+
+// Discover returns the given packages along with the packages referenced by
+// their types, transitively, if discovery is enabled. Discovered packages
+// have no funcs and none of their structs is marked for generation, so only
+// the types reached from the given packages are generated when they are
+// resolved.
+func (r *Resolver) Discover(pkgs []*scanner.Package) ([]*scanner.Package, error) {
+	if r.scan == nil {
+		return pkgs, nil
+	}
+
+	d := &discovery{
+		r:       r,
+		pkgs:    make(map[string]*scanner.Package),
+		visited: make(map[string]struct{}),
+		result:  pkgs,
+	}
+
+	for _, p := range pkgs {
+		d.pkgs[p.Path] = p
+	}
+
+	for _, p := range pkgs {
+		if err := d.visitPackage(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.result, nil
+}
+
+func (r *Resolver) canDiscover(path string) bool {
+	for _, prefix := range r.discoverPrefixes {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type discovery struct {
+	r       *Resolver
+	pkgs    map[string]*scanner.Package
+	visited map[string]struct{}
+	result  []*scanner.Package
+}
+
+func (d *discovery) visitPackage(p *scanner.Package) error {
+	for _, s := range p.Structs {
+		if err := d.visitStruct(s); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range p.Funcs {
+		if err := d.visitList(f.Input); err != nil {
+			return err
+		}
+
+		if err := d.visitList(f.Output); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range p.Aliases {
+		if err := d.visit(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *discovery) visitList(types []scanner.Type) error {
+	for _, t := range types {
+		if err := d.visit(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *discovery) visitStruct(s *scanner.Struct) error {
+	for _, f := range s.Fields {
+		if err := d.visit(f.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *discovery) visit(typ scanner.Type) error {
+	switch t := typ.(type) {
+	case *scanner.Map:
+		if err := d.visit(t.Key); err != nil {
+			return err
+		}
+		return d.visit(t.Value)
+	case *scanner.Anonymous:
+		return d.visitStruct(t.Struct)
+	case *scanner.Named:
+		return d.visitNamed(t)
+	}
+	return nil
+}
+
+// visitNamed discovers the package of the given type if it has not been
+// scanned, and visits the type itself if its package was discovered, as the
+// packages given are visited entirely.
+func (d *discovery) visitNamed(n *scanner.Named) error {
+	name := n.String()
+	if _, ok := d.visited[name]; ok {
+		return nil
+	}
+	d.visited[name] = struct{}{}
+
+	if d.r.isCustomType(n) || d.r.isExternalType(n) || scanner.IsSQLNull(n) {
+		return nil
+	}
+
+	pkg, ok := d.pkgs[n.Path]
+	if !ok {
+		if !d.r.canDiscover(n.Path) {
+			return nil
+		}
+
+		var err error
+		pkg, err = d.discover(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !pkg.Discovered {
+		return nil
+	}
+
+	for _, s := range pkg.Structs {
+		if s.Name == n.Name {
+			return d.visitStruct(s)
+		}
+	}
+
+	for _, i := range pkg.Interfaces {
+		if i.Name == n.Name {
+			for _, impl := range i.Implementations {
+				if err := d.visit(impl); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	if alias, ok := pkg.Aliases[name]; ok {
+		return d.visit(alias)
+	}
+
+	return nil
+}
+
+func (d *discovery) discover(n *scanner.Named) (*scanner.Package, error) {
+	pkg, err := d.r.scan(n.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Info("package %s was discovered because type %s is referenced", n.Path, n.String())
+
+	pkg.Discovered = true
+	pkg.Funcs = nil
+	for _, s := range pkg.Structs {
+		s.Generate = false
+	}
+
+	d.pkgs[n.Path] = pkg
+	d.result = append(d.result, pkg)
+	return pkg, nil
+}
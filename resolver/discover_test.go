@@ -0,0 +1,155 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+func TestDiscover(t *testing.T) {
+	report.TestMode()
+	defer report.EndTestMode()
+
+	sc, err := scanner.New(projectPath("fixtures"))
+	require.Nil(t, err)
+	pkgs, err := sc.Scan()
+	require.Nil(t, err)
+	require.Len(t, pkgs, 1)
+
+	r := New()
+	r.SetDiscovery(sc.ScanPackage, project+"/fixtures")
+	pkgs, err = r.Discover(pkgs)
+	require.Nil(t, err)
+	require.Len(t, pkgs, 2)
+
+	subpkg := pkgs[1]
+	require.Equal(t, projectPath("fixtures/subpkg"), subpkg.Path)
+	require.True(t, subpkg.Discovered)
+	require.Len(t, subpkg.Funcs, 0, "funcs of discovered packages are not generated")
+	require.Len(t, subpkg.Structs, 3)
+	for _, s := range subpkg.Structs {
+		require.False(t, s.Generate, "struct %s is not marked for generation", s.Name)
+	}
+
+	r.Resolve(pkgs)
+	require.True(t, subpkg.Resolved)
+	require.Len(t, subpkg.Structs, 1, "only the referenced struct is kept")
+	require.Equal(t, "Point", subpkg.Structs[0].Name)
+}
+
+func TestDiscoverDisabled(t *testing.T) {
+	pkgs := []*scanner.Package{fakePackage("a", "a", "b.B")}
+	result, err := New().Discover(pkgs)
+	require.Nil(t, err)
+	require.Equal(t, pkgs, result)
+}
+
+func TestDiscoverTransitive(t *testing.T) {
+	report.TestMode()
+	defer report.EndTestMode()
+
+	fakes := map[string]*scanner.Package{
+		"example.com/b":     fakePackage("example.com/b", "B", "example.com/c.C"),
+		"example.com/c":     fakePackage("example.com/c", "C", "other.com/d.D"),
+		"example.com/e":     fakePackage("example.com/e", "E", "example.com/c.C"),
+		"example.com/notme": fakePackage("example.com/notme", "X"),
+	}
+
+	var scanned []string
+	r := New()
+	r.SetDiscovery(func(path string) (*scanner.Package, error) {
+		scanned = append(scanned, path)
+		if p, ok := fakes[path]; ok {
+			return p, nil
+		}
+		return nil, fmt.Errorf("package %s not found", path)
+	}, "example.com/")
+
+	a := fakePackage("example.com/a", "A", "example.com/b.B", "other.com/d.D")
+	a.Structs[0].Generate = true
+	a.Structs = append(a.Structs, &scanner.Struct{Name: "Unreferenced"})
+	pkgs, err := r.Discover([]*scanner.Package{a})
+	require.Nil(t, err)
+	require.Equal(t, []string{"example.com/b", "example.com/c"}, scanned)
+	require.Len(t, pkgs, 3)
+
+	r.Resolve(pkgs)
+	require.Len(t, pkgs[1].Structs, 1)
+	require.Len(t, pkgs[2].Structs, 1)
+	require.Len(t, pkgs[2].Structs[0].Fields, 0, "type of an undiscovered package is dropped")
+}
+
+func TestDiscoverUnreachedStructs(t *testing.T) {
+	report.TestMode()
+	defer report.EndTestMode()
+
+	b := fakePackage("example.com/b", "B")
+	unreached := fakePackage("example.com/b", "Unreached", "example.com/b.Leaf").Structs[0]
+	b.Structs = append(b.Structs, unreached, &scanner.Struct{Name: "Leaf"})
+	b.Enums = []*scanner.Enum{enum("Kind", "X"), enum("Unused", "Y")}
+	b.Structs[0].Fields = append(b.Structs[0].Fields, &scanner.Field{
+		Name: "Kind",
+		Type: scanner.NewNamed("example.com/b", "Kind"),
+	})
+
+	r := New()
+	r.SetDiscovery(func(path string) (*scanner.Package, error) {
+		return b, nil
+	}, "example.com/b")
+
+	a := fakePackage("example.com/a", "A", "example.com/b.B")
+	a.Structs[0].Generate = true
+	pkgs, err := r.Discover([]*scanner.Package{a})
+	require.Nil(t, err)
+
+	r.Resolve(pkgs)
+	require.Len(t, b.Structs, 1, "structs referenced by unreached structs are not kept")
+	require.Equal(t, "B", b.Structs[0].Name)
+	require.Len(t, b.Enums, 1, "unreferenced enums are not kept")
+	require.Equal(t, "Kind", b.Enums[0].Name)
+}
+
+func TestCanDiscover(t *testing.T) {
+	r := New()
+	r.SetDiscovery(nil, "example.com/foo", "example.com/bar/")
+
+	cases := []struct {
+		path   string
+		result bool
+	}{
+		{"example.com/foo", true},
+		{"example.com/foo/baz", true},
+		{"example.com/foobar", false},
+		{"example.com/bar", false},
+		{"example.com/bar/baz", true},
+		{"example.com", false},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.result, r.canDiscover(c.path), c.path)
+	}
+}
+
+// fakePackage returns a package with a struct with the given name, whose
+// fields are of the given types in the form "path.Name".
+func fakePackage(path, name string, types ...string) *scanner.Package {
+	s := &scanner.Struct{Name: name}
+	for i, typ := range types {
+		idx := strings.LastIndex(typ, ".")
+		s.Fields = append(s.Fields, &scanner.Field{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: scanner.NewNamed(typ[:idx], typ[idx+1:]),
+		})
+	}
+
+	return &scanner.Package{
+		Path:    path,
+		Name:    name,
+		Structs: []*scanner.Struct{s},
+		Aliases: make(map[string]scanner.Type),
+	}
+}
@@ -0,0 +1,69 @@
+package resolver
+
+import (
+	"fmt"
+
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// resolveEnums marks the enums used by the structs and funcs that are kept
+// and removes the ones that are neither used nor opted-in with the
+// proteus:generate comment. Enums of discovered packages are only kept if
+// they are used. A warning is reported for every enum that is generated only
+// because it is used, with the first struct field or func using it.
+func (r *Resolver) resolveEnums(pkgs []*scanner.Package, info *packagesInfo) {
+	for _, p := range pkgs {
+		for _, s := range p.Structs {
+			for _, f := range s.Fields {
+				markEnums(f.Type, fmt.Sprintf("field %s of struct %s", f.Name, s.Name), info)
+			}
+		}
+
+		for _, f := range p.Funcs {
+			usedBy := fmt.Sprintf("func %s", f.Name)
+			for _, t := range f.Input {
+				markEnums(t, usedBy, info)
+			}
+
+			for _, t := range f.Output {
+				markEnums(t, usedBy, info)
+			}
+		}
+	}
+
+	for _, p := range pkgs {
+		var enums = make([]*scanner.Enum, 0, len(p.Enums))
+		for _, e := range p.Enums {
+			usedBy := info.enumUser(fmt.Sprintf("%s.%s", p.Path, e.Name))
+			if e.Generate && !p.Discovered {
+				enums = append(enums, e)
+				continue
+			}
+
+			if usedBy != "" {
+				report.Warn("enum %s of package %s will be generated because it is used by %s", e.Name, p.Path, usedBy)
+				enums = append(enums, e)
+			}
+		}
+		p.Enums = enums
+	}
+}
+
+func markEnums(typ scanner.Type, usedBy string, info *packagesInfo) {
+	switch t := typ.(type) {
+	case *scanner.Named:
+		if info.isEnum(t.String()) {
+			info.markEnum(t.String(), usedBy)
+		}
+	case *scanner.Alias:
+		markEnums(t.Underlying, usedBy, info)
+	case *scanner.Map:
+		markEnums(t.Key, usedBy, info)
+		markEnums(t.Value, usedBy, info)
+	case *scanner.Anonymous:
+		for _, f := range t.Struct.Fields {
+			markEnums(f.Type, usedBy, info)
+		}
+	}
+}
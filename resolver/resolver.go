@@ -16,6 +16,14 @@ import (
 // type `int`.
 type Resolver struct {
 	customTypes map[string]struct{}
+	// externalTypes and externalPackages are the types and packages
+	// registered by the user, which are only allowed if they have a mapping.
+	externalTypes    map[string]struct{}
+	externalPackages map[string]struct{}
+	hasMapping       func(string) bool
+
+	scan             PackageScanner
+	discoverPrefixes []string
 }
 
 // New creates a new Resolver with the default custom types registered.
@@ -28,19 +36,88 @@ func New() *Resolver {
 			"time.Duration":   {},
 			"context.Context": {},
 			"error":           {},
+
+			"encoding/json.RawMessage":     {},
+			"encoding/json/jsontext.Value": {},
 		},
+		externalTypes:    make(map[string]struct{}),
+		externalPackages: make(map[string]struct{}),
+	}
+}
+
+// AddCustomTypes registers the given types as custom types, so they are
+// considered correct even though their packages are not in any of the
+// packages given. Types are given by their full name, e.g.
+// "github.com/google/uuid.UUID". If a mapping checker is set, types without a
+// mapping are ignored anyway.
+func (r *Resolver) AddCustomTypes(names ...string) {
+	for _, name := range names {
+		r.externalTypes[name] = struct{}{}
 	}
 }
 
+// AddCustomPackages registers all the types of the given packages as custom
+// types, see AddCustomTypes. Packages are given by their import path.
+func (r *Resolver) AddCustomPackages(paths ...string) {
+	for _, path := range paths {
+		r.externalPackages[path] = struct{}{}
+	}
+}
+
+// SetMappingChecker sets the function reporting whether the Go type with the
+// given full name has a mapping to a protobuf type. When set, the types
+// registered with AddCustomTypes and AddCustomPackages are only allowed if
+// they have a mapping, as they would be dropped later otherwise.
+func (r *Resolver) SetMappingChecker(hasMapping func(name string) bool) {
+	r.hasMapping = hasMapping
+}
+
 // Resolve checks the types of all the packages passed in a global manner.
 // Also, it sets to `true` the `Resolved` field of the package, meaning that
-// they can be safely used after it.
+// they can be safely used after it. Discovered packages are resolved after
+// the rest, and only their structs reached from the other packages are kept.
+// Enums are kept if they are opted-in or used by the structs and funcs kept.
 func (r *Resolver) Resolve(pkgs []*scanner.Package) {
 	info := getPackagesInfo(pkgs)
 
+	var discovered []*scanner.Package
 	for _, p := range pkgs {
+		if p.Discovered {
+			discovered = append(discovered, p)
+			continue
+		}
 		r.resolvePackage(p, info)
 	}
+
+	r.resolveDiscovered(discovered, info)
+	r.resolveEnums(pkgs, info)
+}
+
+// resolveDiscovered resolves the structs of the discovered packages that are
+// marked for generation until no more structs are marked, so the structs
+// that are not reached do not mark the types they refer to.
+func (r *Resolver) resolveDiscovered(pkgs []*scanner.Package, info *packagesInfo) {
+	var resolved = make(map[*scanner.Struct]struct{})
+	for changed := true; changed; {
+		changed = false
+		for _, p := range pkgs {
+			for _, s := range p.Structs {
+				if _, ok := resolved[s]; ok || !info.isStructMarked(p.Path+"."+s.Name) {
+					continue
+				}
+
+				resolved[s] = struct{}{}
+				r.resolveStruct(s, info)
+				changed = true
+			}
+		}
+	}
+
+	for _, p := range pkgs {
+		p.Funcs = nil
+		r.removeUnmarkedStructs(p, info)
+		p.Resolved = true
+	}
 }
 
 func (r *Resolver) isCustomType(n *scanner.Named) bool {
@@ -48,6 +125,15 @@ func (r *Resolver) isCustomType(n *scanner.Named) bool {
 	return ok
 }
 
+func (r *Resolver) isExternalType(n *scanner.Named) bool {
+	if _, ok := r.externalTypes[n.String()]; ok {
+		return true
+	}
+
+	_, ok := r.externalPackages[n.Path]
+	return ok
+}
+
 func (r *Resolver) resolvePackage(p *scanner.Package, info *packagesInfo) {
 	for _, s := range p.Structs {
 		r.resolveStruct(s, info)
@@ -84,6 +170,11 @@ func (r *Resolver) resolveFunc(f *scanner.Func, info *packagesInfo) bool {
 func (r *Resolver) resolveTypeList(types []scanner.Type, info *packagesInfo) []scanner.Type {
 	var result = make([]scanner.Type, 0, len(types))
 	for _, t := range types {
+		if hasAnonymous(t) {
+			report.Warn("anonymous struct type %s can only be used as the type of a struct field, it will be ignored", t)
+			return nil
+		}
+
 		typ := r.resolveType(t, info)
 		if typ == nil {
 			return nil
@@ -108,6 +199,24 @@ func (r *Resolver) resolveStruct(s *scanner.Struct, info *packagesInfo) {
 	var result = make([]*scanner.Field, 0, len(s.Fields))
 
 	for _, f := range s.Fields {
+		if iface := info.interfaceOf(f.Type); iface != nil {
+			if f.Type.IsRepeated() || f.Type.IsNullable() {
+				report.Warn("field %q of struct %q can only be of the interface type %q, not a slice or pointer of it, it will be ignored", f.Name, s.Name, iface.Name)
+				continue
+			}
+
+			for _, impl := range iface.Implementations {
+				info.markStruct(impl.String())
+			}
+			result = append(result, f)
+			continue
+		}
+
+		if scanner.IsSQLNull(f.Type) {
+			result = append(result, f)
+			continue
+		}
+
 		if typ := r.resolveType(f.Type, info); typ != nil {
 			f.Type = typ
 			result = append(result, f)
@@ -125,10 +234,23 @@ func (r *Resolver) resolveType(typ scanner.Type, info *packagesInfo) (result sca
 		}
 
 		if !info.hasPackage(t.Path) {
+			if r.isExternalType(t) {
+				if r.hasMapping != nil && !r.hasMapping(t.String()) {
+					report.Warn("type %q of package %s will be ignored because it has no mapping to a protobuf type.", t.Name, t.Path)
+					return nil
+				}
+				return t
+			}
+
 			report.Warn("type %q of package %s will be ignored because it was not present on the scan path.", t.Name, t.Path)
 			return nil
 		}
 
+		if info.interfaceOf(t) != nil {
+			report.Warn("interface type %q of package %s can only be used as the type of a struct field, it will be ignored", t.Name, t.Path)
+			return nil
+		}
+
 		alias := info.aliasOf(t)
 		if alias != nil {
 			if alias.IsRepeated() && t.IsRepeated() {
@@ -154,22 +276,42 @@ func (r *Resolver) resolveType(typ scanner.Type, info *packagesInfo) (result sca
 		t.Key = r.resolveType(t.Key, info)
 		t.Value = r.resolveType(t.Value, info)
 		result = t
+	case *scanner.Anonymous:
+		r.resolveStruct(t.Struct, info)
+		result = t
 	}
 
 	return
 }
 
+// hasAnonymous reports whether the given type is an anonymous struct or a map
+// whose values are anonymous structs.
+func hasAnonymous(typ scanner.Type) bool {
+	switch t := typ.(type) {
+	case *scanner.Anonymous:
+		return true
+	case *scanner.Map:
+		return hasAnonymous(t.Value)
+	}
+	return false
+}
+
 // getPackagesInfo retrieves some information about a list of packages like the
 // aliases in all of them combined and the paths of all the packages.
 // Note that enums are removed from the aliases as we do not want to
 // think of them as aliases but as named types instead.
 func getPackagesInfo(pkgs []*scanner.Package) *packagesInfo {
 	result := &packagesInfo{
-		aliases:  make(map[string]scanner.Type),
-		packages: make(map[string]struct{}),
-		structs:  make(map[string]bool),
+		aliases:    make(map[string]scanner.Type),
+		packages:   make(map[string]struct{}),
+		structs:    make(map[string]bool),
+		enums:      make(map[string]string),
+		interfaces: make(map[string]*scanner.Interface),
 	}
 	enums := packagesEnums(pkgs)
+	for e := range enums {
+		result.enums[e] = ""
+	}
 
 	for _, p := range pkgs {
 		result.packages[p.Path] = struct{}{}
@@ -182,6 +324,10 @@ func getPackagesInfo(pkgs []*scanner.Package) *packagesInfo {
 		for _, s := range p.Structs {
 			result.structs[fmt.Sprintf("%s.%s", p.Path, s.Name)] = s.Generate
 		}
+
+		for _, i := range p.Interfaces {
+			result.interfaces[fmt.Sprintf("%s.%s", p.Path, i.Name)] = i
+		}
 	}
 
 	return result
@@ -205,6 +351,9 @@ type packagesInfo struct {
 	aliases  map[string]scanner.Type
 	packages map[string]struct{}
 	structs  map[string]bool
+	// enums are the first struct field or func using every enum, if any.
+	enums      map[string]string
+	interfaces map[string]*scanner.Interface
 }
 
 // aliasOf returns the alias of a given named type or nil if there is
@@ -217,6 +366,16 @@ func (i *packagesInfo) aliasOf(named *scanner.Named) scanner.Type {
 	return alias
 }
 
+// interfaceOf returns the interface to be generated of the given type, or nil
+// if it is not one.
+func (i *packagesInfo) interfaceOf(typ scanner.Type) *scanner.Interface {
+	named, ok := typ.(*scanner.Named)
+	if !ok {
+		return nil
+	}
+	return i.interfaces[named.String()]
+}
+
 func (i *packagesInfo) isStruct(name string) bool {
 	_, ok := i.structs[name]
 	return ok
@@ -230,6 +389,21 @@ func (i *packagesInfo) isStructMarked(name string) bool {
 	return i.structs[name]
 }
 
+func (i *packagesInfo) isEnum(name string) bool {
+	_, ok := i.enums[name]
+	return ok
+}
+
+func (i *packagesInfo) markEnum(name, usedBy string) {
+	if i.enums[name] == "" {
+		i.enums[name] = usedBy
+	}
+}
+
+func (i *packagesInfo) enumUser(name string) string {
+	return i.enums[name]
+}
+
 func (i *packagesInfo) hasPackage(path string) bool {
 	_, ok := i.packages[path]
 	return ok
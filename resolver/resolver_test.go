@@ -100,10 +100,51 @@ func (s *ResolverSuite) TestIsCustomType() {
 	}
 
 	for _, c := range cases {
-		s.Equal(c.result, s.r.isCustomType(&scanner.Named{nil, c.path, c.name}), "%s.%s", c.path, c.name)
+		s.Equal(c.result, s.r.isCustomType(&scanner.Named{Path: c.path, Name: c.name}), "%s.%s", c.path, c.name)
 	}
 }
 
+func (s *ResolverSuite) TestAddCustomTypes() {
+	r := New()
+	uuid := &scanner.Named{Path: "github.com/google/uuid", Name: "UUID"}
+	s.Nil(r.resolveType(uuid, &packagesInfo{}))
+
+	r.AddCustomTypes("github.com/google/uuid.UUID")
+	s.Equal(uuid, r.resolveType(uuid, &packagesInfo{}))
+}
+
+func (s *ResolverSuite) TestAddCustomPackages() {
+	r := New()
+	dec := &scanner.Named{Path: "github.com/shopspring/decimal", Name: "Decimal"}
+	s.Nil(r.resolveType(dec, &packagesInfo{}))
+
+	r.AddCustomPackages("github.com/shopspring/decimal")
+	s.Equal(dec, r.resolveType(dec, &packagesInfo{}))
+	s.Nil(r.resolveType(&scanner.Named{Path: "github.com/shopspring", Name: "Decimal"}, &packagesInfo{}))
+}
+
+func (s *ResolverSuite) TestMappingChecker() {
+	report.TestMode()
+	defer report.EndTestMode()
+
+	r := New()
+	r.AddCustomPackages("github.com/google/uuid")
+	r.SetMappingChecker(func(name string) bool {
+		return name == "github.com/google/uuid.UUID"
+	})
+
+	uuid := &scanner.Named{Path: "github.com/google/uuid", Name: "UUID"}
+	s.Equal(uuid, r.resolveType(uuid, &packagesInfo{}))
+	s.Len(report.MessageStack(), 0)
+
+	s.Nil(r.resolveType(&scanner.Named{Path: "github.com/google/uuid", Name: "NullUUID"}, &packagesInfo{}))
+	s.Len(report.MessageStack(), 1)
+	s.True(strings.HasSuffix(report.MessageStack()[0], "has no mapping to a protobuf type."))
+
+	t := scanner.NewNamed("time", "Time")
+	s.Equal(t, r.resolveType(t, &packagesInfo{}), "default custom types are not checked")
+}
+
 func (s *ResolverSuite) TestNotInScanPathWarning() {
 	report.TestMode()
 
@@ -239,6 +280,162 @@ func (s *ResolverSuite) TestResolve() {
 	}, findFuncByName("Name", pkgs[1].Funcs))
 }
 
+func (s *ResolverSuite) TestResolveInterfaceFields() {
+	circle := scanner.NewNamed("shapes", "Circle").(*scanner.Named)
+	circle.SetNullable(true)
+	square := scanner.NewNamed("shapes", "Square").(*scanner.Named)
+
+	shapes := func() scanner.Type { return scanner.NewNamed("shapes", "Shape") }
+	repeated := shapes()
+	repeated.SetRepeated(true)
+
+	pkg := &scanner.Package{
+		Path:    "shapes",
+		Aliases: map[string]scanner.Type{},
+		Structs: []*scanner.Struct{
+			{Name: "Circle"},
+			{Name: "Square"},
+			{
+				Name:     "Drawing",
+				Generate: true,
+				Fields: []*scanner.Field{
+					{Name: "Shape", Type: shapes()},
+					{Name: "Shapes", Type: repeated},
+				},
+			},
+		},
+		Funcs: []*scanner.Func{
+			{Name: "Draw", Input: []scanner.Type{shapes()}},
+		},
+		Interfaces: []*scanner.Interface{
+			{Name: "Shape", Implementations: []*scanner.Named{circle, square}},
+		},
+	}
+
+	report.TestMode()
+	s.r.Resolve([]*scanner.Package{pkg})
+	report.EndTestMode()
+
+	s.Len(pkg.Structs, 3, "implementations are required by the interface")
+	s.assertStruct(pkg.Structs[2], "Drawing", "Shape")
+	s.Len(pkg.Funcs, 0, "interfaces are not valid outside struct fields")
+}
+
+func (s *ResolverSuite) TestResolveAnonymousStructs() {
+	meta := scanner.NewAnonymous(&scanner.Struct{
+		Fields: []*scanner.Field{
+			{Name: "Author", Type: scanner.NewNamed("users", "Author")},
+			{Name: "Other", Type: scanner.NewNamed("other", "Other")},
+		},
+	})
+
+	pkg := &scanner.Package{
+		Path:    "users",
+		Aliases: map[string]scanner.Type{},
+		Structs: []*scanner.Struct{
+			{Name: "Author"},
+			{
+				Name:     "Post",
+				Generate: true,
+				Fields: []*scanner.Field{
+					{Name: "Meta", Type: meta},
+				},
+			},
+		},
+		Funcs: []*scanner.Func{
+			{Name: "Save", Input: []scanner.Type{scanner.NewMap(scanner.NewBasic("string"), meta)}},
+		},
+	}
+
+	report.TestMode()
+	s.r.Resolve([]*scanner.Package{pkg})
+	report.EndTestMode()
+
+	s.Len(pkg.Structs, 2, "structs are required by the anonymous struct")
+	s.assertStruct(pkg.Structs[1], "Post", "Meta")
+	s.assertStruct(meta.(*scanner.Anonymous).Struct, "", "Author")
+	s.Len(pkg.Funcs, 0, "anonymous structs are not valid outside struct fields")
+}
+
+func (s *ResolverSuite) TestResolveSQLNullTypes() {
+	pkg := &scanner.Package{
+		Path:    "users",
+		Aliases: map[string]scanner.Type{},
+		Structs: []*scanner.Struct{
+			{
+				Name:     "User",
+				Generate: true,
+				Fields: []*scanner.Field{
+					{Name: "Email", Type: scanner.NewNamed("database/sql", "NullString")},
+					{Name: "DB", Type: scanner.NewNamed("database/sql", "DB")},
+				},
+			},
+		},
+		Funcs: []*scanner.Func{
+			{Name: "Find", Input: []scanner.Type{scanner.NewNamed("database/sql", "NullString")}},
+		},
+	}
+
+	report.TestMode()
+	s.r.Resolve([]*scanner.Package{pkg})
+	report.EndTestMode()
+
+	s.assertStruct(pkg.Structs[0], "User", "Email")
+	s.Len(pkg.Funcs, 0, "sql null types are only allowed in struct fields")
+}
+
+func (s *ResolverSuite) TestResolveEnumsByRequirement() {
+	optedIn := enum("Color", "Red")
+	optedIn.Generate = true
+	pkg := &scanner.Package{
+		Path:    "shapes",
+		Aliases: map[string]scanner.Type{},
+		Enums: []*scanner.Enum{
+			optedIn,
+			enum("Shade", "Light"),
+			enum("Kind", "Circle"),
+			enum("Unit", "Cm"),
+			enum("Unused", "Foo"),
+			enum("Hidden", "Bar"),
+		},
+		Structs: []*scanner.Struct{
+			{
+				Name:     "Shape",
+				Generate: true,
+				Fields: []*scanner.Field{
+					{Name: "Shade", Type: scanner.NewNamed("shapes", "Shade")},
+					{Name: "Kinds", Type: repeated(scanner.NewNamed("shapes", "Kind"))},
+				},
+			},
+			{
+				Name: "NotUsed",
+				Fields: []*scanner.Field{
+					{Name: "Hidden", Type: scanner.NewNamed("shapes", "Hidden")},
+				},
+			},
+		},
+		Funcs: []*scanner.Func{
+			{Name: "Measure", Output: []scanner.Type{scanner.NewNamed("shapes", "Unit")}},
+		},
+	}
+
+	report.TestMode()
+	defer report.EndTestMode()
+	s.r.Resolve([]*scanner.Package{pkg})
+
+	var names []string
+	for _, e := range pkg.Enums {
+		names = append(names, e.Name)
+	}
+	s.Equal([]string{"Color", "Shade", "Kind", "Unit"}, names)
+
+	s.Equal([]string{
+		"WARN: enum Shade of package shapes will be generated because it is used by field Shade of struct Shape",
+		"WARN: enum Kind of package shapes will be generated because it is used by field Kinds of struct Shape",
+		"WARN: enum Unit of package shapes will be generated because it is used by func Measure",
+	}, report.MessageStack())
+}
+
 func (s *ResolverSuite) assertStruct(st *scanner.Struct, name string, fields ...string) {
 	s.Equal(name, st.Name, "struct name")
 	s.Equal(len(fields), len(st.Fields), "should have same struct fields")
@@ -289,3 +486,8 @@ func nullable(t scanner.Type) scanner.Type {
 func mkDocs(docs ...string) scanner.Docs {
 	return scanner.Docs{Doc: docs}
 }
+
+func repeated(t scanner.Type) scanner.Type {
+	t.SetRepeated(true)
+	return t
+}
@@ -0,0 +1,295 @@
+package rpc
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"gopkg.in/src-d/proteus.v1/loader"
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// stringEnum returns the Go type of the given protobuf type if it is an enum
+// whose underlying type is string, or nil otherwise. Values of these enums
+// need to be converted from and to the type declared in the generated
+// protobuf code for the enum.
+func (c *context) stringEnum(t protobuf.Type) *types.Named {
+	n, ok := t.(*protobuf.Named)
+	if !ok || n.Generated {
+		return nil
+	}
+
+	src, ok := n.Source().(*scanner.Named)
+	if !ok {
+		return nil
+	}
+
+	pkg := c.findPackage(src.Path)
+	if pkg == nil {
+		return nil
+	}
+
+	obj, ok := pkg.Scope().Lookup(src.Name).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+
+	return asStringEnum(obj.Type())
+}
+
+func (c *context) findPackage(path string) *types.Package {
+	if loader.ImportPath(c.pkg.Path()) == path {
+		return c.pkg
+	}
+
+	for _, imp := range c.pkg.Imports() {
+		if loader.ImportPath(imp.Path()) == path {
+			return imp
+		}
+	}
+
+	return nil
+}
+
+func asStringEnum(t types.Type) *types.Named {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil
+	}
+
+	if b, ok := named.Underlying().(*types.Basic); ok && b.Kind() == types.String {
+		return named
+	}
+
+	return nil
+}
+
+// enumFunc returns the name of the function that converts the values of the
+// given string enum in the given direction, qualified with its package if
+// it is not the one being generated.
+func (c *context) enumFunc(enum *types.Named, repeated bool, direction string) string {
+	name := enumFuncName(enum.Obj().Name(), repeated, direction)
+	pkg := enum.Obj().Pkg()
+	if pkg.Path() == c.pkg.Path() {
+		return name
+	}
+
+	c.addImport(pkg.Path())
+	return fmt.Sprintf("%s.%s", pkg.Name(), name)
+}
+
+func enumFuncName(enum string, repeated bool, direction string) string {
+	if repeated {
+		return fmt.Sprintf("%sSlice%s", enum, direction)
+	}
+	return fmt.Sprintf("%s%s", enum, direction)
+}
+
+const (
+	toProto   = "ToProto"
+	fromProto = "FromProto"
+)
+
+// declStringEnumFuncs declares the functions that convert between the
+// values of all the string enums of the package and the values of the types
+// declared for them in the generated protobuf code.
+func (g *Generator) declStringEnumFuncs(ctx *context) (decls []ast.Decl) {
+	for _, e := range ctx.proto.Enums {
+		obj, ok := ctx.pkg.Scope().Lookup(e.Name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+
+		enum := asStringEnum(obj.Type())
+		if enum == nil {
+			continue
+		}
+
+		consts := enumConsts(ctx.pkg, enum)
+		decls = append(
+			decls,
+			g.declEnumToProto(enum, consts),
+			g.declEnumFromProto(enum, consts),
+			g.declEnumSliceConversion(enum, toProto),
+			g.declEnumSliceConversion(enum, fromProto),
+		)
+	}
+	return
+}
+
+// enumConsts returns the exported constants of the given enum in the order
+// in which they were declared, skipping the ones whose value is the same as
+// the one of a previous constant.
+func enumConsts(pkg *types.Package, enum *types.Named) []*types.Const {
+	var consts []*types.Const
+	for _, n := range pkg.Scope().Names() {
+		c, ok := pkg.Scope().Lookup(n).(*types.Const)
+		if ok && c.Exported() && types.Identical(c.Type(), enum) {
+			consts = append(consts, c)
+		}
+	}
+
+	sort.Slice(consts, func(i, j int) bool {
+		return consts[i].Pos() < consts[j].Pos()
+	})
+
+	var (
+		result []*types.Const
+		seen   = make(map[string]struct{})
+	)
+	for _, c := range consts {
+		val := constant.StringVal(c.Val())
+		if _, ok := seen[val]; !ok {
+			seen[val] = struct{}{}
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+func (g *Generator) declEnumToProto(enum *types.Named, consts []*types.Const) ast.Decl {
+	name := enum.Obj().Name()
+	protoName := protobuf.StringEnumTypeName(name)
+
+	var cases []ast.Stmt
+	for _, c := range consts {
+		cases = append(cases, caseReturn(
+			ast.NewIdent(c.Name()),
+			ast.NewIdent(fmt.Sprintf("%s_%s", protoName, c.Name())),
+		))
+	}
+
+	return enumConversionFunc(
+		enumFuncName(name, false, toProto),
+		ast.NewIdent(name),
+		ast.NewIdent(protoName),
+		cases,
+		&ast.BasicLit{Kind: token.INT, Value: "0"},
+	)
+}
+
+func (g *Generator) declEnumFromProto(enum *types.Named, consts []*types.Const) ast.Decl {
+	name := enum.Obj().Name()
+	protoName := protobuf.StringEnumTypeName(name)
+
+	var cases []ast.Stmt
+	for _, c := range consts {
+		cases = append(cases, caseReturn(
+			ast.NewIdent(fmt.Sprintf("%s_%s", protoName, c.Name())),
+			ast.NewIdent(c.Name()),
+		))
+	}
+
+	return enumConversionFunc(
+		enumFuncName(name, false, fromProto),
+		ast.NewIdent(protoName),
+		ast.NewIdent(name),
+		cases,
+		&ast.BasicLit{Kind: token.STRING, Value: `""`},
+	)
+}
+
+func enumConversionFunc(name string, in, out ast.Expr, cases []ast.Stmt, zero ast.Expr) ast.Decl {
+	return &ast.FuncDecl{
+		Name: ast.NewIdent(name),
+		Type: &ast.FuncType{
+			Params:  fields(field("v", in)),
+			Results: fields(&ast.Field{Type: out}),
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.SwitchStmt{
+					Tag:  ast.NewIdent("v"),
+					Body: &ast.BlockStmt{List: cases},
+				},
+				&ast.ReturnStmt{Results: []ast.Expr{zero}},
+			},
+		},
+	}
+}
+
+func caseReturn(value, result ast.Expr) ast.Stmt {
+	return &ast.CaseClause{
+		List: []ast.Expr{value},
+		Body: []ast.Stmt{
+			&ast.ReturnStmt{Results: []ast.Expr{result}},
+		},
+	}
+}
+
+// declEnumSliceConversion declares a function that converts a slice of enum
+// values in the given direction using the function that converts a single
+// value.
+func (g *Generator) declEnumSliceConversion(enum *types.Named, direction string) ast.Decl {
+	name := enum.Obj().Name()
+	var in, out ast.Expr = ast.NewIdent(name), ast.NewIdent(protobuf.StringEnumTypeName(name))
+	if direction == fromProto {
+		in, out = out, in
+	}
+
+	return &ast.FuncDecl{
+		Name: ast.NewIdent(enumFuncName(name, true, direction)),
+		Type: &ast.FuncType{
+			Params:  fields(field("vs", &ast.ArrayType{Elt: in})),
+			Results: fields(&ast.Field{Type: &ast.ArrayType{Elt: out}}),
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.IfStmt{
+					Cond: &ast.BinaryExpr{
+						X:  ast.NewIdent("vs"),
+						Op: token.EQL,
+						Y:  ast.NewIdent("nil"),
+					},
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("nil")}},
+						},
+					},
+				},
+				&ast.AssignStmt{
+					Tok: token.DEFINE,
+					Lhs: []ast.Expr{ast.NewIdent("result")},
+					Rhs: []ast.Expr{
+						&ast.CallExpr{
+							Fun: ast.NewIdent("make"),
+							Args: []ast.Expr{
+								&ast.ArrayType{Elt: out},
+								&ast.CallExpr{
+									Fun:  ast.NewIdent("len"),
+									Args: []ast.Expr{ast.NewIdent("vs")},
+								},
+							},
+						},
+					},
+				},
+				&ast.RangeStmt{
+					Key:   ast.NewIdent("i"),
+					Value: ast.NewIdent("v"),
+					Tok:   token.DEFINE,
+					X:     ast.NewIdent("vs"),
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.AssignStmt{
+								Tok: token.ASSIGN,
+								Lhs: []ast.Expr{ast.NewIdent("result[i]")},
+								Rhs: []ast.Expr{
+									&ast.CallExpr{
+										Fun:  ast.NewIdent(enumFuncName(name, false, direction)),
+										Args: []ast.Expr{ast.NewIdent("v")},
+									},
+								},
+							},
+						},
+					},
+				},
+				&ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("result")}},
+			},
+		},
+	}
+}
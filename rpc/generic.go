@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+// genericSource returns the scanner type of the given protobuf type if it is
+// the message declared for an instantiation of a generic struct, or nil
+// otherwise.
+func genericSource(t protobuf.Type) *scanner.Named {
+	n, ok := t.(*protobuf.Named)
+	if !ok {
+		return nil
+	}
+
+	src, ok := n.Source().(*scanner.Named)
+	if !ok || src.Generic == "" {
+		return nil
+	}
+
+	return src
+}
+
+// convertGeneric converts the pointer to the message type in the given
+// expression to a pointer to the given instantiated generic type.
+func (c *context) convertGeneric(expr ast.Expr, generic string) ast.Expr {
+	typ, err := c.genericExpr(generic)
+	if err != nil {
+		report.Warn("unable to convert to %s: %s", generic, err)
+		return expr
+	}
+
+	return &ast.CallExpr{
+		Fun:  &ast.ParenExpr{X: ptr(typ)},
+		Args: []ast.Expr{expr},
+	}
+}
+
+func hasGenerics(proto *protobuf.Package) bool {
+	for _, m := range proto.Messages {
+		if m.Generic != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// declGenericTypes declares the types of the messages of the package that
+// are declared for instantiations of generic structs, which are defined as
+// the instantiated type, e.g. `type PageUser Page[User]`.
+func (g *Generator) declGenericTypes(ctx *context) (decls []ast.Decl) {
+	for _, m := range ctx.proto.Messages {
+		if m.Generic == "" || ctx.isNameDefined(m.Name) {
+			continue
+		}
+
+		typ, err := ctx.genericExpr(m.Generic)
+		if err != nil {
+			report.Warn("unable to declare type %s for %s: %s", m.Name, m.Generic, err)
+			continue
+		}
+
+		decls = append(decls, &ast.GenDecl{
+			Tok: token.TYPE,
+			Specs: []ast.Spec{
+				&ast.TypeSpec{
+					Name: ast.NewIdent(m.Name),
+					Type: typ,
+				},
+			},
+		})
+	}
+	return
+}
+
+// genericExpr returns the expression of the given instantiated generic type,
+// whose generic type is qualified with its package path if it is not the
+// package being generated, importing it if needed.
+func (c *context) genericExpr(generic string) (ast.Expr, error) {
+	typ, name := generic, generic
+	if idx := strings.Index(generic, "["); idx >= 0 {
+		name = generic[:idx]
+	}
+
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		path := generic[:idx]
+		if pkg := c.findPackage(path); pkg != nil {
+			c.addImport(pkg.Path())
+			typ = pkg.Name() + generic[idx:]
+		}
+	}
+
+	return parser.ParseExpr(typ)
+}
@@ -1,18 +1,17 @@
 package rpc // import "gopkg.in/src-d/proteus.v1/rpc"
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
 	"go/printer"
 	"go/token"
-	"os"
 	"path/filepath"
-	"strings"
 
+	"gopkg.in/src-d/proteus.v1/loader"
+	"gopkg.in/src-d/proteus.v1/output"
 	"gopkg.in/src-d/proteus.v1/protobuf"
 	"gopkg.in/src-d/proteus.v1/report"
-
-	"gopkg.in/src-d/go-parse-utils.v1"
 )
 
 // Generator generates implementations of an RPC server for a package.
@@ -43,36 +42,43 @@ import (
 // constructor.
 //
 // A single file per package will be generated containing all the RPC methods.
-// The file will be written to the package path and it will be named
-// "server.proteus.go"
+// The file will be written to the directory of the package and it will be
+// named "server.proteus.go".
 type Generator struct {
-	importer *parseutil.Importer
+	loader *loader.Loader
+	output output.Output
 }
 
-// NewGenerator creates a new Generator.
+// NewGenerator creates a new Generator, which writes the files to disk.
 func NewGenerator() *Generator {
-	return &Generator{parseutil.NewImporter()}
+	return &Generator{loader.New(), output.Disk}
+}
+
+// SetOutput sets the output the generated files are written to.
+func (g *Generator) SetOutput(o output.Output) {
+	g.output = o
 }
 
 // Generate creates a new file in the package at the given path and implements
 // the server according to the given proto package.
+//
+// Functions to convert between the values of the enums of the package whose
+// underlying type is string and the values of the types declared for them in
+// the generated protobuf code are generated as well, even if there are no
+// RPCs. For an enum Foo, they are FooToProto, FooFromProto, FooSliceToProto
+// and FooSliceFromProto. The types of the messages declared for
+// instantiations of generic structs are declared too, e.g.
+// `type PageUser Page[User]`. If the arguments or results of any RPC are
+// free-form values mapped to well-known types, such as interface{}, the
+// functions to convert them are generated along with the RPCs, e.g.
+// interfaceToProto and interfaceFromProto.
 func (g *Generator) Generate(proto *protobuf.Package, path string) error {
-	if len(proto.RPCs) == 0 {
+	if len(proto.RPCs) == 0 && !hasEnums(proto) && !hasGenerics(proto) {
 		report.Warn("no RPCs in the given proto file, not generating anything")
 		return nil
 	}
 
-	pkg, err := g.importer.ImportWithFilters(
-		path,
-		parseutil.FileFilters{
-			func(pkg, file string, typ parseutil.FileType) bool {
-				return !strings.HasSuffix(file, ".pb.go")
-			},
-			func(pkg, file string, typ parseutil.FileType) bool {
-				return !strings.HasSuffix(file, ".proteus.go")
-			},
-		},
-	)
+	pkg, err := g.loader.Load(path)
 	if err != nil {
 		return err
 	}
@@ -81,10 +87,20 @@ func (g *Generator) Generate(proto *protobuf.Package, path string) error {
 		implName:        serviceImplName(proto),
 		constructorName: constructorName(proto),
 		proto:           proto,
-		pkg:             pkg,
+		pkg:             pkg.Types,
+	}
+
+	decls := g.declGenericTypes(ctx)
+	decls = append(decls, g.declStringEnumFuncs(ctx)...)
+	if len(proto.RPCs) == 0 {
+		if len(decls) == 0 {
+			report.Warn("no RPCs in the given proto file, not generating anything")
+			return nil
+		}
+
+		return g.writeFile(g.buildFile(ctx, decls), pkg.Dir)
 	}
 
-	var decls []ast.Decl
 	if !ctx.isNameDefined(ctx.implName) {
 		decls = append(decls, g.declImplType(ctx.implName))
 	}
@@ -97,8 +113,13 @@ func (g *Generator) Generate(proto *protobuf.Package, path string) error {
 	for _, rpc := range proto.RPCs {
 		decls = append(decls, g.declMethod(ctx, rpc))
 	}
+	decls = append(decls, g.declWKTFuncs(ctx)...)
 
-	return g.writeFile(g.buildFile(ctx, decls), path)
+	return g.writeFile(g.buildFile(ctx, decls), pkg.Dir)
+}
+
+func hasEnums(proto *protobuf.Package) bool {
+	return len(proto.Enums) > 0
 }
 
 func (g *Generator) declImplType(implName string) ast.Decl {
@@ -144,13 +165,13 @@ func (g *Generator) declConstructor(implName, constructorName string) ast.Decl {
 func (g *Generator) genMethodType(ctx *context, rpc *protobuf.RPC) *ast.FuncType {
 	var in, out string
 
-	if isGenerated(rpc.Input) {
+	if isGenerated(rpc.Input) || genericSource(rpc.Input) != nil {
 		in = typeName(rpc.Input)
 	} else {
 		in = ctx.argumentType(rpc)
 	}
 
-	if isGenerated(rpc.Output) {
+	if isGenerated(rpc.Output) || genericSource(rpc.Output) != nil {
 		out = typeName(rpc.Output)
 	} else {
 		out = ctx.returnType(rpc)
@@ -183,6 +204,10 @@ func (g *Generator) genMethodCall(ctx *context, rpc *protobuf.RPC) ast.Expr {
 
 	if !isGenerated(rpc.Input) {
 		var in ast.Expr = ast.NewIdent("in")
+		if src := genericSource(rpc.Input); src != nil {
+			in = ctx.convertGeneric(in, src.Generic)
+		}
+
 		if !rpc.Input.IsNullable() {
 			in = &ast.StarExpr{
 				X: in,
@@ -191,10 +216,22 @@ func (g *Generator) genMethodCall(ctx *context, rpc *protobuf.RPC) ast.Expr {
 		call.Args = append(call.Args, in)
 	} else {
 		msg := ctx.findMessage(typeName(rpc.Input))
-		for i := range msg.Fields {
-			call.Args = append(call.Args, ast.NewIdent(fmt.Sprintf(
-				"in.Arg%d", i+1,
-			)))
+		for i, f := range msg.Fields {
+			var arg ast.Expr = ast.NewIdent(fmt.Sprintf("in.Arg%d", i+1))
+			if f != nil {
+				if enum := ctx.stringEnum(f.Type); enum != nil {
+					arg = &ast.CallExpr{
+						Fun:  ast.NewIdent(ctx.enumFunc(enum, f.Repeated, fromProto)),
+						Args: []ast.Expr{arg},
+					}
+				} else if fn := wktFunc(f, fromProto); fn != "" {
+					arg = &ast.CallExpr{
+						Fun:  ast.NewIdent(fn),
+						Args: []ast.Expr{arg},
+					}
+				}
+			}
+			call.Args = append(call.Args, arg)
 		}
 	}
 
@@ -228,14 +265,47 @@ func (g *Generator) genMethodBody(ctx *context, rpc *protobuf.RPC, typ *ast.Func
 	}
 }
 
-func (g *Generator) genMethodBodyAssignmentsForGeneratedOutput(ctx *context, rpc *protobuf.RPC, msg *protobuf.Message) (lhs []ast.Expr) {
+// genMethodBodyAssignmentsForGeneratedOutput returns the expressions the
+// results of the method call are assigned to. If any of the results needs to
+// be converted before being set in the output message, results are assigned
+// to auxiliary variables and the statements that set the output message
+// fields are returned as well.
+func (g *Generator) genMethodBodyAssignmentsForGeneratedOutput(ctx *context, rpc *protobuf.RPC, msg *protobuf.Message) (lhs []ast.Expr, stmts []ast.Stmt) {
+	var needsConversion bool
+	for _, f := range msg.Fields {
+		if f != nil && (ctx.stringEnum(f.Type) != nil || wktKind(f) != "") {
+			needsConversion = true
+		}
+	}
+
 	for i, f := range msg.Fields {
+		result := ast.NewIdent(fmt.Sprintf("result.Result%d", i+1))
 		if f == nil {
 			lhs = append(lhs, ast.NewIdent("_"))
+		} else if !needsConversion {
+			lhs = append(lhs, result)
 		} else {
-			lhs = append(lhs, ast.NewIdent(fmt.Sprintf(
-				"result.Result%d", i+1,
-			)))
+			aux := ast.NewIdent(fmt.Sprintf("aux%d", i+1))
+			lhs = append(lhs, aux)
+
+			var value ast.Expr = aux
+			if enum := ctx.stringEnum(f.Type); enum != nil {
+				value = &ast.CallExpr{
+					Fun:  ast.NewIdent(ctx.enumFunc(enum, f.Repeated, toProto)),
+					Args: []ast.Expr{aux},
+				}
+			} else if fn := wktFunc(f, toProto); fn != "" {
+				value = &ast.CallExpr{
+					Fun:  ast.NewIdent(fn),
+					Args: []ast.Expr{aux},
+				}
+			}
+
+			stmts = append(stmts, &ast.AssignStmt{
+				Tok: token.ASSIGN,
+				Lhs: []ast.Expr{result},
+				Rhs: []ast.Expr{value},
+			})
 		}
 	}
 	return
@@ -268,13 +338,17 @@ func (g *Generator) genMethodBodyForGeneratedOutput(ctx *context, rpc *protobuf.
 	}
 
 	body.List = append(body.List, call)
-	lhs := g.genMethodBodyAssignmentsForGeneratedOutput(ctx, rpc, msg)
+	lhs, stmts := g.genMethodBodyAssignmentsForGeneratedOutput(ctx, rpc, msg)
 	call.Lhs = append(call.Lhs, lhs...)
+	if len(stmts) > 0 {
+		call.Tok = token.DEFINE
+	}
 
 	if rpc.HasError {
 		call.Lhs = append(call.Lhs, ast.NewIdent("err"))
 	}
 
+	body.List = append(body.List, stmts...)
 	body.List = append(body.List, new(ast.ReturnStmt))
 	return body
 }
@@ -285,9 +359,10 @@ func (g *Generator) genMethodBodyForNotGeneratedOutput(ctx *context, rpc *protob
 	call := &ast.AssignStmt{Tok: token.ASSIGN}
 
 	needToAddressOutput := !isGenerated(rpc.Output) && !rpc.Output.IsNullable()
+	generic := genericSource(rpc.Output)
 
 	// Specific code
-	if needToAddressOutput {
+	if needToAddressOutput || generic != nil {
 		call.Lhs = append(call.Lhs, ast.NewIdent("aux"))
 		call.Tok = token.DEFINE
 	} else {
@@ -301,16 +376,26 @@ func (g *Generator) genMethodBodyForNotGeneratedOutput(ctx *context, rpc *protob
 	call.Rhs = append(call.Rhs, methodCall)
 	body.List = append(body.List, call)
 
-	if needToAddressOutput {
+	if needToAddressOutput || generic != nil {
+		var result ast.Expr = ast.NewIdent("aux")
+		if needToAddressOutput {
+			result = &ast.UnaryExpr{
+				Op: token.AND,
+				X:  result,
+			}
+		}
+
+		if generic != nil {
+			result = &ast.CallExpr{
+				Fun:  &ast.ParenExpr{X: ptr(ast.NewIdent(typeName(rpc.Output)))},
+				Args: []ast.Expr{result},
+			}
+		}
+
 		body.List = append(body.List, &ast.AssignStmt{
 			Tok: token.ASSIGN,
 			Lhs: []ast.Expr{ast.NewIdent("result")},
-			Rhs: []ast.Expr{
-				&ast.UnaryExpr{
-					Op: token.AND,
-					X:  ast.NewIdent("aux"),
-				},
-			},
+			Rhs: []ast.Expr{result},
 		})
 	}
 	body.List = append(body.List, new(ast.ReturnStmt))
@@ -332,30 +417,34 @@ func (g *Generator) buildFile(ctx *context, decls []ast.Decl) *ast.File {
 		Name: ast.NewIdent(ctx.pkg.Name()),
 	}
 
-	var specs = []ast.Spec{newNamedImport("xcontext", "golang.org/x/net/context")}
+	var specs []ast.Spec
+	if len(ctx.proto.RPCs) > 0 {
+		specs = append(specs, newNamedImport("xcontext", "golang.org/x/net/context"))
+	}
+
 	for _, i := range ctx.imports {
 		specs = append(specs, newImport(i))
 	}
 
-	f.Decls = append(f.Decls, &ast.GenDecl{
-		Tok:    token.IMPORT,
-		Lparen: token.Pos(1),
-		Specs:  specs,
-	})
+	if len(specs) > 0 {
+		f.Decls = append(f.Decls, &ast.GenDecl{
+			Tok:    token.IMPORT,
+			Lparen: token.Pos(1),
+			Specs:  specs,
+		})
+	}
 	f.Decls = append(f.Decls, decls...)
 
 	return f
 }
 
-func (g *Generator) writeFile(file *ast.File, path string) error {
-	fileName := filepath.Join(goSrc, path, "server.proteus.go")
-	f, err := os.Create(fileName)
-	if err != nil {
+func (g *Generator) writeFile(file *ast.File, dir string) error {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), file); err != nil {
 		return err
 	}
-	defer f.Close()
 
-	return printer.Fprint(f, token.NewFileSet(), file)
+	return g.output.WriteFile(filepath.Join(dir, "server.proteus.go"), buf.Bytes())
 }
 
 func typeName(t protobuf.Type) string {
@@ -378,7 +467,7 @@ func newImport(path string) *ast.ImportSpec {
 	return &ast.ImportSpec{
 		Path: &ast.BasicLit{
 			Kind:  token.STRING,
-			Value: fmt.Sprintf(`"%s"`, removeGoPath(path)),
+			Value: fmt.Sprintf(`"%s"`, loader.ImportPath(path)),
 		},
 	}
 }
@@ -388,7 +477,7 @@ func newNamedImport(name, path string) *ast.ImportSpec {
 		Name: &ast.Ident{Name: name},
 		Path: &ast.BasicLit{
 			Kind:  token.STRING,
-			Value: fmt.Sprintf(`"%s"`, removeGoPath(path)),
+			Value: fmt.Sprintf(`"%s"`, loader.ImportPath(path)),
 		},
 	}
 }
@@ -407,9 +496,3 @@ func fields(fields ...*ast.Field) *ast.FieldList {
 func ptr(expr ast.Expr) ast.Expr {
 	return &ast.StarExpr{X: expr}
 }
-
-var goSrc = filepath.Join(os.Getenv("GOPATH"), "src")
-
-func removeGoPath(path string) string {
-	return strings.TrimLeft(strings.Replace(path, goSrc, "", -1), "/")
-}
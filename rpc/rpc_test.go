@@ -11,10 +11,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/proteus.v1/output"
 	"gopkg.in/src-d/proteus.v1/protobuf"
 	"gopkg.in/src-d/proteus.v1/resolver"
 	"gopkg.in/src-d/proteus.v1/scanner"
@@ -309,6 +311,250 @@ func (s *RPCSuite) TestDeclMethod() {
 	}
 }
 
+const expectedMethodStringEnums = `func (s *FooServer) Paint(ctx xcontext.Context, in *PaintRequest) (result *PaintResponse, err error) {
+	result = new(PaintResponse)
+	aux1, err := Paint(ColorFromProto(in.Arg1), ColorSliceFromProto(in.Arg2))
+	result.Result1 = ColorToProto(aux1)
+	return
+}`
+
+func (s *RPCSuite) TestDeclMethodStringEnums() {
+	color := func(repeated bool) *protobuf.Field {
+		src := scanner.NewNamed("", "Color")
+		typ := protobuf.NewNamed("", "Color")
+		typ.SetSource(src)
+		return &protobuf.Field{Type: typ, Repeated: repeated}
+	}
+
+	ctx := &context{
+		implName: "FooServer",
+		proto: &protobuf.Package{
+			Messages: []*protobuf.Message{
+				{Name: "PaintRequest", Fields: []*protobuf.Field{color(false), color(true)}},
+				{Name: "PaintResponse", Fields: []*protobuf.Field{color(false)}},
+			},
+		},
+		pkg: s.fakePkg(),
+	}
+
+	output, err := render(s.g.declMethod(ctx, &protobuf.RPC{
+		Name:     "Paint",
+		Method:   "Paint",
+		HasError: true,
+		Input:    nullable(protobuf.NewGeneratedNamed("", "PaintRequest")),
+		Output:   nullable(protobuf.NewGeneratedNamed("", "PaintResponse")),
+	}))
+	s.Nil(err)
+	s.Equal(expectedMethodStringEnums, output)
+}
+
+const expectedMethodWKT = `func (s *FooServer) Track(ctx xcontext.Context, in *TrackRequest) (result *TrackResponse, err error) {
+	result = new(TrackResponse)
+	aux1, err := Track(in.Arg1, interfaceMapFromProto(in.Arg2), rawMessageSliceFromProto(in.Arg3))
+	result.Result1 = interfaceToProto(aux1)
+	return
+}`
+
+func (s *RPCSuite) TestDeclMethodWKT() {
+	wkt := func(name string, src scanner.Type) *protobuf.Field {
+		typ := protobuf.NewNamed("google.protobuf", name)
+		typ.SetSource(src)
+		return &protobuf.Field{Type: typ, Repeated: src.IsRepeated()}
+	}
+	raws := scanner.NewNamed("encoding/json", "RawMessage")
+	raws.SetRepeated(true)
+	props := scanner.NewMap(scanner.NewBasic("string"), scanner.NewBasic("interface{}"))
+
+	ctx := &context{
+		implName: "FooServer",
+		proto: &protobuf.Package{
+			Messages: []*protobuf.Message{
+				{Name: "TrackRequest", Fields: []*protobuf.Field{
+					{Type: protobuf.NewBasic("string")},
+					wkt("Struct", props),
+					wkt("Value", raws),
+				}},
+				{Name: "TrackResponse", Fields: []*protobuf.Field{
+					wkt("Value", scanner.NewBasic("interface{}")),
+				}},
+			},
+		},
+		pkg: s.fakePkg(),
+	}
+
+	rpc := &protobuf.RPC{
+		Name:     "Track",
+		Method:   "Track",
+		HasError: true,
+		Input:    nullable(protobuf.NewGeneratedNamed("", "TrackRequest")),
+		Output:   nullable(protobuf.NewGeneratedNamed("", "TrackResponse")),
+	}
+	output, err := render(s.g.declMethod(ctx, rpc))
+	s.Nil(err)
+	s.Equal(expectedMethodWKT, output)
+
+	s.Len(s.g.declWKTFuncs(ctx), 0, "there are no RPCs needing the funcs")
+
+	ctx.proto.RPCs = []*protobuf.RPC{rpc}
+	var names []string
+	for _, d := range s.g.declWKTFuncs(ctx) {
+		names = append(names, d.(*ast.FuncDecl).Name.Name)
+	}
+	s.Equal([]string{
+		"interfaceToProto",
+		"interfaceFromProto",
+		"interfaceSliceToProto",
+		"interfaceSliceFromProto",
+		"interfaceMapToProto",
+		"interfaceMapFromProto",
+		"interfaceMapSliceToProto",
+		"interfaceMapSliceFromProto",
+		"rawMessageToProto",
+		"rawMessageFromProto",
+		"rawMessageSliceToProto",
+		"rawMessageSliceFromProto",
+	}, names)
+	s.Equal([]string{"encoding/json", "github.com/gogo/protobuf/types"}, ctx.imports)
+}
+
+const expectedMethodGenericOutput = `func (s *FooServer) ListFoos(ctx xcontext.Context, in *ListFoosRequest) (result *PageFoo, err error) {
+	result = new(PageFoo)
+	aux, err := ListFoos()
+	result = (*PageFoo)(&aux)
+	return
+}`
+
+const expectedMethodGenericInput = `func (s *FooServer) SaveFoos(ctx xcontext.Context, in *PageFoo) (result *SaveFoosResponse, err error) {
+	SaveFoos((*Page[Foo])(in))
+	return
+}`
+
+func (s *RPCSuite) TestDeclMethodGenerics() {
+	page := func(nullable bool) protobuf.Type {
+		src := scanner.NewNamed("", "PageFoo").(*scanner.Named)
+		src.Generic = "Page[Foo]"
+		src.SetNullable(nullable)
+		typ := protobuf.NewNamed("", "PageFoo")
+		typ.SetSource(src)
+		return typ
+	}
+
+	ctx := &context{
+		implName: "FooServer",
+		proto: &protobuf.Package{
+			Messages: []*protobuf.Message{
+				{Name: "ListFoosRequest"},
+				{Name: "SaveFoosResponse"},
+			},
+		},
+		pkg: s.fakePkg(),
+	}
+
+	output, err := render(s.g.declMethod(ctx, &protobuf.RPC{
+		Name:     "ListFoos",
+		Method:   "ListFoos",
+		HasError: true,
+		Input:    nullable(protobuf.NewGeneratedNamed("", "ListFoosRequest")),
+		Output:   page(false),
+	}))
+	s.Nil(err)
+	s.Equal(expectedMethodGenericOutput, output)
+
+	output, err = render(s.g.declMethod(ctx, &protobuf.RPC{
+		Name:   "SaveFoos",
+		Method: "SaveFoos",
+		Input:  page(true),
+		Output: nullable(protobuf.NewGeneratedNamed("", "SaveFoosResponse")),
+	}))
+	s.Nil(err)
+	s.Equal(expectedMethodGenericInput, output)
+}
+
+const expectedStringEnumFuncs = `func ColorToProto(v Color) ColorProto {
+	switch v {
+	case Red:
+		return ColorProto_Red
+	case Blue:
+		return ColorProto_Blue
+	}
+	return 0
+}
+func ColorFromProto(v ColorProto) Color {
+	switch v {
+	case ColorProto_Red:
+		return Red
+	case ColorProto_Blue:
+		return Blue
+	}
+	return ""
+}
+func ColorSliceToProto(vs []Color) []ColorProto {
+	if vs == nil {
+		return nil
+	}
+	result := make([]ColorProto, len(vs))
+	for i, v := range vs {
+		result[i] = ColorToProto(v)
+	}
+	return result
+}
+func ColorSliceFromProto(vs []ColorProto) []Color {
+	if vs == nil {
+		return nil
+	}
+	result := make([]Color, len(vs))
+	for i, v := range vs {
+		result[i] = ColorFromProto(v)
+	}
+	return result
+}`
+
+func (s *RPCSuite) TestDeclStringEnumFuncs() {
+	ctx := &context{
+		proto: &protobuf.Package{
+			Enums: []*protobuf.Enum{{Name: "Color"}, {Name: "Foo"}},
+		},
+		pkg: s.fakePkg(),
+	}
+
+	decls := s.g.declStringEnumFuncs(ctx)
+	s.Len(decls, 4)
+
+	var outputs []string
+	for _, d := range decls {
+		output, err := render(d)
+		s.Nil(err)
+		outputs = append(outputs, output)
+	}
+	s.Equal(expectedStringEnumFuncs, strings.Join(outputs, "\n"))
+}
+
+func (s *RPCSuite) TestDeclGenericTypes() {
+	ctx := &context{
+		proto: &protobuf.Package{
+			Messages: []*protobuf.Message{
+				{Name: "Foo"},
+				{Name: "PageBar", Generic: "Page[Bar]"},
+				{Name: "PageFoo", Generic: "Page[Foo]"},
+				{Name: "ListExpr", Generic: "go/ast.List[Expr]"},
+			},
+		},
+		pkg: s.fakePkg(),
+	}
+
+	decls := s.g.declGenericTypes(ctx)
+	s.Len(decls, 2, "already declared types are skipped")
+
+	var outputs []string
+	for _, d := range decls {
+		output, err := render(d)
+		s.Nil(err)
+		outputs = append(outputs, output)
+	}
+	s.Equal("type PageBar Page[Bar]\ntype ListExpr ast.List[Expr]", strings.Join(outputs, "\n"))
+	s.Equal([]string{"go/ast"}, ctx.imports)
+}
+
 const expectedGeneratedFile = `package subpkg
 
 import (
@@ -355,7 +601,9 @@ func (s *RPCSuite) TestGenerate() {
 	r.Resolve(pkgs)
 
 	t := protobuf.NewTransformer()
-	s.Nil(s.g.Generate(t.Transform(pkgs[0]), pkg))
+	proto, err := t.Transform(pkgs[0])
+	s.Nil(err)
+	s.Nil(s.g.Generate(proto, pkg))
 
 	data, err := ioutil.ReadFile(projectPath("fixtures/subpkg/server.proteus.go"))
 	s.Nil(err)
@@ -364,6 +612,33 @@ func (s *RPCSuite) TestGenerate() {
 	s.Nil(os.Remove(projectPath("fixtures/subpkg/server.proteus.go")))
 }
 
+func (s *RPCSuite) TestGenerateToOutput() {
+	pkg := "gopkg.in/src-d/proteus.v1/fixtures/subpkg"
+	scanner, err := scanner.New(pkg)
+	s.Nil(err)
+
+	pkgs, err := scanner.Scan()
+	s.Nil(err)
+
+	r := resolver.New()
+	r.Resolve(pkgs)
+
+	files := output.NewMemory()
+	s.g.SetOutput(files)
+
+	t := protobuf.NewTransformer()
+	proto, err := t.Transform(pkgs[0])
+	s.Nil(err)
+	s.Nil(s.g.Generate(proto, pkg))
+
+	path := projectPath("fixtures/subpkg/server.proteus.go")
+	s.Equal([]string{path}, files.Paths())
+	s.Equal(expectedGeneratedFile, string(files[path]))
+
+	_, err = os.Stat(path)
+	s.True(os.IsNotExist(err), "nothing is written to disk")
+}
+
 func TestServiceImplName(t *testing.T) {
 	require.Equal(t, "fooServiceServer", serviceImplName(&protobuf.Package{
 		Name: "foo",
@@ -401,6 +676,31 @@ type T struct{}
 func (*T) Foo(s *ast.BlockStmt) int {
 	return 0
 }
+
+type Color string
+
+const (
+	Red   Color = "red"
+	Blue  Color = "blue"
+	Azure Color = "blue"
+)
+
+func Paint(c Color, cs []Color) (Color, error) {
+	return c, nil
+}
+
+type Page[T any] struct {
+	Items []T
+}
+
+type PageFoo Page[Foo]
+
+func ListFoos() (Page[Foo], error) {
+	return Page[Foo]{}, nil
+}
+
+func SaveFoos(p *Page[Foo]) {}
+
 `
 
 func (s *RPCSuite) fakePkg() *types.Package {
@@ -448,6 +748,12 @@ func TestRPCSuite(t *testing.T) {
 	suite.Run(t, new(RPCSuite))
 }
 
+// projectPath returns the absolute path of the given file of the project,
+// found from the directory of the package being tested.
 func projectPath(path string) string {
-	return filepath.Join(os.Getenv("GOPATH"), "src", "gopkg.in/src-d/proteus.v1", path)
+	abs, err := filepath.Abs(filepath.Join("..", path))
+	if err != nil {
+		panic(err)
+	}
+	return abs
 }
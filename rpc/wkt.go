@@ -0,0 +1,281 @@
+package rpc
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+
+	"gopkg.in/src-d/proteus.v1/protobuf"
+	"gopkg.in/src-d/proteus.v1/report"
+	"gopkg.in/src-d/proteus.v1/scanner"
+)
+
+const (
+	wktInterface    = "interface"
+	wktInterfaceMap = "interfaceMap"
+	wktRawMessage   = "rawMessage"
+)
+
+// wktKind returns the kind of the free-form Go value of the given field if it
+// is mapped to the google.protobuf.Value or google.protobuf.Struct well-known
+// types, or an empty string otherwise.
+func wktKind(f *protobuf.Field) string {
+	n, ok := f.Type.(*protobuf.Named)
+	if !ok || n.Package != "google.protobuf" {
+		return ""
+	}
+
+	switch src := n.Source().(type) {
+	case *scanner.Basic:
+		if src.Name == "interface{}" && n.Name == "Value" {
+			return wktInterface
+		}
+	case *scanner.Map:
+		if src.String() == "map[string]interface{}" && n.Name == "Struct" {
+			return wktInterfaceMap
+		}
+	case *scanner.Named:
+		if isRawMessage(src) && n.Name == "Value" {
+			return wktRawMessage
+		}
+	}
+	return ""
+}
+
+// isRawMessage reports whether the given type is json.RawMessage, which is an
+// alias of jsontext.Value since encoding/json is implemented on top of
+// encoding/json/v2.
+func isRawMessage(n *scanner.Named) bool {
+	name := n.String()
+	return name == "encoding/json.RawMessage" || name == "encoding/json/jsontext.Value"
+}
+
+// wktFunc returns the name of the function that converts the free-form Go
+// value of the given field in the given direction, or an empty string if it
+// needs no conversion.
+func wktFunc(f *protobuf.Field, direction string) string {
+	kind := wktKind(f)
+	if kind == "" {
+		return ""
+	}
+	return enumFuncName(kind, f.Repeated, direction)
+}
+
+// hasWKTConversions reports whether any of the messages generated for the
+// arguments and results of the RPCs has a field that needs to be converted
+// to or from a well-known type.
+func hasWKTConversions(ctx *context) bool {
+	for _, rpc := range ctx.proto.RPCs {
+		for _, t := range []protobuf.Type{rpc.Input, rpc.Output} {
+			if !isGenerated(t) {
+				continue
+			}
+
+			msg := ctx.findMessage(typeName(t))
+			if msg == nil {
+				continue
+			}
+
+			for _, f := range msg.Fields {
+				if f != nil && wktKind(f) != "" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// declWKTFuncs declares the functions that convert between free-form Go
+// values, which are interface{}, map[string]interface{} and json.RawMessage,
+// and the google.protobuf.Value and google.protobuf.Struct well-known types
+// they are mapped to, if any RPC needs them. Functions already defined in
+// the package are not declared again.
+func (g *Generator) declWKTFuncs(ctx *context) (decls []ast.Decl) {
+	if !hasWKTConversions(ctx) {
+		return nil
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "", wktFuncsSrc, 0)
+	if err != nil {
+		report.Error("unable to parse the well-known type conversion functions: %s", err)
+		return nil
+	}
+
+	for _, d := range file.Decls {
+		if fn, ok := d.(*ast.FuncDecl); ok && !ctx.isNameDefined(fn.Name.Name) {
+			decls = append(decls, fn)
+		}
+	}
+
+	ctx.addImport("encoding/json")
+	ctx.addImport("github.com/gogo/protobuf/types")
+	return decls
+}
+
+const wktFuncsSrc = `package rpc
+
+func interfaceToProto(v interface{}) *types.Value {
+	switch v := v.(type) {
+	case nil:
+		return &types.Value{Kind: &types.Value_NullValue{}}
+	case bool:
+		return &types.Value{Kind: &types.Value_BoolValue{BoolValue: v}}
+	case float64:
+		return &types.Value{Kind: &types.Value_NumberValue{NumberValue: v}}
+	case string:
+		return &types.Value{Kind: &types.Value_StringValue{StringValue: v}}
+	case []interface{}:
+		return &types.Value{Kind: &types.Value_ListValue{ListValue: &types.ListValue{Values: interfaceSliceToProto(v)}}}
+	case map[string]interface{}:
+		return &types.Value{Kind: &types.Value_StructValue{StructValue: interfaceMapToProto(v)}}
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return interfaceToProto(nil)
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return interfaceToProto(nil)
+	}
+	return interfaceToProto(decoded)
+}
+
+func interfaceFromProto(v *types.Value) interface{} {
+	switch k := v.GetKind().(type) {
+	case *types.Value_BoolValue:
+		return k.BoolValue
+	case *types.Value_NumberValue:
+		return k.NumberValue
+	case *types.Value_StringValue:
+		return k.StringValue
+	case *types.Value_ListValue:
+		return interfaceSliceFromProto(k.ListValue.GetValues())
+	case *types.Value_StructValue:
+		return interfaceMapFromProto(k.StructValue)
+	}
+	return nil
+}
+
+func interfaceSliceToProto(vs []interface{}) []*types.Value {
+	if vs == nil {
+		return nil
+	}
+
+	result := make([]*types.Value, len(vs))
+	for i, v := range vs {
+		result[i] = interfaceToProto(v)
+	}
+	return result
+}
+
+func interfaceSliceFromProto(vs []*types.Value) []interface{} {
+	if vs == nil {
+		return nil
+	}
+
+	result := make([]interface{}, len(vs))
+	for i, v := range vs {
+		result[i] = interfaceFromProto(v)
+	}
+	return result
+}
+
+func interfaceMapToProto(m map[string]interface{}) *types.Struct {
+	if m == nil {
+		return nil
+	}
+
+	result := &types.Struct{Fields: make(map[string]*types.Value, len(m))}
+	for k, v := range m {
+		result.Fields[k] = interfaceToProto(v)
+	}
+	return result
+}
+
+func interfaceMapFromProto(s *types.Struct) map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+
+	result := make(map[string]interface{}, len(s.Fields))
+	for k, v := range s.Fields {
+		result[k] = interfaceFromProto(v)
+	}
+	return result
+}
+
+func interfaceMapSliceToProto(ms []map[string]interface{}) []*types.Struct {
+	if ms == nil {
+		return nil
+	}
+
+	result := make([]*types.Struct, len(ms))
+	for i, m := range ms {
+		result[i] = interfaceMapToProto(m)
+	}
+	return result
+}
+
+func interfaceMapSliceFromProto(ss []*types.Struct) []map[string]interface{} {
+	if ss == nil {
+		return nil
+	}
+
+	result := make([]map[string]interface{}, len(ss))
+	for i, s := range ss {
+		result[i] = interfaceMapFromProto(s)
+	}
+	return result
+}
+
+func rawMessageToProto(raw json.RawMessage) *types.Value {
+	if raw == nil {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	return interfaceToProto(v)
+}
+
+func rawMessageFromProto(v *types.Value) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(interfaceFromProto(v))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func rawMessageSliceToProto(raws []json.RawMessage) []*types.Value {
+	if raws == nil {
+		return nil
+	}
+
+	result := make([]*types.Value, len(raws))
+	for i, raw := range raws {
+		result[i] = rawMessageToProto(raw)
+	}
+	return result
+}
+
+func rawMessageSliceFromProto(vs []*types.Value) []json.RawMessage {
+	if vs == nil {
+		return nil
+	}
+
+	result := make([]json.RawMessage, len(vs))
+	for i, v := range vs {
+		result[i] = rawMessageFromProto(v)
+	}
+	return result
+}
+`
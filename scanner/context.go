@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"strings"
+	"unicode"
 
-	"gopkg.in/src-d/go-parse-utils.v1"
+	"gopkg.in/src-d/proteus.v1/report"
 )
 
 // context holds all the scanning context of a single package. Contains all
@@ -17,9 +19,7 @@ type context struct {
 	// is guaranteed to include the comments, if any, even though they were on
 	// the GenDecl.
 	types map[string]*ast.TypeSpec
-	// consts holds the const objects indexed by the const name. We store an
-	// object instead of a ValueSpec because the iota of the const is not
-	// available there.
+	// consts holds the const objects indexed by the const name.
 	consts map[string]*ast.Object
 	// funcs holds the func objects indexed by the function or method name.
 	// In case of methods, it's indexed by their qualified name, that is,
@@ -27,30 +27,27 @@ type context struct {
 	funcs map[string]*ast.FuncDecl
 	// enumValues contains all the values found until a point in time.
 	// It is indexed by qualified type name e.g: time.Time
-	enumValues map[string][]string
+	enumValues map[string][]*types.Const
 	// enums with string method
 	enumWithString []string
+	// pkgDoc holds the comments of the package clauses of all the files.
+	pkgDoc *ast.CommentGroup
 }
 
-func newContext(path string) (*context, error) {
-	pkg, err := parseutil.PackageAST(path)
-	if err != nil {
-		return nil, err
-	}
-
-	types, funcs := findPkgTypesAndFuncs(pkg)
+func newContext(pkg *ast.Package) *context {
+	f := ast.MergePackageFiles(pkg, 0)
+	typeSpecs, funcs := findPkgTypesAndFuncs(f)
 	return &context{
-		types:          types,
+		pkgDoc:         f.Doc,
+		types:          typeSpecs,
 		funcs:          funcs,
 		consts:         findObjectsOfType(pkg, ast.Con),
-		enumValues:     make(map[string][]string),
+		enumValues:     make(map[string][]*types.Const),
 		enumWithString: []string{},
-	}, nil
+	}
 }
 
-func findPkgTypesAndFuncs(pkg *ast.Package) (map[string]*ast.TypeSpec, map[string]*ast.FuncDecl) {
-	f := ast.MergePackageFiles(pkg, 0)
-
+func findPkgTypesAndFuncs(f *ast.File) (map[string]*ast.TypeSpec, map[string]*ast.FuncDecl) {
 	var types = make(map[string]*ast.TypeSpec)
 	var funcs = make(map[string]*ast.FuncDecl)
 	for _, d := range f.Decls {
@@ -121,7 +118,62 @@ func (ctx *context) trySetDocs(name string, obj Documentable) {
 	}
 }
 
-const genComment = `//proteus:generate`
+const (
+	genComment    = `//proteus:generate`
+	optionComment = `//proteus:option`
+	importComment = `//proteus:import`
+)
+
+// directive returns the arguments of the given comment if it is the given
+// directive, such as //proteus:option deprecated=true.
+func directive(comment, name string) (string, bool) {
+	if !strings.HasPrefix(comment, name) {
+		return "", false
+	}
+
+	arg := comment[len(name):]
+	if arg != "" && !unicode.IsSpace(rune(arg[0])) {
+		return "", false
+	}
+	return strings.TrimSpace(arg), true
+}
+
+// warnFieldOptions warns about the //proteus:option comments of the fields
+// of the struct type with the given name, which are ignored, as the options
+// of fields are set in their proteus tags.
+func (ctx *context) warnFieldOptions(name string) {
+	typ, ok := ctx.types[name]
+	if !ok {
+		return
+	}
+
+	st, ok := typ.Type.(*ast.StructType)
+	if !ok {
+		return
+	}
+
+	for _, f := range st.Fields.List {
+		field := types.ExprString(f.Type)
+		if len(f.Names) > 0 {
+			field = f.Names[0].Name
+		}
+
+		for _, doc := range []*ast.CommentGroup{f.Doc, f.Comment} {
+			if doc == nil {
+				continue
+			}
+
+			for _, c := range doc.List {
+				if arg, ok := directive(c.Text, optionComment); ok {
+					report.Warn(
+						"ignoring the %s comment of field %q of struct %q, set the option in the proteus tag of the field instead, e.g. proteus:\"opt:%s\"",
+						optionComment, field, name, arg,
+					)
+				}
+			}
+		}
+	}
+}
 
 func (ctx *context) shouldGenerateType(name string) bool {
 	if typ, ok := ctx.types[name]; ok && typ.Doc != nil {
@@ -130,6 +182,24 @@ func (ctx *context) shouldGenerateType(name string) bool {
 	return false
 }
 
+// generateArgs returns the names listed after the generate comment of the
+// type with the given name, separated by spaces or commas.
+func (ctx *context) generateArgs(name string) []string {
+	typ, ok := ctx.types[name]
+	if !ok || typ.Doc == nil {
+		return nil
+	}
+
+	for _, l := range typ.Doc.List {
+		if strings.HasPrefix(l.Text, genComment) {
+			return strings.FieldsFunc(l.Text[len(genComment):], func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})
+		}
+	}
+	return nil
+}
+
 func (ctx *context) shouldGenerateFunc(name string) bool {
 	if fn, ok := ctx.funcs[name]; ok && fn.Doc != nil {
 		return hasGenerateComment(fn.Doc)
@@ -0,0 +1,176 @@
+package scanner
+
+import (
+	"fmt"
+	"go/types"
+	"unicode"
+
+	"gopkg.in/src-d/proteus.v1/loader"
+)
+
+// collectInstances declares a struct in the package for every instantiation
+// of a generic struct used in it, and makes all the types referring to an
+// instantiation refer to its struct instead. The struct is named after the
+// generic struct and its type arguments, e.g. PageUser for Page[User].
+func (p *Package) collectInstances(gopkg *types.Package) error {
+	qualifier := func(pkg *types.Package) string {
+		if pkg.Path() == gopkg.Path() {
+			return ""
+		}
+		return loader.ImportPath(pkg.Path())
+	}
+
+	instances := make(map[string]*Struct)
+	var visit func(Type) error
+	visit = func(typ Type) error {
+		switch t := typ.(type) {
+		case *Named:
+			if t.instance == nil {
+				return nil
+			}
+
+			inst := t.instance
+			name, err := instanceName(inst)
+			if err != nil {
+				return err
+			}
+
+			t.Path, t.Name = p.Path, name
+			t.Generic = types.TypeString(inst, qualifier)
+			t.instance = nil
+
+			if s, ok := instances[name]; ok {
+				if s.Generic != t.Generic {
+					return fmt.Errorf("instantiations %s and %s of a generic struct would have the same name %q", s.Generic, t.Generic, name)
+				}
+				return nil
+			}
+
+			s, err := scanStruct(&Struct{Name: name, Generic: t.Generic}, inst.Underlying().(*types.Struct))
+			if err != nil {
+				return err
+			}
+
+			s.origin = objName(inst.Origin().Obj())
+			if inst.Obj().Pkg() == gopkg {
+				s.Docs = p.generics[inst.Obj().Name()]
+			}
+
+			instances[name] = s
+			p.Structs = append(p.Structs, s)
+			for _, f := range s.Fields {
+				if err := visit(f.Type); err != nil {
+					return err
+				}
+			}
+		case *Anonymous:
+			for _, f := range t.Struct.Fields {
+				if err := visit(f.Type); err != nil {
+					return err
+				}
+			}
+		case *Map:
+			if err := visit(t.Key); err != nil {
+				return err
+			}
+			return visit(t.Value)
+		}
+		return nil
+	}
+
+	var used []Type
+	for _, s := range p.Structs {
+		for _, f := range s.Fields {
+			used = append(used, f.Type)
+		}
+	}
+
+	for _, fn := range p.Funcs {
+		used = append(used, fn.Input...)
+		used = append(used, fn.Output...)
+	}
+
+	for _, t := range p.Aliases {
+		used = append(used, t)
+	}
+
+	for _, t := range used {
+		if err := visit(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// instanceName returns the name of the struct declared for the given
+// instantiation of a generic struct, which is the name of the generic struct
+// followed by the names of its type arguments.
+func instanceName(n *types.Named) (string, error) {
+	name := n.Obj().Name()
+	args := n.TypeArgs()
+	for i := 0; i < args.Len(); i++ {
+		arg, err := typeArgName(args.At(i))
+		if err != nil {
+			return "", fmt.Errorf("unable to name instantiation %s: %s", n, err)
+		}
+		name += arg
+	}
+	return name, nil
+}
+
+func typeArgName(t types.Type) (string, error) {
+	switch t := t.(type) {
+	case *types.Named:
+		if t.TypeArgs().Len() > 0 {
+			return instanceName(t)
+		}
+		return t.Obj().Name(), nil
+	case *types.Basic:
+		return capitalize(t.Name()), nil
+	case *types.Pointer:
+		return typeArgName(t.Elem())
+	case *types.Slice:
+		elem, err := typeArgName(t.Elem())
+		return elem + "List", err
+	case *types.Array:
+		elem, err := typeArgName(t.Elem())
+		return elem + "List", err
+	case *types.Map:
+		key, err := typeArgName(t.Key())
+		if err != nil {
+			return "", err
+		}
+
+		val, err := typeArgName(t.Elem())
+		return "Map" + key + val, err
+	}
+
+	return "", fmt.Errorf("type argument %s is not supported", t)
+}
+
+func capitalize(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
+
+// setInstanceDocs sets the documentation of the structs declared for
+// instantiations of generic structs of other packages.
+func setInstanceDocs(pkgs []*Package) {
+	var docs = make(map[string]Docs)
+	for _, p := range pkgs {
+		for name, d := range p.generics {
+			docs[fmt.Sprintf("%s.%s", p.Path, name)] = d
+		}
+	}
+
+	for _, p := range pkgs {
+		for _, s := range p.Structs {
+			if s.origin != "" && s.Docs.isEmpty() {
+				s.Docs = docs[s.origin]
+			}
+		}
+	}
+}
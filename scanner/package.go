@@ -1,38 +1,63 @@
 package scanner
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
+	"go/constant"
+	"go/types"
 	"strings"
+	"unicode"
+
+	"gopkg.in/src-d/proteus.v1/report"
 )
 
 // Package holds information about a single Go package and
 // a reference of all defined structs and type aliases.
 // A Package is only safe to use once it is resolved.
 type Package struct {
+	// Docs are the documentation of the package, given in the comments of
+	// its package clauses, along with the options and imports of the proto
+	// file of the package set in them.
+	Docs
 	Resolved bool
-	Path     string
-	Name     string
-	Structs  []*Struct
-	Enums    []*Enum
-	Funcs    []*Func
-	Aliases  map[string]Type
+	// Discovered reports whether the package was not given to be scanned,
+	// but it was scanned because its types are referenced by the types of
+	// the given packages. Only its types that are referenced are generated.
+	Discovered bool
+	Path       string
+	Name       string
+	Structs    []*Struct
+	Enums      []*Enum
+	Funcs      []*Func
+	// Interfaces are the interfaces marked to be generated, whose fields
+	// are generated as oneofs.
+	Interfaces []*Interface
+	Aliases    map[string]Type
+
+	// generics holds the documentation of the generic structs of the
+	// package indexed by their name.
+	generics map[string]Docs
 }
 
 // collectEnums finds the enum values collected during the scan and generates
 // the corresponding enum types, removing them as aliases from the package.
+// All the named types with constant values are collected, whether they are
+// opted-in or not, so the resolver can keep the ones that are used.
 func (p *Package) collectEnums(ctx *context) {
 	for k := range p.Aliases {
 		if vals, ok := ctx.enumValues[k]; ok {
 			idx := strings.LastIndex(k, ".")
 			name := k[idx+1:]
-			if !ctx.shouldGenerateType(name) {
-				continue
-			}
-
 			hasStringMethod := containsString(ctx.enumWithString, k)
 
-			p.Enums = append(p.Enums, newEnum(ctx, name, vals, hasStringMethod))
+			enum := newEnum(ctx, name, vals, hasStringMethod)
+			enum.Generate = ctx.shouldGenerateType(name)
+			if b, ok := p.Aliases[k].(*Basic); ok && b.Name == "string" {
+				enum.IsString = true
+			}
+
+			p.Enums = append(p.Enums, enum)
 			delete(p.Aliases, k)
 		}
 	}
@@ -139,6 +164,14 @@ type Named struct {
 	*BaseType
 	Path string
 	Name string
+	// Generic is the Go type, e.g. Page[User], of the instantiated generic
+	// struct whose struct declared for it in the package is Name. It is empty
+	// for any other named type.
+	Generic string
+
+	// instance is the instantiated generic type until the struct for it is
+	// declared in the package.
+	instance *types.Named
 }
 
 // String returns a string representation for the type
@@ -162,9 +195,9 @@ func (n Named) UnqualifiedName() string {
 // NewNamed creates a new named type given its package path and name.
 func NewNamed(path, name string) Type {
 	return &Named{
-		newBaseType(),
-		path,
-		name,
+		BaseType: newBaseType(),
+		Path:     path,
+		Name:     name,
 	}
 }
 
@@ -233,6 +266,40 @@ func (m Map) UnqualifiedName() string {
 	return m.String()
 }
 
+// Anonymous is a struct type declared in place instead of being named, such
+// as the type of the field `Meta struct { A string }`.
+type Anonymous struct {
+	*BaseType
+	Struct *Struct
+}
+
+// NewAnonymous creates a new anonymous struct type with the given struct.
+func NewAnonymous(s *Struct) Type {
+	return &Anonymous{
+		newBaseType(),
+		s,
+	}
+}
+
+// String returns a string representation for the type
+func (a Anonymous) String() string {
+	var fields = make([]string, len(a.Struct.Fields))
+	for i, f := range a.Struct.Fields {
+		fields[i] = fmt.Sprintf("%s %s", f.Name, f.Type.String())
+	}
+	return fmt.Sprintf("struct{%s}", strings.Join(fields, "; "))
+}
+
+// TypeString returns a string representation for the type casting
+func (a Anonymous) TypeString() string {
+	return a.String()
+}
+
+// UnqualifiedName returns the bare name, without the package.
+func (a Anonymous) UnqualifiedName() string {
+	return a.String()
+}
+
 // Documentable is something whose documentation can be set.
 type Documentable interface {
 	// SetDocs sets the documentation from an AST comment group.
@@ -242,15 +309,34 @@ type Documentable interface {
 // Docs holds the documentation of a struct, enum, value, field, etc.
 type Docs struct {
 	Doc []string
+	// Options are the protobuf options set with //proteus:option comments,
+	// or in the struct tag of fields, indexed by their name. Their values
+	// are written as they would be in a proto file.
+	Options map[string]string
+	// Imports are the proto files imported with //proteus:import comments,
+	// such as the ones declaring the extensions used as options.
+	Imports []string
 }
 
 // SetDocs sets the documentation from an AST comment group.
-// It removes the //proteus:generate comment from the comments.
+// It removes the //proteus:generate comment from the comments, as well as
+// the //proteus:option and //proteus:import comments, which are kept as
+// options and imports.
 func (d *Docs) SetDocs(comments *ast.CommentGroup) {
 	var list []*ast.Comment
 	if comments != nil {
 		for _, c := range comments.List {
-			if !strings.HasPrefix(c.Text, genComment) {
+			if strings.HasPrefix(c.Text, genComment) {
+				continue
+			}
+
+			if arg, ok := directive(c.Text, optionComment); ok {
+				d.addOption(arg)
+			} else if arg, ok := directive(c.Text, importComment); ok {
+				for _, i := range strings.Fields(arg) {
+					d.Imports = append(d.Imports, strings.Trim(i, `"`))
+				}
+			} else {
 				list = append(list, c)
 			}
 		}
@@ -263,18 +349,43 @@ func (d *Docs) SetDocs(comments *ast.CommentGroup) {
 	}
 }
 
+func (d *Docs) addOption(option string) {
+	name, value, err := parseOption(option)
+	if err != nil {
+		report.Warn("invalid %s comment, ignoring it: %s", optionComment, err)
+		return
+	}
+
+	if d.Options == nil {
+		d.Options = make(map[string]string)
+	}
+	d.Options[name] = value
+}
+
+func (d *Docs) isEmpty() bool {
+	return len(d.Doc) == 0 && len(d.Options) == 0 && len(d.Imports) == 0
+}
+
 // Enum consists of a list of possible values.
 type Enum struct {
 	Docs
+	// Generate reports whether the enum is opted-in with the
+	// proteus:generate comment. Enums that are not are only generated if
+	// they are used by the generated structs and funcs.
+	Generate   bool
 	Name       string
 	Values     []*EnumValue
 	IsStringer bool
+	// IsString reports whether the underlying type of the enum is string.
+	IsString bool
 }
 
 // EnumValue is a possible value of an enum.
 type EnumValue struct {
 	Docs
 	Name string
+	// Value is the value of the constant.
+	Value constant.Value
 }
 
 // Struct represents a Go struct with its name and fields.
@@ -285,6 +396,13 @@ type Struct struct {
 	Name       string
 	Fields     []*Field
 	IsStringer bool
+	// Generic is the Go type, e.g. Page[User], if the struct is declared
+	// for an instantiation of a generic struct.
+	Generic string
+
+	// origin is the qualified name of the generic struct the struct is
+	// declared for.
+	origin string
 }
 
 // HasField reports wether a struct has a given field name.
@@ -302,6 +420,51 @@ type Field struct {
 	Docs
 	Name string
 	Type Type
+	// Pos is the protobuf field number set in the struct tag of the field.
+	// It is 0 if no number was set.
+	Pos int
+	// ProtoName is the protobuf field name set in the struct tag of the
+	// field. It is empty if no name was set.
+	ProtoName string
+	// JSONName is the JSON name set in the struct tag of the field. It is
+	// empty if no JSON name was set.
+	JSONName string
+}
+
+// ProtobufName returns the name of the protobuf field of the struct field,
+// which is the name set in its tag or its name in snake case.
+func (f *Field) ProtobufName() string {
+	if f.ProtoName != "" {
+		return f.ProtoName
+	}
+	return ToLowerSnakeCase(f.Name)
+}
+
+// ToLowerSnakeCase converts the given Go name to lower snake case, which is
+// how protobuf fields are named, e.g. UserName to user_name. Consecutive
+// upper case letters are kept together, so UserID becomes user_id.
+func ToLowerSnakeCase(s string) string {
+	var buf bytes.Buffer
+	var lastWasUpper bool
+	for i, r := range s {
+		if unicode.IsUpper(r) && i != 0 && !lastWasUpper {
+			buf.WriteRune('_')
+		}
+		lastWasUpper = unicode.IsUpper(r)
+		buf.WriteRune(unicode.ToLower(r))
+	}
+	return buf.String()
+}
+
+// Interface is a sealed interface, that is, an interface with a known set of
+// implementations. Struct fields of the interface type are generated as a
+// oneof with a field for every implementation.
+type Interface struct {
+	Docs
+	Name string
+	// Implementations are the types implementing the interface. They are
+	// nullable if only pointers to them implement the interface.
+	Implementations []*Named
 }
 
 // Func is either a function or a method. Receiver will be nil in functions,
@@ -3,16 +3,16 @@ package scanner
 import (
 	"errors"
 	"fmt"
+	"go/constant"
+	"go/token"
 	"go/types"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 
+	"gopkg.in/src-d/proteus.v1/loader"
 	"gopkg.in/src-d/proteus.v1/report"
-
-	"gopkg.in/src-d/go-parse-utils.v1"
 )
 
 var goPath = os.Getenv("GOPATH")
@@ -21,34 +21,32 @@ var goPath = os.Getenv("GOPATH")
 // and extract types and structs from.
 type Scanner struct {
 	packages []string
-	importer *parseutil.Importer
+	loader   *loader.Loader
 }
 
 // ErrNoGoPathSet is the error returned when the GOPATH variable is not
-// set.
+// set and the working directory is not inside a Go module.
 var ErrNoGoPathSet = errors.New("GOPATH environment variable is not set")
 
 // New creates a new Scanner that will look for types and structs
 // only in the given packages.
+// Inside a Go module, packages can be given as import paths or as patterns
+// relative to the working directory, such as "./models/...". Outside of a
+// module, packages are import paths looked up in GOPATH.
 func New(packages ...string) (*Scanner, error) {
-	if goPath == "" {
+	l := loader.New()
+	if !l.Modules() && goPath == "" {
 		return nil, ErrNoGoPathSet
 	}
 
-	for _, pkg := range packages {
-		p := filepath.Join(goPath, "src", pkg)
-		fi, err := os.Stat(p)
-		switch {
-		case err != nil:
-			return nil, err
-		case !fi.IsDir():
-			return nil, fmt.Errorf("path is not directory: %s", p)
-		}
+	paths, err := l.Resolve(packages...)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Scanner{
-		packages: packages,
-		importer: parseutil.NewImporter(),
+		packages: paths,
+		loader:   l,
 	}, nil
 }
 
@@ -84,40 +82,44 @@ func (s *Scanner) Scan() ([]*Package, error) {
 		return nil, errors.err()
 	}
 
+	setInstanceDocs(pkgs)
 	return pkgs, nil
 }
 
-func (s *Scanner) scanPackage(p string) (*Package, error) {
-	pkg, err := s.importer.ImportWithFilters(
-		p,
-		parseutil.FileFilters{
-			func(pkg, file string, typ parseutil.FileType) bool {
-				return !strings.HasSuffix(file, ".pb.go")
-			},
-			func(pkg, file string, typ parseutil.FileType) bool {
-				return !strings.HasSuffix(file, ".proteus.go")
-			},
-		},
-	)
+// ScanPackage scans the package with the given import path, which does not
+// need to be one of the packages of the scanner. It is used to scan the
+// packages referenced by the scanned ones.
+func (s *Scanner) ScanPackage(path string) (*Package, error) {
+	pkg, err := s.scanPackage(path)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error scanning package %q: %s", path, err)
 	}
 
-	ctx, err := newContext(p)
+	setInstanceDocs([]*Package{pkg})
+	return pkg, nil
+}
+
+func (s *Scanner) scanPackage(p string) (*Package, error) {
+	pkg, err := s.loader.Load(p)
 	if err != nil {
 		return nil, err
 	}
 
-	return buildPackage(ctx, pkg)
+	return buildPackage(newContext(pkg.AST), pkg.Types)
 }
 
 func buildPackage(ctx *context, gopkg *types.Package) (*Package, error) {
 	objs := objectsInScope(gopkg.Scope())
 
 	pkg := &Package{
-		Path:    removeGoPath(gopkg),
-		Name:    gopkg.Name(),
-		Aliases: make(map[string]Type),
+		Path:     removeGoPath(gopkg),
+		Name:     gopkg.Name(),
+		Aliases:  make(map[string]Type),
+		generics: make(map[string]Docs),
+	}
+
+	if ctx.pkgDoc != nil {
+		pkg.SetDocs(ctx.pkgDoc)
 	}
 
 	for _, o := range objs {
@@ -126,6 +128,10 @@ func buildPackage(ctx *context, gopkg *types.Package) (*Package, error) {
 		}
 	}
 
+	if err := pkg.collectInstances(gopkg); err != nil {
+		return nil, err
+	}
+
 	pkg.collectEnums(ctx)
 	return pkg, nil
 }
@@ -144,11 +150,30 @@ func (p *Package) scanObject(ctx *context, o types.Object) error {
 		switch o.(type) {
 		case *types.Const:
 			if _, ok := t.Underlying().(*types.Basic); ok {
-				scanEnumValue(ctx, o.Name(), t, hasStringMethod)
+				scanEnumValue(ctx, o.(*types.Const), t, hasStringMethod)
 			}
 		case *types.TypeName:
+			if t.TypeParams().Len() > 0 {
+				ctx.warnFieldOptions(o.Name())
+				var docs Docs
+				ctx.trySetDocs(o.Name(), &docs)
+				p.generics[o.Name()] = docs
+				return nil
+			}
+
+			if iface, ok := t.Underlying().(*types.Interface); ok && ctx.shouldGenerateType(o.Name()) {
+				i, err := scanInterface(ctx, t, iface)
+				if err != nil {
+					return err
+				}
+
+				ctx.trySetDocs(o.Name(), i)
+				p.Interfaces = append(p.Interfaces, i)
+				return nil
+			}
+
 			if s, ok := t.Underlying().(*types.Struct); ok {
-				st := scanStruct(
+				st, err := scanStruct(
 					&Struct{
 						Name:       o.Name(),
 						Generate:   ctx.shouldGenerateType(o.Name()),
@@ -156,6 +181,15 @@ func (p *Package) scanObject(ctx *context, o types.Object) error {
 					},
 					s,
 				)
+				if err != nil {
+					return err
+				}
+
+				if err := checkStructFields(st); err != nil {
+					return err
+				}
+
+				ctx.warnFieldOptions(o.Name())
 				ctx.trySetDocs(o.Name(), st)
 				p.Structs = append(p.Structs, st)
 				return nil
@@ -165,6 +199,11 @@ func (p *Package) scanObject(ctx *context, o types.Object) error {
 		}
 	case *types.Signature:
 		if ctx.shouldGenerateFunc(nameForFunc(o)) {
+			if t.TypeParams().Len() > 0 || t.RecvTypeParams().Len() > 0 {
+				report.Warn("func %s is generic and it will not be generated", nameForFunc(o))
+				return nil
+			}
+
 			fn := scanFunc(&Func{Name: o.Name()}, t)
 			ctx.trySetDocs(nameForFunc(o), fn)
 			p.Funcs = append(p.Funcs, fn)
@@ -227,10 +266,21 @@ func scanType(typ types.Type) (t Type) {
 	case *types.Basic:
 		t = NewBasic(u.Name())
 	case *types.Named:
-		t = NewNamed(
+		if u.TypeArgs().Len() > 0 {
+			if _, ok := u.Underlying().(*types.Struct); !ok {
+				report.Warn("ignoring type %s, only generic structs are supported", typ.String())
+				return nil
+			}
+		}
+
+		n := NewNamed(
 			removeGoPath(u.Obj().Pkg()),
 			u.Obj().Name(),
-		)
+		).(*Named)
+		if u.TypeArgs().Len() > 0 {
+			n.instance = u
+		}
+		t = n
 	case *types.Slice:
 		t = scanType(u.Elem())
 		t.SetRepeated(true)
@@ -248,6 +298,32 @@ func scanType(typ types.Type) (t Type) {
 			return nil
 		}
 		t = NewMap(key, val)
+	case *types.Alias:
+		return scanType(types.Unalias(u))
+	case *types.Interface:
+		// Only the empty interface can hold free-form values, any other
+		// interface needs to be named to be generated.
+		if !u.Empty() {
+			report.Warn("ignoring type %s", typ.String())
+			return nil
+		}
+		t = NewBasic("interface{}")
+	case *types.Struct:
+		if u.NumFields() == 0 {
+			report.Warn("ignoring empty struct type %s", typ.String())
+			return nil
+		}
+
+		s, err := scanStruct(&Struct{}, u)
+		if err == nil {
+			err = checkStructFields(s)
+		}
+
+		if err != nil {
+			report.Warn("ignoring anonymous struct type %s: %s", typ.String(), err)
+			return nil
+		}
+		t = NewAnonymous(s)
 	default:
 		report.Warn("ignoring type %s", typ.String())
 		return nil
@@ -256,13 +332,13 @@ func scanType(typ types.Type) (t Type) {
 	return
 }
 
-func scanEnumValue(ctx *context, name string, named *types.Named, hasStringMethod bool) {
+func scanEnumValue(ctx *context, c *types.Const, named *types.Named, hasStringMethod bool) {
 	typ := objName(named.Obj())
-	ctx.enumValues[typ] = append(ctx.enumValues[typ], name)
+	ctx.enumValues[typ] = append(ctx.enumValues[typ], c)
 	ctx.enumWithString = append(ctx.enumWithString, typ)
 }
 
-func scanStruct(s *Struct, elem *types.Struct) *Struct {
+func scanStruct(s *Struct, elem *types.Struct) (*Struct, error) {
 	for i := 0; i < elem.NumFields(); i++ {
 		v := elem.Field(i)
 		tags := findProtoTags(elem.Tag(i))
@@ -271,6 +347,11 @@ func scanStruct(s *Struct, elem *types.Struct) *Struct {
 			continue
 		}
 
+		tag, err := parseFieldTag(tags)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proteus tag in field %q of struct %q: %s", v.Name(), s.Name, err)
+		}
+
 		// TODO: It has not been decided yet what exact behaviour
 		// is the intended when a struct overrides a field from
 		// a previously embedded type. For now, the field is just
@@ -285,15 +366,19 @@ func scanStruct(s *Struct, elem *types.Struct) *Struct {
 			embedded := findStruct(v.Type())
 			if embedded == nil {
 				report.Warn("field %q with type %q is not a valid embedded type", v.Name(), v.Type())
-			} else {
-				s = scanStruct(s, embedded)
+			} else if s, err = scanStruct(s, embedded); err != nil {
+				return nil, err
 			}
 			continue
 		}
 
 		f := &Field{
-			Name: v.Name(),
-			Type: scanType(v.Type()),
+			Docs:      Docs{Options: tag.options},
+			Name:      v.Name(),
+			Type:      scanType(v.Type()),
+			Pos:       tag.pos,
+			ProtoName: tag.name,
+			JSONName:  tag.jsonName,
 		}
 		if f.Type == nil {
 			continue
@@ -302,7 +387,98 @@ func scanStruct(s *Struct, elem *types.Struct) *Struct {
 		s.Fields = append(s.Fields, f)
 	}
 
-	return s
+	return s, nil
+}
+
+// checkStructFields returns an error if two fields of the struct have been
+// given the same number in their tags, or would have the same protobuf name,
+// either set in their tags or derived from their Go names.
+func checkStructFields(s *Struct) error {
+	var (
+		numbers = make(map[int]string)
+		names   = make(map[string]string)
+	)
+
+	for _, f := range s.Fields {
+		if f.Pos > 0 {
+			if other, ok := numbers[f.Pos]; ok {
+				return fmt.Errorf("fields %q and %q of struct %q have the same number %d", other, f.Name, s.Name, f.Pos)
+			}
+			numbers[f.Pos] = f.Name
+		}
+
+		name := f.ProtobufName()
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("fields %q and %q of struct %q have the same name %q", other, f.Name, s.Name, name)
+		}
+		names[name] = f.Name
+	}
+
+	return nil
+}
+
+// scanInterface scans an interface marked to be generated. Its
+// implementations are the structs listed after the generate comment or, if
+// none are listed, all the exported structs of its package implementing it.
+func scanInterface(ctx *context, named *types.Named, iface *types.Interface) (*Interface, error) {
+	var (
+		name  = named.Obj().Name()
+		scope = named.Obj().Pkg().Scope()
+		i     = &Interface{Name: name}
+	)
+
+	if listed := ctx.generateArgs(name); len(listed) > 0 {
+		for _, n := range listed {
+			obj, ok := scope.Lookup(n).(*types.TypeName)
+			if !ok || findStruct(obj.Type()) == nil {
+				return nil, fmt.Errorf("implementation %q of interface %q is not a struct of its package", n, name)
+			}
+
+			impl := implementation(obj, iface)
+			if impl == nil {
+				return nil, fmt.Errorf("type %q does not implement interface %q", n, name)
+			}
+			i.Implementations = append(i.Implementations, impl)
+		}
+	} else {
+		for _, n := range scope.Names() {
+			obj, ok := scope.Lookup(n).(*types.TypeName)
+			if !ok || !obj.Exported() {
+				continue
+			}
+
+			if _, ok := obj.Type().Underlying().(*types.Struct); !ok {
+				continue
+			}
+
+			if impl := implementation(obj, iface); impl != nil {
+				i.Implementations = append(i.Implementations, impl)
+			}
+		}
+	}
+
+	if len(i.Implementations) == 0 {
+		return nil, fmt.Errorf("interface %q has no implementations", name)
+	}
+
+	return i, nil
+}
+
+// implementation returns the type of the given object as an implementation
+// of the given interface, which is nullable if only the pointer to the type
+// implements the interface, or nil if it does not implement it at all.
+func implementation(obj *types.TypeName, iface *types.Interface) *Named {
+	var nullable bool
+	if !types.Implements(obj.Type(), iface) {
+		if !types.Implements(types.NewPointer(obj.Type()), iface) {
+			return nil
+		}
+		nullable = true
+	}
+
+	n := NewNamed(removeGoPath(obj.Pkg()), obj.Name()).(*Named)
+	n.SetNullable(nullable)
+	return n
 }
 
 func scanFunc(fn *Func, signature *types.Signature) *Func {
@@ -339,27 +515,26 @@ func findStruct(t types.Type) *types.Struct {
 	}
 }
 
-// newEnum creates a new enum with the given name.
-// The values are looked up in the ast package and only if they are constants
-// they will be added as enum values.
-// All values are guaranteed to be sorted by their iota.
-func newEnum(ctx *context, name string, vals []string, hasStringMethod bool) *Enum {
+// newEnum creates a new enum with the given name and values.
+// Values of integer enums are sorted by their value and, if several of them
+// have the same value, by the order in which they were declared. Values of
+// any other kind of enum are sorted by the order in which they were declared.
+func newEnum(ctx *context, name string, vals []*types.Const, hasStringMethod bool) *Enum {
 	enum := &Enum{Name: name, IsStringer: hasStringMethod}
 	ctx.trySetDocs(name, enum)
-	var values enumValues
+	var values = make(enumValues, 0, len(vals))
 	for _, v := range vals {
-		if obj, ok := ctx.consts[v]; ok {
-			values = append(values, enumValue{
-				name: v,
-				pos:  uint(obj.Data.(int)),
-			})
-		}
+		values = append(values, enumValue{
+			name: v.Name(),
+			val:  v.Val(),
+			pos:  v.Pos(),
+		})
 	}
 
 	sort.Stable(values)
 
 	for _, v := range values {
-		val := &EnumValue{Name: v.name}
+		val := &EnumValue{Name: v.name, Value: v.val}
 		ctx.trySetDocs(v.name, val)
 		enum.Values = append(enum.Values, val)
 	}
@@ -369,7 +544,8 @@ func newEnum(ctx *context, name string, vals []string, hasStringMethod bool) *En
 
 type enumValue struct {
 	name string
-	pos  uint
+	val  constant.Value
+	pos  token.Pos
 }
 
 type enumValues []enumValue
@@ -383,6 +559,11 @@ func (v enumValues) Len() int {
 }
 
 func (v enumValues) Less(i, j int) bool {
+	a, b := v[i].val, v[j].val
+	if a.Kind() == constant.Int && b.Kind() == constant.Int &&
+		!constant.Compare(a, token.EQL, b) {
+		return constant.Compare(a, token.LSS, b)
+	}
 	return v[i].pos < v[j].pos
 }
 
@@ -424,9 +605,8 @@ func removeGoPath(pkg *types.Package) string {
 	// error is a type.Named whose package is nil.
 	if pkg == nil {
 		return ""
-	} else {
-		return strings.Replace(pkg.Path(), filepath.Join(goPath, "src")+"/", "", -1)
 	}
+	return loader.ImportPath(pkg.Path())
 }
 
 type errorList []error
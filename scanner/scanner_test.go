@@ -2,15 +2,20 @@ package scanner
 
 import (
 	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
 	"go/token"
 	"go/types"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/proteus.v1/report"
 )
 
 var gopath = os.Getenv("GOPATH")
@@ -88,14 +93,71 @@ func TestScanType(t *testing.T) {
 			types.NewStruct(nil, nil),
 			nil,
 		},
+		{
+			"anonymous struct",
+			types.NewStruct(
+				[]*types.Var{
+					mkField("Foo", types.Typ[types.Int], false),
+					mkField("bar", types.Typ[types.Int], false),
+				},
+				nil,
+			),
+			NewAnonymous(&Struct{
+				Fields: []*Field{
+					{Name: "Foo", Type: NewBasic("int")},
+				},
+			}),
+		},
+		{
+			"slice of pointers to anonymous struct",
+			types.NewSlice(types.NewPointer(types.NewStruct(
+				[]*types.Var{
+					mkField("Foo", types.Typ[types.Int], false),
+				},
+				nil,
+			))),
+			nullable(repeated(NewAnonymous(&Struct{
+				Fields: []*Field{
+					{Name: "Foo", Type: NewBasic("int")},
+				},
+			}))),
+		},
+		{
+			"anonymous struct with invalid tags",
+			types.NewStruct(
+				[]*types.Var{
+					mkField("Foo", types.Typ[types.Int], false),
+					mkField("Bar", types.Typ[types.Int], false),
+				},
+				[]string{`proteus:"1"`, `proteus:"1"`},
+			),
+			nil,
+		},
 		{
 			"interface",
 			types.NewInterface(nil, nil),
-			nil,
+			NewBasic("interface{}"),
 		},
 		{
 			"map interface",
 			types.NewMap(types.Typ[types.String], &types.Interface{}),
+			NewMap(NewBasic("string"), NewBasic("interface{}")),
+		},
+		{
+			"any",
+			types.Universe.Lookup("any").Type(),
+			NewBasic("interface{}"),
+		},
+		{
+			"non-empty interface",
+			types.NewInterfaceType([]*types.Func{
+				types.NewFunc(0, nil, "Foo", types.NewSignatureType(nil, nil, nil, nil, nil, false)),
+			}, nil).Complete(),
+			nil,
+		},
+		{
+			"map non-empty interface",
+			types.NewMap(types.Typ[types.String], types.Universe.Lookup("error").Type().Underlying()),
 			nil,
 		},
 	}
@@ -272,7 +334,95 @@ func TestScanStruct(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		require.Equal(t, c.expected, scanStruct(&Struct{}, c.elem), c.name)
+		st, err := scanStruct(&Struct{}, c.elem)
+		require.NoError(t, err, c.name)
+		require.Equal(t, c.expected, st, c.name)
+	}
+}
+
+func TestScanStructTags(t *testing.T) {
+	st, err := scanStruct(&Struct{}, types.NewStruct(
+		[]*types.Var{
+			mkField("Foo", types.Typ[types.Int], false),
+			mkField("Bar", types.Typ[types.String], false),
+			mkField("Baz", types.Typ[types.String], false),
+		},
+		[]string{
+			`proteus:"5,name=foo_id,json=fooId"`,
+			`json:"bar" proteus:"name=bar_name"`,
+			`json:"baz"`,
+		},
+	))
+	require.NoError(t, err)
+	require.Equal(t, &Struct{
+		Fields: []*Field{
+			{Name: "Foo", Type: NewBasic("int"), Pos: 5, ProtoName: "foo_id", JSONName: "fooId"},
+			{Name: "Bar", Type: NewBasic("string"), ProtoName: "bar_name"},
+			{Name: "Baz", Type: NewBasic("string")},
+		},
+	}, st)
+
+	_, err = scanStruct(&Struct{Name: "Foo"}, types.NewStruct(
+		[]*types.Var{mkField("Foo", types.Typ[types.Int], false)},
+		[]string{`proteus:"foo"`},
+	))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `field "Foo" of struct "Foo"`)
+}
+
+func TestCheckStructFields(t *testing.T) {
+	cases := []struct {
+		name   string
+		fields []*Field
+		err    bool
+	}{
+		{
+			"no tags",
+			[]*Field{{Name: "Foo"}, {Name: "Bar"}},
+			false,
+		},
+		{
+			"different numbers and names",
+			[]*Field{
+				{Name: "Foo", Pos: 1, ProtoName: "foo"},
+				{Name: "Bar", Pos: 2, ProtoName: "bar"},
+			},
+			false,
+		},
+		{
+			"duplicate numbers",
+			[]*Field{{Name: "Foo", Pos: 1}, {Name: "Bar", Pos: 1}},
+			true,
+		},
+		{
+			"duplicate names",
+			[]*Field{{Name: "Foo", ProtoName: "foo"}, {Name: "Bar", ProtoName: "foo"}},
+			true,
+		},
+		{
+			"name of another field",
+			[]*Field{{Name: "Foo", ProtoName: "bar"}, {Name: "Bar"}},
+			true,
+		},
+		{
+			"same name in snake case",
+			[]*Field{{Name: "UserID"}, {Name: "UserId"}},
+			true,
+		},
+		{
+			"field renamed to a free name",
+			[]*Field{{Name: "Foo", ProtoName: "bar"}, {Name: "Bar", ProtoName: "foo"}},
+			false,
+		},
+	}
+
+	for _, c := range cases {
+		err := checkStructFields(&Struct{Name: "Foo", Fields: c.fields})
+		if c.err {
+			require.Error(t, err, c.name)
+		} else {
+			require.NoError(t, err, c.name)
+		}
 	}
 }
 
@@ -451,11 +601,36 @@ func assertEnumValues(t *testing.T, values []*EnumValue, expected ...string) {
 	require.Len(values, len(expected), "expected same enum values")
 	for i := range values {
 		require.Equal(expected[i], values[i].Name, "expected same enum value name")
+		require.Equal(constant.MakeInt64(int64(i)), values[i].Value, "expected same enum value")
 		require.Equal(fmt.Sprintf("%s ...", values[i].Name), strings.TrimSpace(strings.Join(values[i].Doc, "\n")))
 	}
 
 }
 
+func TestEnumValuesSort(t *testing.T) {
+	values := enumValues{
+		{name: "C", val: constant.MakeInt64(4), pos: 1},
+		{name: "B", val: constant.MakeInt64(2), pos: 2},
+		{name: "Alias", val: constant.MakeInt64(2), pos: 4},
+		{name: "A", val: constant.MakeInt64(2), pos: 3},
+		{name: "Neg", val: constant.MakeInt64(-1), pos: 5},
+	}
+	sort.Stable(values)
+
+	var names []string
+	for _, v := range values {
+		names = append(names, v.name)
+	}
+	require.Equal(t, []string{"Neg", "B", "A", "Alias", "C"}, names)
+
+	values = enumValues{
+		{name: "B", val: constant.MakeString("b"), pos: 2},
+		{name: "A", val: constant.MakeString("a"), pos: 1},
+	}
+	sort.Stable(values)
+	require.Equal(t, "A", values[0].name, "non integer values are sorted by position")
+}
+
 func findFuncByName(name string, fns []*Func) *Func {
 	for _, f := range fns {
 		if f.Name == name {
@@ -568,5 +743,304 @@ func projectPkg(pkg string) string {
 }
 
 func absPath(path string) string {
-	return filepath.Join(goPath, "src", project, path)
+	return filepath.Join("..", path)
+}
+
+const interfacesSrc = `package shapes
+
+// Shape is a shape.
+//proteus:generate
+type Shape interface {
+	Area() float64
+}
+
+//proteus:generate Square, Circle
+type Listed interface {
+	Area() float64
+}
+
+//proteus:generate Square Point
+type Invalid interface {
+	Area() float64
+}
+
+//proteus:generate
+type Empty interface {
+	Empty()
+}
+
+type Circle struct{}
+
+func (*Circle) Area() float64 { return 0 }
+
+type Square struct{}
+
+func (Square) Area() float64 { return 0 }
+
+type Point struct{}
+
+type hidden struct{}
+
+func (hidden) Area() float64 { return 0 }
+`
+
+func TestScanInterface(t *testing.T) {
+	require := require.New(t)
+
+	pkg, ctx := checkSource(t, "shapes", interfacesSrc)
+	scan := func(name string) (*Interface, error) {
+		named := pkg.Scope().Lookup(name).Type().(*types.Named)
+		return scanInterface(ctx, named, named.Underlying().(*types.Interface))
+	}
+
+	circle := NewNamed("shapes", "Circle").(*Named)
+	circle.SetNullable(true)
+	square := NewNamed("shapes", "Square").(*Named)
+
+	iface, err := scan("Shape")
+	require.NoError(err)
+	require.Equal([]*Named{circle, square}, iface.Implementations, "discovered")
+
+	iface, err = scan("Listed")
+	require.NoError(err)
+	require.Equal([]*Named{square, circle}, iface.Implementations, "listed")
+
+	_, err = scan("Invalid")
+	require.Error(err, "listed type does not implement the interface")
+
+	_, err = scan("Empty")
+	require.Error(err, "no implementations")
+}
+
+const genericsSrc = `package pages
+
+// Page is a page of results.
+type Page[T any] struct {
+	Items []T
+	Total int64
+	Next  *Cursor[T]
+}
+
+type Cursor[T any] struct {
+	Last T
+}
+
+type User struct {
+	Name string
+}
+
+//proteus:generate
+type Users struct {
+	First Page[User]
+	Rest  []Page[User]
+	Names Page[string]
+}
+
+//proteus:generate
+func Find(q string) Page[User] {
+	return Page[User]{}
+}
+
+//proteus:generate
+func Map[T any](p Page[T]) []T {
+	return nil
+}
+`
+
+func TestScanGenerics(t *testing.T) {
+	require := require.New(t)
+
+	gopkg, ctx := checkSource(t, "pages", genericsSrc)
+	pkg, err := buildPackage(ctx, gopkg)
+	require.NoError(err)
+
+	names := make([]string, len(pkg.Structs))
+	for i, s := range pkg.Structs {
+		names[i] = s.Name
+	}
+	require.Equal([]string{"User", "Users", "PageUser", "CursorUser", "PageString", "CursorString"}, names)
+
+	users := pkg.Structs[1]
+	first := users.Fields[0].Type.(*Named)
+	require.Equal("pages", first.Path)
+	require.Equal("PageUser", first.Name)
+	require.Equal("Page[User]", first.Generic)
+
+	rest := users.Fields[1].Type.(*Named)
+	require.Equal("PageUser", rest.Name)
+	require.True(rest.IsRepeated())
+
+	page := pkg.Structs[2]
+	require.Equal("Page[User]", page.Generic)
+	require.Equal([]string{"Page is a page of results."}, page.Doc)
+	require.Len(page.Fields, 3)
+	require.Equal("User", page.Fields[0].Type.(*Named).Name)
+	require.True(page.Fields[0].Type.IsRepeated())
+	require.Equal("CursorUser", page.Fields[2].Type.(*Named).Name)
+	require.Equal("pages.Cursor", pkg.Structs[3].origin)
+
+	require.Len(pkg.Funcs, 1, "generic funcs are not generated")
+	require.Equal("PageUser", pkg.Funcs[0].Output[0].(*Named).Name)
+}
+
+func TestScanGenericsNameClash(t *testing.T) {
+	src := `package pages
+
+type Page[T any] struct {
+	Items []T
+}
+
+type User struct{}
+
+type Users struct {
+	A Page[User]
+	B Page[*User]
+}
+`
+	gopkg, ctx := checkSource(t, "pages", src)
+	_, err := buildPackage(ctx, gopkg)
+	require.Error(t, err)
+}
+
+func TestSetInstanceDocs(t *testing.T) {
+	pkgs := []*Package{
+		{Path: "a", generics: map[string]Docs{"Page": {
+			Doc:     []string{"Page doc"},
+			Options: map[string]string{"deprecated": "true"},
+		}}},
+		{Path: "b", Structs: []*Struct{{Name: "PageUser", origin: "a.Page"}, {Name: "User"}}},
+	}
+
+	setInstanceDocs(pkgs)
+	require.Equal(t, []string{"Page doc"}, pkgs[1].Structs[0].Doc)
+	require.Equal(t, map[string]string{"deprecated": "true"}, pkgs[1].Structs[0].Options)
+	require.Nil(t, pkgs[1].Structs[1].Doc)
+}
+
+const enumsSrc = `package colors
+
+//proteus:generate
+type Color int
+
+const (
+	Red Color = iota
+	Blue
+)
+
+type Shade string
+
+const (
+	Light Shade = "light"
+	Dark  Shade = "dark"
+)
+
+type Plain int
+`
+
+func TestScanEnumsNotOptedIn(t *testing.T) {
+	require := require.New(t)
+
+	gopkg, ctx := checkSource(t, "colors", enumsSrc)
+	pkg, err := buildPackage(ctx, gopkg)
+	require.NoError(err)
+
+	require.Len(pkg.Enums, 2)
+	sort.Slice(pkg.Enums, func(i, j int) bool {
+		return pkg.Enums[i].Name < pkg.Enums[j].Name
+	})
+
+	require.Equal("Color", pkg.Enums[0].Name)
+	require.True(pkg.Enums[0].Generate)
+	require.Equal("Shade", pkg.Enums[1].Name)
+	require.False(pkg.Enums[1].Generate)
+	require.True(pkg.Enums[1].IsString)
+
+	require.Len(pkg.Aliases, 1, "types without constants are still aliases")
+	require.Contains(pkg.Aliases, "colors.Plain")
+}
+
+const optionsSrc = `// Package users holds the users.
+//proteus:option java_multiple_files=true
+//proteus:import validate/validate.proto
+package users
+
+// User is a user.
+//proteus:generate
+//proteus:option deprecated=true
+//proteus:option (foo.bar)="a b"
+type User struct {
+	Name  string ` + "`proteus:\"2,opt:(validate.rules).string.min_len=1\"`" + `
+	Email string
+	//proteus:option deprecated=true
+	Nick string ` + "`proteus:\"opt:(validate.rules).string.pattern=\\\"^a$\\\"\"`" + `
+}
+
+//proteus:generate
+type Role int
+
+const (
+	Admin Role = iota
+	//proteus:option deprecated=true
+	Guest
+)
+
+//proteus:generate
+//proteus:option idempotency_level=NO_SIDE_EFFECTS
+//proteus:option invalid
+func GetUser(id int) User {
+	return User{}
+}
+`
+
+func TestScanOptions(t *testing.T) {
+	require := require.New(t)
+
+	report.TestMode()
+	gopkg, ctx := checkSource(t, "users", optionsSrc)
+	pkg, err := buildPackage(ctx, gopkg)
+	require.NoError(err)
+
+	var warnings []string
+	for _, msg := range report.MessageStack() {
+		if strings.Contains(msg, "field") {
+			warnings = append(warnings, msg)
+		}
+	}
+	report.EndTestMode()
+
+	require.Equal([]string{"Package users holds the users."}, pkg.Doc)
+	require.Equal(map[string]string{"java_multiple_files": "true"}, pkg.Options)
+	require.Equal([]string{"validate/validate.proto"}, pkg.Imports)
+
+	user := pkg.Structs[0]
+	require.Equal([]string{"User is a user."}, user.Doc)
+	require.Equal(map[string]string{"deprecated": "true", "(foo.bar)": `"a b"`}, user.Options)
+	require.Equal(2, user.Fields[0].Pos)
+	require.Equal(map[string]string{"(validate.rules).string.min_len": "1"}, user.Fields[0].Options)
+	require.Nil(user.Fields[1].Options)
+	require.Equal(map[string]string{"(validate.rules).string.pattern": `"^a$"`}, user.Fields[2].Options)
+	require.Equal([]string{
+		`WARN: ignoring the //proteus:option comment of field "Nick" of struct "User", set the option in the proteus tag of the field instead, e.g. proteus:"opt:deprecated=true"`,
+	}, warnings)
+
+	values := pkg.Enums[0].Values
+	require.Nil(values[0].Options)
+	require.Equal(map[string]string{"deprecated": "true"}, values[1].Options)
+
+	fn := pkg.Funcs[0]
+	require.Equal(map[string]string{"idempotency_level": "NO_SIDE_EFFECTS"}, fn.Options)
+}
+
+func checkSource(t *testing.T, name, src string) (*types.Package, *context) {
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, name+".go", src, parser.ParseComments)
+	require.NoError(t, err)
+
+	pkg, err := (&types.Config{}).Check(name, fs, []*ast.File{f}, nil)
+	require.NoError(t, err)
+
+	return pkg, newContext(&ast.Package{
+		Name:  name,
+		Files: map[string]*ast.File{name + ".go": f},
+	})
 }
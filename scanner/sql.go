@@ -0,0 +1,27 @@
+package scanner
+
+// SQLNullTypes are the database/sql Null* types indexed by their name, with
+// the Go type of the value they hold. They are allowed as the types of struct
+// fields even though database/sql is not scanned.
+var SQLNullTypes = map[string]string{
+	"database/sql.NullBool":    "bool",
+	"database/sql.NullByte":    "byte",
+	"database/sql.NullFloat64": "float64",
+	"database/sql.NullInt16":   "int16",
+	"database/sql.NullInt32":   "int32",
+	"database/sql.NullInt64":   "int64",
+	"database/sql.NullString":  "string",
+	"database/sql.NullTime":    "time.Time",
+}
+
+// IsSQLNull reports whether the given type is one of the database/sql Null*
+// types.
+func IsSQLNull(typ Type) bool {
+	named, ok := typ.(*Named)
+	if !ok {
+		return false
+	}
+
+	_, ok = SQLNullTypes[named.String()]
+	return ok
+}
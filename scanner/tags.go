@@ -1,20 +1,154 @@
 package scanner
 
 import (
+	"fmt"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-var protoTagRegex = regexp.MustCompile(`proteus:"([^"]+)"`)
+var (
+	protoIdentRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// protoOptionRegex matches the names of protobuf options, which are
+	// either plain options or extensions enclosed in parentheses, optionally
+	// followed by the path of a field of their value, e.g.
+	// (validate.rules).string.min_len.
+	protoOptionRegex = regexp.MustCompile(`^(\(\.?[a-zA-Z_][a-zA-Z0-9_.]*\)|[a-zA-Z_][a-zA-Z0-9_]*)(\.[a-zA-Z_][a-zA-Z0-9_]*)*$`)
+)
+
+// optionTagPrefix is the prefix of the tag options that set a protobuf
+// option of the field.
+const optionTagPrefix = "opt:"
+
+const (
+	maxFieldNumber      = 1<<29 - 1
+	firstReservedNumber = 19000
+	lastReservedNumber  = 19999
+)
 
+// findProtoTags returns the comma-separated options of the proteus key of
+// the given struct tag, whose value is unquoted as reflect does, so it can
+// contain escaped quotes.
 func findProtoTags(tag string) []string {
-	if !protoTagRegex.MatchString(tag) {
+	value, ok := reflect.StructTag(tag).Lookup("proteus")
+	if !ok || value == "" {
 		return nil
 	}
 
-	tags := strings.Split(protoTagRegex.FindStringSubmatch(tag)[1], ",")
+	tags := strings.Split(value, ",")
 	for i, t := range tags {
 		tags[i] = strings.TrimSpace(t)
 	}
 	return tags
 }
+
+// fieldTag is the parsed content of a proteus struct tag, which has the
+// following form:
+//
+//	proteus:"5,name=user_id,json=userId,opt:deprecated=true"
+//
+// All parts are optional, but the number, if present, must be the first one.
+// There can be any number of opt parts, each one setting an option of the
+// field, whose value cannot contain commas.
+type fieldTag struct {
+	pos      int
+	name     string
+	jsonName string
+	options  map[string]string
+}
+
+// parseFieldTag parses the given proteus tags of a field, as returned by
+// findProtoTags.
+func parseFieldTag(tags []string) (*fieldTag, error) {
+	var t fieldTag
+	for i, tag := range tags {
+		if tag == "" {
+			return nil, fmt.Errorf("empty tag option")
+		}
+
+		if strings.HasPrefix(tag, optionTagPrefix) {
+			name, value, err := parseOption(tag[len(optionTagPrefix):])
+			if err != nil {
+				return nil, err
+			}
+
+			if t.options == nil {
+				t.options = make(map[string]string)
+			}
+			t.options[name] = value
+			continue
+		}
+
+		idx := strings.Index(tag, "=")
+		if idx < 0 {
+			if i > 0 {
+				return nil, fmt.Errorf("field number %q must be the first tag option", tag)
+			}
+
+			pos, err := parseFieldNumber(tag)
+			if err != nil {
+				return nil, err
+			}
+			t.pos = pos
+			continue
+		}
+
+		key, val := strings.TrimSpace(tag[:idx]), strings.TrimSpace(tag[idx+1:])
+		if val == "" {
+			return nil, fmt.Errorf("empty value for tag option %q", key)
+		}
+
+		switch key {
+		case "name":
+			if !protoIdentRegex.MatchString(val) {
+				return nil, fmt.Errorf("%q is not a valid protobuf field name", val)
+			}
+			t.name = val
+		case "json":
+			t.jsonName = val
+		default:
+			return nil, fmt.Errorf("unknown tag option %q", key)
+		}
+	}
+
+	return &t, nil
+}
+
+func parseFieldNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid field number", s)
+	}
+
+	if n < 1 || n > maxFieldNumber {
+		return 0, fmt.Errorf("field number %d is out of the range 1 to %d", n, maxFieldNumber)
+	}
+
+	if n >= firstReservedNumber && n <= lastReservedNumber {
+		return 0, fmt.Errorf("field number %d is reserved for the protobuf implementation", n)
+	}
+
+	return n, nil
+}
+
+// parseOption parses a protobuf option written as name=value, such as
+// deprecated=true or (validate.rules).string.min_len=1. The value is
+// returned as written, as it would be in a proto file.
+func parseOption(s string) (name, value string, err error) {
+	idx := strings.Index(s, "=")
+	if idx < 0 {
+		return "", "", fmt.Errorf("option %q has no value", s)
+	}
+
+	name, value = strings.TrimSpace(s[:idx]), strings.TrimSpace(s[idx+1:])
+	if !protoOptionRegex.MatchString(name) {
+		return "", "", fmt.Errorf("%q is not a valid protobuf option name", name)
+	}
+
+	if value == "" {
+		return "", "", fmt.Errorf("empty value for option %q", name)
+	}
+
+	return name, value, nil
+}
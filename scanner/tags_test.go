@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindProtoTags(t *testing.T) {
+	require.Nil(t, findProtoTags(`json:"foo"`))
+	require.Equal(t, []string{"-"}, findProtoTags(`proteus:"-"`))
+	require.Equal(
+		t,
+		[]string{"5", "name=foo", "json=bar"},
+		findProtoTags(`json:"foo" proteus:"5, name=foo,json=bar"`),
+	)
+	require.Equal(
+		t,
+		[]string{"1", `opt:(validate.rules).string.pattern="^a$"`},
+		findProtoTags(`proteus:"1,opt:(validate.rules).string.pattern=\"^a$\"" json:"foo"`),
+	)
+	require.Nil(t, findProtoTags(`proteus:""`))
+}
+
+func TestParseFieldTag(t *testing.T) {
+	cases := []struct {
+		name     string
+		tags     []string
+		expected *fieldTag
+		err      string
+	}{
+		{"no tags", nil, &fieldTag{}, ""},
+		{"number", []string{"5"}, &fieldTag{pos: 5}, ""},
+		{"name", []string{"name=foo_bar"}, &fieldTag{name: "foo_bar"}, ""},
+		{
+			"all",
+			[]string{"5", "name=user_id", "json=userId"},
+			&fieldTag{pos: 5, name: "user_id", jsonName: "userId"},
+			"",
+		},
+		{
+			"options",
+			[]string{"5", "opt:deprecated=true", "opt:(validate.rules).string.min_len = 1"},
+			&fieldTag{pos: 5, options: map[string]string{
+				"deprecated":                      "true",
+				"(validate.rules).string.min_len": "1",
+			}},
+			"",
+		},
+		{"invalid number", []string{"foo"}, nil, `"foo" is not a valid field number`},
+		{"zero number", []string{"0"}, nil, "out of the range"},
+		{"number too big", []string{"536870912"}, nil, "out of the range"},
+		{"reserved number", []string{"19500"}, nil, "reserved for the protobuf implementation"},
+		{"number not first", []string{"name=foo", "5"}, nil, "must be the first tag option"},
+		{"empty option", []string{"5", ""}, nil, "empty tag option"},
+		{"empty value", []string{"name="}, nil, `empty value for tag option "name"`},
+		{"invalid name", []string{"name=1foo"}, nil, "not a valid protobuf field name"},
+		{"unknown option", []string{"foo=bar"}, nil, `unknown tag option "foo"`},
+		{"option without value", []string{"opt:deprecated"}, nil, `option "deprecated" has no value`},
+		{"empty option value", []string{"opt:deprecated="}, nil, `empty value for option "deprecated"`},
+		{"invalid option name", []string{"opt:(foo=1"}, nil, "not a valid protobuf option name"},
+	}
+
+	for _, c := range cases {
+		tag, err := parseFieldTag(c.tags)
+		if c.err != "" {
+			require.Error(t, err, c.name)
+			require.Contains(t, err.Error(), c.err, c.name)
+		} else {
+			require.NoError(t, err, c.name)
+			require.Equal(t, c.expected, tag, c.name)
+		}
+	}
+}